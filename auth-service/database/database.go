@@ -1,7 +1,9 @@
 package database
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -19,6 +21,12 @@ type User struct {
 	Password string `json:"password"`
 }
 
+// Errors AuthenticateUser returns for a login that doesn't match a user
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidPassword = errors.New("invalid password")
+)
+
 var db *dynamodb.DynamoDB
 
 func init() {
@@ -59,7 +67,7 @@ func AuthenticateUser(username, password string) (*User, error) {
 	}
 
 	if result.Item == nil {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 
 	var user User
@@ -71,7 +79,7 @@ func AuthenticateUser(username, password string) (*User, error) {
 	// Compare the provided password with the hashed password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return nil, fmt.Errorf("invalid password")
+		return nil, ErrInvalidPassword
 	}
 
 	return &user, nil
@@ -130,6 +138,41 @@ func GetUserByUsername(username string) (*User, error) {
 	return &user, nil
 }
 
+// FindUser returns the user with the username, ignoring case when no one has
+// it exactly. Nil when no user matches, or more than one only differs in case.
+func FindUser(username string) (*User, error) {
+	user, err := GetUserByUsername(username)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	var found *User
+	var unmarshalErr error
+	err = db.ScanPages(&dynamodb.ScanInput{TableName: aws.String("users")}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var users []User
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &users)
+		if unmarshalErr != nil {
+			return false
+		}
+		for _, u := range users {
+			if !strings.EqualFold(u.Username, username) {
+				continue
+			}
+			if found != nil {
+				found = nil
+				return false
+			}
+			match := u
+			found = &match
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, unmarshalErr
+}
+
 func UpdateUserRole(username, role string) error {
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String("users"),
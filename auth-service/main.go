@@ -4,8 +4,10 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -17,12 +19,14 @@ import (
 
 	database "github.com/Draupniyr/auth-service/database"
 	kafka "github.com/Draupniyr/auth-service/kafka"
+	problem "github.com/Draupniyr/auth-service/problem"
 	"github.com/dgrijalva/jwt-go"
 )
 
 // Claims represents the JWT claims
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
 	jwt.StandardClaims
 }
 
@@ -35,6 +39,7 @@ func main() {
 	http.HandleFunc("/auth/register", registerHandler)
 
 	http.Handle("/auth/update-role", Authorize(http.HandlerFunc(updateUserRoleHandler), "admin"))
+	http.Handle("/auth/users/{username}", Authorize(http.HandlerFunc(getUserHandler), "user", "dev", "admin"))
 
 	err := registerService()
 	if err != nil {
@@ -63,27 +68,113 @@ func updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		problem.Error(w, r, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
+	user, err := database.GetUserByUsername(request.UserID)
+	if err != nil {
+		log.Println("Error getting user:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		problem.Error(w, r, "User not found", http.StatusNotFound)
+		return
+	}
+	before := user.Audience
+
 	// Update the user's role in the database
 	err = database.UpdateUserRole(request.UserID, request.Role)
 	if err != nil {
-		http.Error(w, "Failed to update user role", http.StatusInternalServerError)
+		problem.Error(w, r, "Failed to update user role", http.StatusInternalServerError)
 		return
 	}
+	audit(r, "user.role", "user", request.UserID, map[string]string{"Role": before}, map[string]string{"Role": request.Role})
 
 	// Return a success response
 	response := map[string]string{"message": "User role updated successfully"}
 	json.NewEncoder(w).Encode(response)
 }
 
+// getUserHandler tells other services if a username exists, like the carts
+// service before sending a gift. Only the ID and the username as registered
+// are returned.
+func getUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := database.FindUser(strings.TrimSpace(r.PathValue("username")))
+	if err != nil {
+		log.Println("Error getting user:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		problem.Error(w, r, "User not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": user.ID, "username": user.Username})
+}
+
+// auditEntry is what the audit topic carries, the games service keeps the log
+type auditEntry struct {
+	ID         string            `json:"ID"`
+	Time       string            `json:"Time"`
+	Service    string            `json:"Service"`
+	ActorID    string            `json:"ActorID"`
+	ActorRole  string            `json:"ActorRole"`
+	Action     string            `json:"Action"`
+	TargetType string            `json:"TargetType"`
+	TargetID   string            `json:"TargetID"`
+	Before     map[string]string `json:"Before,omitempty"`
+	After      map[string]string `json:"After,omitempty"`
+	RequestID  string            `json:"RequestID"`
+	IP         string            `json:"IP"`
+}
+
+// audit publishes what the admin just did to the audit log. The change is
+// made by then, so a failure to publish is only logged.
+func audit(r *http.Request, action string, targetType string, targetID string, before map[string]string, after map[string]string) {
+	userID, _ := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = generateUserID()
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		ip = strings.TrimSpace(first)
+	}
+	entry, err := json.Marshal(auditEntry{
+		ID:         generateUserID(),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Service:    "auth",
+		ActorID:    userID,
+		ActorRole:  userRole,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+		RequestID:  requestID,
+		IP:         ip,
+	})
+	if err == nil {
+		err = kafka.PushCommentToQueue("audit", targetID, entry)
+	}
+	if err != nil {
+		log.Println("Error publishing audit entry for", action, targetID, ":", err)
+	}
+}
+
 func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
-			http.Error(w, "Missing token", http.StatusUnauthorized)
+			problem.Error(w, r, "Missing token", http.StatusUnauthorized)
 			return
 		}
 
@@ -94,7 +185,7 @@ func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 		})
 
 		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			problem.Error(w, r, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
@@ -103,7 +194,7 @@ func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 		userRole := claims.Audience
 
 		if !contains(allowedRoles, userRole) {
-			http.Error(w, "Unauthorized", http.StatusForbidden)
+			problem.Error(w, r, "Unauthorized", http.StatusForbidden)
 			return
 		}
 
@@ -127,28 +218,30 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var user database.User
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Error(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Authenticate user credentials against DynamoDB
 	authenticatedUser, err := database.AuthenticateUser(user.Username, user.Password)
+	if errors.Is(err, database.ErrUserNotFound) {
+		problem.Error(w, r, "User not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrInvalidPassword) {
+		problem.Error(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		if err.Error() == "user not found" {
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		} else if err.Error() == "invalid password" {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-			return
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		log.Println("Error authenticating user:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
 	// Create JWT claims
 	claims := &Claims{
-		UserID: authenticatedUser.ID,
+		UserID:   authenticatedUser.ID,
+		Username: authenticatedUser.Username,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
 			Audience:  authenticatedUser.Audience,
@@ -159,7 +252,8 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println("Error signing token:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
@@ -172,24 +266,24 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
     var requestData map[string]interface{}
     err := json.NewDecoder(r.Body).Decode(&requestData)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
+        problem.Error(w, r, err.Error(), http.StatusBadRequest)
         return
     }
 
     var user database.User
-    user.Username = requestData["username"].(string)
-    user.Password = requestData["password"].(string)
+    user.Username, _ = requestData["username"].(string)
+    user.Password, _ = requestData["password"].(string)
 
     // Validate username and password
     if user.Username == "" || user.Password == "" {
-        http.Error(w, "Username and password are required", http.StatusBadRequest)
+        problem.Error(w, r, "Username and password are required", http.StatusBadRequest)
         return
     }
 
     // Check if the username already exists
     existingUser, _ := database.GetUserByUsername(user.Username)
     if existingUser != nil {
-        http.Error(w, "Username already exists", http.StatusConflict)
+        problem.Error(w, r, "Username already exists", http.StatusConflict)
         return
     }
 
@@ -206,14 +300,14 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
     // Save the user to DynamoDB
     err = database.SaveUser(user)
     if err != nil {
-        http.Error(w, "Failed to save user", http.StatusInternalServerError)
+        problem.Error(w, r, "Failed to save user", http.StatusInternalServerError)
         return
     }
 
     // Turn user to JSON
     userJSON, err := json.Marshal(user)
     if err != nil {
-        http.Error(w, "Failed to marshal user", http.StatusInternalServerError)
+        problem.Error(w, r, "Failed to marshal user", http.StatusInternalServerError)
         return
     }
 
@@ -221,7 +315,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
     // Send user to Kafka
     err = kafka.PushCommentToQueue("user", "registered", userByte)
     if err != nil {
-        http.Error(w, "Failed to push user to Kafka", http.StatusInternalServerError)
+        problem.Error(w, r, "Failed to push user to Kafka", http.StatusInternalServerError)
 		fmt.Printf("Failed to push user to Kafka: %v\n", err)
         return
     }
@@ -2,22 +2,26 @@ package authmiddleware
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
+
+	problem "github.com/Draupniyr/carts-service/problem"
 )
 
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
 	jwt.StandardClaims
 }
 
-func Authorize(next http.Handler) http.Handler {
+func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
-			http.Error(w, "Missing token", http.StatusUnauthorized)
+			problem.Error(w, r, "Missing token", http.StatusUnauthorized)
 			return
 		}
 
@@ -28,17 +32,44 @@ func Authorize(next http.Handler) http.Handler {
 		})
 
 		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			problem.Error(w, r, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
 		claims := token.Claims.(*Claims)
 		userID := claims.UserID
 		userRole := claims.Audience
+		if allowedRoles != nil && !contains(allowedRoles, userRole) {
+			log.Println("Denied", r.Method, r.URL.Path, "to user", userID, "with role", userRole)
+			problem.Error(w, r, "Unauthorized", http.StatusForbidden)
+			return
+		}
 
 		// Pass the user information to the next handler
 		ctx := context.WithValue(r.Context(), "userID", userID)
 		ctx = context.WithValue(ctx, "userRole", userRole)
+		ctx = context.WithValue(ctx, "username", claims.Username)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Optional lets requests without a token through as anonymous, with no
+// userID in the context. A token that is sent still has to be valid.
+func Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		Authorize(next).ServeHTTP(w, r)
+	})
+}
+
+func contains(s []string, str string) bool {
+	for _, v := range s {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package authmiddleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Users looks up usernames in the auth service at BaseURL
+type Users struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewUsers uses AUTH_SERVICE_URL, or the auth service on the compose network
+func NewUsers() Users {
+	baseURL := os.Getenv("AUTH_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://auth-service:3000"
+	}
+	return Users{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Find returns the username as it was registered, false when nobody has it.
+// The auth service only answers signed in users, so the caller's
+// Authorization header is passed on.
+func (u Users) Find(authorization string, username string) (string, bool, error) {
+	request, err := http.NewRequest(http.MethodGet, u.BaseURL+"/auth/users/"+url.PathEscape(username), nil)
+	if err != nil {
+		return "", false, err
+	}
+	request.Header.Set("Authorization", authorization)
+	resp, err := u.HTTP.Do(request)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("auth service answered %s for user %s", resp.Status, username)
+	}
+
+	var user struct {
+		Username string `json:"username"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&user)
+	if err != nil {
+		return "", false, err
+	}
+	return user.Username, user.Username != "", nil
+}
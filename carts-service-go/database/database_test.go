@@ -0,0 +1,114 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+type item struct {
+	ID     string
+	UserID string
+	Count  int
+}
+
+func TestMemory(t *testing.T) {
+	db := Memory[item]{}
+	db.Init("Items", "ID")
+	err := db.BatchWrite([]item{{ID: "1", UserID: "alice"}, {ID: "2", UserID: "bob"}, {ID: "3", UserID: "alice"}}, nil)
+	if err != nil {
+		t.Fatalf("Error writing items: %v", err)
+	}
+
+	got, err := db.Get("2")
+	if err != nil || got.UserID != "bob" {
+		t.Errorf("Expected item 2 got %v %v", got, err)
+	}
+	_, err = db.Get("4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound got %v", err)
+	}
+
+	// the whole value has to match, alice doesn't find malice's items
+	db.Put(item{ID: "5", UserID: "malice"})
+	owned, err := db.Query("UserID", "alice")
+	if err != nil || len(owned) != 2 {
+		t.Errorf("Expected the two items of alice got %v %v", owned, err)
+	}
+	none, err := db.Query("UserID", "carol")
+	if err != nil || len(none) != 0 {
+		t.Errorf("Expected no items got %v %v", none, err)
+	}
+
+	batch, err := db.BatchGet([]string{"3", "4", "1"})
+	if err != nil || len(batch) != 2 || batch[0].ID != "3" || batch[1].ID != "1" {
+		t.Errorf("Expected items 3 and 1 got %v %v", batch, err)
+	}
+
+	err = db.BatchWrite([]item{{ID: "2", UserID: "bob", Count: 2}}, []string{"1", "3", "5"})
+	if err != nil {
+		t.Fatalf("Error writing items: %v", err)
+	}
+	all, _ := db.Scan()
+	if len(all) != 1 || all[0].Count != 2 {
+		t.Errorf("Expected only item 2 left got %v", all)
+	}
+}
+
+func TestMemoryCreate(t *testing.T) {
+	db := Memory[item]{}
+	db.Init("Items", "ID")
+	err := db.Create(item{ID: "1", Count: 1})
+	if err != nil {
+		t.Fatalf("Error creating the item: %v", err)
+	}
+	err = db.Create(item{ID: "1", Count: 2})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists got %v", err)
+	}
+	got, _ := db.Get("1")
+	if got.Count != 1 {
+		t.Errorf("Expected the first item to be kept got %v", got)
+	}
+}
+
+func TestMemoryPutIf(t *testing.T) {
+	db := Memory[item]{}
+	db.Init("Items", "ID")
+	db.Put(item{ID: "1", UserID: "alice"})
+	err := db.PutIf(item{ID: "1", UserID: "bob"}, "UserID", "alice")
+	if err != nil {
+		t.Fatalf("Error replacing the item: %v", err)
+	}
+	// the second writer still expects alice and loses
+	err = db.PutIf(item{ID: "1", UserID: "carol"}, "UserID", "alice")
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed got %v", err)
+	}
+	err = db.PutIf(item{ID: "2", UserID: "carol"}, "UserID", "alice")
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed for a missing item got %v", err)
+	}
+	all, _ := db.Scan()
+	if len(all) != 1 || all[0].UserID != "bob" {
+		t.Errorf("Expected only bob's item got %v", all)
+	}
+
+	// an unset attribute matches the empty value
+	db.Put(item{ID: "3"})
+	err = db.PutIf(item{ID: "3", UserID: "dave"}, "UserID", "")
+	if err != nil {
+		t.Errorf("Error replacing the item without a user: %v", err)
+	}
+	err = db.PutIf(item{ID: "3", UserID: "erin"}, "UserID", "")
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed for a set attribute got %v", err)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	keys := make([]string, 60)
+	got := chunks(keys, maxBatchWrite)
+	if len(got) != 3 || len(got[0]) != 25 || len(got[2]) != 10 {
+		t.Errorf("Unexpected chunks of %d, %d and %d", len(got[0]), len(got[1]), len(got[2]))
+	}
+}
@@ -0,0 +1,108 @@
+// Package games looks up games in the games service, which owns their prices,
+// discounts and release state.
+package games
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+// releaseUpcoming is the release state of games that aren't out yet, copied
+// over from the games service
+const releaseUpcoming = "upcoming"
+
+// Client reads games from the games service at BaseURL
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient uses GAMES_SERVICE_URL, or the games service on the compose network
+func NewClient() Client {
+	baseURL := os.Getenv("GAMES_SERVICE_URL")
+	if baseURL == "" {
+		baseURL = "http://games-service:3000"
+	}
+	return Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// game is a game as /games/api/{id} returns it, with only what the cart uses
+type game struct {
+	ID           string                   `json:"ID"`
+	Title        string                   `json:"Title"`
+	Description  string                   `json:"Description"`
+	Tags         []string                 `json:"Tags"`
+	Price        structs.Money            `json:"Price"`
+	Prices       map[string]structs.Money `json:"Prices"`
+	Discounts    []structs.Discount       `json:"Discounts"`
+	Published    string                   `json:"Published"`
+	Author       string                   `json:"Author"`
+	AuthorID     string                   `json:"AuthorID"`
+	Kind         string                   `json:"Kind"`
+	ParentID     string                   `json:"ParentID"`
+	BundleGames  []game                   `json:"BundleGames"`
+	ReleaseState string                   `json:"ReleaseState"`
+	PreOrders    bool                     `json:"PreOrders"`
+}
+
+// Get returns the game as it is sold now, false when the games service
+// doesn't have it
+func (c Client) Get(gameID string) (structs.StoreGame, bool, error) {
+	resp, err := c.HTTP.Get(c.BaseURL + "/games/api/" + url.PathEscape(gameID))
+	if err != nil {
+		return structs.StoreGame{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return structs.StoreGame{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return structs.StoreGame{}, false, fmt.Errorf("games service answered %s for game %s", resp.Status, gameID)
+	}
+
+	var found game
+	err = json.NewDecoder(resp.Body).Decode(&found)
+	if err != nil {
+		return structs.StoreGame{}, false, err
+	}
+	return found.storeGame(), true, nil
+}
+
+func (g game) storeGame() structs.StoreGame {
+	sold := structs.Game{
+		ID:          g.ID,
+		Title:       g.Title,
+		Description: g.Description,
+		Tags:        g.Tags,
+		Price:       g.Price,
+		Prices:      g.Prices,
+		Discounts:   g.Discounts,
+		Published:   g.Published,
+		Author:      g.Author,
+		AuthorID:    g.AuthorID,
+		Kind:        g.Kind,
+		ParentID:    g.ParentID,
+	}
+	for _, item := range g.BundleGames {
+		sold.BundleItems = append(sold.BundleItems, structs.BundleItem{
+			ID:     item.ID,
+			Title:  item.Title,
+			Price:  item.Price,
+			Prices: item.Prices,
+		})
+	}
+	return structs.StoreGame{
+		Game:        sold,
+		Purchasable: g.ReleaseState != releaseUpcoming || g.PreOrders,
+	}
+}
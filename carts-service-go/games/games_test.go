@@ -0,0 +1,43 @@
+package games
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/games/api/Bundle1":
+			w.Write([]byte(`{"ID":"Bundle1","Title":"Bundle","Price":{"Amount":3000,"Currency":"USD"},"Kind":"bundle",
+				"BundleItems":["Game1"],"BundleGames":[{"ID":"Game1","Title":"One","Price":{"Amount":1000,"Currency":"USD"}}],
+				"ReleaseState":"released"}`))
+		case "/games/api/Game2":
+			w.Write([]byte(`{"ID":"Game2","ReleaseState":"upcoming"}`))
+		default:
+			http.Error(w, "game not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := Client{BaseURL: server.URL, HTTP: &http.Client{Timeout: time.Second}}
+
+	bundle, found, err := client.Get("Bundle1")
+	if err != nil || !found {
+		t.Fatalf("Expected the bundle got %v %v", found, err)
+	}
+	if !bundle.Purchasable || !bundle.IsBundle() || len(bundle.BundleItems) != 1 || bundle.BundleItems[0].Price.Amount != 1000 {
+		t.Errorf("Unexpected bundle %+v", bundle)
+	}
+
+	upcoming, found, err := client.Get("Game2")
+	if err != nil || !found || upcoming.Purchasable {
+		t.Errorf("Expected Game2 to be found and not purchasable got %+v %v %v", upcoming, found, err)
+	}
+
+	_, found, err = client.Get("Game3")
+	if err != nil || found {
+		t.Errorf("Expected Game3 to be missing got %v %v", found, err)
+	}
+}
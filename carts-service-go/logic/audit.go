@@ -0,0 +1,40 @@
+package logic
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+// PublishAudit sends the entry to the audit topic with the JSON of the
+// target before and after the action
+func PublishAudit(entry structs.Audit, before interface{}, after interface{}, now time.Time, kafka kafka.KafkaProducer) error {
+	var err error
+	entry.ID = uuid.New().String()
+	entry.Time = now.UTC().Format(time.RFC3339)
+	entry.Service = "carts"
+	entry.Before, err = auditJSON(before)
+	if err != nil {
+		return err
+	}
+	entry.After, err = auditJSON(after)
+	if err != nil {
+		return err
+	}
+	entryJson, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return kafka.PushCommentToQueue("audit", entry.TargetID, entryJson)
+}
+
+func auditJSON(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,45 @@
+package logic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+)
+
+func TestPublishAudit(t *testing.T) {
+	now := time.Now()
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "audit" {
+			t.Errorf("Expected topic audit got %s", msg.Topic)
+		}
+		value, _ := msg.Value.Encode()
+		entry := structs.Audit{}
+		json.Unmarshal(value, &entry)
+		if entry.ID == "" || entry.Service != "carts" || entry.Action != "refund.approve" || entry.ActorID != "Admin1" {
+			t.Errorf("Unexpected audit entry %+v", entry)
+		}
+		if entry.Before != nil || string(entry.After) != `{"ID":"Refund1"}` {
+			t.Errorf("Unexpected before %s and after %s", entry.Before, entry.After)
+		}
+		return nil
+	})
+	entry := structs.Audit{ActorID: "Admin1", Action: "refund.approve", TargetType: "refund", TargetID: "Refund1"}
+	err := PublishAudit(entry, nil, map[string]string{"ID": "Refund1"}, now, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Errorf("Error publishing audit entry: %v", err)
+	}
+
+	producer = mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	err = PublishAudit(entry, nil, nil, now, kafka.KafkaProducer{Producer: producer})
+	if err != sarama.ErrOutOfBrokers {
+		t.Errorf("Expected %v got %v", sarama.ErrOutOfBrokers, err)
+	}
+}
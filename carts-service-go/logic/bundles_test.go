@@ -0,0 +1,151 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama/mocks"
+
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+func TestPriceBundleTakesOffOwnedGames(t *testing.T) {
+	bundle := createTestBundle("Bundle1", usd(3000), "Game1", "Game2")
+	cart := structs.Cart{Games: []structs.Game{bundle}}
+
+	summary := PriceCart(cart, "USD", nil, nil, time.Now())
+	simpleAssert(t, usd(3000), summary.Total)
+
+	// Game1 is 10.00 of the 40.00 the games cost alone, so a quarter comes off
+	summary = PriceCart(cart, "USD", nil, map[string]bool{"Game1": true}, time.Now())
+	simpleAssert(t, "", summary.Error)
+	simpleAssert(t, usd(750), summary.Lines[0].OwnedDiscount)
+	simpleAssert(t, usd(750), summary.OwnedDiscount)
+	simpleAssert(t, usd(2250), summary.Total)
+
+	summary = PriceCart(cart, "USD", nil, map[string]bool{"Game1": true, "Game2": true}, time.Now())
+	if summary.Error == "" {
+		t.Errorf("Expected a bundle of owned games to be refused")
+	}
+}
+
+func TestPriceBundleOwnedAfterSale(t *testing.T) {
+	bundle := createTestBundle("Bundle1", usd(3000), "Game1", "Game2")
+	bundle.Discounts = []structs.Discount{{Type: structs.DiscountPercent, Value: 50}}
+	cart := structs.Cart{Games: []structs.Game{bundle}}
+
+	summary := PriceCart(cart, "USD", nil, map[string]bool{"Game2": true}, time.Now())
+	simpleAssert(t, usd(1500), summary.Discount)
+	// Game2 is three quarters of the value
+	simpleAssert(t, usd(1125), summary.OwnedDiscount)
+	simpleAssert(t, usd(375), summary.Total)
+}
+
+func TestPriceCartAlreadyOwned(t *testing.T) {
+	cart := structs.Cart{Games: []structs.Game{createTestGame("Game1")}}
+	summary := PriceCart(cart, "USD", nil, map[string]bool{"Game1": true}, time.Now())
+	if summary.Error == "" {
+		t.Errorf("Expected an owned game to be refused")
+	}
+
+	// it can still be bought for someone else
+	cart.Games[0].GiftTo = "friend"
+	summary = PriceCart(cart, "USD", nil, map[string]bool{"Game1": true}, time.Now())
+	simpleAssert(t, "", summary.Error)
+}
+
+func TestPriceDLCNeedsBaseGame(t *testing.T) {
+	dlc := createTestDLC("DLC1", "Game1")
+	cart := structs.Cart{Games: []structs.Game{dlc}}
+
+	summary := PriceCart(cart, "USD", nil, nil, time.Now())
+	if summary.Error == "" {
+		t.Errorf("Expected DLC without its base game to be refused")
+	}
+
+	summary = PriceCart(cart, "USD", nil, map[string]bool{"Game1": true}, time.Now())
+	simpleAssert(t, "", summary.Error)
+
+	// buying the base game in the same cart, or a bundle with it, is enough
+	cart.Games = append(cart.Games, createTestGame("Game1"))
+	summary = PriceCart(cart, "USD", nil, nil, time.Now())
+	simpleAssert(t, "", summary.Error)
+
+	cart.Games = []structs.Game{dlc, createTestBundle("Bundle1", usd(3000), "Game1", "Game2")}
+	summary = PriceCart(cart, "USD", nil, nil, time.Now())
+	simpleAssert(t, "", summary.Error)
+}
+
+func TestGetOwnedGames(t *testing.T) {
+	dbs := newCheckoutDatabases()
+	db.Init("Test", "ID")
+	CreateORUpdateCart("TestID1", "Bundle1", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game3", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+	order, err := Checkout("TestID1", "tester", "USD", tax.Location{}, false, dbs, k)
+	if err != nil {
+		t.Fatalf("Error checking out: %v", err)
+	}
+
+	owned, err := GetOwnedGames("TestID1", dbs.ownership())
+	if err != nil {
+		t.Fatalf("Error getting owned games: %v", err)
+	}
+	simpleAssert(t, true, owned["Game1"])
+	simpleAssert(t, true, owned["Game2"])
+	simpleAssert(t, true, owned["Game3"])
+	simpleAssert(t, false, owned["Bundle1"])
+
+	// refunding the bundle takes both games back
+	refundDBs := RefundDatabases{Orders: dbs.Orders, Refunds: dbs.Refunds, Wallets: dbs.Wallets}
+	refund, err := RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Bundle1"}, DefaultRefundPolicy, refundDBs, k)
+	if err != nil {
+		t.Fatalf("Error requesting refund: %v", err)
+	}
+	ApproveRefund(refund.ID, "Admin", refundDBs, k)
+
+	owned, _ = GetOwnedGames("TestID1", dbs.ownership())
+	simpleAssert(t, false, owned["Game1"])
+	simpleAssert(t, false, owned["Game2"])
+	simpleAssert(t, true, owned["Game3"])
+}
+
+func TestCheckoutRefusesDLCWithoutBase(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateORUpdateCart("TestID1", "DLC1", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	_, err := Checkout("TestID1", "tester", "USD", tax.Location{}, false, newCheckoutDatabases(), kafka.KafkaProducer{Producer: producer})
+	if err == nil {
+		t.Errorf("Expected checkout to fail without the base game")
+	}
+}
+
+// createTestBundle is a bundle of games that cost 10.00, 30.00 and so on alone
+func createTestBundle(id string, price structs.Money, items ...string) structs.Game {
+	bundle := createTestGame(id)
+	bundle.Kind = structs.KindBundle
+	bundle.Price = price
+	for i, item := range items {
+		bundle.BundleItems = append(bundle.BundleItems, structs.BundleItem{
+			ID:    item,
+			Title: item,
+			Price: usd(int64(1000 + 2000*i)),
+		})
+	}
+	return bundle
+}
+
+func createTestDLC(id string, parentID string) structs.Game {
+	dlc := createTestGame(id)
+	dlc.Kind = structs.KindDLC
+	dlc.ParentID = parentID
+	return dlc
+}
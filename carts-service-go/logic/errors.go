@@ -0,0 +1,57 @@
+package logic
+
+import (
+	"errors"
+
+	database "github.com/Draupniyr/carts-service/database"
+)
+
+// Kinds of error the logic returns. The handlers turn them into 400, 404,
+// 409 and 422, the errors wrap one of these, check with errors.Is.
+var (
+	ErrInvalid    = errors.New("invalid")
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrNotAllowed = errors.New("not allowed")
+)
+
+// kindError is an error of one of the kinds above with its own message
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+func invalid(message string) error {
+	return &kindError{kind: ErrInvalid, message: message}
+}
+
+func notFound(message string) error {
+	return &kindError{kind: ErrNotFound, message: message}
+}
+
+func conflict(message string) error {
+	return &kindError{kind: ErrConflict, message: message}
+}
+
+// notAllowed is for requests that are well formed but break a rule of the
+// store, like refunding a game past the refund window
+func notAllowed(message string) error {
+	return &kindError{kind: ErrNotAllowed, message: message}
+}
+
+// missing turns the database not finding anything into the not found error
+// of the logic, other errors are left as they are
+func missing(err error, notFound error) error {
+	if errors.Is(err, database.ErrNotFound) {
+		return notFound
+	}
+	return err
+}
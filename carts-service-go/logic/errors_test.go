@@ -0,0 +1,79 @@
+package logic
+
+import (
+	"errors"
+	"testing"
+
+	database "github.com/Draupniyr/carts-service/database"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{ErrCartNotFound, ErrNotFound},
+		{ErrCartEmpty, ErrInvalid},
+		{ErrCheckoutInProgress, ErrConflict},
+		{ErrGiftNotFound, ErrNotFound},
+		{ErrGiftAlreadyHandled, ErrConflict},
+		{ErrGiftToSelf, ErrInvalid},
+		{ErrNotInCart, ErrInvalid},
+		{ErrPromoNotFound, ErrNotFound},
+		{ErrPromoExists, ErrConflict},
+		{ErrPromoExpired, ErrConflict},
+		{ErrOrderNotFound, ErrNotFound},
+		{ErrRefundNotFound, ErrNotFound},
+		{ErrRefundExists, ErrConflict},
+		{ErrRefundWindowClosed, ErrNotAllowed},
+		{ErrNotRefundable, ErrNotAllowed},
+		{ErrInsufficientFunds, ErrNotAllowed},
+		{ErrInvalidAmount, ErrInvalid},
+		{ErrGiftCardNotFound, ErrNotFound},
+		{ErrCurrencyMismatch, ErrConflict},
+		{ErrGameNotFound, ErrNotFound},
+		{ErrGameNotSold, ErrNotAllowed},
+		{ErrNotPurchasable, ErrNotAllowed},
+		{ErrRecipientNotFound, ErrNotFound},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.kind) {
+			t.Errorf("Expected %q to be %v", test.err, test.kind)
+		}
+	}
+	simpleAssert(t, "cart is empty", ErrCartEmpty.Error())
+}
+
+// brokenDB fails every lookup the way an unreachable DynamoDB would
+type brokenDB[T any] struct {
+	database.Memory[T]
+}
+
+func (b *brokenDB[T]) Get(key string) (T, error) {
+	var item T
+	return item, errors.New("connection refused")
+}
+
+func (b *brokenDB[T]) Query(attribute string, value string) ([]T, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestNotFoundErrors(t *testing.T) {
+	orderDB := database.Memory[structs.Order]{}
+	orderDB.Init("Orders", "ID")
+	_, err := GetOrder("Missing", &orderDB)
+	simpleAssert(t, ErrOrderNotFound, err)
+	orders, err := GetOrders("Nobody", &orderDB)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, 0, len(orders))
+
+	// other database errors aren't mistaken for nothing being there
+	broken := &brokenDB[structs.Order]{}
+	_, err = GetOrder("Order1", broken)
+	simpleAssert(t, false, errors.Is(err, ErrNotFound))
+	_, err = GetOrders("TestID1", broken)
+	simpleAssert(t, "connection refused", err.Error())
+	_, err = GetWallet("TestID1", &brokenDB[structs.WalletEntry]{})
+	simpleAssert(t, "connection refused", err.Error())
+}
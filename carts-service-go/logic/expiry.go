@@ -0,0 +1,190 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+// how long a cart lives after its last change, guests get less
+var (
+	CartTTL      = 30 * 24 * time.Hour
+	GuestCartTTL = 7 * 24 * time.Hour
+)
+
+const guestPrefix = "guest_"
+
+// ----------------- Expiry -----------------
+
+// loadCart reads the user's cart. A cart past its expiry that DynamoDB hasn't
+// removed yet counts as empty, but keeps its ID so it gets reused.
+func loadCart(userID string, db database.Repository[structs.Cart]) (structs.Cart, bool) {
+	cart, err := findCart(userID, db)
+	if err != nil {
+		return structs.Cart{}, false
+	}
+	if cartExpired(cart, time.Now()) {
+		cart.Games = []structs.Game{}
+		cart.PromoCode = ""
+	}
+	return cart, true
+}
+
+// findCart reads the user's cart as it is stored, or ErrCartNotFound
+func findCart(userID string, db database.Repository[structs.Cart]) (structs.Cart, error) {
+	carts, err := db.Query("UserID", userID)
+	if err != nil {
+		return structs.Cart{}, err
+	}
+	if len(carts) == 0 {
+		return structs.Cart{}, ErrCartNotFound
+	}
+	return carts[0], nil
+}
+
+// saveCart stamps the cart with the time it changed and pushes its expiry out
+func saveCart(cart structs.Cart, db database.Repository[structs.Cart]) (structs.Cart, error) {
+	touchCart(&cart, time.Now())
+	err := db.Put(cart)
+	return cart, err
+}
+
+func touchCart(cart *structs.Cart, now time.Time) {
+	ttl := CartTTL
+	if IsGuest(cart.UserID) {
+		ttl = GuestCartTTL
+	}
+	cart.Updated = now.UTC().Format(time.RFC3339)
+	cart.ExpiresAt = now.Add(ttl).Unix()
+}
+
+func cartExpired(cart structs.Cart, now time.Time) bool {
+	return cart.ExpiresAt != 0 && cart.ExpiresAt <= now.Unix()
+}
+
+// ----------------- Abandoned carts -----------------
+
+// PublishAbandonedCarts sends cart.abandoned for every signed in user's cart
+// with games in it that hasn't changed for idle. Carts saved before expiry
+// existed get stamped so they start ageing from now.
+func PublishAbandonedCarts(idle time.Duration, db database.Repository[structs.Cart], noticeDB database.Repository[structs.AbandonedCartNotice], kafka kafka.KafkaProducer) (int, error) {
+	carts, err := db.Scan()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	published := 0
+	for _, cart := range carts {
+		if cart.Updated == "" {
+			touchCart(&cart, now)
+			err = db.Put(cart)
+			if err != nil {
+				log.Println("Error stamping cart", cart.ID, ":", err)
+			}
+			continue
+		}
+		if IsGuest(cart.UserID) || len(cart.Games) == 0 || cartExpired(cart, now) {
+			continue
+		}
+		updated, err := time.Parse(time.RFC3339, cart.Updated)
+		if err != nil || now.Sub(updated) < idle {
+			continue
+		}
+
+		notice := structs.AbandonedCartNotice{
+			ID:        cart.ID + "_" + cart.Updated,
+			CartID:    cart.ID,
+			UserID:    cart.UserID,
+			Date:      now.Format(time.RFC3339),
+			ExpiresAt: cart.ExpiresAt,
+		}
+		err = noticeDB.Create(notice)
+		if errors.Is(err, database.ErrAlreadyExists) {
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+
+		abandonedJson, err := json.Marshal(structs.AbandonedCart{
+			CartID:  cart.ID,
+			UserID:  cart.UserID,
+			Games:   cart.Games,
+			Updated: cart.Updated,
+			Date:    notice.Date,
+		})
+		if err != nil {
+			return published, err
+		}
+		err = kafka.PushCommentToQueue("cart.abandoned", cart.UserID, abandonedJson)
+		if err != nil {
+			// let the next run try again
+			noticeDB.Delete(notice.ID)
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// ----------------- Guest carts -----------------
+
+// GuestUserID is the cart owner for the guest cookie value
+func GuestUserID(token string) string {
+	return guestPrefix + token
+}
+
+func IsGuest(userID string) bool {
+	return strings.HasPrefix(userID, guestPrefix)
+}
+
+// MergeGuestCart moves the games in the guest cart into the user's cart and
+// removes the guest cart. Games already in the user's cart aren't added twice.
+func MergeGuestCart(guestID string, userID string, db database.Repository[structs.Cart]) (*structs.Cart, error) {
+	if !IsGuest(guestID) || IsGuest(userID) {
+		return nil, invalid("can only merge a guest cart into a user's cart")
+	}
+	guestCart, ok := loadCart(guestID, db)
+	if !ok {
+		return nil, nil
+	}
+
+	cart, ok := loadCart(userID, db)
+	if !ok {
+		cart = structs.Cart{
+			ID:     structs.GetNewUUID(),
+			UserID: userID,
+			Games:  []structs.Game{},
+		}
+	}
+	for _, game := range guestCart.Games {
+		if !cartContains(cart, game.ID) {
+			cart.Games = append(cart.Games, game)
+		}
+	}
+	cart, err := saveCart(cart, db)
+	if err != nil {
+		log.Println("Error saving merged cart:", err)
+		return nil, err
+	}
+	err = db.Delete(guestCart.ID)
+	if err != nil {
+		log.Println("Error deleting guest cart:", err)
+	}
+	return &cart, nil
+}
+
+func cartContains(cart structs.Cart, gameID string) bool {
+	for _, game := range cart.Games {
+		if game.ID == gameID {
+			return true
+		}
+	}
+	return false
+}
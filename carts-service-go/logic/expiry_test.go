@@ -0,0 +1,110 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+func TestGetCartDoesNotCreate(t *testing.T) {
+	db.Init("Test", "ID")
+	cart, err := GetCart("TestID1", &db)
+	if err != nil {
+		t.Errorf("Error getting cart: %v", err)
+	}
+	simpleAssert(t, 0, len(cart.Games))
+	simpleAssert(t, 0, len(db.Items))
+}
+
+func TestSavedCartGetsExpiry(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart(GuestUserID("Guest1"), "Game1", testGames, &db)
+
+	cart, _ := GetCart("TestID1", &db)
+	if cart.ExpiresAt < time.Now().Add(CartTTL-time.Minute).Unix() {
+		t.Errorf("Expected the cart to expire in %v got %v", CartTTL, cart.ExpiresAt)
+	}
+	guestCart, _ := GetCart(GuestUserID("Guest1"), &db)
+	if guestCart.ExpiresAt > time.Now().Add(GuestCartTTL).Unix() {
+		t.Errorf("Expected the guest cart to expire in %v got %v", GuestCartTTL, guestCart.ExpiresAt)
+	}
+}
+
+func TestExpiredCartIsEmpty(t *testing.T) {
+	db.Init("Test", "ID")
+	cart := CreateTestCart("TestID1", createTestGame("Game1"))
+	cart.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	db.Items = append(db.Items, cart)
+
+	got, _ := GetCart("TestID1", &db)
+	simpleAssert(t, 0, len(got.Games))
+
+	// adding to it reuses the expired cart instead of making a second one
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
+	simpleAssert(t, 1, len(db.Items))
+	got, _ = GetCart("TestID1", &db)
+	simpleAssert(t, 1, len(got.Games))
+	simpleAssert(t, "Game2", got.Games[0].ID)
+}
+
+func TestMergeGuestCart(t *testing.T) {
+	db.Init("Test", "ID")
+	guestID := GuestUserID("Guest1")
+	CreateORUpdateCart(guestID, "Game1", testGames, &db)
+	CreateORUpdateCart(guestID, "Game2", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
+
+	cart, err := MergeGuestCart(guestID, "TestID1", &db)
+	if err != nil {
+		t.Errorf("Error merging guest cart: %v", err)
+	}
+	simpleAssert(t, 2, len(cart.Games))
+	simpleAssert(t, 1, len(db.Items))
+
+	// nothing to merge the second time
+	cart, err = MergeGuestCart(guestID, "TestID1", &db)
+	if err != nil || cart != nil {
+		t.Errorf("Expected nothing to merge got %v %v", cart, err)
+	}
+}
+
+func TestPublishAbandonedCarts(t *testing.T) {
+	db.Init("Test", "ID")
+	noticeDB := database.Memory[structs.AbandonedCartNotice]{}
+	noticeDB.Init("AbandonedCartNotices", "ID")
+
+	idle := CreateTestCart("TestID1", createTestGame("Game1"))
+	touchCart(&idle, time.Now().Add(-48*time.Hour))
+	fresh := CreateTestCart("TestID2", createTestGame("Game1"))
+	touchCart(&fresh, time.Now())
+	guest := CreateTestCart(GuestUserID("Guest1"), createTestGame("Game1"))
+	touchCart(&guest, time.Now().Add(-48*time.Hour))
+	legacy := CreateTestCart("TestID3", createTestGame("Game1"))
+	db.Items = append(db.Items, idle, fresh, guest, legacy)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+
+	published, err := PublishAbandonedCarts(24*time.Hour, &db, &noticeDB, k)
+	if err != nil {
+		t.Errorf("Error publishing abandoned carts: %v", err)
+	}
+	simpleAssert(t, 1, published)
+
+	// the same cart isn't reported twice
+	published, _ = PublishAbandonedCarts(24*time.Hour, &db, &noticeDB, k)
+	simpleAssert(t, 0, published)
+
+	// the legacy cart got stamped so it starts ageing
+	legacyCart, _ := GetCart("TestID3", &db)
+	if legacyCart.Updated == "" {
+		t.Errorf("Expected the legacy cart to be stamped")
+	}
+}
@@ -0,0 +1,56 @@
+package logic
+
+import (
+	"errors"
+
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+var (
+	ErrGameNotFound   = notFound("game not found")
+	ErrGameNotSold    = notAllowed("a game in the cart is no longer sold")
+	ErrNotPurchasable = notAllowed("this game can't be bought before it comes out")
+)
+
+// GameLookup returns the game as the games service sells it, false when there
+// is no such game. The cart never trusts the copy of a game the client sends,
+// its price, discounts, kind and contents always come from here.
+type GameLookup func(gameID string) (structs.StoreGame, bool, error)
+
+// storeGame is the game to put in a cart
+func storeGame(gameID string, lookup GameLookup) (structs.Game, error) {
+	if gameID == "" {
+		return structs.Game{}, ErrGameNotFound
+	}
+	game, found, err := lookup(gameID)
+	if err != nil {
+		return structs.Game{}, err
+	}
+	if !found || game.ID != gameID {
+		return structs.Game{}, ErrGameNotFound
+	}
+	if !game.Purchasable {
+		return structs.Game{}, ErrNotPurchasable
+	}
+	return game.Game, nil
+}
+
+// refreshCart swaps every game in the cart for the one the games service
+// sells now, so checkout charges today's prices for games still on sale.
+// Only the gift recipient the buyer picked is kept from the line.
+func refreshCart(cart structs.Cart, lookup GameLookup) (structs.Cart, error) {
+	games := []structs.Game{}
+	for _, line := range cart.Games {
+		game, err := storeGame(line.ID, lookup)
+		if errors.Is(err, ErrGameNotFound) {
+			return cart, ErrGameNotSold
+		}
+		if err != nil {
+			return cart, err
+		}
+		game.GiftTo = line.GiftTo
+		games = append(games, game)
+	}
+	cart.Games = games
+	return cart, nil
+}
@@ -0,0 +1,92 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama/mocks"
+
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+// sells is a games service selling only these games
+func sells(games ...structs.StoreGame) GameLookup {
+	return func(gameID string) (structs.StoreGame, bool, error) {
+		for _, game := range games {
+			if game.ID == gameID {
+				return game, true, nil
+			}
+		}
+		return structs.StoreGame{}, false, nil
+	}
+}
+
+func TestAddToCartLooksUpTheGame(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1")
+	game.Price = usd(5000)
+	upcoming := createTestGame("Game2")
+	lookup := sells(structs.StoreGame{Game: game, Purchasable: true}, structs.StoreGame{Game: upcoming})
+
+	err := CreateORUpdateCart("TestID1", "Game1", lookup, &db)
+	if err != nil {
+		t.Fatalf("Error adding game: %v", err)
+	}
+	cart, _ := GetCart("TestID1", &db)
+	simpleAssert(t, usd(5000), cart.Games[0].Price)
+
+	_, err = AddOrRemoveFromCart("TestID1", "Game2", lookup, &db)
+	simpleAssert(t, ErrNotPurchasable, err)
+	_, err = AddOrRemoveFromCart("TestID1", "Game3", lookup, &db)
+	simpleAssert(t, ErrGameNotFound, err)
+	err = CreateORUpdateCart("TestID2", "Game3", lookup, &db)
+	simpleAssert(t, ErrGameNotFound, err)
+
+	// taking a game out doesn't need the games service
+	updated, err := AddOrRemoveFromCart("TestID1", "Game1", sells(), &db)
+	if err != nil {
+		t.Fatalf("Error removing game: %v", err)
+	}
+	simpleAssert(t, 0, len(updated.Games))
+}
+
+func TestCheckoutChargesTheCurrentPrice(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	// a cart saved with a price the games service never asked for
+	stale := createTestGame("Game1")
+	stale.Price = usd(1)
+	stale.GiftTo = "bob"
+	db.Put(CreateTestCart("TestID1", stale))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed() // checkout
+	producer.ExpectSendMessageAndSucceed() // gift.sent
+	order, err := Checkout("TestID1", "alice", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error checking out: %v", err)
+	}
+	simpleAssert(t, usd(1234), order.Total)
+	simpleAssert(t, "bob", order.Gifts[0].RecipientUsername)
+}
+
+func TestCheckoutRefusesGamesNoLongerSold(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
+
+	dbs := newCheckoutDatabases()
+	producer := mocks.NewSyncProducer(t, nil)
+	k := kafka.KafkaProducer{Producer: producer}
+	dbs.Games = sells(structs.StoreGame{Game: createTestGame("Game1"), Purchasable: true})
+	_, err := Checkout("TestID1", "tester", "USD", tax.Location{}, false, dbs, k)
+	simpleAssert(t, ErrGameNotSold, err)
+
+	dbs.Games = sells(structs.StoreGame{Game: createTestGame("Game1"), Purchasable: true}, structs.StoreGame{Game: createTestGame("Game2")})
+	_, err = Checkout("TestID1", "tester", "USD", tax.Location{}, false, dbs, k)
+	simpleAssert(t, ErrNotPurchasable, err)
+
+	orders, _ := GetOrders("TestID1", dbs.Orders)
+	simpleAssert(t, 0, len(orders))
+}
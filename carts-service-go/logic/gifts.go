@@ -0,0 +1,265 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+var (
+	ErrGiftNotFound       = notFound("gift not found")
+	ErrGiftAlreadyHandled = conflict("gift was already accepted, declined or cancelled")
+	ErrNotInCart          = invalid("game is not in the cart")
+	ErrGiftToSelf         = invalid("cannot gift a game to yourself")
+	ErrRecipientNotFound  = notFound("no user has that username")
+)
+
+// UserLookup returns the username as it was registered, which may differ in
+// case from the one asked for, and false when nobody has it
+type UserLookup func(username string) (string, bool, error)
+
+// ----------------- Gifts -----------------
+
+// SetGiftRecipient marks a cart line as a gift for another user. An empty
+// recipient turns it back into a purchase for the buyer. The recipient has to
+// exist, and is kept the way they registered so their inbox finds the gift.
+func SetGiftRecipient(userID string, username string, gameID string, recipient string, users UserLookup, db database.Repository[structs.Cart]) (*structs.Cart, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient != "" {
+		registered, found, err := users(recipient)
+		if err != nil {
+			log.Println("Error looking up gift recipient:", err)
+			return nil, err
+		}
+		if !found {
+			return nil, ErrRecipientNotFound
+		}
+		recipient = registered
+	}
+	if recipient != "" && strings.EqualFold(recipient, username) {
+		return nil, ErrGiftToSelf
+	}
+
+	cart, err := findCart(userID, db)
+	if err != nil {
+		log.Println("Error getting cart:", err)
+		return nil, err
+	}
+	found := false
+	for i := range cart.Games {
+		if cart.Games[i].ID == gameID {
+			cart.Games[i].GiftTo = recipient
+			found = true
+		}
+	}
+	if !found {
+		return nil, ErrNotInCart
+	}
+
+	cart, err = saveCart(cart, db)
+	if err != nil {
+		log.Println("Error updating cart:", err)
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// GetGiftInbox returns the gifts waiting for the user to accept or decline
+func GetGiftInbox(username string, giftDB database.Repository[structs.Gift]) ([]structs.Gift, error) {
+	gifts, err := giftDB.Query("RecipientUsername", username)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []structs.Gift{}
+	for _, gift := range gifts {
+		if strings.EqualFold(gift.RecipientUsername, username) && gift.Status == structs.GiftPending {
+			pending = append(pending, gift)
+		}
+	}
+	return pending, nil
+}
+
+// GetSentGifts returns the gifts the user sent that nobody has answered yet
+func GetSentGifts(senderID string, giftDB database.Repository[structs.Gift]) ([]structs.Gift, error) {
+	gifts, err := giftDB.Query("SenderID", senderID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []structs.Gift{}
+	for _, gift := range gifts {
+		if gift.SenderID == senderID && gift.Status == structs.GiftPending {
+			pending = append(pending, gift)
+		}
+	}
+	return pending, nil
+}
+
+// AcceptGift hands ownership to the recipient by publishing gift.accepted
+func AcceptGift(giftID string, userID string, username string, giftDB database.Repository[structs.Gift], kafka kafka.KafkaProducer) (*structs.Gift, error) {
+	gift, err := getPendingGift(giftID, username, giftDB)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := *gift
+	gift.Status = structs.GiftAccepted
+	gift.RecipientID = userID
+	gift.Responded = time.Now().Format(time.RFC3339)
+	err = respondGift(*gift, giftDB)
+	if err != nil {
+		return nil, err
+	}
+	err = publishGift("gift.accepted", *gift, kafka)
+	if err != nil {
+		// without the event the recipient never gets the game, so leave it pending
+		giftDB.PutIf(pending, "Status", structs.GiftAccepted)
+		return nil, err
+	}
+	return gift, nil
+}
+
+// DeclineGift sends the gift back and refunds what the buyer paid to their
+// wallet
+func DeclineGift(giftID string, userID string, username string, giftDB database.Repository[structs.Gift], walletDB database.Repository[structs.WalletEntry], kafka kafka.KafkaProducer) (*structs.Gift, error) {
+	gift, err := getPendingGift(giftID, username, giftDB)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := *gift
+	gift.Status = structs.GiftDeclined
+	gift.RecipientID = userID
+	gift.Responded = time.Now().Format(time.RFC3339)
+	err = returnGift(*gift, pending, "gift-declined", giftDB, walletDB)
+	if err != nil {
+		return nil, err
+	}
+	err = publishGift("gift.declined", *gift, kafka)
+	if err != nil {
+		log.Println("Error pushing gift to kafka:", err)
+	}
+	return gift, nil
+}
+
+// CancelGift lets the buyer take back a gift nobody has answered, like one
+// sent to the wrong person, and refunds it to their wallet
+func CancelGift(giftID string, senderID string, giftDB database.Repository[structs.Gift], walletDB database.Repository[structs.WalletEntry], kafka kafka.KafkaProducer) (*structs.Gift, error) {
+	gift, err := giftDB.Get(giftID)
+	if err != nil {
+		return nil, missing(err, ErrGiftNotFound)
+	}
+	if gift.SenderID != senderID {
+		return nil, ErrGiftNotFound
+	}
+	if gift.Status != structs.GiftPending {
+		return nil, ErrGiftAlreadyHandled
+	}
+
+	pending := gift
+	gift.Status = structs.GiftCancelled
+	gift.Responded = time.Now().Format(time.RFC3339)
+	err = returnGift(gift, pending, "gift-cancelled", giftDB, walletDB)
+	if err != nil {
+		return nil, err
+	}
+	err = publishGift("gift.cancelled", gift, kafka)
+	if err != nil {
+		log.Println("Error pushing gift to kafka:", err)
+	}
+	return &gift, nil
+}
+
+// returnGift saves the declined or cancelled gift and then credits the buyer.
+// The gift is decided before the buyer is credited, so of two answers at once
+// only one can pay.
+func returnGift(gift structs.Gift, pending structs.Gift, reason string, giftDB database.Repository[structs.Gift], walletDB database.Repository[structs.WalletEntry]) error {
+	err := respondGift(gift, giftDB)
+	if err != nil {
+		return err
+	}
+	if gift.Price.Amount <= 0 || walletCredited(gift.SenderID, reason, gift.ID, walletDB) {
+		return nil
+	}
+	_, err = CreditWallet(gift.SenderID, gift.Price, reason, gift.ID, walletDB)
+	if err != nil {
+		// back to pending so it can be answered again
+		log.Println("Error refunding gift", gift.ID, ":", err)
+		if rollbackErr := giftDB.PutIf(pending, "Status", gift.Status); rollbackErr != nil {
+			log.Println("Error putting gift back to pending:", rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func getPendingGift(giftID string, username string, giftDB database.Repository[structs.Gift]) (*structs.Gift, error) {
+	gift, err := giftDB.Get(giftID)
+	if err != nil {
+		return nil, missing(err, ErrGiftNotFound)
+	}
+	if !strings.EqualFold(gift.RecipientUsername, username) {
+		return nil, ErrGiftNotFound
+	}
+	if gift.Status != structs.GiftPending {
+		return nil, ErrGiftAlreadyHandled
+	}
+	return &gift, nil
+}
+
+// respondGift saves the answer only if the gift is still pending, so it can't
+// be both accepted and declined
+func respondGift(gift structs.Gift, giftDB database.Repository[structs.Gift]) error {
+	err := giftDB.PutIf(gift, "Status", structs.GiftPending)
+	if errors.Is(err, database.ErrConditionFailed) {
+		return ErrGiftAlreadyHandled
+	}
+	if err != nil {
+		log.Println("Error updating gift:", err)
+	}
+	return err
+}
+
+func newGift(order structs.Order, senderUsername string, line structs.CartLine) structs.Gift {
+	return structs.Gift{
+		ID:                structs.GetNewUUID(),
+		OrderID:           order.ID,
+		SenderID:          order.UserID,
+		SenderUsername:    senderUsername,
+		RecipientUsername: line.Game.GiftTo,
+		Game:              line.Game,
+		Price:             line.Total,
+		Status:            structs.GiftPending,
+		Sent:              order.Date,
+	}
+}
+
+// cancelGifts removes the gifts of an order that failed to go through
+func cancelGifts(order structs.Order, giftDB database.Repository[structs.Gift]) {
+	for _, gift := range order.Gifts {
+		err := giftDB.Delete(gift.ID)
+		if err != nil {
+			log.Println("Error removing gift", gift.ID, ":", err)
+		}
+	}
+}
+
+// publishGift keys gift events by the recipient, by ID once they have answered
+func publishGift(topic string, gift structs.Gift, kafka kafka.KafkaProducer) error {
+	giftJson, err := json.Marshal(gift)
+	if err != nil {
+		return err
+	}
+	key := gift.RecipientID
+	if key == "" {
+		key = gift.RecipientUsername
+	}
+	return kafka.PushCommentToQueue(topic, key, giftJson)
+}
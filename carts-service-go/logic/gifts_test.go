@@ -0,0 +1,233 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+func TestCheckoutSendsGiftInsteadOfGranting(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
+	SetGiftRecipient("TestID1", "alice", "Game2", "bob", testUsers, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed() // checkout
+	producer.ExpectSendMessageAndSucceed() // gift.sent
+	order, err := Checkout("TestID1", "alice", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Errorf("Error checking out: %v", err)
+	}
+
+	simpleAssert(t, 1, len(order.Games))
+	simpleAssert(t, "Game1", order.Games[0].ID)
+	simpleAssert(t, 1, len(order.Gifts))
+	inbox, _ := GetGiftInbox("bob", dbs.Gifts)
+	simpleAssert(t, 1, len(inbox))
+	simpleAssert(t, "Game2", inbox[0].Game.ID)
+	simpleAssert(t, "alice", inbox[0].SenderUsername)
+}
+
+func TestSetGiftRecipientToSelf(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	_, err := SetGiftRecipient("TestID1", "alice", "Game1", "Alice", testUsers, &db)
+	simpleAssert(t, ErrGiftToSelf, err)
+}
+
+func TestSetGiftRecipientChecksTheUser(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	_, err := SetGiftRecipient("TestID1", "alice", "Game1", "bbo", testUsers, &db)
+	simpleAssert(t, ErrRecipientNotFound, err)
+
+	// the name is kept the way bob registered it
+	cart, err := SetGiftRecipient("TestID1", "alice", "Game1", " BOB ", testUsers, &db)
+	if err != nil {
+		t.Fatalf("Error setting gift recipient: %v", err)
+	}
+	simpleAssert(t, "bob", cart.Games[0].GiftTo)
+
+	cart, _ = SetGiftRecipient("TestID1", "alice", "Game1", "", testUsers, &db)
+	simpleAssert(t, "", cart.Games[0].GiftTo)
+}
+
+func TestGiftInboxOnlyMatchesExactUsername(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+	giftDB.Put(createTestGift("Gift2", "bobby"))
+
+	inbox, _ := GetGiftInbox("bob", &giftDB)
+	simpleAssert(t, 1, len(inbox))
+	simpleAssert(t, "Gift1", inbox[0].ID)
+}
+
+func TestAcceptGift(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	gift, err := AcceptGift("Gift1", "BobID", "bob", &giftDB, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Errorf("Error accepting gift: %v", err)
+	}
+	simpleAssert(t, structs.GiftAccepted, gift.Status)
+	simpleAssert(t, "BobID", gift.RecipientID)
+
+	_, err = AcceptGift("Gift1", "BobID", "bob", &giftDB, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, ErrGiftAlreadyHandled, err)
+}
+
+func TestAcceptSomeoneElsesGift(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	_, err := AcceptGift("Gift1", "EveID", "eve", &giftDB, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, ErrGiftNotFound, err)
+}
+
+func TestDeclineGiftRefundsBuyer(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	_, err := DeclineGift("Gift1", "BobID", "bob", &giftDB, &walletDB, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Errorf("Error declining gift: %v", err)
+	}
+
+	wallet, _ := GetWallet("SenderID", &walletDB)
+	simpleAssert(t, usd(1234), wallet.Balance)
+	inbox, _ := GetGiftInbox("bob", &giftDB)
+	simpleAssert(t, 0, len(inbox))
+}
+
+// staleGifts reads every gift as still pending, like a second tab opened
+// before the gift was answered
+type staleGifts struct {
+	database.Repository[structs.Gift]
+}
+
+func (s staleGifts) Get(key string) (structs.Gift, error) {
+	gift, err := s.Repository.Get(key)
+	gift.Status = structs.GiftPending
+	return gift, err
+}
+
+func TestDeclineAcceptedGift(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	_, err := AcceptGift("Gift1", "BobID", "bob", &giftDB, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error accepting gift: %v", err)
+	}
+
+	// the second answer loses before anything is paid
+	stale := staleGifts{&giftDB}
+	_, err = DeclineGift("Gift1", "BobID", "bob", stale, &walletDB, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, ErrGiftAlreadyHandled, err)
+	_, err = AcceptGift("Gift1", "BobID", "bob", stale, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, ErrGiftAlreadyHandled, err)
+
+	wallet, _ := GetWallet("SenderID", &walletDB)
+	simpleAssert(t, 0, len(wallet.Entries))
+	gift, _ := giftDB.Get("Gift1")
+	simpleAssert(t, structs.GiftAccepted, gift.Status)
+}
+
+func TestDeclineGiftCreditsOnce(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+	// a decline that credited the buyer and then failed half way
+	CreditWallet("SenderID", usd(1234), "gift-declined", "Gift1", &walletDB)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	_, err := DeclineGift("Gift1", "BobID", "bob", &giftDB, &walletDB, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error declining gift: %v", err)
+	}
+	wallet, _ := GetWallet("SenderID", &walletDB)
+	simpleAssert(t, usd(1234), wallet.Balance)
+}
+
+func TestCancelGift(t *testing.T) {
+	giftDB := database.Memory[structs.Gift]{}
+	giftDB.Init("Gifts", "ID")
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+	giftDB.Put(createTestGift("Gift1", "bob"))
+
+	sent, _ := GetSentGifts("SenderID", &giftDB)
+	simpleAssert(t, 1, len(sent))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+	_, err := CancelGift("Gift1", "BobID", &giftDB, &walletDB, k)
+	simpleAssert(t, ErrGiftNotFound, err)
+	gift, err := CancelGift("Gift1", "SenderID", &giftDB, &walletDB, k)
+	if err != nil {
+		t.Fatalf("Error cancelling gift: %v", err)
+	}
+	simpleAssert(t, structs.GiftCancelled, gift.Status)
+
+	wallet, _ := GetWallet("SenderID", &walletDB)
+	simpleAssert(t, usd(1234), wallet.Balance)
+	_, err = AcceptGift("Gift1", "BobID", "bob", &giftDB, k)
+	simpleAssert(t, ErrGiftAlreadyHandled, err)
+	_, err = CancelGift("Gift1", "SenderID", &giftDB, &walletDB, k)
+	simpleAssert(t, ErrGiftAlreadyHandled, err)
+	sent, _ = GetSentGifts("SenderID", &giftDB)
+	simpleAssert(t, 0, len(sent))
+}
+
+// testUsers knows alice and bob
+func testUsers(username string) (string, bool, error) {
+	for _, user := range []string{"alice", "bob"} {
+		if strings.EqualFold(user, username) {
+			return user, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func createTestGift(id string, recipient string) structs.Gift {
+	return structs.Gift{
+		ID:                id,
+		SenderID:          "SenderID",
+		SenderUsername:    "sender",
+		RecipientUsername: recipient,
+		Game:              createTestGame("Game1"),
+		Price:             usd(1234),
+		Status:            structs.GiftPending,
+	}
+}
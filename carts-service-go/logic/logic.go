@@ -1,36 +1,44 @@
 package logic
 
 import (
-	"log"
 	"encoding/json"
-
+	"errors"
+	"log"
+	"time"
 
 	database "github.com/Draupniyr/carts-service/database"
-
 	kafka "github.com/Draupniyr/carts-service/kafka"
 	structs "github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
 )
 
+var (
+	ErrCartNotFound       = notFound("cart not found")
+	ErrCartEmpty          = invalid("cart is empty")
+	ErrCheckoutInProgress = conflict("the cart is already being checked out")
+)
+
+// CheckoutTimeout is how long a checkout holds the cart. A checkout that
+// stopped without letting go, like when the service restarted, doesn't
+// block the cart for longer.
+var CheckoutTimeout = 5 * time.Minute
+
 // ----------------- Carts -----------------
-func GetCart(userID string, db database.DatabaseFunctionality) (structs.Cart, error) {
-	cart := structs.Cart{}
-	err := db.GetFilter(userID, "UserID", &cart)
-	if err != nil {
-		log.Println("Error getting cart:", err)
-		// if cart does not exist, create it winth an empty array of games
+// GetCart returns the user's cart, or an empty one that isn't stored until a
+// game is added to it
+func GetCart(userID string, db database.Repository[structs.Cart]) (structs.Cart, error) {
+	cart, ok := loadCart(userID, db)
+	if !ok {
 		cart = structs.Cart{
-			ID:     structs.GetNewUUID(),
 			UserID: userID,
 			Games:  []structs.Game{},
 		}
-		db.CreateOrUpdate(cart)
 	}
 	return cart, nil
 }
 
-func GetAllCarts(db database.DatabaseFunctionality) ([]structs.Cart, error) {
-	carts := []structs.Cart{}
-	err := db.GetAll(&carts)
+func GetAllCarts(db database.Repository[structs.Cart]) ([]structs.Cart, error) {
+	carts, err := db.Scan()
 	if err != nil {
 		log.Println("Error getting all carts:", err)
 		return nil, err
@@ -38,23 +46,27 @@ func GetAllCarts(db database.DatabaseFunctionality) ([]structs.Cart, error) {
 	return carts, nil
 }
 
-func CreateORUpdateCart(userId string, game structs.Game, db database.DatabaseFunctionality) error {
-	Cart := structs.Cart{}
-	err := db.GetFilter(userId, "UserID", &Cart)
-	if err != nil {
+// CreateORUpdateCart starts the user's cart with the game, or adds or removes
+// it when they have one
+func CreateORUpdateCart(userId string, gameID string, lookup GameLookup, db database.Repository[structs.Cart]) error {
+	_, ok := loadCart(userId, db)
+	if !ok {
+		game, err := storeGame(gameID, lookup)
+		if err != nil {
+			return err
+		}
 		cartRequest := structs.CreateCartRequest{
 			UserID: userId,
 			Game:   &game,
 		}
-		Cart = cartRequest.CreateCartRequestToCart()
-		err = db.CreateOrUpdate(Cart)
+		_, err = saveCart(cartRequest.CreateCartRequestToCart(), db)
 		if err != nil {
 			log.Println("Error creating cart:", err)
 			return err
 		}
 		return nil
 	} else {
-		_, err = AddOrRemoveFromCart(userId, game, db)
+		_, err := AddOrRemoveFromCart(userId, gameID, lookup, db)
 		if err != nil {
 			log.Println("Error adding or removing game from cart:", err)
 			return err
@@ -63,33 +75,38 @@ func CreateORUpdateCart(userId string, game structs.Game, db database.DatabaseFu
 	}
 }
 
-func AddOrRemoveFromCart(userID string, gameToAddOrRemove structs.Game, db database.DatabaseFunctionality) (*structs.Cart, error) {
-	cartOG := structs.Cart{}
-	err := db.GetFilter(userID, "UserID", &cartOG)
-	if err != nil {
-		log.Println("Error getting cart:", err)
-		return nil, err
+// AddOrRemoveFromCart takes the game out of the cart when it is in it, and
+// otherwise adds it as the games service sells it
+func AddOrRemoveFromCart(userID string, gameID string, lookup GameLookup, db database.Repository[structs.Cart]) (*structs.Cart, error) {
+	cartOG, ok := loadCart(userID, db)
+	if !ok {
+		log.Println("Error getting cart for", userID)
+		return nil, ErrCartNotFound
 	}
 
 	newgames := []structs.Game{}
 	contains := false
 	for _, game := range cartOG.Games {
-		if game.ID == gameToAddOrRemove.ID {
+		if game.ID == gameID {
 			contains = true
 		}
 	}
 	if contains {
 		for _, game := range cartOG.Games {
-			if game.ID != gameToAddOrRemove.ID {
+			if game.ID != gameID {
 				newgames = append(newgames, game)
 			}
 		}
 		cartOG.Games = newgames
 	} else {
-		cartOG.Games = append(cartOG.Games, gameToAddOrRemove)
+		gameToAdd, err := storeGame(gameID, lookup)
+		if err != nil {
+			return nil, err
+		}
+		cartOG.Games = append(cartOG.Games, gameToAdd)
 	}
 
-	err = db.CreateOrUpdate(cartOG)
+	cartOG, err := saveCart(cartOG, db)
 	if err != nil {
 		log.Println("Error adding or removing game from cart:", err)
 		return nil, err
@@ -97,9 +114,18 @@ func AddOrRemoveFromCart(userID string, gameToAddOrRemove structs.Game, db datab
 	return &cartOG, nil
 }
 
-func DeleteCart(UserID string, db database.DatabaseFunctionality) error {
-
-	err := db.DeleteFilter(UserID, "UserID")
+// DeleteCart removes the user's cart, or returns ErrCartNotFound when there
+// is none
+func DeleteCart(UserID string, db database.Repository[structs.Cart]) error {
+	carts, err := db.Query("UserID", UserID)
+	if err != nil {
+		log.Println("Error getting cart:", err)
+		return err
+	}
+	if len(carts) == 0 {
+		return ErrCartNotFound
+	}
+	err = db.BatchWrite(nil, cartIDs(carts))
 	if err != nil {
 		log.Println("Error deleting cart:", err)
 		return err
@@ -107,8 +133,13 @@ func DeleteCart(UserID string, db database.DatabaseFunctionality) error {
 	return nil
 }
 
-func DeleteAll(db database.DatabaseFunctionality) error {
-	err := db.DeleteAll()
+func DeleteAll(db database.Repository[structs.Cart]) error {
+	carts, err := db.Scan()
+	if err != nil {
+		log.Println("Error getting all carts:", err)
+		return err
+	}
+	err = db.BatchWrite(nil, cartIDs(carts))
 	if err != nil {
 		log.Println("Error deleting all carts:", err)
 		return err
@@ -116,31 +147,229 @@ func DeleteAll(db database.DatabaseFunctionality) error {
 	return nil
 }
 
-func Checkout(userID string, db database.DatabaseFunctionality, kafka kafka.KafkaProducer) error {
-	cart := structs.Cart{}
-	err := db.GetFilter(userID, "UserID", &cart)
+func cartIDs(carts []structs.Cart) []string {
+	ids := make([]string, len(carts))
+	for i, cart := range carts {
+		ids[i] = cart.ID
+	}
+	return ids
+}
+
+// CheckoutDatabases groups the tables checkout reads from and writes to, and
+// where it looks up the games.
+type CheckoutDatabases struct {
+	Games            GameLookup
+	Carts            database.Repository[structs.Cart]
+	Orders           database.Repository[structs.Order]
+	Wallets          database.Repository[structs.WalletEntry]
+	Gifts            database.Repository[structs.Gift]
+	PromoCodes       database.Repository[structs.PromoCode]
+	PromoRedemptions database.Repository[structs.PromoRedemption]
+	Refunds          database.Repository[structs.Refund]
+}
+
+func (dbs CheckoutDatabases) ownership() OwnershipDatabases {
+	return OwnershipDatabases{
+		Orders:  dbs.Orders,
+		Refunds: dbs.Refunds,
+		Gifts:   dbs.Gifts,
+	}
+}
+
+// Checkout turns the cart into an order priced in the currency and taxed for
+// the buyer's location, the summary with the tax is kept on the order. When useWallet
+// is set as much of the total as the wallet covers is debited from it and the
+// rest goes on the card.
+// Lines with a gift recipient are not granted to the buyer, they are sent to
+// the recipient's gift inbox instead.
+// The games are looked up again first, whatever the cart stored when they
+// were added may be out of date.
+func Checkout(userID string, username string, currency string, location tax.Location, useWallet bool, dbs CheckoutDatabases, kafka kafka.KafkaProducer) (*structs.Order, error) {
+	cart, ok := loadCart(userID, dbs.Carts)
+	if !ok || len(cart.Games) == 0 {
+		return nil, ErrCartEmpty
+	}
+	stored := cart
+	cart, err := refreshCart(cart, dbs.Games)
 	if err != nil {
-		log.Println("Error getting cart:", err)
-		return err
+		return nil, err
 	}
 
-	// turn cart into a byte array
-	cartJson, err := json.Marshal(cart)
+	owned, err := GetOwnedGames(userID, dbs.ownership())
 	if err != nil {
-		log.Println("Error marshaling cart:", err)
-		return err
+		return nil, err
+	}
+	summary := GetCartSummary(cart, currency, location, owned, dbs.PromoCodes)
+	if summary.Error != "" {
+		return nil, notAllowed(summary.Error)
 	}
-	cartByte := []byte(cartJson)
-	// turn gamejson into a byte array
-	err = kafka.PushCommentToQueue("checkout", userID, cartByte)
+	if summary.PromoError != "" {
+		return nil, notAllowed(summary.PromoError)
+	}
+	order := structs.Order{
+		ID:         structs.GetNewUUID(),
+		UserID:     userID,
+		Games:      []structs.Game{},
+		Gifts:      []structs.Gift{},
+		Summary:    summary,
+		Currency:   summary.Currency,
+		Tax:        summary.Tax,
+		Total:      summary.Total,
+		WalletPaid: structs.NewMoney(0, summary.Currency),
+		Date:       time.Now().Format(time.RFC3339),
+	}
+	for _, line := range summary.Lines {
+		if line.Game.GiftTo == "" {
+			order.Games = append(order.Games, line.Game)
+		} else {
+			order.Gifts = append(order.Gifts, newGift(order, username, line))
+		}
+	}
+	order.CardPaid = order.Total
+
+	claimed, err := claimCart(stored, order.ID, time.Now(), dbs.Carts)
 	if err != nil {
-		log.Println("Error pushing cart to kafka:", err)
-		return err
+		return nil, err
+	}
+
+	var redemption *structs.PromoRedemption
+	if summary.PromoCode != "" {
+		promo, err := GetPromoCode(summary.PromoCode, dbs.PromoCodes)
+		if err != nil {
+			releaseCart(claimed, dbs.Carts)
+			return nil, err
+		}
+		redemption, err = redeemPromoCode(*promo, userID, order.ID, dbs.PromoRedemptions)
+		if err != nil {
+			log.Println("Error redeeming promo code:", err)
+			releaseCart(claimed, dbs.Carts)
+			return nil, err
+		}
+	}
+	// undo puts back whatever this checkout took if it can't finish
+	undo := func() {
+		cancelGifts(order, dbs.Gifts)
+		refundWalletPayment(order, dbs.Wallets)
+		dbs.Orders.Delete(order.ID)
+		if redemption != nil {
+			dbs.PromoRedemptions.Delete(redemption.ID)
+		}
+		releaseCart(claimed, dbs.Carts)
+	}
+
+	if useWallet && order.Total.Amount > 0 {
+		wallet, err := GetWallet(userID, dbs.Wallets)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		if wallet.Balance.Amount > 0 && wallet.Balance.Currency != order.Currency {
+			undo()
+			return nil, ErrCurrencyMismatch
+		}
+		walletPaid := order.Total
+		if wallet.Balance.Amount < walletPaid.Amount {
+			walletPaid.Amount = wallet.Balance.Amount
+		}
+		if walletPaid.Amount > 0 {
+			_, err = DebitWallet(userID, walletPaid, "checkout", order.ID, dbs.Wallets)
+			if err != nil {
+				log.Println("Error debiting wallet:", err)
+				undo()
+				return nil, err
+			}
+			order.WalletPaid = walletPaid
+		}
+		order.CardPaid = order.Total.Sub(order.WalletPaid)
 	}
-	err = db.Delete(cart.ID)
+
+	for _, gift := range order.Gifts {
+		err := dbs.Gifts.Put(gift)
+		if err != nil {
+			log.Println("Error saving gift:", err)
+			undo()
+			return nil, err
+		}
+	}
+
+	err = dbs.Orders.Put(order)
 	if err != nil {
-		log.Println("Error deleting cart:", err)
-		return err
+		log.Println("Error saving order:", err)
+		undo()
+		return nil, err
+	}
+
+	// turn order into a byte array
+	orderJson, err := json.Marshal(order)
+	if err != nil {
+		log.Println("Error marshaling order:", err)
+		undo()
+		return nil, err
+	}
+	err = kafka.PushCommentToQueue("checkout", userID, orderJson)
+	if err != nil {
+		log.Println("Error pushing order to kafka:", err)
+		undo()
+		return nil, err
+	}
+	for _, gift := range order.Gifts {
+		err = publishGift("gift.sent", gift, kafka)
+		if err != nil {
+			log.Println("Error pushing gift to kafka:", err)
+		}
+	}
+
+	// the order went through, a cart left behind only holds on until the
+	// claim times out
+	err = dbs.Carts.Delete(cart.ID)
+	if err != nil {
+		log.Println("Error deleting cart", cart.ID, "after order", order.ID, ":", err)
+	}
+	return &order, nil
+}
+
+// claimCart marks the cart as being checked out into the order. Of two
+// checkouts of the cart at once only one gets it, the other gets
+// ErrCheckoutInProgress.
+func claimCart(cart structs.Cart, orderID string, now time.Time, db database.Repository[structs.Cart]) (structs.Cart, error) {
+	if cart.CheckoutID != "" {
+		started, err := time.Parse(time.RFC3339, cart.CheckoutStarted)
+		if err == nil && now.Sub(started) < CheckoutTimeout {
+			return structs.Cart{}, ErrCheckoutInProgress
+		}
+	}
+	claimed := cart
+	claimed.CheckoutID = orderID
+	claimed.CheckoutStarted = now.UTC().Format(time.RFC3339)
+	err := db.PutIf(claimed, "CheckoutID", cart.CheckoutID)
+	if errors.Is(err, database.ErrConditionFailed) {
+		return structs.Cart{}, ErrCheckoutInProgress
+	}
+	if err != nil {
+		return structs.Cart{}, err
+	}
+	return claimed, nil
+}
+
+// releaseCart lets go of the cart after a checkout that didn't go through, as
+// long as no other checkout took it over meanwhile
+func releaseCart(claimed structs.Cart, db database.Repository[structs.Cart]) {
+	released := claimed
+	released.CheckoutID = ""
+	released.CheckoutStarted = ""
+	err := db.PutIf(released, "CheckoutID", claimed.CheckoutID)
+	if err != nil {
+		log.Println("Error releasing cart", claimed.ID, ":", err)
+	}
+}
+
+// refundWalletPayment gives back the wallet part of an order that failed to go through
+func refundWalletPayment(order structs.Order, walletDB database.Repository[structs.WalletEntry]) {
+	if order.WalletPaid.Amount <= 0 {
+		return
+	}
+	_, err := CreditWallet(order.UserID, order.WalletPaid, "checkout-reversal", order.ID, walletDB)
+	if err != nil {
+		log.Println("Error reversing wallet payment for order", order.ID, ":", err)
 	}
-	return nil
 }
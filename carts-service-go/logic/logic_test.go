@@ -1,20 +1,26 @@
 package logic
 
 import (
+	"errors"
 	"testing"
+	"time"
 
-	database "github.com/Draupniyr/carts-service/mockdb"
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
 	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
 )
 
-var db database.Database
+var db database.Memory[structs.Cart]
 
 func TestGetAllCarts(t *testing.T) {
 	//setup
 	db.Init("Test", "ID")
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID1", createTestGame("Game1")))
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID2", createTestGame("Game1")))
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID3", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID1", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID2", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID3", createTestGame("Game1")))
 
 	// TestGetAllCarts tests the GetAllCarts function.
 	carts, err := GetAllCarts(&db)
@@ -30,9 +36,9 @@ func TestGetAllCarts(t *testing.T) {
 
 func TestGetCart(t *testing.T) {
 	db.Init("Test", "ID")
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID1", createTestGame("Game1")))
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID2", createTestGame("Game1")))
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID3", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID1", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID2", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID3", createTestGame("Game1")))
 	// TestGetCart tests the GetCart function.
 	// It should return a cart with the given userID.
 	cart, err := GetCart("TestID2", &db)
@@ -45,49 +51,98 @@ func TestGetCart(t *testing.T) {
 func TestCreateOfCreateOrUpdateCart(t *testing.T) {
 	db.Init("Test", "ID")
 	// TestCreateOrUpdateCart tests the CreateOrUpdateCart function.
-	CreateORUpdateCart("TestID1", createTestGame("Game1"), &db)
-	CreateORUpdateCart("TestID2", createTestGame("Game2"), &db)
-	CreateORUpdateCart("TestID3", createTestGame("Game3"), &db)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID2", "Game2", testGames, &db)
+	CreateORUpdateCart("TestID3", "Game3", testGames, &db)
 	// It should create a new cart if the user does not have one.
-	simpleAssert(t, 3, len(db.DynamodbClient))
-	simpleAssert(t, "Game1", db.DynamodbClient[0].(structs.Cart).Games[0].ID)
-	simpleAssert(t, "Game2", db.DynamodbClient[1].(structs.Cart).Games[0].ID)
-	simpleAssert(t, "Game3", db.DynamodbClient[2].(structs.Cart).Games[0].ID)
+	simpleAssert(t, 3, len(db.Items))
+	simpleAssert(t, "Game1", db.Items[0].Games[0].ID)
+	simpleAssert(t, "Game2", db.Items[1].Games[0].ID)
+	simpleAssert(t, "Game3", db.Items[2].Games[0].ID)
 }
 
 func TestUpdateOfCreateOrUpdateCart(t *testing.T) {
 	db.Init("Test", "ID")
 	// It should add a game to the cart if the user already has one.
-	CreateORUpdateCart("TestID1", createTestGame("Game1"), &db)
-	updateGame := createTestGame("Game2")
-
-	CreateORUpdateCart("TestID1", updateGame, &db)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
 
-	simpleAssert(t, 1, len(db.DynamodbClient))
-	simpleAssert(t, 2, len(db.DynamodbClient[0].(structs.Cart).Games))
+	simpleAssert(t, 1, len(db.Items))
+	simpleAssert(t, 2, len(db.Items[0].Games))
 }
 
 func TestUpdateOfCreateOrUpdateCarttwo(t *testing.T) {
 	db.Init("Test", "ID")
 	// It should add a game to the cart if the user already has one.
-	CreateORUpdateCart("TestID1", createTestGame("Game1"), &db)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
 
-	CreateORUpdateCart("TestID1", createTestGame("Game1"), &db)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
 
-	simpleAssert(t, 1, len(db.DynamodbClient))
-	simpleAssert(t, 0, len(db.DynamodbClient[0].(structs.Cart).Games))
+	simpleAssert(t, 1, len(db.Items))
+	simpleAssert(t, 0, len(db.Items[0].Games))
 }
 
 func TestDeletCart(t *testing.T) {
 	db.Init("Test", "ID")
 	// TestDeleteGameFromCart tests the DeleteGameFromCart function.
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID1", createTestGame("Game1")))
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID2", createTestGame("Game1")))
-	db.DynamodbClient = append(db.DynamodbClient, CreateTestCart("TestID3", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID1", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID2", createTestGame("Game1")))
+	db.Items = append(db.Items, CreateTestCart("TestID3", createTestGame("Game1")))
 
 	DeleteCart("TestID2", &db)
 	// It should remove the cart from the database.
-	simpleAssert(t, 2, len(db.DynamodbClient))
+	simpleAssert(t, 2, len(db.Items))
+}
+
+func TestCheckoutClaimsTheCart(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	// another checkout has the cart, this one stops before doing anything
+	cart, _ := loadCart("TestID1", &db)
+	_, err := claimCart(cart, "Order1", time.Now(), &db)
+	simpleAssert(t, nil, err)
+	_, err = claimCart(cart, "Order2", time.Now(), &db)
+	simpleAssert(t, ErrCheckoutInProgress, err)
+	_, err = Checkout("TestID1", "tester", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{})
+	simpleAssert(t, ErrCheckoutInProgress, err)
+	orders, _ := dbs.Orders.Scan()
+	simpleAssert(t, 0, len(orders))
+
+	// one that stopped without letting go only holds the cart so long
+	db.Items[0].CheckoutStarted = time.Now().Add(-CheckoutTimeout - time.Minute).UTC().Format(time.RFC3339)
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	_, err = Checkout("TestID1", "tester", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, nil, err)
+	simpleAssert(t, 0, len(db.Items))
+}
+
+// undeletableCarts keeps the carts it is asked to delete
+type undeletableCarts struct {
+	*database.Memory[structs.Cart]
+}
+
+func (db undeletableCarts) Delete(key string) error {
+	return errors.New("delete failed")
+}
+
+func TestCheckoutKeepsOrderWhenCartStays(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	dbs.Carts = undeletableCarts{Memory: &db}
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	order, err := Checkout("TestID1", "tester", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error checking out: %v", err)
+	}
+	_, err = dbs.Orders.Get(order.ID)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, order.ID, db.Items[0].CheckoutID)
 }
 
 // ----------------- Helper Functions -----------------
@@ -97,6 +152,39 @@ func simpleAssert[T comparable](t *testing.T, want, got T) {
 	}
 }
 
+// newCheckoutDatabases uses the shared cart table and empty tables for the rest
+func newCheckoutDatabases() CheckoutDatabases {
+	return CheckoutDatabases{
+		Games:            testGames,
+		Carts:            &db,
+		Orders:           newTable[structs.Order]("Orders"),
+		Wallets:          newTable[structs.WalletEntry]("Wallets"),
+		Gifts:            newTable[structs.Gift]("Gifts"),
+		PromoCodes:       newTable[structs.PromoCode]("PromoCodes"),
+		PromoRedemptions: newTable[structs.PromoRedemption]("PromoRedemptions"),
+		Refunds:          newTable[structs.Refund]("Refunds"),
+	}
+}
+
+// testGames is the games service of the tests. It sells Bundle1 and DLC1 of
+// Game1, and every other ID as createTestGame makes it.
+func testGames(gameID string) (structs.StoreGame, bool, error) {
+	game := createTestGame(gameID)
+	switch gameID {
+	case "Bundle1":
+		game = createTestBundle("Bundle1", usd(3000), "Game1", "Game2")
+	case "DLC1":
+		game = createTestDLC("DLC1", "Game1")
+	}
+	return structs.StoreGame{Game: game, Purchasable: true}, true, nil
+}
+
+func newTable[T any](name string) *database.Memory[T] {
+	table := database.Memory[T]{}
+	table.Init(name, "ID")
+	return &table
+}
+
 func CreateTestCart(userID string, game structs.Game) structs.Cart {
 
 	cart := structs.CreateCartRequest{
@@ -106,13 +194,18 @@ func CreateTestCart(userID string, game structs.Game) structs.Cart {
 	return cart.CreateCartRequestToCart()
 }
 
+// usd is an amount in cents
+func usd(cents int64) structs.Money {
+	return structs.NewMoney(cents, "USD")
+}
+
 func createTestGame(id string) structs.Game {
 	return structs.Game{
 		ID:          id,
 		Title:       "TestTitle",
 		Description: "TestDescription",
 		Tags:        []string{"TestTag1", "TestTag2"},
-		Price:       12.34,
+		Price:       usd(1234),
 		Published:   "TestPublished",
 		Author:      "TestAuthor",
 		AuthorID:    "TestAuthorID",
@@ -0,0 +1,75 @@
+package logic
+
+import (
+	database "github.com/Draupniyr/carts-service/database"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+// OwnershipDatabases groups the tables that say which games a user owns
+type OwnershipDatabases struct {
+	Orders  database.Repository[structs.Order]
+	Refunds database.Repository[structs.Refund]
+	Gifts   database.Repository[structs.Gift]
+}
+
+// ----------------- Ownership -----------------
+
+// GetOwnedGames returns the IDs of the games the user owns. That is what they
+// bought for themselves, bundles counting as the games in them, less approved
+// refunds, plus the gifts they accepted. A game bought twice stays owned when
+// one of the purchases is refunded.
+func GetOwnedGames(userID string, dbs OwnershipDatabases) (map[string]bool, error) {
+	copies := map[string]int{}
+
+	orders, err := GetOrders(userID, dbs.Orders)
+	if err != nil {
+		return nil, err
+	}
+	ordersByID := map[string]structs.Order{}
+	for _, order := range orders {
+		ordersByID[order.ID] = order
+		for _, game := range order.Games {
+			for _, id := range game.GameIDs() {
+				copies[id]++
+			}
+		}
+	}
+
+	refunds, err := GetRefunds(userID, dbs.Refunds)
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		if refund.Status != structs.RefundApproved {
+			continue
+		}
+		line, ok := orderLine(ordersByID[refund.OrderID], refund.GameID)
+		if !ok {
+			continue
+		}
+		for _, id := range line.Game.GameIDs() {
+			copies[id]--
+		}
+	}
+
+	gifts, err := dbs.Gifts.Query("RecipientID", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, gift := range gifts {
+		if gift.RecipientID != userID || gift.Status != structs.GiftAccepted {
+			continue
+		}
+		for _, id := range gift.Game.GameIDs() {
+			copies[id]++
+		}
+	}
+
+	owned := map[string]bool{}
+	for id, count := range copies {
+		if count > 0 {
+			owned[id] = true
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,268 @@
+package logic
+
+import (
+	"fmt"
+	"time"
+
+	structs "github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+// ----------------- Pricing -----------------
+
+// PriceCart works out what every line costs in the currency. Game discounts
+// are applied per line first, then a bundle loses the share of its price that
+// the games in it the buyer already owns are worth, then the promo code is
+// taken off the discounted subtotal and spread over the lines in proportion to
+// their price. owned is the games the buyer has, nil for nobody in particular.
+func PriceCart(cart structs.Cart, currency string, promo *structs.PromoCode, owned map[string]bool, now time.Time) structs.CartSummary {
+	zero := structs.NewMoney(0, currency)
+	summary := structs.CartSummary{
+		Currency:      currency,
+		Lines:         []structs.CartLine{},
+		Subtotal:      zero,
+		Discount:      zero,
+		OwnedDiscount: zero,
+		PromoDiscount: zero,
+	}
+	for _, game := range cart.Games {
+		line := structs.CartLine{
+			Game:          game,
+			OriginalPrice: game.PriceIn(currency),
+			OwnedDiscount: zero,
+			PromoDiscount: zero,
+		}
+		if line.OriginalPrice.Currency != currency {
+			// no price in this currency, the cart can't be bought in it
+			summary.Error = fmt.Sprintf("%s is not sold in %s", game.Title, currency)
+			line.OriginalPrice = zero
+		}
+		line.Price = bestDiscountPrice(game, line.OriginalPrice, now)
+		line.Discount = line.OriginalPrice.Sub(line.Price)
+		if game.GiftTo == "" {
+			err := checkOwnership(cart, game, owned)
+			if err != nil {
+				summary.Error = err.Error()
+			}
+			line.OwnedDiscount = ownedBundleDiscount(game, line.Price, owned)
+			line.Price = line.Price.Sub(line.OwnedDiscount)
+		}
+		summary.Lines = append(summary.Lines, line)
+		summary.Subtotal = summary.Subtotal.Add(line.OriginalPrice)
+		summary.Discount = summary.Discount.Add(line.Discount)
+		summary.OwnedDiscount = summary.OwnedDiscount.Add(line.OwnedDiscount)
+	}
+	discounted := summary.Subtotal.Sub(summary.Discount).Sub(summary.OwnedDiscount)
+
+	if promo != nil {
+		summary.PromoCode = promo.Code
+		err := promoApplies(*promo, discounted, now)
+		if err != nil {
+			summary.PromoError = err.Error()
+		} else {
+			off := discountAmount(promo.Type, promo.Value, promo.Amount, discounted)
+			if off.Amount > discounted.Amount {
+				off = discounted
+			}
+			summary.PromoDiscount = off
+			spreadPromoDiscount(summary.Lines, summary.PromoDiscount, discounted)
+		}
+	}
+
+	summary.Total = discounted.Sub(summary.PromoDiscount)
+	for i := range summary.Lines {
+		summary.Lines[i].Tax = zero
+		summary.Lines[i].Total = summary.Lines[i].Price
+	}
+	summary.Tax = zero
+	return summary
+}
+
+// TaxCart adds the tax for the buyer's location to every line of a priced
+// cart. Games are digital goods. Exclusive tax is added to the total,
+// inclusive tax is only shown since the prices already contain it.
+func TaxCart(summary *structs.CartSummary, location tax.Location) {
+	summary.Country = location.Country
+	summary.Region = location.Region
+	summary.Tax = structs.NewMoney(0, summary.Currency)
+	for i := range summary.Lines {
+		line := tax.Calculate(location, summary.Lines[i].Price, true)
+		summary.Lines[i].Tax = line.Tax
+		summary.Lines[i].Total = line.Total
+		summary.Tax = summary.Tax.Add(line.Tax)
+		if line.Name != "" {
+			summary.TaxName = line.Name
+			summary.TaxRate = line.Rate
+			summary.TaxInclusive = line.Inclusive
+		}
+		if !line.Inclusive {
+			summary.Total = summary.Total.Add(line.Tax)
+		}
+	}
+}
+
+// checkOwnership stops the buyer paying for a game they have, or for DLC
+// without its base game in their library or in the same cart
+func checkOwnership(cart structs.Cart, game structs.Game, owned map[string]bool) error {
+	if game.IsBundle() {
+		for _, id := range game.GameIDs() {
+			if !owned[id] {
+				return nil
+			}
+		}
+		return fmt.Errorf("you already own everything in %s", game.Title)
+	}
+	if owned[game.ID] {
+		return fmt.Errorf("you already own %s", game.Title)
+	}
+	if game.IsDLC() && !owned[game.ParentID] && !cartBuysGame(cart, game.ParentID) {
+		return fmt.Errorf("%s needs its base game, add it to your cart first", game.Title)
+	}
+	return nil
+}
+
+// cartBuysGame reports if checking out the cart puts the game in the buyer's
+// library, on its own or in a bundle
+func cartBuysGame(cart structs.Cart, gameID string) bool {
+	for _, game := range cart.Games {
+		if game.GiftTo != "" {
+			continue
+		}
+		for _, id := range game.GameIDs() {
+			if id == gameID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ownedBundleDiscount is the part of a bundle's price that the games in it the
+// buyer owns make up, going by what the games cost on their own
+func ownedBundleDiscount(game structs.Game, price structs.Money, owned map[string]bool) structs.Money {
+	off := structs.NewMoney(0, price.Currency)
+	if !game.IsBundle() {
+		return off
+	}
+	var total, ownedValue int64
+	for _, item := range game.BundleItems {
+		itemPrice := item.PriceIn(price.Currency)
+		if itemPrice.Currency != price.Currency {
+			continue
+		}
+		total += itemPrice.Amount
+		if owned[item.ID] {
+			ownedValue += itemPrice.Amount
+		}
+	}
+	if total <= 0 || ownedValue <= 0 {
+		return off
+	}
+	off.Amount = (price.Amount*ownedValue + total/2) / total
+	return off
+}
+
+// bestDiscountPrice is the lowest price any running discount gives for the
+// line's price, a regional price gets the same share off as the base price.
+func bestDiscountPrice(game structs.Game, price structs.Money, now time.Time) structs.Money {
+	best := price
+	for _, discount := range game.Discounts {
+		if !inWindow(discount.Start, discount.End, now) {
+			continue
+		}
+		off := discountAmount(discount.Type, discount.Value, discount.Amount, price)
+		if discount.Type == structs.DiscountFixed && discount.Amount.Currency != price.Currency {
+			off = structs.NewMoney(0, price.Currency)
+			if game.Price.Amount > 0 {
+				off.Amount = (discount.Amount.Amount*price.Amount + game.Price.Amount/2) / game.Price.Amount
+			}
+		}
+		if off.Amount > price.Amount {
+			off = price
+		}
+		discounted := price.Sub(off)
+		if discounted.Amount < best.Amount {
+			best = discounted
+		}
+	}
+	return best
+}
+
+// discountAmount is how much comes off price. A fixed amount in another
+// currency can't be converted so it takes nothing off.
+func discountAmount(discountType string, value float64, amount structs.Money, price structs.Money) structs.Money {
+	switch discountType {
+	case structs.DiscountPercent:
+		return price.Percent(value)
+	case structs.DiscountFixed:
+		if amount.Currency == price.Currency {
+			return amount
+		}
+	}
+	return structs.NewMoney(0, price.Currency)
+}
+
+// spreadPromoDiscount splits the promo discount over the lines, the last
+// line with a price takes the rounding leftovers.
+func spreadPromoDiscount(lines []structs.CartLine, promoDiscount structs.Money, discounted structs.Money) {
+	if discounted.Amount <= 0 {
+		return
+	}
+	last := -1
+	for i := range lines {
+		if lines[i].Price.Amount > 0 {
+			last = i
+		}
+	}
+	remaining := promoDiscount
+	for i := range lines {
+		if lines[i].Price.Amount <= 0 {
+			continue
+		}
+		share := structs.NewMoney((promoDiscount.Amount*lines[i].Price.Amount+discounted.Amount/2)/discounted.Amount, promoDiscount.Currency)
+		if i == last {
+			share = remaining
+		}
+		if share.Amount > lines[i].Price.Amount {
+			share = lines[i].Price
+		}
+		lines[i].PromoDiscount = share
+		lines[i].Price = lines[i].Price.Sub(share)
+		remaining = remaining.Sub(share)
+	}
+}
+
+func promoApplies(promo structs.PromoCode, spend structs.Money, now time.Time) error {
+	if !inWindow(promo.Start, promo.End, now) {
+		return ErrPromoExpired
+	}
+	if promo.Type == structs.DiscountFixed && promo.Amount.Currency != spend.Currency {
+		return fmt.Errorf("this code can only be used in %s", promo.Amount.Currency)
+	}
+	if promo.MinSpend.Amount > 0 {
+		if promo.MinSpend.Currency != spend.Currency {
+			return fmt.Errorf("this code can only be used in %s", promo.MinSpend.Currency)
+		}
+		if spend.Amount < promo.MinSpend.Amount {
+			return fmt.Errorf("spend at least %s to use this code", promo.MinSpend)
+		}
+	}
+	return nil
+}
+
+// inWindow reports if now is between the RFC3339 start and end, empty means open
+func inWindow(start string, end string, now time.Time) bool {
+	if start != "" {
+		t, err := time.Parse(time.RFC3339, start)
+		if err != nil || now.Before(t) {
+			return false
+		}
+	}
+	if end != "" {
+		t, err := time.Parse(time.RFC3339, end)
+		if err != nil || !now.Before(t) {
+			return false
+		}
+	}
+	return true
+}
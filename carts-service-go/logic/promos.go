@@ -0,0 +1,227 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	database "github.com/Draupniyr/carts-service/database"
+	structs "github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+var (
+	ErrPromoNotFound    = notFound("promo code not found")
+	ErrPromoExists      = conflict("promo code already exists")
+	ErrPromoExpired     = conflict("promo code is not active")
+	ErrPromoExhausted   = conflict("promo code has been used up")
+	ErrPromoUserLimit   = conflict("you have already used this promo code")
+	ErrInvalidPromoCode = invalid("invalid promo code")
+	ErrPromoBusy        = conflict("promo code is busy, try again")
+)
+
+// how many times a redemption is retried when another checkout took the use number first
+const promoRedeemAttempts = 5
+
+// ----------------- Promo codes -----------------
+func CreatePromoCode(adminID string, request structs.PromoCodeRequest, promoDB database.Repository[structs.PromoCode]) (structs.PromoCode, error) {
+	code := normalizePromoCode(request.Code)
+	if code == "" || strings.Contains(code, "#") {
+		return structs.PromoCode{}, ErrInvalidPromoCode
+	}
+	currency := structs.NormalizeCurrency(request.Currency)
+	amount, err := parseAmount(request.Amount, currency)
+	if err != nil {
+		return structs.PromoCode{}, err
+	}
+	minSpend, err := parseAmount(request.MinSpend, currency)
+	if err != nil {
+		return structs.PromoCode{}, err
+	}
+	switch request.Type {
+	case structs.DiscountPercent:
+		if request.Value <= 0 || request.Value > 100 {
+			return structs.PromoCode{}, invalid("percent promo must be between 0 and 100")
+		}
+	case structs.DiscountFixed:
+		if amount.Amount <= 0 {
+			return structs.PromoCode{}, ErrInvalidAmount
+		}
+	default:
+		return structs.PromoCode{}, invalid("promo type must be percent or fixed")
+	}
+	if request.MaxUses < 0 || request.PerUserLimit < 0 || minSpend.Amount < 0 {
+		return structs.PromoCode{}, invalid("limits cannot be negative")
+	}
+
+	promo := structs.PromoCode{
+		ID:           code,
+		Code:         code,
+		Type:         request.Type,
+		Value:        request.Value,
+		Amount:       amount,
+		MaxUses:      request.MaxUses,
+		PerUserLimit: request.PerUserLimit,
+		MinSpend:     minSpend,
+		Start:        request.Start,
+		End:          request.End,
+		CreatedBy:    adminID,
+	}
+	err = promoDB.Create(promo)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return structs.PromoCode{}, ErrPromoExists
+	}
+	if err != nil {
+		return structs.PromoCode{}, err
+	}
+	return promo, nil
+}
+
+func GetAllPromoCodes(promoDB database.Repository[structs.PromoCode]) ([]structs.PromoCode, error) {
+	promos, err := promoDB.Scan()
+	if err != nil {
+		return nil, err
+	}
+	return promos, nil
+}
+
+func GetPromoCode(code string, promoDB database.Repository[structs.PromoCode]) (*structs.PromoCode, error) {
+	promo, err := promoDB.Get(normalizePromoCode(code))
+	if err != nil {
+		return nil, missing(err, ErrPromoNotFound)
+	}
+	return &promo, nil
+}
+
+// ApplyPromoCode puts the code on the user's cart after checking it can be
+// used. An empty code takes the promo off the cart.
+func ApplyPromoCode(userID string, code string, db database.Repository[structs.Cart], promoDB database.Repository[structs.PromoCode], redemptionDB database.Repository[structs.PromoRedemption]) (*structs.Cart, error) {
+	cart, err := findCart(userID, db)
+	if err != nil {
+		log.Println("Error getting cart:", err)
+		return nil, err
+	}
+
+	code = normalizePromoCode(code)
+	if code != "" {
+		promo, err := GetPromoCode(code, promoDB)
+		if err != nil {
+			return nil, err
+		}
+		if !inWindow(promo.Start, promo.End, time.Now()) {
+			return nil, ErrPromoExpired
+		}
+		redemptions, err := getPromoRedemptions(code, redemptionDB)
+		if err != nil {
+			return nil, err
+		}
+		err = checkPromoLimits(*promo, userID, redemptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cart.PromoCode = code
+	cart, err = saveCart(cart, db)
+	if err != nil {
+		log.Println("Error updating cart:", err)
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// GetCartSummary prices the cart in the currency with the promo code it
+// carries, if any, for a buyer owning the owned games, and adds the tax for
+// the buyer's location
+func GetCartSummary(cart structs.Cart, currency string, location tax.Location, owned map[string]bool, promoDB database.Repository[structs.PromoCode]) structs.CartSummary {
+	var summary structs.CartSummary
+	if cart.PromoCode == "" {
+		summary = PriceCart(cart, currency, nil, owned, time.Now())
+	} else if promo, err := GetPromoCode(cart.PromoCode, promoDB); err != nil {
+		summary = PriceCart(cart, currency, nil, owned, time.Now())
+		summary.PromoCode = cart.PromoCode
+		summary.PromoError = err.Error()
+	} else {
+		summary = PriceCart(cart, currency, promo, owned, time.Now())
+	}
+	TaxCart(&summary, tax.NormalizeLocation(location))
+	return summary
+}
+
+// redeemPromoCode records one use of the code for the order. Uses are numbered
+// and written with a conditional create, so two checkouts can't both take the
+// last use of a code.
+func redeemPromoCode(promo structs.PromoCode, userID string, orderID string, redemptionDB database.Repository[structs.PromoRedemption]) (*structs.PromoRedemption, error) {
+	for attempt := 0; attempt < promoRedeemAttempts; attempt++ {
+		redemptions, err := getPromoRedemptions(promo.Code, redemptionDB)
+		if err != nil {
+			return nil, err
+		}
+		err = checkPromoLimits(promo, userID, redemptions)
+		if err != nil {
+			return nil, err
+		}
+
+		number := 1
+		for _, redemption := range redemptions {
+			if redemption.Number >= number {
+				number = redemption.Number + 1
+			}
+		}
+		redemption := structs.PromoRedemption{
+			ID:      fmt.Sprintf("%s#%06d", promo.Code, number),
+			Code:    promo.Code,
+			Number:  number,
+			UserID:  userID,
+			OrderID: orderID,
+			Date:    time.Now().Format(time.RFC3339),
+		}
+		err = redemptionDB.Create(redemption)
+		if errors.Is(err, database.ErrAlreadyExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &redemption, nil
+	}
+	return nil, ErrPromoBusy
+}
+
+func checkPromoLimits(promo structs.PromoCode, userID string, redemptions []structs.PromoRedemption) error {
+	if promo.MaxUses > 0 && len(redemptions) >= promo.MaxUses {
+		return ErrPromoExhausted
+	}
+	if promo.PerUserLimit > 0 {
+		used := 0
+		for _, redemption := range redemptions {
+			if redemption.UserID == userID {
+				used++
+			}
+		}
+		if used >= promo.PerUserLimit {
+			return ErrPromoUserLimit
+		}
+	}
+	return nil
+}
+
+func getPromoRedemptions(code string, redemptionDB database.Repository[structs.PromoRedemption]) ([]structs.PromoRedemption, error) {
+	found, err := redemptionDB.Query("Code", code)
+	if err != nil {
+		return nil, err
+	}
+	redemptions := []structs.PromoRedemption{}
+	for _, redemption := range found {
+		if redemption.Code == code {
+			redemptions = append(redemptions, redemption)
+		}
+	}
+	return redemptions, nil
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
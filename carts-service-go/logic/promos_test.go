@@ -0,0 +1,164 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama/mocks"
+
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+func TestPriceCartLineDiscounts(t *testing.T) {
+	game := createTestGame("Game1")
+	game.Price = usd(2000)
+	game.Discounts = []structs.Discount{
+		{Type: structs.DiscountPercent, Value: 25},
+		{Type: structs.DiscountFixed, Amount: usd(100)},
+		{Type: structs.DiscountPercent, Value: 90, End: "2000-01-01T00:00:00Z"}, // over
+	}
+	cart := structs.Cart{Games: []structs.Game{game, createTestGame("Game2")}}
+
+	summary := PriceCart(cart, "USD", nil, nil, time.Now())
+	// the best running discount wins
+	simpleAssert(t, usd(1500), summary.Lines[0].Price)
+	simpleAssert(t, usd(2000), summary.Lines[0].OriginalPrice)
+	simpleAssert(t, usd(3234), summary.Subtotal)
+	simpleAssert(t, usd(500), summary.Discount)
+	simpleAssert(t, usd(2734), summary.Total)
+}
+
+func TestPriceCartSpreadsPromo(t *testing.T) {
+	gameA := createTestGame("Game1")
+	gameA.Price = usd(1000)
+	gameB := createTestGame("Game2")
+	gameB.Price = usd(2000)
+	cart := structs.Cart{Games: []structs.Game{gameA, gameB}}
+	promo := &structs.PromoCode{Code: "SAVE5", Type: structs.DiscountFixed, Amount: usd(500)}
+
+	summary := PriceCart(cart, "USD", promo, nil, time.Now())
+	simpleAssert(t, usd(500), summary.PromoDiscount)
+	simpleAssert(t, usd(2500), summary.Total)
+	simpleAssert(t, usd(833), summary.Lines[0].Price)
+	simpleAssert(t, usd(1667), summary.Lines[1].Price)
+}
+
+func TestPriceCartPromoMinSpend(t *testing.T) {
+	cart := structs.Cart{Games: []structs.Game{createTestGame("Game1")}}
+	promo := &structs.PromoCode{Code: "BIG", Type: structs.DiscountPercent, Value: 10, MinSpend: usd(5000)}
+
+	summary := PriceCart(cart, "USD", promo, nil, time.Now())
+	simpleAssert(t, usd(0), summary.PromoDiscount)
+	simpleAssert(t, usd(1234), summary.Total)
+	if summary.PromoError == "" {
+		t.Errorf("Expected the minimum spend to be reported")
+	}
+}
+
+func TestSingleUsePromoCode(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreatePromoCode("Admin", structs.PromoCodeRequest{Code: "once", Type: structs.DiscountPercent, Value: 50, MaxUses: 1}, dbs.PromoCodes)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID2", "Game1", testGames, &db)
+
+	_, err := ApplyPromoCode("TestID1", "ONCE", &db, dbs.PromoCodes, dbs.PromoRedemptions)
+	if err != nil {
+		t.Errorf("Error applying promo code: %v", err)
+	}
+	ApplyPromoCode("TestID2", "once", &db, dbs.PromoCodes, dbs.PromoRedemptions)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	order, err := Checkout("TestID1", "alice", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Errorf("Error checking out: %v", err)
+	}
+	simpleAssert(t, usd(617), order.Total)
+
+	// the only use is gone so the second cart can't check out with it
+	_, err = Checkout("TestID2", "bob", "USD", tax.Location{}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, ErrPromoExhausted, err)
+}
+
+func TestPromoCodePerUserLimit(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreatePromoCode("Admin", structs.PromoCodeRequest{Code: "WELCOME", Type: structs.DiscountFixed, Amount: "1.00", PerUserLimit: 1}, dbs.PromoCodes)
+	promo, _ := GetPromoCode("WELCOME", dbs.PromoCodes)
+
+	_, err := redeemPromoCode(*promo, "TestID1", "Order1", dbs.PromoRedemptions)
+	if err != nil {
+		t.Errorf("Error redeeming promo code: %v", err)
+	}
+	_, err = redeemPromoCode(*promo, "TestID1", "Order2", dbs.PromoRedemptions)
+	simpleAssert(t, ErrPromoUserLimit, err)
+	_, err = redeemPromoCode(*promo, "TestID2", "Order3", dbs.PromoRedemptions)
+	if err != nil {
+		t.Errorf("Error redeeming promo code for another user: %v", err)
+	}
+}
+
+func TestApplyUnknownPromoCode(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	_, err := ApplyPromoCode("TestID1", "NOPE", &db, dbs.PromoCodes, dbs.PromoRedemptions)
+	simpleAssert(t, ErrPromoNotFound, err)
+}
+
+func TestPriceCartInRegionalCurrency(t *testing.T) {
+	game := createTestGame("Game1")
+	game.Price = usd(2000)
+	game.Prices = map[string]structs.Money{"EUR": structs.NewMoney(1800, "EUR")}
+	game.Discounts = []structs.Discount{{Type: structs.DiscountFixed, Amount: usd(500)}}
+	cart := structs.Cart{Games: []structs.Game{game}}
+
+	// a quarter off the base price is a quarter off the regional one
+	summary := PriceCart(cart, "EUR", nil, nil, time.Now())
+	simpleAssert(t, structs.NewMoney(1350, "EUR"), summary.Total)
+
+	// a fixed USD promo doesn't work on a EUR cart
+	promo := &structs.PromoCode{Code: "SAVE5", Type: structs.DiscountFixed, Amount: usd(500)}
+	summary = PriceCart(cart, "EUR", promo, nil, time.Now())
+	simpleAssert(t, structs.NewMoney(1350, "EUR"), summary.Total)
+	if summary.PromoError == "" {
+		t.Errorf("Expected the promo currency to be reported")
+	}
+}
+
+func TestPriceCartNotSoldInCurrency(t *testing.T) {
+	game := createTestGame("Game1")
+	game.Price = structs.NewMoney(1500, "EUR")
+	cart := structs.Cart{Games: []structs.Game{game}}
+
+	summary := PriceCart(cart, "USD", nil, nil, time.Now())
+	if summary.Error == "" {
+		t.Errorf("Expected the missing price to be reported")
+	}
+}
+
+func TestCheckoutKeepsTaxOnOrder(t *testing.T) {
+	tax.SetRules(tax.Rules{Rules: []tax.Rule{{Country: "US", Region: "TX", Name: "Sales tax", Rate: 6.25}}})
+	defer tax.SetRules(tax.Rules{})
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	order, err := Checkout("TestID1", "alice", "USD", tax.Location{Country: "us", Region: "tx"}, false, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error checking out: %v", err)
+	}
+	// 12.34 * 6.25% rounds to 0.77 on each line
+	simpleAssert(t, usd(154), order.Tax)
+	simpleAssert(t, usd(2622), order.Total)
+	simpleAssert(t, usd(2622), order.CardPaid)
+	simpleAssert(t, "TX", order.Summary.Region)
+	simpleAssert(t, usd(1311), order.Summary.Lines[0].Total)
+}
@@ -0,0 +1,348 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+var (
+	ErrOrderNotFound        = notFound("order not found")
+	ErrRefundNotFound       = notFound("refund not found")
+	ErrRefundExists         = conflict("a refund was already requested for this game")
+	ErrRefundAlreadyHandled = conflict("refund was already handled")
+	ErrRefundWindowClosed   = notAllowed("the refund window for this purchase has closed")
+	ErrRefundPlaytime       = notAllowed("the game has been played too long to be refunded")
+	ErrNotRefundable        = notAllowed("this game can't be refunded")
+)
+
+// RefundPolicy decides which purchases can be refunded. Playtime is optional,
+// when it is nil or doesn't know the game only the window is checked.
+type RefundPolicy struct {
+	Window      time.Duration
+	MaxPlaytime time.Duration
+	Playtime    func(userID string, gameID string) (time.Duration, bool)
+}
+
+// DefaultRefundPolicy is two weeks from purchase and under two hours played
+var DefaultRefundPolicy = RefundPolicy{
+	Window:      14 * 24 * time.Hour,
+	MaxPlaytime: 2 * time.Hour,
+}
+
+// RefundDatabases groups the tables the refund workflow uses
+type RefundDatabases struct {
+	Orders  database.Repository[structs.Order]
+	Refunds database.Repository[structs.Refund]
+	Wallets database.Repository[structs.WalletEntry]
+}
+
+// ----------------- Orders -----------------
+func GetOrder(orderID string, orderDB database.Repository[structs.Order]) (*structs.Order, error) {
+	order, err := orderDB.Get(orderID)
+	if err != nil {
+		return nil, missing(err, ErrOrderNotFound)
+	}
+	if order.ID != orderID {
+		return nil, ErrOrderNotFound
+	}
+	return &order, nil
+}
+
+// GetOrders returns the user's orders, newest first
+func GetOrders(userID string, orderDB database.Repository[structs.Order]) ([]structs.Order, error) {
+	found, err := orderDB.Query("UserID", userID)
+	if err != nil {
+		return nil, err
+	}
+	orders := []structs.Order{}
+	for _, order := range found {
+		if order.UserID == userID {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].Date > orders[j].Date
+	})
+	return orders, nil
+}
+
+// ----------------- Refunds -----------------
+
+// RequestRefund checks the purchase against the policy and queues the refund
+// for an admin. Gifts can't be refunded by the sender once sent.
+func RequestRefund(userID string, request structs.RefundRequest, policy RefundPolicy, dbs RefundDatabases, kafka kafka.KafkaProducer) (*structs.Refund, error) {
+	order, err := GetOrder(request.OrderID, dbs.Orders)
+	if err != nil || order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	line, ok := orderLine(*order, request.GameID)
+	if !ok {
+		return nil, ErrNotRefundable
+	}
+	err = checkRefundPolicy(userID, *order, request.GameID, policy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	refund := structs.Refund{
+		ID:        order.ID + "_" + request.GameID,
+		OrderID:   order.ID,
+		UserID:    userID,
+		GameID:    request.GameID,
+		GameTitle: line.Game.Title,
+		Amount:    line.Total,
+		ToWallet:  request.ToWallet,
+		Reason:    request.Reason,
+		Status:    structs.RefundPending,
+		Requested: time.Now().Format(time.RFC3339),
+	}
+	err = dbs.Refunds.Create(refund)
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return nil, ErrRefundExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = publishRefund("refund.requested", refund, kafka)
+	if err != nil {
+		log.Println("Error pushing refund to kafka:", err)
+		dbs.Refunds.Delete(refund.ID)
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// GetRefunds returns the user's refunds
+func GetRefunds(userID string, refundDB database.Repository[structs.Refund]) ([]structs.Refund, error) {
+	found, err := refundDB.Query("UserID", userID)
+	if err != nil {
+		return nil, err
+	}
+	refunds := []structs.Refund{}
+	for _, refund := range found {
+		if refund.UserID == userID {
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
+
+// GetRefundQueue returns the refunds waiting for an admin, oldest first
+func GetRefundQueue(refundDB database.Repository[structs.Refund]) ([]structs.Refund, error) {
+	all, err := refundDB.Scan()
+	if err != nil {
+		return nil, err
+	}
+	queue := []structs.Refund{}
+	for _, refund := range all {
+		if refund.Status == structs.RefundPending {
+			queue = append(queue, refund)
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		return queue[i].Requested < queue[j].Requested
+	})
+	return queue, nil
+}
+
+// ApproveRefund pays the refund back and publishes refund.approved, which is
+// what takes the game out of the buyer's library. The card part is whatever
+// the card paid on the order that earlier refunds haven't already given back,
+// the rest goes to the wallet. The refund is moved out of pending before the
+// wallet is credited, so of two admins approving at once only one pays.
+func ApproveRefund(refundID string, adminID string, dbs RefundDatabases, kafka kafka.KafkaProducer) (*structs.Refund, error) {
+	refund, err := getPendingRefund(refundID, dbs.Refunds)
+	if err != nil {
+		return nil, err
+	}
+	order, err := GetOrder(refund.OrderID, dbs.Orders)
+	if err != nil {
+		return nil, err
+	}
+
+	refund.CardAmount = structs.NewMoney(0, refund.Amount.Currency)
+	if !refund.ToWallet {
+		cardLeft, err := cardLeftToRefund(*order, dbs.Refunds)
+		if err != nil {
+			return nil, err
+		}
+		refund.CardAmount.Amount = min(refund.Amount.Amount, cardLeft.Amount)
+	}
+	refund.WalletAmount = refund.Amount.Sub(refund.CardAmount)
+	pending := *refund
+	refund.Status = structs.RefundApproved
+	refund.DecidedBy = adminID
+	refund.Decided = time.Now().Format(time.RFC3339)
+	refund.PublishPending = true
+
+	err = decideRefund(*refund, dbs.Refunds)
+	if err != nil {
+		return nil, err
+	}
+	if refund.WalletAmount.Amount > 0 && !walletCredited(refund.UserID, "refund", refund.ID, dbs.Wallets) {
+		_, err = CreditWallet(refund.UserID, refund.WalletAmount, "refund", refund.ID, dbs.Wallets)
+		if err != nil {
+			// back to pending so it can be approved again
+			log.Println("Error crediting refund to wallet:", err)
+			if rollbackErr := dbs.Refunds.PutIf(pending, "Status", structs.RefundApproved); rollbackErr != nil {
+				log.Println("Error putting refund back to pending:", rollbackErr)
+			}
+			return nil, err
+		}
+	}
+
+	err = publishApproval(refund, dbs.Refunds, kafka)
+	if err != nil {
+		// the money has moved, RepublishRefunds sends the event later
+		log.Println("Error pushing refund to kafka:", err)
+	}
+	return refund, nil
+}
+
+// RepublishRefunds sends refund.approved again for the approved refunds it
+// never went out for, so the library still takes the games back. Refunds
+// decided in the last minute are left to the approval still sending them.
+func RepublishRefunds(now time.Time, refundDB database.Repository[structs.Refund], kafka kafka.KafkaProducer) (int, error) {
+	all, err := refundDB.Scan()
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for _, refund := range all {
+		if refund.Status != structs.RefundApproved || !refund.PublishPending {
+			continue
+		}
+		decided, err := time.Parse(time.RFC3339, refund.Decided)
+		if err == nil && now.Sub(decided) < time.Minute {
+			continue
+		}
+		err = publishApproval(&refund, refundDB, kafka)
+		if err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// publishApproval sends refund.approved and clears PublishPending
+func publishApproval(refund *structs.Refund, refundDB database.Repository[structs.Refund], kafka kafka.KafkaProducer) error {
+	err := publishRefund("refund.approved", *refund, kafka)
+	if err != nil {
+		return err
+	}
+	refund.PublishPending = false
+	err = refundDB.Update(refund.ID, map[string]interface{}{"PublishPending": false}, nil)
+	if err != nil {
+		// sending it twice is harmless, the library already dropped the game
+		log.Println("Error clearing pending publish of refund", refund.ID, ":", err)
+	}
+	return nil
+}
+
+func DenyRefund(refundID string, adminID string, dbs RefundDatabases, kafka kafka.KafkaProducer) (*structs.Refund, error) {
+	refund, err := getPendingRefund(refundID, dbs.Refunds)
+	if err != nil {
+		return nil, err
+	}
+	refund.Status = structs.RefundDenied
+	refund.DecidedBy = adminID
+	refund.Decided = time.Now().Format(time.RFC3339)
+	err = decideRefund(*refund, dbs.Refunds)
+	if err != nil {
+		return nil, err
+	}
+	err = publishRefund("refund.denied", *refund, kafka)
+	if err != nil {
+		log.Println("Error pushing refund to kafka:", err)
+	}
+	return refund, nil
+}
+
+func checkRefundPolicy(userID string, order structs.Order, gameID string, policy RefundPolicy, now time.Time) error {
+	purchased, err := time.Parse(time.RFC3339, order.Date)
+	if err != nil {
+		return ErrNotRefundable
+	}
+	if policy.Window > 0 && now.Sub(purchased) > policy.Window {
+		return ErrRefundWindowClosed
+	}
+	if policy.Playtime != nil && policy.MaxPlaytime > 0 {
+		played, tracked := policy.Playtime(userID, gameID)
+		if tracked && played > policy.MaxPlaytime {
+			return ErrRefundPlaytime
+		}
+	}
+	return nil
+}
+
+// orderLine finds the game in the order. Only games the buyer kept count,
+// gifts went to someone else's library.
+func orderLine(order structs.Order, gameID string) (structs.CartLine, bool) {
+	for _, line := range order.Summary.Lines {
+		if line.Game.ID == gameID && line.Game.GiftTo == "" {
+			return line, true
+		}
+	}
+	return structs.CartLine{}, false
+}
+
+func cardLeftToRefund(order structs.Order, refundDB database.Repository[structs.Refund]) (structs.Money, error) {
+	left := order.CardPaid
+	found, err := refundDB.Query("OrderID", order.ID)
+	if err != nil {
+		return left, err
+	}
+	for _, refund := range found {
+		if refund.OrderID == order.ID && refund.Status == structs.RefundApproved {
+			left = left.Sub(refund.CardAmount)
+		}
+	}
+	if left.Amount < 0 {
+		left.Amount = 0
+	}
+	return left, nil
+}
+
+// decideRefund saves the decided refund only if it is still pending, anyone
+// who decided it first wins
+func decideRefund(refund structs.Refund, refundDB database.Repository[structs.Refund]) error {
+	err := refundDB.PutIf(refund, "Status", structs.RefundPending)
+	if errors.Is(err, database.ErrConditionFailed) {
+		return ErrRefundAlreadyHandled
+	}
+	if err != nil {
+		log.Println("Error saving refund:", err)
+	}
+	return err
+}
+
+func getPendingRefund(refundID string, refundDB database.Repository[structs.Refund]) (*structs.Refund, error) {
+	refund, err := refundDB.Get(refundID)
+	if err != nil {
+		return nil, missing(err, ErrRefundNotFound)
+	}
+	if refund.ID != refundID {
+		return nil, ErrRefundNotFound
+	}
+	if refund.Status != structs.RefundPending {
+		return nil, ErrRefundAlreadyHandled
+	}
+	return &refund, nil
+}
+
+func publishRefund(topic string, refund structs.Refund, kafka kafka.KafkaProducer) error {
+	refundJson, err := json.Marshal(refund)
+	if err != nil {
+		return err
+	}
+	return kafka.PushCommentToQueue(topic, refund.UserID, refundJson)
+}
@@ -0,0 +1,209 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+// checkoutForRefund buys Game1 and Game2 for TestID1, 10.00 of it from the wallet
+func checkoutForRefund(t *testing.T, dbs CheckoutDatabases) (*structs.Order, RefundDatabases) {
+	db.Init("Test", "ID")
+	CreditWallet("TestID1", usd(1000), "test", "", dbs.Wallets)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+	CreateORUpdateCart("TestID1", "Game2", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	order, err := Checkout("TestID1", "tester", "USD", tax.Location{}, true, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error checking out: %v", err)
+	}
+	refundDB := database.Memory[structs.Refund]{}
+	refundDB.Init("Refunds", "ID")
+	refundDBs := RefundDatabases{
+		Orders:  dbs.Orders,
+		Refunds: &refundDB,
+		Wallets: dbs.Wallets,
+	}
+	return order, refundDBs
+}
+
+func TestRequestAndApproveRefund(t *testing.T) {
+	dbs := newCheckoutDatabases()
+	order, refundDBs := checkoutForRefund(t, dbs)
+	// 24.68 paid, 10.00 from the wallet and 14.68 by card
+	simpleAssert(t, usd(1468), order.CardPaid)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+
+	refund, err := RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1"}, DefaultRefundPolicy, refundDBs, k)
+	if err != nil {
+		t.Fatalf("Error requesting refund: %v", err)
+	}
+	simpleAssert(t, structs.RefundPending, refund.Status)
+	queue, _ := GetRefundQueue(refundDBs.Refunds)
+	simpleAssert(t, 1, len(queue))
+
+	// the card gets its 12.34 back in full
+	refund, err = ApproveRefund(refund.ID, "Admin", refundDBs, k)
+	if err != nil {
+		t.Fatalf("Error approving refund: %v", err)
+	}
+	simpleAssert(t, usd(1234), refund.CardAmount)
+	simpleAssert(t, usd(0), refund.WalletAmount)
+
+	// only 2.34 is left on the card so the rest of the second refund goes to the wallet
+	refund, _ = RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game2"}, DefaultRefundPolicy, refundDBs, k)
+	refund, err = ApproveRefund(refund.ID, "Admin", refundDBs, k)
+	if err != nil {
+		t.Fatalf("Error approving refund: %v", err)
+	}
+	simpleAssert(t, usd(234), refund.CardAmount)
+	simpleAssert(t, usd(1000), refund.WalletAmount)
+	wallet, _ := GetWallet("TestID1", dbs.Wallets)
+	simpleAssert(t, usd(1000), wallet.Balance)
+
+	queue, _ = GetRefundQueue(refundDBs.Refunds)
+	simpleAssert(t, 0, len(queue))
+	_, err = ApproveRefund(refund.ID, "Admin", refundDBs, k)
+	simpleAssert(t, ErrRefundAlreadyHandled, err)
+}
+
+func TestRefundToWallet(t *testing.T) {
+	dbs := newCheckoutDatabases()
+	order, refundDBs := checkoutForRefund(t, dbs)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+	refund, _ := RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1", ToWallet: true}, DefaultRefundPolicy, refundDBs, k)
+	ApproveRefund(refund.ID, "Admin", refundDBs, k)
+
+	wallet, _ := GetWallet("TestID1", dbs.Wallets)
+	simpleAssert(t, usd(1234), wallet.Balance)
+}
+
+// staleRefunds reads every refund as still pending, like an admin who loaded
+// the queue before someone else decided it
+type staleRefunds struct {
+	database.Repository[structs.Refund]
+}
+
+func (s staleRefunds) Get(key string) (structs.Refund, error) {
+	refund, err := s.Repository.Get(key)
+	refund.Status = structs.RefundPending
+	return refund, err
+}
+
+func TestApproveRefundTwiceAtOnce(t *testing.T) {
+	dbs := newCheckoutDatabases()
+	order, refundDBs := checkoutForRefund(t, dbs)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+	refund, _ := RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1", ToWallet: true}, DefaultRefundPolicy, refundDBs, k)
+	_, err := ApproveRefund(refund.ID, "Admin", refundDBs, k)
+	if err != nil {
+		t.Fatalf("Error approving refund: %v", err)
+	}
+
+	stale := refundDBs
+	stale.Refunds = staleRefunds{refundDBs.Refunds}
+	_, err = ApproveRefund(refund.ID, "Admin2", stale, k)
+	simpleAssert(t, ErrRefundAlreadyHandled, err)
+	_, err = DenyRefund(refund.ID, "Admin2", stale, k)
+	simpleAssert(t, ErrRefundAlreadyHandled, err)
+
+	wallet, _ := GetWallet("TestID1", dbs.Wallets)
+	simpleAssert(t, usd(1234), wallet.Balance)
+	saved, _ := refundDBs.Refunds.Get(refund.ID)
+	simpleAssert(t, "Admin", saved.DecidedBy)
+}
+
+func TestRepublishApprovedRefund(t *testing.T) {
+	dbs := newCheckoutDatabases()
+	order, refundDBs := checkoutForRefund(t, dbs)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	k := kafka.KafkaProducer{Producer: producer}
+	refund, _ := RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1"}, DefaultRefundPolicy, refundDBs, k)
+	// the refund is paid even though the event didn't go out
+	refund, err := ApproveRefund(refund.ID, "Admin", refundDBs, k)
+	if err != nil {
+		t.Fatalf("Error approving refund: %v", err)
+	}
+	saved, _ := refundDBs.Refunds.Get(refund.ID)
+	simpleAssert(t, true, saved.PublishPending)
+
+	// too soon, the approval may still be sending it
+	published, _ := RepublishRefunds(time.Now(), refundDBs.Refunds, k)
+	simpleAssert(t, 0, published)
+
+	producer.ExpectSendMessageAndSucceed()
+	published, err = RepublishRefunds(time.Now().Add(time.Hour), refundDBs.Refunds, k)
+	if err != nil {
+		t.Fatalf("Error republishing refunds: %v", err)
+	}
+	simpleAssert(t, 1, published)
+	saved, _ = refundDBs.Refunds.Get(refund.ID)
+	simpleAssert(t, false, saved.PublishPending)
+	published, _ = RepublishRefunds(time.Now().Add(time.Hour), refundDBs.Refunds, k)
+	simpleAssert(t, 0, published)
+}
+
+func TestRefundRules(t *testing.T) {
+	dbs := newCheckoutDatabases()
+	order, refundDBs := checkoutForRefund(t, dbs)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+
+	_, err := RequestRefund("TestID2", structs.RefundRequest{OrderID: order.ID, GameID: "Game1"}, DefaultRefundPolicy, refundDBs, k)
+	simpleAssert(t, ErrOrderNotFound, err)
+	_, err = RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game3"}, DefaultRefundPolicy, refundDBs, k)
+	simpleAssert(t, ErrNotRefundable, err)
+
+	played := DefaultRefundPolicy
+	played.Playtime = func(userID string, gameID string) (time.Duration, bool) {
+		return 3 * time.Hour, true
+	}
+	_, err = RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1"}, played, refundDBs, k)
+	simpleAssert(t, ErrRefundPlaytime, err)
+
+	_, err = RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1"}, DefaultRefundPolicy, refundDBs, k)
+	if err != nil {
+		t.Errorf("Error requesting refund: %v", err)
+	}
+	_, err = RequestRefund("TestID1", structs.RefundRequest{OrderID: order.ID, GameID: "Game1"}, DefaultRefundPolicy, refundDBs, k)
+	simpleAssert(t, ErrRefundExists, err)
+}
+
+func TestRefundWindow(t *testing.T) {
+	order := structs.Order{Date: "2024-01-01T00:00:00Z"}
+	now, _ := time.Parse(time.RFC3339, "2024-01-10T00:00:00Z")
+	err := checkRefundPolicy("TestID1", order, "Game1", DefaultRefundPolicy, now)
+	simpleAssert(t, nil, err)
+
+	now, _ = time.Parse(time.RFC3339, "2024-01-20T00:00:00Z")
+	err = checkRefundPolicy("TestID1", order, "Game1", DefaultRefundPolicy, now)
+	simpleAssert(t, ErrRefundWindowClosed, err)
+}
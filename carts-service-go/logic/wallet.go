@@ -0,0 +1,242 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	database "github.com/Draupniyr/carts-service/database"
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+var (
+	ErrInsufficientFunds = notAllowed("insufficient wallet balance")
+	ErrInvalidAmount     = invalid("amount must be greater than zero")
+	ErrWalletBusy        = conflict("wallet is busy, try again")
+	ErrGiftCardNotFound  = notFound("gift card not found")
+	ErrGiftCardRedeemed  = conflict("gift card already redeemed")
+	ErrCurrencyMismatch  = conflict("wallet holds a different currency")
+)
+
+// how many times an append is retried when another writer took the sequence number first
+const walletAppendAttempts = 5
+
+// ----------------- Wallet -----------------
+func GetWallet(userID string, walletDB database.Repository[structs.WalletEntry]) (structs.Wallet, error) {
+	// no ledger rows yet means an empty wallet
+	entries, err := walletDB.Query("UserID", userID)
+	if err != nil {
+		return structs.Wallet{}, err
+	}
+	sortEntries(entries)
+
+	wallet := structs.Wallet{
+		UserID:  userID,
+		Balance: structs.NewMoney(0, structs.DefaultCurrency),
+		Entries: entries,
+	}
+	if len(entries) > 0 {
+		wallet.Balance = entries[len(entries)-1].Balance
+	}
+	return wallet, nil
+}
+
+func CreditWallet(userID string, amount structs.Money, reason string, reference string, walletDB database.Repository[structs.WalletEntry]) (structs.WalletEntry, error) {
+	return appendWalletEntry(userID, structs.WalletCredit, amount, reason, reference, walletDB)
+}
+
+func DebitWallet(userID string, amount structs.Money, reason string, reference string, walletDB database.Repository[structs.WalletEntry]) (structs.WalletEntry, error) {
+	return appendWalletEntry(userID, structs.WalletDebit, amount, reason, reference, walletDB)
+}
+
+// appendWalletEntry reads the latest ledger row and writes the next one with a
+// conditional create. If another checkout appended first the create fails, and
+// we retry against the new balance, so the balance can never go below zero.
+// An empty wallet takes the currency of whatever is credited to it.
+func appendWalletEntry(userID string, entryType string, amount structs.Money, reason string, reference string, walletDB database.Repository[structs.WalletEntry]) (structs.WalletEntry, error) {
+	if amount.Amount <= 0 {
+		return structs.WalletEntry{}, ErrInvalidAmount
+	}
+	for attempt := 0; attempt < walletAppendAttempts; attempt++ {
+		wallet, err := GetWallet(userID, walletDB)
+		if err != nil {
+			return structs.WalletEntry{}, err
+		}
+
+		balance := wallet.Balance
+		if balance.IsZero() {
+			balance.Currency = amount.Currency
+		}
+		if balance.Currency != amount.Currency {
+			return structs.WalletEntry{}, ErrCurrencyMismatch
+		}
+		if entryType == structs.WalletDebit {
+			if balance.Amount < amount.Amount {
+				return structs.WalletEntry{}, ErrInsufficientFunds
+			}
+			balance = balance.Sub(amount)
+		} else {
+			balance = balance.Add(amount)
+		}
+
+		sequence := 1
+		if len(wallet.Entries) > 0 {
+			sequence = wallet.Entries[len(wallet.Entries)-1].Sequence + 1
+		}
+		entry := structs.WalletEntry{
+			ID:        fmt.Sprintf("%s#%010d", userID, sequence),
+			UserID:    userID,
+			Sequence:  sequence,
+			Type:      entryType,
+			Amount:    amount,
+			Balance:   balance,
+			Reason:    reason,
+			Reference: reference,
+			Date:      time.Now().Format(time.RFC3339),
+		}
+		err = walletDB.Create(entry)
+		if errors.Is(err, database.ErrAlreadyExists) {
+			log.Println("Wallet entry", entry.ID, "already written, retrying")
+			continue
+		}
+		if err != nil {
+			return structs.WalletEntry{}, err
+		}
+		return entry, nil
+	}
+	return structs.WalletEntry{}, ErrWalletBusy
+}
+
+// walletCredited reports if the wallet already has the credit for the reason
+// and reference, so trying again after a credit that failed half way doesn't
+// pay twice
+func walletCredited(userID string, reason string, reference string, walletDB database.Repository[structs.WalletEntry]) bool {
+	wallet, err := GetWallet(userID, walletDB)
+	if err != nil {
+		return false
+	}
+	for _, entry := range wallet.Entries {
+		if entry.Type == structs.WalletCredit && entry.Reason == reason && entry.Reference == reference {
+			return true
+		}
+	}
+	return false
+}
+
+func sortEntries(entries []structs.WalletEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+}
+
+// ----------------- Gift cards -----------------
+func CreateGiftCard(adminID string, request structs.GiftCardRequest, giftCardDB database.Repository[structs.GiftCard]) (structs.GiftCard, error) {
+	amount, err := parseAmount(request.Amount, structs.NormalizeCurrency(request.Currency))
+	if err != nil {
+		return structs.GiftCard{}, err
+	}
+	if amount.Amount <= 0 {
+		return structs.GiftCard{}, ErrInvalidAmount
+	}
+	code, err := newGiftCardCode()
+	if err != nil {
+		return structs.GiftCard{}, err
+	}
+	card := structs.GiftCard{
+		ID:        code,
+		Code:      code,
+		Amount:    amount,
+		CreatedBy: adminID,
+		Created:   time.Now().Format(time.RFC3339),
+	}
+	err = giftCardDB.Create(card)
+	if err != nil {
+		return structs.GiftCard{}, err
+	}
+	return card, nil
+}
+
+func GetAllGiftCards(giftCardDB database.Repository[structs.GiftCard]) ([]structs.GiftCard, error) {
+	cards, err := giftCardDB.Scan()
+	if err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+// RedeemGiftCard claims the code with a conditional create in the redemption
+// table and then credits the wallet. If the credit fails the claim is released.
+func RedeemGiftCard(userID string, code string, giftCardDB database.Repository[structs.GiftCard], redemptionDB database.Repository[structs.GiftCardRedemption], walletDB database.Repository[structs.WalletEntry]) (structs.WalletEntry, error) {
+	code = normalizeGiftCardCode(code)
+	card, err := giftCardDB.Get(code)
+	if err != nil {
+		return structs.WalletEntry{}, missing(err, ErrGiftCardNotFound)
+	}
+
+	err = redemptionDB.Create(structs.GiftCardRedemption{
+		ID:     card.ID,
+		UserID: userID,
+		Date:   time.Now().Format(time.RFC3339),
+	})
+	if errors.Is(err, database.ErrAlreadyExists) {
+		return structs.WalletEntry{}, ErrGiftCardRedeemed
+	}
+	if err != nil {
+		return structs.WalletEntry{}, err
+	}
+
+	entry, err := CreditWallet(userID, card.Amount, "giftcard", card.ID, walletDB)
+	if err != nil {
+		log.Println("Error crediting gift card, releasing claim:", err)
+		redemptionDB.Delete(card.ID)
+		return structs.WalletEntry{}, err
+	}
+
+	card.RedeemedBy = userID
+	card.Redeemed = entry.Date
+	err = giftCardDB.Put(card)
+	if err != nil {
+		log.Println("Error marking gift card redeemed:", err)
+	}
+	return entry, nil
+}
+
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newGiftCardCode returns a code like ABCD-EFGH-JKLM-NPQR
+func newGiftCardCode() (string, error) {
+	var sb strings.Builder
+	for i := 0; i < 16; i++ {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(giftCardAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+func normalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// parseAmount reads a decimal amount from a request, empty is zero
+func parseAmount(amount json.Number, currency string) (structs.Money, error) {
+	if amount == "" {
+		return structs.NewMoney(0, currency), nil
+	}
+	money, err := structs.ParseMoney(amount.String(), currency)
+	if err != nil {
+		return structs.Money{}, ErrInvalidAmount
+	}
+	return money, nil
+}
@@ -0,0 +1,146 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/carts-service/database"
+	kafka "github.com/Draupniyr/carts-service/kafka"
+	"github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
+)
+
+func TestCreditAndDebitWallet(t *testing.T) {
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+
+	CreditWallet("User1", usd(2000), "test", "", &walletDB)
+	DebitWallet("User1", usd(500), "test", "", &walletDB)
+
+	wallet, err := GetWallet("User1", &walletDB)
+	if err != nil {
+		t.Errorf("Error getting wallet: %v", err)
+	}
+	simpleAssert(t, usd(1500), wallet.Balance)
+	simpleAssert(t, 2, len(wallet.Entries))
+	simpleAssert(t, "User1#0000000002", wallet.Entries[1].ID)
+}
+
+func TestDebitWalletInsufficientFunds(t *testing.T) {
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+
+	CreditWallet("User1", usd(500), "test", "", &walletDB)
+	_, err := DebitWallet("User1", usd(1000), "test", "", &walletDB)
+	simpleAssert(t, ErrInsufficientFunds, err)
+
+	wallet, _ := GetWallet("User1", &walletDB)
+	simpleAssert(t, usd(500), wallet.Balance)
+}
+
+// racingDB lets another debit sneak in right before our first write lands
+type racingDB struct {
+	*database.Memory[structs.WalletEntry]
+	raced bool
+}
+
+func (db *racingDB) Create(entry structs.WalletEntry) error {
+	if !db.raced {
+		db.raced = true
+		DebitWallet("User1", usd(800), "other checkout", "", db.Memory)
+	}
+	return db.Memory.Create(entry)
+}
+
+func TestDebitWalletConcurrentCheckout(t *testing.T) {
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+	CreditWallet("User1", usd(1000), "test", "", &walletDB)
+
+	// the other checkout takes 8 of the 10, so our debit of 5 must now fail
+	_, err := DebitWallet("User1", usd(500), "test", "", &racingDB{Memory: &walletDB})
+	simpleAssert(t, ErrInsufficientFunds, err)
+
+	wallet, _ := GetWallet("User1", &walletDB)
+	simpleAssert(t, usd(200), wallet.Balance)
+}
+
+func TestRedeemGiftCardOnce(t *testing.T) {
+	giftCardDB := database.Memory[structs.GiftCard]{}
+	giftCardDB.Init("GiftCards", "ID")
+	redemptionDB := database.Memory[structs.GiftCardRedemption]{}
+	redemptionDB.Init("GiftCardRedemptions", "ID")
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+
+	card, err := CreateGiftCard("Admin", structs.GiftCardRequest{Amount: "25"}, &giftCardDB)
+	if err != nil {
+		t.Errorf("Error creating gift card: %v", err)
+	}
+
+	_, err = RedeemGiftCard("User1", card.Code, &giftCardDB, &redemptionDB, &walletDB)
+	if err != nil {
+		t.Errorf("Error redeeming gift card: %v", err)
+	}
+	_, err = RedeemGiftCard("User2", card.Code, &giftCardDB, &redemptionDB, &walletDB)
+	simpleAssert(t, ErrGiftCardRedeemed, err)
+
+	wallet, _ := GetWallet("User1", &walletDB)
+	simpleAssert(t, usd(2500), wallet.Balance)
+	wallet, _ = GetWallet("User2", &walletDB)
+	simpleAssert(t, usd(0), wallet.Balance)
+}
+
+func TestCheckoutPartlyFromWallet(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreditWallet("TestID1", usd(1000), "test", "", dbs.Wallets)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	order, err := Checkout("TestID1", "tester", "USD", tax.Location{}, true, dbs, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Errorf("Error checking out: %v", err)
+	}
+
+	simpleAssert(t, usd(1000), order.WalletPaid)
+	simpleAssert(t, usd(234), order.CardPaid)
+	wallet, _ := GetWallet("TestID1", dbs.Wallets)
+	simpleAssert(t, usd(0), wallet.Balance)
+	simpleAssert(t, 0, len(db.Items))
+}
+
+func TestCheckoutRefundsWalletWhenPublishFails(t *testing.T) {
+	db.Init("Test", "ID")
+	dbs := newCheckoutDatabases()
+	CreditWallet("TestID1", usd(5000), "test", "", dbs.Wallets)
+	CreateORUpdateCart("TestID1", "Game1", testGames, &db)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	_, err := Checkout("TestID1", "tester", "USD", tax.Location{}, true, dbs, kafka.KafkaProducer{Producer: producer})
+	if err == nil {
+		t.Errorf("Expected checkout to fail")
+	}
+
+	wallet, _ := GetWallet("TestID1", dbs.Wallets)
+	simpleAssert(t, usd(5000), wallet.Balance)
+	simpleAssert(t, 1, len(db.Items))
+	simpleAssert(t, "", db.Items[0].CheckoutID)
+	simpleAssert(t, structs.WalletCredit, wallet.Entries[len(wallet.Entries)-1].Type)
+}
+
+func TestWalletKeepsOneCurrency(t *testing.T) {
+	walletDB := database.Memory[structs.WalletEntry]{}
+	walletDB.Init("Wallets", "ID")
+
+	CreditWallet("User1", usd(1000), "test", "", &walletDB)
+	_, err := CreditWallet("User1", structs.NewMoney(1000, "EUR"), "test", "", &walletDB)
+	simpleAssert(t, ErrCurrencyMismatch, err)
+
+	wallet, _ := GetWallet("User1", &walletDB)
+	simpleAssert(t, usd(1000), wallet.Balance)
+}
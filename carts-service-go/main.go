@@ -2,23 +2,44 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"html/template"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/consul/api"
 
 	auth "github.com/Draupniyr/carts-service/auth"
 	database "github.com/Draupniyr/carts-service/database"
+	games "github.com/Draupniyr/carts-service/games"
 	kafkaProducer "github.com/Draupniyr/carts-service/kafka"
 	logic "github.com/Draupniyr/carts-service/logic"
+	problem "github.com/Draupniyr/carts-service/problem"
 	structs "github.com/Draupniyr/carts-service/structs"
+	tax "github.com/Draupniyr/carts-service/tax"
 )
 
-var db database.Database
+var db database.Table[structs.Cart]
+var walletDB database.Table[structs.WalletEntry]
+var giftCardDB database.Table[structs.GiftCard]
+var redemptionDB database.Table[structs.GiftCardRedemption]
+var giftDB database.Table[structs.Gift]
+var promoDB database.Table[structs.PromoCode]
+var promoRedemptionDB database.Table[structs.PromoRedemption]
+var orderDB database.Table[structs.Order]
+var refundDB database.Table[structs.Refund]
+var refundPolicy = logic.DefaultRefundPolicy
+var abandonedNoticeDB database.Table[structs.AbandonedCartNotice]
 var consulClient *api.Client
+var gamesClient games.Client
+var users auth.Users
 var kafka kafkaProducer.KafkaProducer
 
 
@@ -27,8 +48,73 @@ func init() {
 	if err != nil {
 		log.Fatal("Error initializing database:", err)
 	}
+	err = walletDB.Init("Wallets", "ID")
+	if err != nil {
+		log.Fatal("Error initializing wallet database:", err)
+	}
+	err = giftCardDB.Init("GiftCards", "ID")
+	if err != nil {
+		log.Fatal("Error initializing gift card database:", err)
+	}
+	err = redemptionDB.Init("GiftCardRedemptions", "ID")
+	if err != nil {
+		log.Fatal("Error initializing gift card redemption database:", err)
+	}
+	err = giftDB.Init("Gifts", "ID")
+	if err != nil {
+		log.Fatal("Error initializing gift database:", err)
+	}
+	err = promoDB.Init("PromoCodes", "ID")
+	if err != nil {
+		log.Fatal("Error initializing promo code database:", err)
+	}
+	err = promoRedemptionDB.Init("PromoRedemptions", "ID")
+	if err != nil {
+		log.Fatal("Error initializing promo redemption database:", err)
+	}
+	err = orderDB.Init("Orders", "ID")
+	if err != nil {
+		log.Fatal("Error initializing order database:", err)
+	}
+	err = refundDB.Init("Refunds", "ID")
+	if err != nil {
+		log.Fatal("Error initializing refund database:", err)
+	}
+	err = abandonedNoticeDB.Init("AbandonedCartNotices", "ID")
+	if err != nil {
+		log.Fatal("Error initializing abandoned cart database:", err)
+	}
+	err = db.EnableTTL("ExpiresAt")
+	if err != nil {
+		log.Println("Error enabling TTL on", db.TableName, ":", err)
+	}
+	err = abandonedNoticeDB.EnableTTL("ExpiresAt")
+	if err != nil {
+		log.Println("Error enabling TTL on", abandonedNoticeDB.TableName, ":", err)
+	}
+	if days, err := strconv.Atoi(os.Getenv("CART_TTL_DAYS")); err == nil {
+		logic.CartTTL = time.Duration(days) * 24 * time.Hour
+	}
 	log.Println("Database initialized")
 
+	if days, err := strconv.Atoi(os.Getenv("REFUND_WINDOW_DAYS")); err == nil {
+		refundPolicy.Window = time.Duration(days) * 24 * time.Hour
+	}
+	if minutes, err := strconv.Atoi(os.Getenv("REFUND_MAX_PLAYTIME_MINUTES")); err == nil {
+		refundPolicy.MaxPlaytime = time.Duration(minutes) * time.Minute
+	}
+
+	taxRulesFile := os.Getenv("TAX_RULES_FILE")
+	if taxRulesFile == "" {
+		taxRulesFile = "taxrules.json"
+	}
+	// pricing orders without the rules would charge no tax at all
+	err = tax.LoadRules(taxRulesFile)
+	if err != nil {
+		log.Fatal("Error loading tax rules from ", taxRulesFile, ": ", err)
+	}
+	log.Println("Tax rules loaded from", taxRulesFile)
+
 	err = kafka.InitKafkaProducer()
 	for err != nil {
 		err = kafka.InitKafkaProducer()
@@ -36,6 +122,9 @@ func init() {
 	}
 	log.Println("Kafka producer initialized")
 
+	gamesClient = games.NewClient()
+	users = auth.NewUsers()
+
 	consulConfig := api.DefaultConfig()
 	consulConfig.Address = os.Getenv("CONSUL_ADDRESS")
 	consulClient, err = api.NewClient(consulConfig)
@@ -54,10 +143,31 @@ func main() {
 	}
 	// http.Handle("/games/dev/create", auth.Authorize(http.HandlerFunc(createGame)))
 
-	http.Handle("/carts/all", auth.Authorize(http.HandlerFunc(CartsHandlerAll)))
-	http.Handle("/carts", auth.Authorize(http.HandlerFunc(CartsHandler)))
+	http.Handle("/carts/all", auth.Authorize(http.HandlerFunc(CartsHandlerAll), "admin"))
+	// guests can fill a cart before logging in, see cartOwner
+	http.Handle("/carts", auth.Optional(http.HandlerFunc(CartsHandler)))
+	http.Handle("/carts/merge", auth.Authorize(http.HandlerFunc(mergeGuestCart)))
 	http.Handle("/carts/checkout", auth.Authorize(http.HandlerFunc(checkout)))
-	log.Fatal(http.ListenAndServe(":3000", nil))
+	http.Handle("/carts/wallet", auth.Authorize(http.HandlerFunc(getWallet)))
+	http.Handle("/carts/wallet/redeem", auth.Authorize(http.HandlerFunc(redeemGiftCard)))
+	http.Handle("/carts/promo", auth.Authorize(http.HandlerFunc(applyPromoCode)))
+	http.Handle("/carts/gift", auth.Authorize(http.HandlerFunc(setGiftRecipient)))
+	http.Handle("/carts/gifts", auth.Authorize(http.HandlerFunc(getGiftInbox)))
+	http.Handle("/carts/gifts/{id}/accept", auth.Authorize(http.HandlerFunc(acceptGift)))
+	http.Handle("/carts/gifts/{id}/decline", auth.Authorize(http.HandlerFunc(declineGift)))
+	http.Handle("/carts/gifts/{id}/cancel", auth.Authorize(http.HandlerFunc(cancelGift)))
+	http.Handle("/carts/orders", auth.Authorize(http.HandlerFunc(getOrders)))
+	http.Handle("/carts/refunds", auth.Authorize(http.HandlerFunc(requestRefund)))
+
+	// Admin endpoints
+	http.Handle("/carts/admin/giftcards", auth.Authorize(http.HandlerFunc(GiftCardsHandler), "admin"))
+	http.Handle("/carts/admin/promos", auth.Authorize(http.HandlerFunc(PromoCodesHandler), "admin"))
+	http.Handle("/carts/admin/refunds", auth.Authorize(http.HandlerFunc(getRefundQueue), "admin"))
+	http.Handle("/carts/admin/refunds/{id}/approve", auth.Authorize(http.HandlerFunc(approveRefund), "admin"))
+	http.Handle("/carts/admin/refunds/{id}/deny", auth.Authorize(http.HandlerFunc(denyRefund), "admin"))
+
+	go runAbandonedCartJob()
+	go runRefundPublishJob()
 
 	log.Printf("Carts service listening on port %d", port)
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), nil))
@@ -97,7 +207,7 @@ func CartsHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPatch:
 		updateCartID(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -109,25 +219,128 @@ func CartsHandlerAll(w http.ResponseWriter, r *http.Request) {
 	case http.MethodDelete: // ADMIN
 		deleteCart(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 
 func getCartsID(w http.ResponseWriter, r *http.Request) {
 	log.Println("GET /carts hit")
-	id := r.Context().Value("userID").(string)
+	id := cartOwner(w, r)
+
+	// the location form in the cart sends the buyer's country, remember it for checkout
+	if r.URL.Query().Has("country") {
+		http.SetCookie(w, &http.Cookie{Name: "country", Value: r.URL.Query().Get("country"), Path: "/"})
+		http.SetCookie(w, &http.Cookie{Name: "region", Value: r.URL.Query().Get("region"), Path: "/"})
+	}
 
 	cart, err := logic.GetCart(id, &db)
 	if err != nil {
-		log.Println("Error getting item from Carts table:", err)
-		http.Error(w, "Cart not found", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
-	renderTemplate(w, "cart.html", map[string]interface{}{
-		"Cart": cart,
-	})
+	renderCart(w, r, cart)
+}
+
+// cartOwner is who the cart belongs to. Visitors that aren't logged in get a
+// guest cart keyed by the guest_cart cookie, and the first request after they
+// log in merges it into their own cart.
+func cartOwner(w http.ResponseWriter, r *http.Request) string {
+	userID, _ := r.Context().Value("userID").(string)
+	guestToken := ""
+	if cookie, err := r.Cookie("guest_cart"); err == nil {
+		if _, err := uuid.Parse(cookie.Value); err == nil {
+			guestToken = cookie.Value
+		}
+	}
+
+	if userID != "" {
+		if guestToken != "" {
+			_, err := logic.MergeGuestCart(logic.GuestUserID(guestToken), userID, &db)
+			if err != nil {
+				log.Println("Error merging guest cart:", err)
+			} else {
+				http.SetCookie(w, &http.Cookie{Name: "guest_cart", Value: "", Path: "/", MaxAge: -1})
+			}
+		}
+		return userID
+	}
+
+	if guestToken == "" {
+		guestToken = uuid.New().String()
+		http.SetCookie(w, &http.Cookie{
+			Name:     "guest_cart",
+			Value:    guestToken,
+			Path:     "/",
+			MaxAge:   int(logic.GuestCartTTL.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return logic.GuestUserID(guestToken)
+}
+
+// mergeGuestCart is called by the frontend right after login
+func mergeGuestCart(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/merge hit")
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := cartOwner(w, r)
+	cart, err := logic.GetCart(userID, &db)
+	if err != nil {
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderCart(w, r, cart)
+}
+
+// runAbandonedCartJob looks for idle carts every CART_ABANDONED_INTERVAL_MINUTES
+// and publishes cart.abandoned for the ones idle over CART_ABANDONED_AFTER_HOURS
+func runAbandonedCartJob() {
+	idle := 24 * time.Hour
+	if hours, err := strconv.Atoi(os.Getenv("CART_ABANDONED_AFTER_HOURS")); err == nil {
+		idle = time.Duration(hours) * time.Hour
+	}
+	interval := time.Hour
+	if minutes, err := strconv.Atoi(os.Getenv("CART_ABANDONED_INTERVAL_MINUTES")); err == nil && minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		published, err := logic.PublishAbandonedCarts(idle, &db, &abandonedNoticeDB, kafka)
+		if err != nil {
+			log.Println("Error publishing abandoned carts:", err)
+		}
+		if published > 0 {
+			log.Println("Published", published, "abandoned carts")
+		}
+	}
+}
+
+// runRefundPublishJob sends refund.approved again every
+// REFUND_REPUBLISH_INTERVAL_MINUTES for approved refunds it failed to go out for
+func runRefundPublishJob() {
+	interval := 5 * time.Minute
+	if minutes, err := strconv.Atoi(os.Getenv("REFUND_REPUBLISH_INTERVAL_MINUTES")); err == nil && minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		published, err := logic.RepublishRefunds(time.Now(), &refundDB, kafka)
+		if err != nil {
+			log.Println("Error republishing refunds:", err)
+		}
+		if published > 0 {
+			log.Println("Republished", published, "approved refunds")
+		}
+	}
 }
 
 func getCarts(w http.ResponseWriter, r *http.Request) {
@@ -136,11 +349,11 @@ func getCarts(w http.ResponseWriter, r *http.Request) {
 	carts, err := logic.GetAllCarts(&db)
 	if err != nil {
 		log.Println("Error getting items from Carts table:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "cart.html", map[string]interface{}{
+	renderTemplate(w, r, "cart.html", map[string]interface{}{
 		"Carts": carts,
 	})
 }
@@ -148,20 +361,21 @@ func getCarts(w http.ResponseWriter, r *http.Request) {
 func createCart(w http.ResponseWriter, r *http.Request) {
 	// Create a new cart
 	log.Println("POST to /carts hit")
-	id := r.Context().Value("userID").(string)
+	id := cartOwner(w, r)
 
 	var game structs.Game
 	err := json.NewDecoder(r.Body).Decode(&game)
 	if err != nil {
 		log.Println("Error decoding request body:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	err = logic.CreateORUpdateCart(id, game, &db)
+	// only the ID is taken from the body, the rest comes from the games service
+	err = logic.CreateORUpdateCart(id, game.ID, gamesClient.Get, &db)
 	if err != nil {
 		log.Println("Error creating item in Carts table:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 }
@@ -170,11 +384,10 @@ func createCart(w http.ResponseWriter, r *http.Request) {
 func deleteCartID(w http.ResponseWriter, r *http.Request) {
 	// Delete the cart with the given ID
 	log.Println("DELETE /carts hit")
-	id := r.Context().Value("userID").(string)
+	id := cartOwner(w, r)
 	err := logic.DeleteCart(id, &db)
 	if err != nil {
-		log.Println("Error deleting item from Carts table:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 }
@@ -183,37 +396,36 @@ func deleteCart(w http.ResponseWriter, r *http.Request) {
 	// Delete all items from the Carts table
 	log.Println("DELETE /carts/all hit")
 
+	carts, _ := logic.GetAllCarts(&db)
 	err := logic.DeleteAll(&db)
 	if err != nil {
 		log.Println("Error deleting items from Carts table:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	audit(r, "cart.delete_all", "carts", "", map[string]int{"Count": len(carts)}, nil)
 }
 
 func updateCartID(w http.ResponseWriter, r *http.Request) {
 	// Update the cart with the given ID
 	log.Println("PATCH /carts hit")
 
-	userID := r.Context().Value("userID").(string)
+	userID := cartOwner(w, r)
 
 	var game structs.Game
 	err := json.NewDecoder(r.Body).Decode(&game)
 	if err != nil {
 		log.Println("Error decoding request body:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	cart, err := logic.AddOrRemoveFromCart(userID, game, &db)
+	cart, err := logic.AddOrRemoveFromCart(userID, game.ID, gamesClient.Get, &db)
 	if err != nil {
-		log.Println("Error adding or removing game from cart:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	renderTemplate(w, "cart.html", map[string]interface{}{
-		"Cart": &cart,
-	})
+	renderCart(w, r, *cart)
 }
 
 func checkout(w http.ResponseWriter, r *http.Request) {
@@ -221,27 +433,531 @@ func checkout(w http.ResponseWriter, r *http.Request) {
 	log.Println("POST /carts/checkout hit")
 
 	id := r.Context().Value("userID").(string)
-	err := logic.Checkout(id, &db, kafka)
+	username, _ := r.Context().Value("username").(string)
+
+	// the body is optional, an empty one means pay everything by card
+	var checkoutRequest structs.CheckoutRequest
+	err := json.NewDecoder(r.Body).Decode(&checkoutRequest)
+	if err != nil && err != io.EOF {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	order, err := logic.Checkout(id, username, requestCurrency(r), requestLocation(r), checkoutRequest.UseWallet, checkoutDatabases(), kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	renderTemplate(w, r, "cart.html", map[string]interface{}{
+		"Cart":    structs.Cart{},
+		"Summary": structs.CartSummary{},
+		"Order":   order,
+	})
+}
+
+func setGiftRecipient(w http.ResponseWriter, r *http.Request) {
+	log.Println("PATCH /carts/gift hit")
+	if r.Method != http.MethodPatch {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	username, _ := r.Context().Value("username").(string)
+
+	var giftRequest structs.GiftRecipientRequest
+	err := json.NewDecoder(r.Body).Decode(&giftRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// the auth service only answers signed in users, so ask as the buyer
+	authorization := r.Header.Get("Authorization")
+	findUser := func(name string) (string, bool, error) {
+		return users.Find(authorization, name)
+	}
+	cart, err := logic.SetGiftRecipient(userID, username, giftRequest.GameID, giftRequest.GiftTo, findUser, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	renderCart(w, r, *cart)
+}
+
+func getGiftInbox(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /carts/gifts hit")
+	userID := r.Context().Value("userID").(string)
+	username, _ := r.Context().Value("username").(string)
+
+	gifts, err := logic.GetGiftInbox(username, &giftDB)
+	if err != nil {
+		log.Println("Error getting gifts:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sent, err := logic.GetSentGifts(userID, &giftDB)
+	if err != nil {
+		log.Println("Error getting sent gifts:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "gifts.html", map[string]interface{}{
+		"Gifts": gifts,
+		"Sent":  sent,
+	})
+}
+
+func acceptGift(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/gifts/{id}/accept hit")
+	userID := r.Context().Value("userID").(string)
+	username, _ := r.Context().Value("username").(string)
+
+	_, err := logic.AcceptGift(r.PathValue("id"), userID, username, &giftDB, kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	getGiftInbox(w, r)
+}
+
+func declineGift(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/gifts/{id}/decline hit")
+	userID := r.Context().Value("userID").(string)
+	username, _ := r.Context().Value("username").(string)
+
+	_, err := logic.DeclineGift(r.PathValue("id"), userID, username, &giftDB, &walletDB, kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	getGiftInbox(w, r)
+}
+
+func cancelGift(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/gifts/{id}/cancel hit")
+	userID := r.Context().Value("userID").(string)
+
+	_, err := logic.CancelGift(r.PathValue("id"), userID, &giftDB, &walletDB, kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	getGiftInbox(w, r)
+}
+
+func getOrders(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /carts/orders hit")
+	userID := r.Context().Value("userID").(string)
+
+	orders, err := logic.GetOrders(userID, &orderDB)
+	if err != nil {
+		log.Println("Error getting orders:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	refunds, err := logic.GetRefunds(userID, &refundDB)
+	if err != nil {
+		log.Println("Error getting refunds:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// refund status by refund ID so the page can show it next to the game
+	refundStatus := map[string]string{}
+	for _, refund := range refunds {
+		refundStatus[refund.ID] = refund.Status
+	}
+	renderTemplate(w, r, "orders.html", map[string]interface{}{
+		"Orders":  orders,
+		"Refunds": refundStatus,
+	})
+}
+
+func requestRefund(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/refunds hit")
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+
+	var refundRequest structs.RefundRequest
+	err := json.NewDecoder(r.Body).Decode(&refundRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	_, err = logic.RequestRefund(userID, refundRequest, refundPolicy, refundDatabases(), kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	getOrders(w, r)
+}
+
+func getRefundQueue(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /carts/admin/refunds hit")
+	queue, err := logic.GetRefundQueue(&refundDB)
+	if err != nil {
+		log.Println("Error getting refund queue:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "refunds.html", map[string]interface{}{
+		"Refunds": queue,
+	})
+}
+
+func approveRefund(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/admin/refunds/{id}/approve hit")
+	adminID := r.Context().Value("userID").(string)
+
+	refund, err := logic.ApproveRefund(r.PathValue("id"), adminID, refundDatabases(), kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "refund.approve", "refund", refund.ID, nil, refund)
+	getRefundQueue(w, r)
+}
+
+func denyRefund(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/admin/refunds/{id}/deny hit")
+	adminID := r.Context().Value("userID").(string)
+
+	refund, err := logic.DenyRefund(r.PathValue("id"), adminID, refundDatabases(), kafka)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "refund.deny", "refund", refund.ID, nil, refund)
+	getRefundQueue(w, r)
+}
+
+func getWallet(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /carts/wallet hit")
+	userID := r.Context().Value("userID").(string)
+
+	wallet, err := logic.GetWallet(userID, &walletDB)
+	if err != nil {
+		log.Println("Error getting wallet:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "wallet.html", map[string]interface{}{
+		"Wallet": wallet,
+	})
+}
+
+func redeemGiftCard(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/wallet/redeem hit")
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+
+	var redeemRequest structs.RedeemRequest
+	err := json.NewDecoder(r.Body).Decode(&redeemRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	_, err = logic.RedeemGiftCard(userID, redeemRequest.Code, &giftCardDB, &redemptionDB, &walletDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	getWallet(w, r)
+}
+
+func GiftCardsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet: // ADMIN
+		getGiftCards(w, r)
+	case http.MethodPost: // ADMIN
+		createGiftCard(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getGiftCards(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /carts/admin/giftcards hit")
+	cards, err := logic.GetAllGiftCards(&giftCardDB)
+	if err != nil {
+		log.Println("Error getting gift cards:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cards)
+}
+
+func createGiftCard(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/admin/giftcards hit")
+	adminID := r.Context().Value("userID").(string)
+
+	var giftCardRequest structs.GiftCardRequest
+	err := json.NewDecoder(r.Body).Decode(&giftCardRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	card, err := logic.CreateGiftCard(adminID, giftCardRequest, &giftCardDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// the code is as good as the money, so it stays out of the log
+	logged := card
+	logged.Code = ""
+	audit(r, "giftcard.create", "giftcard", card.ID, nil, logged)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(card)
+}
+
+func applyPromoCode(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/promo hit")
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+
+	var promoRequest structs.ApplyPromoRequest
+	err := json.NewDecoder(r.Body).Decode(&promoRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := logic.ApplyPromoCode(userID, promoRequest.Code, &db, &promoDB, &promoRedemptionDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	renderCart(w, r, *cart)
+}
+
+func PromoCodesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet: // ADMIN
+		getPromoCodes(w, r)
+	case http.MethodPost: // ADMIN
+		createPromoCode(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getPromoCodes(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /carts/admin/promos hit")
+	promos, err := logic.GetAllPromoCodes(&promoDB)
+	if err != nil {
+		log.Println("Error getting promo codes:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promos)
+}
+
+func createPromoCode(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /carts/admin/promos hit")
+	adminID := r.Context().Value("userID").(string)
+
+	var promoRequest structs.PromoCodeRequest
+	err := json.NewDecoder(r.Body).Decode(&promoRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	promo, err := logic.CreatePromoCode(adminID, promoRequest, &promoDB)
 	if err != nil {
-		log.Println("Error checking out cart:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	audit(r, "promo.create", "promo", promo.ID, nil, promo)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(promo)
+}
 
-	renderTemplate(w, "cart.html", map[string]interface{}{
-		"Cart": structs.Cart{},
+// audit publishes what the admin just did to the audit log. The action has
+// happened by then, so a failure to publish is only logged.
+func audit(r *http.Request, action string, targetType string, targetID string, before interface{}, after interface{}) {
+	userID, _ := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+	entry := structs.Audit{
+		ActorID:    userID,
+		ActorRole:  userRole,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  requestID(r),
+		IP:         clientIP(r),
+	}
+	err := logic.PublishAudit(entry, before, after, time.Now(), kafka)
+	if err != nil {
+		log.Println("Error publishing audit entry for", action, targetID, ":", err)
+	}
+}
+
+// requestID is the ID the proxy gave the request, or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// clientIP is the address the request came from, before the proxy
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func checkoutDatabases() logic.CheckoutDatabases {
+	return logic.CheckoutDatabases{
+		Games:            gamesClient.Get,
+		Carts:            &db,
+		Orders:           &orderDB,
+		Wallets:          &walletDB,
+		Gifts:            &giftDB,
+		PromoCodes:       &promoDB,
+		PromoRedemptions: &promoRedemptionDB,
+		Refunds:          &refundDB,
+	}
+}
+
+func ownershipDatabases() logic.OwnershipDatabases {
+	return logic.OwnershipDatabases{
+		Orders:  &orderDB,
+		Refunds: &refundDB,
+		Gifts:   &giftDB,
+	}
+}
+
+func refundDatabases() logic.RefundDatabases {
+	return logic.RefundDatabases{
+		Orders:  &orderDB,
+		Refunds: &refundDB,
+		Wallets: &walletDB,
+	}
+}
+
+// renderCart shows the cart with its line prices, discounts and total in the
+// user's currency
+func renderCart(w http.ResponseWriter, r *http.Request, cart structs.Cart) {
+	// guests own nothing yet
+	owned := map[string]bool{}
+	if !logic.IsGuest(cart.UserID) {
+		var err error
+		owned, err = logic.GetOwnedGames(cart.UserID, ownershipDatabases())
+		if err != nil {
+			log.Println("Error getting owned games:", err)
+			problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	renderTemplate(w, r, "cart.html", map[string]interface{}{
+		"Cart":    cart,
+		"Guest":   logic.IsGuest(cart.UserID),
+		"Summary": logic.GetCartSummary(cart, requestCurrency(r), requestLocation(r), owned, &promoDB),
 	})
 }
 
-func renderTemplate(w http.ResponseWriter, templateName string, data interface{}) {
+// requestLocation is where the buyer is for tax, from the query or the
+// country and region cookies.
+func requestLocation(r *http.Request) tax.Location {
+	if r.URL.Query().Has("country") {
+		return tax.NormalizeLocation(tax.Location{
+			Country: r.URL.Query().Get("country"),
+			Region:  r.URL.Query().Get("region"),
+		})
+	}
+	location := tax.Location{}
+	if cookie, err := r.Cookie("country"); err == nil {
+		location.Country = cookie.Value
+	}
+	if cookie, err := r.Cookie("region"); err == nil {
+		location.Region = cookie.Value
+	}
+	return tax.NormalizeLocation(location)
+}
+
+// requestCurrency is the currency the user picked, from the query or the
+// currency cookie set by the frontend.
+func requestCurrency(r *http.Request) string {
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		return structs.NormalizeCurrency(currency)
+	}
+	if cookie, err := r.Cookie("currency"); err == nil {
+		return structs.NormalizeCurrency(cookie.Value)
+	}
+	return structs.DefaultCurrency
+}
+
+func renderTemplate(w http.ResponseWriter, r *http.Request, templateName string, data interface{}) {
 	t, err := template.ParseFiles("templates/" + templateName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	err = t.Execute(w, data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println("Error rendering", templateName, ":", err)
+	}
+}
+
+// writeError replies with the status code for the kind of error. Anything
+// that isn't one of them is logged and answered with a plain 500, so the
+// details of what broke stay in the logs.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Println("Error handling", r.Method, r.URL.Path, ":", err)
+		problem.Error(w, r, "Internal Server Error", status)
+		return
+	}
+	problem.Error(w, r, err.Error(), status)
+}
+
+// errorStatus is the status code for the kinds of error of the logic and the
+// database
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, logic.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, logic.ErrNotFound), errors.Is(err, database.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, logic.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, logic.ErrNotAllowed):
+		return http.StatusUnprocessableEntity
 	}
+	return http.StatusInternalServerError
 }
@@ -0,0 +1,45 @@
+// Package problem writes the error responses of the service. API clients get
+// problem details (RFC 9457) as JSON, HTMX requests get a fragment of HTML
+// the frontend shows to the user.
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// Details is the problem details body
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+const fragment = `<div class="bg-red-100 border border-red-400 text-red-700 px-4 py-2 rounded-md mb-4" role="alert"><strong>%s</strong> %s</div>`
+
+// Error replies to the request with the status code and detail, in place of
+// http.Error
+func Error(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	title := http.StatusText(status)
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, fragment, html.EscapeString(title), html.EscapeString(detail))
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Details{
+		Type:     "about:blank",
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+}
@@ -1,6 +1,8 @@
 package structs
 
 import (
+	"encoding/json"
+
 	"github.com/google/uuid"
 )
 
@@ -9,16 +11,95 @@ type Game struct {
 	Title       string   `json:"Title"`
 	Description string   `json:"Description"`
 	Tags        []string `json:"Tags"`
-	Price       float64  `json:"Price"`
+	Price       Money    `json:"Price"`
+	Prices      map[string]Money `json:"Prices"`
 	Published   string   `json:"Published"`
 	Author      string   `json:"Author"`
 	AuthorID    string   `json:"AuthorID"`
+	Discounts   []Discount `json:"Discounts"`
+	Kind        string     `json:"Kind"`
+	// ParentID is the base game a DLC needs
+	ParentID string `json:"ParentID"`
+	// BundleItems are the games a bundle contains
+	BundleItems []BundleItem `json:"BundleItems"`
+	// GiftTo is the username this line is bought for, empty when buying for yourself
+	GiftTo string `json:"GiftTo,omitempty"`
+}
+
+// Kinds of games, copied over from the games service. Empty is a plain game.
+const (
+	KindGame   = "game"
+	KindDLC    = "dlc"
+	KindBundle = "bundle"
+)
+
+func (g Game) IsDLC() bool {
+	return g.Kind == KindDLC
+}
+
+func (g Game) IsBundle() bool {
+	return g.Kind == KindBundle
+}
+
+// GameIDs are the games buying this line puts in a library, the items for a
+// bundle and the game itself otherwise.
+func (g Game) GameIDs() []string {
+	if !g.IsBundle() {
+		return []string{g.ID}
+	}
+	ids := []string{}
+	for _, item := range g.BundleItems {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// StoreGame is a game as the games service sells it right now
+type StoreGame struct {
+	Game
+	// Purchasable is false for upcoming games that can't be pre-ordered
+	Purchasable bool
+}
+
+// BundleItem is a game sold in a bundle, with the prices used to work out how
+// much of the bundle a buyer already owns
+type BundleItem struct {
+	ID     string           `json:"ID"`
+	Title  string           `json:"Title"`
+	Price  Money            `json:"Price"`
+	Prices map[string]Money `json:"Prices"`
+}
+
+func (b BundleItem) PriceIn(currency string) Money {
+	if price, ok := b.Prices[currency]; ok {
+		return price
+	}
+	return b.Price
+}
+
+// PriceIn returns the regional price for the currency, or the base price when
+// the developer didn't set one.
+func (g Game) PriceIn(currency string) Money {
+	if price, ok := g.Prices[currency]; ok {
+		return price
+	}
+	return g.Price
 }
 
+// Cart belongs to a user, or to a guest when UserID starts with "guest_".
+// Updated is when it last changed and ExpiresAt the epoch seconds DynamoDB
+// TTL removes it at.
 type Cart struct {
-	ID     string `json:"ID"`
-	UserID string `json:"UserID"`
-	Games  []Game `json:"Games"`
+	ID        string `json:"ID"`
+	UserID    string `json:"UserID"`
+	Games     []Game `json:"Games"`
+	PromoCode string `json:"PromoCode"`
+	Updated   string `json:"Updated"`
+	ExpiresAt int64  `json:"ExpiresAt"`
+	// CheckoutID is the order a checkout is making from the cart while it
+	// runs, so a second checkout of the same cart can't start
+	CheckoutID      string `json:"CheckoutID"`
+	CheckoutStarted string `json:"CheckoutStarted"`
 }
 
 type CreateCartRequest struct {
@@ -36,4 +117,287 @@ func (c *CreateCartRequest) CreateCartRequestToCart() Cart {
 
 func GetNewUUID() string {
 	return uuid.New().String()
-}
\ No newline at end of file
+}
+
+type CheckoutRequest struct {
+	UseWallet bool `json:"UseWallet"`
+}
+
+// Order is the snapshot of a cart at checkout, saved in the orders table and
+// published to the checkout topic.
+type Order struct {
+	ID         string      `json:"ID"`
+	UserID     string      `json:"UserID"`
+	Games      []Game      `json:"Games"`
+	Gifts      []Gift      `json:"Gifts"`
+	Summary    CartSummary `json:"Summary"`
+	Currency   string      `json:"Currency"`
+	Tax        Money       `json:"Tax"`
+	Total      Money       `json:"Total"`
+	WalletPaid Money       `json:"WalletPaid"`
+	CardPaid   Money       `json:"CardPaid"`
+	Date       string      `json:"Date"`
+}
+
+// ----------------- Wallet -----------------
+const (
+	WalletCredit = "credit"
+	WalletDebit  = "debit"
+)
+
+// WalletEntry is one row of a user's append-only wallet ledger. The ID is
+// the user ID plus a zero padded sequence number, so two writers can never
+// append the same entry twice. A wallet holds a single currency, set by
+// whatever is credited to it while empty.
+type WalletEntry struct {
+	ID        string  `json:"ID"`
+	UserID    string  `json:"UserID"`
+	Sequence  int     `json:"Sequence"`
+	Type      string  `json:"Type"`
+	Amount    Money   `json:"Amount"`
+	Balance   Money   `json:"Balance"`
+	Reason    string  `json:"Reason"`
+	Reference string  `json:"Reference"`
+	Date      string  `json:"Date"`
+}
+
+type Wallet struct {
+	UserID  string        `json:"UserID"`
+	Balance Money         `json:"Balance"`
+	Entries []WalletEntry `json:"Entries"`
+}
+
+type GiftCard struct {
+	ID         string  `json:"ID"`
+	Code       string  `json:"Code"`
+	Amount     Money   `json:"Amount"`
+	CreatedBy  string  `json:"CreatedBy"`
+	Created    string  `json:"Created"`
+	RedeemedBy string  `json:"RedeemedBy"`
+	Redeemed   string  `json:"Redeemed"`
+}
+
+// GiftCardRequest takes the amount as a decimal in major units of Currency
+type GiftCardRequest struct {
+	Amount   json.Number `json:"Amount"`
+	Currency string      `json:"Currency"`
+}
+
+type RedeemRequest struct {
+	Code string `json:"Code"`
+}
+
+// GiftCardRedemption claims a gift card code. It lives in its own table so the
+// claim can be made with a conditional create.
+type GiftCardRedemption struct {
+	ID     string `json:"ID"`
+	UserID string `json:"UserID"`
+	Date   string `json:"Date"`
+}
+
+// ----------------- Gifts -----------------
+const (
+	GiftPending  = "pending"
+	GiftAccepted = "accepted"
+	GiftDeclined = "declined"
+	// GiftCancelled is a gift the buyer took back before it was answered
+	GiftCancelled = "cancelled"
+)
+
+type Gift struct {
+	ID                string  `json:"ID"`
+	OrderID           string  `json:"OrderID"`
+	SenderID          string  `json:"SenderID"`
+	SenderUsername    string  `json:"SenderUsername"`
+	RecipientUsername string  `json:"RecipientUsername"`
+	RecipientID       string  `json:"RecipientID"`
+	Game              Game    `json:"Game"`
+	Price             Money   `json:"Price"`
+	Status            string  `json:"Status"`
+	Sent              string  `json:"Sent"`
+	Responded         string  `json:"Responded"`
+}
+
+type GiftRecipientRequest struct {
+	GameID string `json:"GameID"`
+	GiftTo string `json:"GiftTo"`
+}
+
+// ----------------- Pricing -----------------
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Discount is a scheduled sale copied over from the games service. Percent
+// discounts use Value, fixed ones take Amount off the base price. Start and
+// End are RFC3339 and either can be empty for an open ended window.
+type Discount struct {
+	ID     string  `json:"ID"`
+	Type   string  `json:"Type"`
+	Value  float64 `json:"Value"`
+	Amount Money   `json:"Amount"`
+	Start  string  `json:"Start"`
+	End    string  `json:"End"`
+}
+
+// CartLine is one game in the cart with what is actually charged for it. The
+// promo discount is spread over the lines so Price is what the buyer paid
+// before tax and Total what they paid with it.
+type CartLine struct {
+	Game          Game  `json:"Game"`
+	OriginalPrice Money `json:"OriginalPrice"`
+	Discount      Money `json:"Discount"`
+	// OwnedDiscount is what comes off a bundle for the games in it the buyer owns
+	OwnedDiscount Money `json:"OwnedDiscount"`
+	PromoDiscount Money `json:"PromoDiscount"`
+	Price         Money `json:"Price"`
+	Tax           Money `json:"Tax"`
+	Total         Money `json:"Total"`
+}
+
+type CartSummary struct {
+	Currency      string     `json:"Currency"`
+	Lines         []CartLine `json:"Lines"`
+	Subtotal      Money      `json:"Subtotal"`
+	Discount      Money      `json:"Discount"`
+	OwnedDiscount Money      `json:"OwnedDiscount"`
+	PromoCode     string     `json:"PromoCode"`
+	PromoDiscount Money      `json:"PromoDiscount"`
+	PromoError    string     `json:"PromoError,omitempty"`
+	// Error is set when a game has no price in the cart's currency or the
+	// buyer can't have it, like DLC without its base game
+	Error         string     `json:"Error,omitempty"`
+	Country       string     `json:"Country"`
+	Region        string     `json:"Region"`
+	TaxName       string     `json:"TaxName"`
+	TaxRate       float64    `json:"TaxRate"`
+	// TaxInclusive means the prices already contain Tax, otherwise it is added to the total
+	TaxInclusive  bool       `json:"TaxInclusive"`
+	Tax           Money      `json:"Tax"`
+	Total         Money      `json:"Total"`
+}
+
+// ----------------- Promo codes -----------------
+
+// PromoCode is an admin created code. MaxUses 1 makes it single use, 0 means
+// unlimited, same for PerUserLimit. Percent codes use Value, fixed codes take
+// Amount off. Fixed codes and MinSpend only work in the currency they are set in.
+type PromoCode struct {
+	ID           string  `json:"ID"`
+	Code         string  `json:"Code"`
+	Type         string  `json:"Type"`
+	Value        float64 `json:"Value"`
+	Amount       Money   `json:"Amount"`
+	MaxUses      int     `json:"MaxUses"`
+	PerUserLimit int     `json:"PerUserLimit"`
+	MinSpend     Money   `json:"MinSpend"`
+	Start        string  `json:"Start"`
+	End          string  `json:"End"`
+	CreatedBy    string  `json:"CreatedBy"`
+}
+
+// PromoCodeRequest takes Amount and MinSpend as decimals in major units of Currency
+type PromoCodeRequest struct {
+	Code         string      `json:"Code"`
+	Type         string      `json:"Type"`
+	Value        float64     `json:"Value"`
+	Amount       json.Number `json:"Amount"`
+	Currency     string      `json:"Currency"`
+	MaxUses      int         `json:"MaxUses"`
+	PerUserLimit int         `json:"PerUserLimit"`
+	MinSpend     json.Number `json:"MinSpend"`
+	Start        string  `json:"Start"`
+	End          string  `json:"End"`
+}
+
+// PromoRedemption is one use of a promo code. The ID is the code plus the
+// use number so the max use count holds with concurrent checkouts.
+type PromoRedemption struct {
+	ID      string `json:"ID"`
+	Code    string `json:"Code"`
+	Number  int    `json:"Number"`
+	UserID  string `json:"UserID"`
+	OrderID string `json:"OrderID"`
+	Date    string `json:"Date"`
+}
+
+type ApplyPromoRequest struct {
+	Code string `json:"Code"`
+}
+
+// AbandonedCart is published to cart.abandoned for carts left idle
+type AbandonedCart struct {
+	CartID  string `json:"CartID"`
+	UserID  string `json:"UserID"`
+	Games   []Game `json:"Games"`
+	Updated string `json:"Updated"`
+	Date    string `json:"Date"`
+}
+
+// AbandonedCartNotice records that cart.abandoned went out for a cart as it
+// was at Updated. The ID is the cart ID plus Updated, so only one replica
+// sends the event and it goes out again only after the cart changes.
+type AbandonedCartNotice struct {
+	ID        string `json:"ID"`
+	CartID    string `json:"CartID"`
+	UserID    string `json:"UserID"`
+	Date      string `json:"Date"`
+	ExpiresAt int64  `json:"ExpiresAt"`
+}
+
+// ----------------- Refunds -----------------
+const (
+	RefundPending  = "pending"
+	RefundApproved = "approved"
+	RefundDenied   = "denied"
+)
+
+// Refund is a request to give back one game from an order. The ID is the
+// order ID plus the game ID so a game can only be asked back once. When it is
+// approved Amount is split into what goes back to the wallet and to the card.
+type Refund struct {
+	ID           string `json:"ID"`
+	OrderID      string `json:"OrderID"`
+	UserID       string `json:"UserID"`
+	GameID       string `json:"GameID"`
+	GameTitle    string `json:"GameTitle"`
+	Amount       Money  `json:"Amount"`
+	WalletAmount Money  `json:"WalletAmount"`
+	CardAmount   Money  `json:"CardAmount"`
+	ToWallet     bool   `json:"ToWallet"`
+	Reason       string `json:"Reason"`
+	Status       string `json:"Status"`
+	Requested    string `json:"Requested"`
+	Decided      string `json:"Decided"`
+	DecidedBy    string `json:"DecidedBy"`
+	// PublishPending is set on an approved refund until refund.approved is
+	// out, the library only gives the game back when it gets the event
+	PublishPending bool `json:"PublishPending"`
+}
+
+// RefundRequest asks for a game back. ToWallet puts the whole amount in the
+// wallet instead of back on the card.
+type RefundRequest struct {
+	OrderID  string `json:"OrderID"`
+	GameID   string `json:"GameID"`
+	Reason   string `json:"Reason"`
+	ToWallet bool   `json:"ToWallet"`
+}
+
+// Audit is what the audit topic carries for an admin action, the games
+// service keeps the log. Before and After are the target as JSON.
+type Audit struct {
+	ID         string          `json:"ID"`
+	Time       string          `json:"Time"`
+	Service    string          `json:"Service"`
+	ActorID    string          `json:"ActorID"`
+	ActorRole  string          `json:"ActorRole"`
+	Action     string          `json:"Action"`
+	TargetType string          `json:"TargetType"`
+	TargetID   string          `json:"TargetID"`
+	Before     json.RawMessage `json:"Before,omitempty"`
+	After      json.RawMessage `json:"After,omitempty"`
+	RequestID  string          `json:"RequestID"`
+	IP         string          `json:"IP"`
+}
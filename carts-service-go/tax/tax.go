@@ -0,0 +1,193 @@
+package tax
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+// Rounding modes for the tax on a line
+const (
+	RoundHalfUp   = "half-up"
+	RoundHalfEven = "half-even"
+	RoundUp       = "up"
+	RoundDown     = "down"
+)
+
+// Location is where the buyer is. Country is an ISO 3166 code like "DE" and
+// Region a state or province code like "CA", empty when it doesn't matter.
+type Location struct {
+	Country string `json:"Country"`
+	Region  string `json:"Region"`
+}
+
+// Rule is the tax for a country, or for one region of it when Region is set.
+// Rate is a percentage. Inclusive means prices already contain the tax, the
+// way VAT is shown in the EU, otherwise it is added on top. Digital goods use
+// DigitalRate when it is set, or nothing at all when DigitalExempt is.
+type Rule struct {
+	Country       string   `json:"Country"`
+	Region        string   `json:"Region"`
+	Name          string   `json:"Name"`
+	Rate          float64  `json:"Rate"`
+	DigitalRate   *float64 `json:"DigitalRate,omitempty"`
+	DigitalExempt bool     `json:"DigitalExempt"`
+	Inclusive     bool     `json:"Inclusive"`
+}
+
+// Rules is the contents of the rules file
+type Rules struct {
+	Rounding       string `json:"Rounding"`
+	DefaultCountry string `json:"DefaultCountry"`
+	Rules          []Rule `json:"Rules"`
+}
+
+// Line is the tax worked out for one line item
+type Line struct {
+	Name      string        `json:"Name"`
+	Rate      float64       `json:"Rate"`
+	Inclusive bool          `json:"Inclusive"`
+	Tax       structs.Money `json:"Tax"`
+	// Total is what the buyer pays for the line, tax included
+	Total structs.Money `json:"Total"`
+}
+
+var (
+	mu      sync.RWMutex
+	current = Rules{Rounding: RoundHalfUp}
+)
+
+// LoadRules reads the rules file and makes it the one used by Calculate
+func LoadRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rules := Rules{}
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return err
+	}
+	SetRules(rules)
+	return nil
+}
+
+func SetRules(rules Rules) {
+	if rules.Rounding == "" {
+		rules.Rounding = RoundHalfUp
+	}
+	for i := range rules.Rules {
+		rules.Rules[i].Country = strings.ToUpper(rules.Rules[i].Country)
+		rules.Rules[i].Region = strings.ToUpper(rules.Rules[i].Region)
+	}
+	mu.Lock()
+	current = rules
+	mu.Unlock()
+}
+
+func GetRules() Rules {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// NormalizeLocation upper cases the codes and fills in the default country
+func NormalizeLocation(location Location) Location {
+	location.Country = strings.ToUpper(strings.TrimSpace(location.Country))
+	location.Region = strings.ToUpper(strings.TrimSpace(location.Region))
+	if location.Country == "" {
+		location.Country = GetRules().DefaultCountry
+		location.Region = ""
+	}
+	return location
+}
+
+// FindRule returns the rule for the location, a region rule wins over the
+// rule for the whole country.
+func (r Rules) FindRule(location Location) (Rule, bool) {
+	var countryRule *Rule
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Country != location.Country {
+			continue
+		}
+		if rule.Region != "" && rule.Region == location.Region {
+			return *rule, true
+		}
+		if rule.Region == "" && countryRule == nil {
+			countryRule = rule
+		}
+	}
+	if countryRule != nil {
+		return *countryRule, true
+	}
+	return Rule{}, false
+}
+
+// Calculate works out the tax on one line with the current rules
+func Calculate(location Location, price structs.Money, digital bool) Line {
+	return GetRules().Calculate(location, price, digital)
+}
+
+// Calculate works out the tax on one line. Each line is rounded on its own so
+// the lines always add up to the tax on the order.
+func (r Rules) Calculate(location Location, price structs.Money, digital bool) Line {
+	line := Line{
+		Tax:   structs.NewMoney(0, price.Currency),
+		Total: price,
+	}
+	rule, ok := r.FindRule(location)
+	if !ok {
+		return line
+	}
+	rate := rule.Rate
+	if digital {
+		if rule.DigitalExempt {
+			return line
+		}
+		if rule.DigitalRate != nil {
+			rate = *rule.DigitalRate
+		}
+	}
+	line.Name = rule.Name
+	line.Rate = rate
+	line.Inclusive = rule.Inclusive
+	if rate <= 0 || price.Amount <= 0 {
+		return line
+	}
+
+	basisPoints := int64(math.Round(rate * 100))
+	if rule.Inclusive {
+		// the price is gross, take out the part that is tax
+		line.Tax.Amount = divide(price.Amount*basisPoints, 10000+basisPoints, r.Rounding)
+	} else {
+		line.Tax.Amount = divide(price.Amount*basisPoints, 10000, r.Rounding)
+		line.Total = price.Add(line.Tax)
+	}
+	return line
+}
+
+// divide rounds num/den for non negative numbers using the rounding mode
+func divide(num int64, den int64, rounding string) int64 {
+	quotient, remainder := num/den, num%den
+	switch rounding {
+	case RoundDown:
+	case RoundUp:
+		if remainder > 0 {
+			quotient++
+		}
+	case RoundHalfEven:
+		if 2*remainder > den || (2*remainder == den && quotient%2 == 1) {
+			quotient++
+		}
+	default:
+		if 2*remainder >= den {
+			quotient++
+		}
+	}
+	return quotient
+}
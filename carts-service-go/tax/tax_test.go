@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"testing"
+
+	structs "github.com/Draupniyr/carts-service/structs"
+)
+
+func usd(cents int64) structs.Money {
+	return structs.NewMoney(cents, "USD")
+}
+
+func testRules() Rules {
+	reduced := 5.5
+	return Rules{
+		Rounding: RoundHalfUp,
+		Rules: []Rule{
+			{Country: "US", Name: "Sales tax", Rate: 0},
+			{Country: "US", Region: "TX", Name: "Sales tax", Rate: 6.25},
+			{Country: "US", Region: "CA", Name: "Sales tax", Rate: 7.25, DigitalExempt: true},
+			{Country: "GB", Name: "VAT", Rate: 20, Inclusive: true},
+			{Country: "FR", Name: "TVA", Rate: 20, DigitalRate: &reduced, Inclusive: true},
+		},
+	}
+}
+
+func TestExclusiveTax(t *testing.T) {
+	line := testRules().Calculate(Location{Country: "US", Region: "TX"}, usd(1999), true)
+	// 19.99 * 6.25% = 1.249375
+	if line.Tax != usd(125) {
+		t.Errorf("Expected 125 got %v", line.Tax)
+	}
+	if line.Total != usd(2124) {
+		t.Errorf("Expected 2124 got %v", line.Total)
+	}
+}
+
+func TestInclusiveTax(t *testing.T) {
+	line := testRules().Calculate(Location{Country: "GB"}, structs.NewMoney(1200, "GBP"), true)
+	// 12.00 includes 2.00 of 20% VAT
+	if line.Tax != structs.NewMoney(200, "GBP") {
+		t.Errorf("Expected 200 got %v", line.Tax)
+	}
+	if line.Total != structs.NewMoney(1200, "GBP") {
+		t.Errorf("Expected the total to stay 1200 got %v", line.Total)
+	}
+}
+
+func TestDigitalGoodsRules(t *testing.T) {
+	rules := testRules()
+	line := rules.Calculate(Location{Country: "US", Region: "CA"}, usd(1000), true)
+	if !line.Tax.IsZero() {
+		t.Errorf("Expected digital goods to be exempt got %v", line.Tax)
+	}
+	line = rules.Calculate(Location{Country: "US", Region: "CA"}, usd(1000), false)
+	if line.Tax != usd(73) {
+		t.Errorf("Expected 73 got %v", line.Tax)
+	}
+	line = rules.Calculate(Location{Country: "FR"}, structs.NewMoney(1055, "EUR"), true)
+	if line.Rate != 5.5 || line.Tax != structs.NewMoney(55, "EUR") {
+		t.Errorf("Expected the digital rate, got %v at %v", line.Tax, line.Rate)
+	}
+}
+
+func TestRegionFallsBackToCountry(t *testing.T) {
+	rules := testRules()
+	rule, ok := rules.FindRule(Location{Country: "US", Region: "OR"})
+	if !ok || rule.Region != "" {
+		t.Errorf("Expected the country rule got %v", rule)
+	}
+	_, ok = rules.FindRule(Location{Country: "BR"})
+	if ok {
+		t.Errorf("Expected no rule for an unknown country")
+	}
+	line := rules.Calculate(Location{Country: "BR"}, usd(1000), true)
+	if !line.Tax.IsZero() || line.Total != usd(1000) {
+		t.Errorf("Expected no tax got %v", line.Tax)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		rounding string
+		num      int64
+		want     int64
+	}{
+		{RoundHalfUp, 25, 3},
+		{RoundHalfEven, 25, 2},
+		{RoundHalfEven, 35, 4},
+		{RoundUp, 21, 3},
+		{RoundDown, 29, 2},
+	}
+	for _, test := range tests {
+		if got := divide(test.num, 10, test.rounding); got != test.want {
+			t.Errorf("%s of %d/10: expected %d got %d", test.rounding, test.num, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package analytics adds up the sales, refunds and wishlist events of each
+// game by day for the developer dashboard. Like the recommendations it lives
+// in memory and is rebuilt from the start of the topics when the service
+// starts, so every event is counted once per process.
+package analytics
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	events "github.com/Draupniyr/games-service/events"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+// Day is what happened to a game on a day, or over a range of days when
+// added up. Gross and Refunded hold an amount per currency.
+type Day struct {
+	Date            string                   `json:"Date"`
+	GameID          string                   `json:"GameID"`
+	Units           int                      `json:"Units"`
+	Gross           map[string]structs.Money `json:"Gross"`
+	Refunds         int                      `json:"Refunds"`
+	Refunded        map[string]structs.Money `json:"Refunded"`
+	WishlistAdds    int                      `json:"WishlistAdds"`
+	WishlistRemoves int                      `json:"WishlistRemoves"`
+	// WishlistSales are the units bought by someone who had the game on
+	// their wishlist
+	WishlistSales int `json:"WishlistSales"`
+}
+
+// Conversion is the share of wishlist adds that turned into a sale
+func (d Day) Conversion() float64 {
+	if d.WishlistAdds == 0 {
+		return 0
+	}
+	return float64(d.WishlistSales) / float64(d.WishlistAdds)
+}
+
+// ConversionPercent is Conversion for showing, like "12.5%"
+func (d Day) ConversionPercent() string {
+	return strconv.FormatFloat(d.Conversion()*100, 'f', 1, 64) + "%"
+}
+
+// Net is the gross less the refunds in each currency
+func (d Day) Net() map[string]structs.Money {
+	net := map[string]structs.Money{}
+	for currency, gross := range d.Gross {
+		net[currency] = gross
+	}
+	for currency, refunded := range d.Refunded {
+		net[currency] = structs.NewMoney(net[currency].Amount-refunded.Amount, currency)
+	}
+	return net
+}
+
+func (d *Day) add(other Day) {
+	d.Units += other.Units
+	d.Refunds += other.Refunds
+	d.WishlistAdds += other.WishlistAdds
+	d.WishlistRemoves += other.WishlistRemoves
+	d.WishlistSales += other.WishlistSales
+	for _, money := range other.Gross {
+		addMoney(d.Gross, money)
+	}
+	for _, money := range other.Refunded {
+		addMoney(d.Refunded, money)
+	}
+}
+
+type Store struct {
+	mu   sync.RWMutex
+	days map[string]*Day
+	// wishlisted is the users with each game on their wishlist right now
+	wishlisted map[string]map[string]bool
+	seen       map[string]bool
+}
+
+func NewStore() *Store {
+	return &Store{
+		days:       map[string]*Day{},
+		wishlisted: map[string]map[string]bool{},
+		seen:       map[string]bool{},
+	}
+}
+
+// Apply counts a checkout, approved refund or wishlist change. Other topics
+// are ignored, as are events already counted.
+func (s *Store) Apply(topic string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch topic {
+	case events.TopicCheckout:
+		order := events.Checkout{}
+		err := json.Unmarshal(value, &order)
+		if err != nil {
+			return err
+		}
+		if s.once(topic, order.ID) {
+			return nil
+		}
+		date := dateOf(order.Date)
+		for _, line := range order.Summary.Lines {
+			day := s.day(date, line.Game.ID)
+			day.Units++
+			addMoney(day.Gross, line.Price)
+			if s.wishlisted[line.Game.ID][order.UserID] {
+				day.WishlistSales++
+			}
+		}
+	case events.TopicRefundApproved:
+		refund := events.Refund{}
+		err := json.Unmarshal(value, &refund)
+		if err != nil {
+			return err
+		}
+		if s.once(topic, refund.ID) {
+			return nil
+		}
+		day := s.day(dateOf(refund.Decided), refund.GameID)
+		day.Refunds++
+		addMoney(day.Refunded, refund.Amount)
+	case events.TopicWishlistAdded, events.TopicWishlistRemoved:
+		change := events.Wishlist{}
+		err := json.Unmarshal(value, &change)
+		if err != nil {
+			return err
+		}
+		if s.once(topic, change.ID) {
+			return nil
+		}
+		day := s.day(dateOf(change.Date), change.GameID)
+		if topic == events.TopicWishlistAdded {
+			day.WishlistAdds++
+			if s.wishlisted[change.GameID] == nil {
+				s.wishlisted[change.GameID] = map[string]bool{}
+			}
+			s.wishlisted[change.GameID][change.UserID] = true
+		} else {
+			day.WishlistRemoves++
+			delete(s.wishlisted[change.GameID], change.UserID)
+		}
+	}
+	return nil
+}
+
+// Replay applies saved events in order
+func (s *Store) Replay(saved []events.Event) error {
+	for _, event := range saved {
+		err := s.Apply(event.Topic, event.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Days returns the days from and to, both included, that something happened
+// to one of the games, by date then game
+func (s *Store) Days(gameIDs []string, from string, to string) []Day {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wanted := map[string]bool{}
+	for _, id := range gameIDs {
+		wanted[id] = true
+	}
+	days := []Day{}
+	for _, day := range s.days {
+		if !wanted[day.GameID] || day.Date < from || day.Date > to {
+			continue
+		}
+		days = append(days, copyDay(*day))
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].Date != days[j].Date {
+			return days[i].Date < days[j].Date
+		}
+		return days[i].GameID < days[j].GameID
+	})
+	return days
+}
+
+// Totals adds the days up for each game, keyed by game ID
+func Totals(days []Day) map[string]Day {
+	totals := map[string]Day{}
+	for _, day := range days {
+		total, ok := totals[day.GameID]
+		if !ok {
+			total = Day{GameID: day.GameID, Gross: map[string]structs.Money{}, Refunded: map[string]structs.Money{}}
+		}
+		total.add(day)
+		totals[day.GameID] = total
+	}
+	return totals
+}
+
+// Sum adds all the days up
+func Sum(days []Day) Day {
+	sum := Day{Gross: map[string]structs.Money{}, Refunded: map[string]structs.Money{}}
+	for _, day := range days {
+		sum.add(day)
+	}
+	return sum
+}
+
+func (s *Store) once(topic string, id string) bool {
+	if id == "" {
+		return false
+	}
+	key := topic + ":" + id
+	if s.seen[key] {
+		return true
+	}
+	s.seen[key] = true
+	return false
+}
+
+func (s *Store) day(date string, gameID string) *Day {
+	key := date + "|" + gameID
+	day, ok := s.days[key]
+	if !ok {
+		day = &Day{Date: date, GameID: gameID, Gross: map[string]structs.Money{}, Refunded: map[string]structs.Money{}}
+		s.days[key] = day
+	}
+	return day
+}
+
+func copyDay(day Day) Day {
+	gross, refunded := day.Gross, day.Refunded
+	day.Gross, day.Refunded = map[string]structs.Money{}, map[string]structs.Money{}
+	for _, money := range gross {
+		addMoney(day.Gross, money)
+	}
+	for _, money := range refunded {
+		addMoney(day.Refunded, money)
+	}
+	return day
+}
+
+func addMoney(totals map[string]structs.Money, money structs.Money) {
+	if money.Currency == "" {
+		return
+	}
+	totals[money.Currency] = structs.NewMoney(totals[money.Currency].Amount+money.Amount, money.Currency)
+}
+
+// dateOf is the UTC day of an RFC 3339 time, or the date it starts with
+func dateOf(value string) string {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return parsed.UTC().Format(time.DateOnly)
+	}
+	if len(value) >= len(time.DateOnly) {
+		return value[:len(time.DateOnly)]
+	}
+	return value
+}
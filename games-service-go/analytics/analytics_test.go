@@ -0,0 +1,75 @@
+package analytics
+
+import (
+	"testing"
+
+	events "github.com/Draupniyr/games-service/events"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+func apply(t *testing.T, store *Store, topic string, body string) {
+	err := store.Apply(topic, []byte(body))
+	if err != nil {
+		t.Fatalf("Error applying %s: %v", topic, err)
+	}
+}
+
+func TestDailyAggregates(t *testing.T) {
+	store := NewStore()
+	apply(t, store, events.TopicWishlistAdded, `{"ID":"W1","UserID":"User1","GameID":"portal","Date":"2024-03-01T10:00:00Z"}`)
+	apply(t, store, events.TopicWishlistAdded, `{"ID":"W2","UserID":"User2","GameID":"portal","Date":"2024-03-01T11:00:00Z"}`)
+	apply(t, store, events.TopicWishlistRemoved, `{"ID":"W3","UserID":"User2","GameID":"portal","Date":"2024-03-02T11:00:00Z"}`)
+	checkout := `{"ID":"Order1","UserID":"User1","Date":"2024-03-02T23:30:00-02:00","Summary":{"Lines":[
+		{"Game":{"ID":"portal"},"Price":{"Amount":999,"Currency":"USD"}},
+		{"Game":{"ID":"racer"},"Price":{"Amount":500,"Currency":"USD"}}]}}`
+	apply(t, store, events.TopicCheckout, checkout)
+	// seen again when the topic is read from the start
+	apply(t, store, events.TopicCheckout, checkout)
+	apply(t, store, events.TopicCheckout, `{"ID":"Order2","UserID":"User2","Date":"2024-03-03T09:00:00Z","Summary":{"Lines":[
+		{"Game":{"ID":"portal"},"Price":{"Amount":899,"Currency":"EUR"}}]}}`)
+	apply(t, store, events.TopicRefundApproved, `{"ID":"Refund1","OrderID":"Order2","GameID":"portal","Amount":{"Amount":899,"Currency":"EUR"},"Decided":"2024-03-04T09:00:00Z"}`)
+
+	days := store.Days([]string{"portal"}, "2024-03-01", "2024-03-04")
+	if len(days) != 4 {
+		t.Fatalf("Expected 4 days got %d: %v", len(days), days)
+	}
+	// the checkout is bucketed by its UTC day
+	if days[2].Date != "2024-03-03" || days[2].Units != 2 {
+		t.Errorf("Expected 2 units on 2024-03-03 got %v", days[2])
+	}
+
+	total := Sum(days)
+	if total.Units != 2 || total.Refunds != 1 || total.WishlistAdds != 2 || total.WishlistRemoves != 1 {
+		t.Errorf("Unexpected totals %+v", total)
+	}
+	// User1 bought it off their wishlist, User2 took it off first
+	if total.WishlistSales != 1 || total.Conversion() != 0.5 {
+		t.Errorf("Expected 1 wishlist sale and 0.5 conversion got %d %v", total.WishlistSales, total.Conversion())
+	}
+	if total.Gross["USD"] != structs.NewMoney(999, "USD") || total.Gross["EUR"] != structs.NewMoney(899, "EUR") {
+		t.Errorf("Unexpected gross %v", total.Gross)
+	}
+	if total.Net()["EUR"].Amount != 0 {
+		t.Errorf("Expected no net EUR got %v", total.Net()["EUR"])
+	}
+
+	// out of range days and other developers' games aren't included
+	if got := len(store.Days([]string{"portal"}, "2024-03-04", "2024-03-31")); got != 1 {
+		t.Errorf("Expected 1 day got %d", got)
+	}
+	totals := Totals(store.Days([]string{"portal", "racer"}, "2024-03-01", "2024-03-31"))
+	if totals["racer"].Units != 1 || len(totals) != 2 {
+		t.Errorf("Unexpected per game totals %v", totals)
+	}
+}
+
+func TestDaysAreCopies(t *testing.T) {
+	store := NewStore()
+	apply(t, store, events.TopicCheckout, `{"ID":"Order1","UserID":"User1","Date":"2024-03-02T10:00:00Z","Summary":{"Lines":[{"Game":{"ID":"portal"},"Price":{"Amount":999,"Currency":"USD"}}]}}`)
+	days := store.Days([]string{"portal"}, "2024-03-01", "2024-03-31")
+	days[0].Gross["USD"] = structs.NewMoney(1, "USD")
+	again := store.Days([]string{"portal"}, "2024-03-01", "2024-03-31")
+	if again[0].Gross["USD"].Amount != 999 {
+		t.Errorf("Expected the store to be unchanged got %v", again[0].Gross)
+	}
+}
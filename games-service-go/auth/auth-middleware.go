@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
+
+	problem "github.com/Draupniyr/games-service/problem"
 )
 
 type Claims struct {
@@ -19,7 +21,7 @@ func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
-			http.Error(w, "Missing token", http.StatusUnauthorized)
+			problem.Error(w, r, "Missing token", http.StatusUnauthorized)
 			return
 		}
 
@@ -30,7 +32,7 @@ func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 		})
 
 		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			problem.Error(w, r, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
@@ -45,7 +47,7 @@ func Authorize(next http.Handler, allowedRoles ...string) http.Handler {
 		} else if !contains(allowedRoles, userRole) {
 			log.Print("Allowedroles:", allowedRoles)
 			log.Print("Userrole:", userRole)
-			http.Error(w, "Unauthorized", http.StatusForbidden)
+			problem.Error(w, r, "Unauthorized", http.StatusForbidden)
 			return
 		}
 
@@ -0,0 +1,141 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store keeps uploaded files under a key like "games/<gameID>/<file>". Other
+// backends, like S3, only have to implement these four methods.
+type Store interface {
+	Put(key string, data io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// URL is where the blob can be downloaded from
+	URL(key string) string
+}
+
+// LocalStore keeps blobs as files under Root and serves them under BaseURL
+type LocalStore struct {
+	Root    string
+	BaseURL string
+}
+
+func NewLocalStore(root string, baseURL string) (*LocalStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStore{Root: root, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *LocalStore) Put(key string, data io.Reader) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	// write next to the file and rename so readers never see half a file
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (s *LocalStore) Open(key string) (io.ReadCloser, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return reader, err
+}
+
+func (s *LocalStore) Delete(key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+// path turns the key into a file under Root, refusing keys that would end up
+// outside of it
+func (s *LocalStore) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// ValidKey reports if the key is a clean relative path without "." or ".."
+// parts
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "." || part == ".." || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// Fallback is a Store that also reads and deletes the blobs still left in Old,
+// for blobs that were moved to Store but stored in Old before. New blobs only
+// go to Store.
+type Fallback struct {
+	Store
+	Old Store
+}
+
+func (f Fallback) Open(key string) (io.ReadCloser, error) {
+	reader, err := f.Store.Open(key)
+	if errors.Is(err, ErrNotFound) {
+		return f.Old.Open(key)
+	}
+	return reader, err
+}
+
+func (f Fallback) Delete(key string) error {
+	err := f.Store.Delete(key)
+	if err != nil {
+		return err
+	}
+	return f.Old.Delete(key)
+}
@@ -0,0 +1,91 @@
+package blobstore
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "/games/media/")
+	if err != nil {
+		t.Fatalf("Error creating store: %v", err)
+	}
+
+	err = store.Put("games/1/cover.png", strings.NewReader("image"))
+	if err != nil {
+		t.Fatalf("Error putting blob: %v", err)
+	}
+	reader, err := store.Open("games/1/cover.png")
+	if err != nil {
+		t.Fatalf("Error opening blob: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "image" {
+		t.Errorf("Expected image got %s", data)
+	}
+	if store.URL("games/1/cover.png") != "/games/media/games/1/cover.png" {
+		t.Errorf("Unexpected URL %s", store.URL("games/1/cover.png"))
+	}
+
+	err = store.Delete("games/1/cover.png")
+	if err != nil {
+		t.Errorf("Error deleting blob: %v", err)
+	}
+	_, err = store.Open("games/1/cover.png")
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound got %v", err)
+	}
+	// deleting twice is fine
+	if err = store.Delete("games/1/cover.png"); err != nil {
+		t.Errorf("Error deleting missing blob: %v", err)
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	valid := []string{"games/1/cover.png", "a"}
+	invalid := []string{"", "/etc/passwd", "../secret", "games/../../secret", "games//1", "games/./1", "games/.upload-1", "games/1/"}
+	for _, key := range valid {
+		if !ValidKey(key) {
+			t.Errorf("Expected %q to be valid", key)
+		}
+	}
+	for _, key := range invalid {
+		if ValidKey(key) {
+			t.Errorf("Expected %q to be invalid", key)
+		}
+	}
+
+	store, _ := NewLocalStore(t.TempDir(), "/media")
+	if err := store.Put("../outside", strings.NewReader("x")); err != ErrInvalidKey {
+		t.Errorf("Expected ErrInvalidKey got %v", err)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	old, _ := NewLocalStore(t.TempDir(), "/games/media")
+	current, _ := NewLocalStore(t.TempDir(), "/games/downloads")
+	store := Fallback{Store: current, Old: old}
+	old.Put("builds/1/game.zip", strings.NewReader("old build"))
+
+	reader, err := store.Open("builds/1/game.zip")
+	if err != nil {
+		t.Fatalf("Expected the old build got %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if string(data) != "old build" {
+		t.Errorf("Expected old build got %s", data)
+	}
+
+	store.Put("builds/2/game.zip", strings.NewReader("new build"))
+	if _, err = old.Open("builds/2/game.zip"); err != ErrNotFound {
+		t.Errorf("Expected new builds to stay out of the old store got %v", err)
+	}
+
+	store.Delete("builds/1/game.zip")
+	if _, err = store.Open("builds/1/game.zip"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after deleting got %v", err)
+	}
+}
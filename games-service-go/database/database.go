@@ -1,37 +1,75 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
-type DatabaseFunctionality interface {
-	GetFilter(attributeValue string, attributeName string, output interface{}) error
-	GetAll(output interface{}) error
-	CreateOrUpdate(object interface{}) error
-	Delete(idValue string) error
-	DeleteFilter(attributeValue string, attrbuteName string) error
-	DeleteAll() error
+// ErrNotFound is returned, wrapped, by Get and Update when there is no item
+// with the key.
+var ErrNotFound = errors.New("item not found")
+
+// ErrConditionFailed is returned by PutIf when the stored item doesn't have
+// the expected value anymore, or isn't there.
+var ErrConditionFailed = errors.New("item was changed")
+
+// Repository stores the items of one table. Every item has a string key in
+// the attribute the repository is set up with, see Init.
+type Repository[T any] interface {
+	// Get returns the item with the key, or ErrNotFound
+	Get(key string) (T, error)
+	// Query returns the items whose attribute is the value, none is not an
+	// error
+	Query(attribute string, value string) ([]T, error)
+	// Scan returns every item
+	Scan() ([]T, error)
+	// Put creates the item, or replaces the one with its key
+	Put(item T) error
+	// PutIf replaces the item only if the stored one still has the value in
+	// the attribute, so of two writers changing the same item only one wins.
+	// An empty value expects the attribute to be unset. It fails with
+	// ErrConditionFailed otherwise.
+	PutIf(item T, attribute string, value string) error
+	// Update sets and removes some attributes of the item with the key, and
+	// leaves the others. It fails with ErrNotFound instead of creating it.
+	Update(key string, set map[string]interface{}, remove []string) error
+	Delete(key string) error
+	// BatchGet returns the items with the keys in the order of the keys,
+	// skipping the ones that don't exist
+	BatchGet(keys []string) ([]T, error)
+	// BatchWrite puts the items and deletes the ones with the keys
+	BatchWrite(puts []T, deletes []string) error
 }
 
-type Database struct {
+// Table is a Repository of the items in a DynamoDB table
+type Table[T any] struct {
 	TableName      string
-	IdName         string
+	Key            string
 	DynamodbClient *dynamodb.DynamoDB
 }
 
+// DynamoDB takes at most this many items in one batch
+const (
+	maxBatchGet   = 100
+	maxBatchWrite = 25
+)
+
 // ----------------- Connection -----------------
-func (db *Database) Init(tableName string, idName string) error {
-	db.TableName = tableName
-	db.IdName = capitalizeFirstLetter(idName)
+func (t *Table[T]) Init(tableName string, key string) error {
+	t.TableName = tableName
+	t.Key = key
 	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
 
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
@@ -41,15 +79,15 @@ func (db *Database) Init(tableName string, idName string) error {
 		},
 	}))
 
-	db.DynamodbClient = dynamodb.New(sess)
+	t.DynamodbClient = dynamodb.New(sess)
 
 	// if table does not exist, create it
-	_, err := db.DynamodbClient.DescribeTable(&dynamodb.DescribeTableInput{
-		TableName: aws.String(db.TableName),
+	_, err := t.DynamodbClient.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(t.TableName),
 	})
 	if err != nil {
 		log.Println("Table does not exist, creating it")
-		err = db.InitializeTables()
+		err = t.InitializeTables()
 		if err != nil {
 			return err
 		}
@@ -57,19 +95,18 @@ func (db *Database) Init(tableName string, idName string) error {
 	return nil
 }
 
-func (db *Database) InitializeTables() error {
-	_, err := db.DynamodbClient.CreateTable(&dynamodb.CreateTableInput{
-		TableName: aws.
-			String(db.TableName),
+func (t *Table[T]) InitializeTables() error {
+	_, err := t.DynamodbClient.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(t.TableName),
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
 			{
-				AttributeName: aws.String(db.IdName),
+				AttributeName: aws.String(t.Key),
 				AttributeType: aws.String("S"),
 			},
 		},
 		KeySchema: []*dynamodb.KeySchemaElement{
 			{
-				AttributeName: aws.String(db.IdName),
+				AttributeName: aws.String(t.Key),
 				KeyType:       aws.String("HASH"),
 			},
 		},
@@ -84,143 +121,303 @@ func (db *Database) InitializeTables() error {
 	return nil
 }
 
+// EnableTTL turns on DynamoDB time to live for the table, items are removed
+// some time after the epoch seconds in the attribute have passed.
+func (t *Table[T]) EnableTTL(attributeName string) error {
+	_, err := t.DynamodbClient.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(t.TableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(attributeName),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && strings.Contains(aerr.Message(), "already enabled") {
+		return nil
+	}
+	return err
+}
+
 // ----------------- Items -----------------
-func (db *Database) GetFilter(attributeValue string, attributeName string, output interface{}) error {
-	if attributeValue == "" {
-		return fmt.Errorf("attributeValue is required")
+func (t *Table[T]) Get(key string) (T, error) {
+	var item T
+	result, err := t.DynamodbClient.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(t.TableName),
+		Key:       t.keyAttribute(key),
+	})
+	if err != nil {
+		return item, err
 	}
-	if attributeName == "" {
-		return fmt.Errorf("attributeName is required")
+	if result.Item == nil {
+		return item, fmt.Errorf("%w for %s: %s", ErrNotFound, t.Key, key)
 	}
-	filterExpression := capitalizeFirstLetter(attributeName) + " = :" + lowercaseFirstLetter(attributeName)
+	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+	return item, err
+}
 
-	result, err := db.DynamodbClient.Scan(&dynamodb.ScanInput{
-		TableName:        aws.String(db.TableName),
-		FilterExpression: aws.String(filterExpression),
+func (t *Table[T]) Query(attribute string, value string) ([]T, error) {
+	return t.scan(&dynamodb.ScanInput{
+		TableName:                aws.String(t.TableName),
+		FilterExpression:         aws.String("#a = :v"),
+		ExpressionAttributeNames: map[string]*string{"#a": aws.String(attribute)},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":" + lowercaseFirstLetter(attributeName): {
-				S: aws.String(attributeValue),
-			},
+			":v": {S: aws.String(value)},
 		},
 	})
-	if err != nil {
-		return err
-	}
-	if len(result.Items) == 0 {
-		return fmt.Errorf("item not found for "+attributeName+": ", attributeValue)
-	}
-	// Check the type of output and unmarshal accordingly
-	if reflect.TypeOf(output).Kind() == reflect.Ptr && reflect.TypeOf(output).Elem().Kind() == reflect.Slice {
-		// Unmarshal list of items
-		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, output)
-		if err != nil {
-			return err
-		}
-	} else {
-		// Unmarshal single item
-		if len(result.Items) > 1 {
-			return fmt.Errorf("more than one item found for %s: %s", attributeName, attributeValue)
-		}
-		err = dynamodbattribute.UnmarshalMap(result.Items[0], output)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
 }
 
-func (db *Database) GetAll(output interface{}) error {
-	result, err := db.DynamodbClient.Scan(&dynamodb.ScanInput{
-		TableName: aws.String(db.TableName),
+func (t *Table[T]) Scan() ([]T, error) {
+	return t.scan(&dynamodb.ScanInput{
+		TableName: aws.String(t.TableName),
+	})
+}
+
+// scan reads every page of the scan, a page stops at 1 MB
+func (t *Table[T]) scan(input *dynamodb.ScanInput) ([]T, error) {
+	items := []T{}
+	var unmarshalErr error
+	err := t.DynamodbClient.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		pageItems := []T{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		items = append(items, pageItems...)
+		return unmarshalErr == nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return items, unmarshalErr
+}
 
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, output)
+func (t *Table[T]) Put(item T) error {
+	attributes, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = t.DynamodbClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(t.TableName),
+		Item:      attributes,
+	})
+	return err
 }
 
-func (db *Database) CreateOrUpdate(object interface{}) error {
-	item, err := dynamodbattribute.MarshalMap(object)
+func (t *Table[T]) PutIf(item T, attribute string, value string) error {
+	attributes, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
 		return err
 	}
 	input := &dynamodb.PutItemInput{
-		TableName: aws.String(db.TableName),
-		Item:      item,
+		TableName:                aws.String(t.TableName),
+		Item:                     attributes,
+		ConditionExpression:      aws.String("#a = :v"),
+		ExpressionAttributeNames: map[string]*string{"#a": aws.String(attribute)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v": {S: aws.String(value)},
+		},
 	}
-	db.DynamodbClient.PutItem(input)
-	return nil
+	if value == "" {
+		// empty strings are stored as NULL, or the attribute isn't there at
+		// all on items written before it was added
+		input.ConditionExpression = aws.String("attribute_not_exists(#a) OR attribute_type(#a, :v)")
+		input.ExpressionAttributeValues[":v"] = &dynamodb.AttributeValue{S: aws.String("NULL")}
+	}
+	_, err = t.DynamodbClient.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrConditionFailed
+	}
+	return err
 }
 
-func (db *Database) Delete(idValue string) error {
-	_, err := db.DynamodbClient.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: aws.String(db.TableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			db.IdName: {
-				S: aws.String(idValue),
-			},
-		},
-	})
+func (t *Table[T]) Update(key string, set map[string]interface{}, remove []string) error {
+	input, err := t.updateInput(key, set, remove)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = t.DynamodbClient.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return fmt.Errorf("%w for %s: %s", ErrNotFound, t.Key, key)
+	}
+	return err
 }
 
-func (db *Database) DeleteFilter(attributeValue string, attrbuteName string) error {
-	array := []any{}
-	db.GetFilter(attributeValue, attrbuteName, array)
-	if len(array) == 0 {
-		return fmt.Errorf("item not found for "+attrbuteName+": ", attributeValue)
+// updateInput builds the update expression, like
+// "SET #a0 = :v0, #a1 = :v1 REMOVE #a2". The attribute names always go
+// through placeholders so reserved words like Name work as attributes.
+func (t *Table[T]) updateInput(key string, set map[string]interface{}, remove []string) (*dynamodb.UpdateItemInput, error) {
+	if len(set) == 0 && len(remove) == 0 {
+		return nil, errors.New("nothing to update")
+	}
+	names := map[string]*string{"#id": aws.String(t.Key)}
+	values := map[string]*dynamodb.AttributeValue{}
+	placeholder := func(name string) (string, error) {
+		if name == "" || name == t.Key {
+			return "", fmt.Errorf("can't update the attribute %q", name)
+		}
+		placeholder := "#a" + strconv.Itoa(len(names)-1)
+		names[placeholder] = aws.String(name)
+		return placeholder, nil
 	}
 
-	for _, item := range array {
-		id, err := getIDValue(item, db.IdName)
+	setNames := make([]string, 0, len(set))
+	for name := range set {
+		setNames = append(setNames, name)
+	}
+	sort.Strings(setNames)
+	sets := []string{}
+	for _, name := range setNames {
+		if slices.Contains(remove, name) {
+			return nil, fmt.Errorf("can't both set and remove %s", name)
+		}
+		namePlaceholder, err := placeholder(name)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		err = db.Delete(id)
+		value, err := dynamodbattribute.Marshal(set[name])
 		if err != nil {
-			return err
+			return nil, err
 		}
+		valuePlaceholder := ":v" + strconv.Itoa(len(values))
+		values[valuePlaceholder] = value
+		sets = append(sets, namePlaceholder+" = "+valuePlaceholder)
 	}
-	return nil
+	removes := []string{}
+	for _, name := range remove {
+		namePlaceholder, err := placeholder(name)
+		if err != nil {
+			return nil, err
+		}
+		removes = append(removes, namePlaceholder)
+	}
+
+	expression := []string{}
+	if len(sets) > 0 {
+		expression = append(expression, "SET "+strings.Join(sets, ", "))
+	}
+	if len(removes) > 0 {
+		expression = append(expression, "REMOVE "+strings.Join(removes, ", "))
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(t.TableName),
+		Key:                      t.keyAttribute(key),
+		UpdateExpression:         aws.String(strings.Join(expression, " ")),
+		ConditionExpression:      aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: names,
+	}
+	if len(values) > 0 {
+		input.ExpressionAttributeValues = values
+	}
+	return input, nil
 }
 
-func (db *Database) DeleteAll() error {
-	db.DynamodbClient.DeleteTable(&dynamodb.DeleteTableInput{
-		TableName: aws.String(db.TableName),
+func (t *Table[T]) Delete(key string) error {
+	_, err := t.DynamodbClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(t.TableName),
+		Key:       t.keyAttribute(key),
 	})
-	db.InitializeTables()
+	return err
+}
+
+func (t *Table[T]) BatchGet(keys []string) ([]T, error) {
+	found := map[string]T{}
+	for _, chunk := range chunks(unique(keys), maxBatchGet) {
+		requested := []map[string]*dynamodb.AttributeValue{}
+		for _, key := range chunk {
+			requested = append(requested, t.keyAttribute(key))
+		}
+		request := map[string]*dynamodb.KeysAndAttributes{
+			t.TableName: {Keys: requested},
+		}
+		// DynamoDB can leave some keys for later when it is busy
+		for len(request) > 0 {
+			result, err := t.DynamodbClient.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, err
+			}
+			for _, attributes := range result.Responses[t.TableName] {
+				var item T
+				err = dynamodbattribute.UnmarshalMap(attributes, &item)
+				if err != nil {
+					return nil, err
+				}
+				found[aws.StringValue(attributes[t.Key].S)] = item
+			}
+			request = result.UnprocessedKeys
+		}
+	}
+	items := []T{}
+	for _, key := range keys {
+		if item, ok := found[key]; ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (t *Table[T]) BatchWrite(puts []T, deletes []string) error {
+	writes := []*dynamodb.WriteRequest{}
+	for _, item := range puts {
+		attributes, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: attributes}})
+	}
+	for _, key := range deletes {
+		writes = append(writes, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: t.keyAttribute(key)}})
+	}
+	for _, chunk := range chunks(writes, maxBatchWrite) {
+		request := map[string][]*dynamodb.WriteRequest{t.TableName: chunk}
+		// DynamoDB can leave some writes for later when it is busy
+		for len(request) > 0 {
+			result, err := t.DynamodbClient.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: request})
+			if err != nil {
+				return err
+			}
+			request = result.UnprocessedItems
+		}
+	}
 	return nil
 }
 
 // ----------------- Helper -----------------
 
-func getIDValue(item interface{}, fieldName string) (string, error) {
-	r := reflect.ValueOf(item)
-	f := reflect.Indirect(r).FieldByName(fieldName)
-	if !f.IsValid() {
-		return "", fmt.Errorf("field %s not found", fieldName)
+func (t *Table[T]) keyAttribute(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		t.Key: {S: aws.String(key)},
+	}
+}
+
+// keyOf reads the key attribute of the item the way it is stored
+func keyOf[T any](item T, key string) (string, error) {
+	attributes, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return "", err
+	}
+	value, ok := attributes[key]
+	if !ok || value.S == nil {
+		return "", fmt.Errorf("the item has no %s", key)
 	}
-	return f.String(), nil
+	return *value.S, nil
 }
 
-func capitalizeFirstLetter(s string) string {
-	if len(s) == 0 {
-		return s
+func chunks[E any](list []E, size int) [][]E {
+	chunks := [][]E{}
+	for len(list) > size {
+		chunks = append(chunks, list[:size])
+		list = list[size:]
 	}
-	return strings.ToUpper(string(s[0])) + s[1:]
+	if len(list) > 0 {
+		chunks = append(chunks, list)
+	}
+	return chunks
 }
 
-func lowercaseFirstLetter(s string) string {
-	if len(s) == 0 {
-		return s
+func unique(keys []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
 	}
-	return strings.ToLower(string(s[0])) + s[1:]
+	return result
 }
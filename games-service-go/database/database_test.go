@@ -0,0 +1,166 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type item struct {
+	ID    string
+	Name  string
+	Count int
+}
+
+func TestUpdateInput(t *testing.T) {
+	db := Table[item]{TableName: "Games", Key: "ID"}
+	input, err := db.updateInput("Game1", map[string]interface{}{
+		"Title": "New title",
+		"Price": map[string]interface{}{"Amount": 0, "Currency": "USD"},
+		"Name":  "reserved word",
+	}, []string{"Publisher"})
+	if err != nil {
+		t.Fatalf("Error building the update: %v", err)
+	}
+
+	expression := "SET #a0 = :v0, #a1 = :v1, #a2 = :v2 REMOVE #a3"
+	if aws.StringValue(input.UpdateExpression) != expression {
+		t.Errorf("Expected %q got %q", expression, aws.StringValue(input.UpdateExpression))
+	}
+	if aws.StringValue(input.ConditionExpression) != "attribute_exists(#id)" {
+		t.Errorf("Unexpected condition %q", aws.StringValue(input.ConditionExpression))
+	}
+	names := map[string]string{"#id": "ID", "#a0": "Name", "#a1": "Price", "#a2": "Title", "#a3": "Publisher"}
+	for key, name := range names {
+		if aws.StringValue(input.ExpressionAttributeNames[key]) != name {
+			t.Errorf("Expected %s to be %s got %s", key, name, aws.StringValue(input.ExpressionAttributeNames[key]))
+		}
+	}
+	// a zero price is still written
+	amount := input.ExpressionAttributeValues[":v1"].M["Amount"]
+	if amount == nil || aws.StringValue(amount.N) != "0" {
+		t.Errorf("Expected the zero amount to be set got %v", input.ExpressionAttributeValues[":v1"])
+	}
+	if aws.StringValue(input.Key["ID"].S) != "Game1" {
+		t.Errorf("Unexpected key %v", input.Key)
+	}
+
+	onlyRemove, err := db.updateInput("Game1", nil, []string{"Publisher"})
+	if err != nil {
+		t.Fatalf("Error building the update: %v", err)
+	}
+	if aws.StringValue(onlyRemove.UpdateExpression) != "REMOVE #a0" || onlyRemove.ExpressionAttributeValues != nil {
+		t.Errorf("Unexpected update %v", onlyRemove)
+	}
+
+	invalid := []struct {
+		set    map[string]interface{}
+		remove []string
+	}{
+		{nil, nil},
+		{map[string]interface{}{"ID": "Game2"}, nil},
+		{map[string]interface{}{"Title": "New"}, []string{"Title"}},
+	}
+	for _, update := range invalid {
+		if _, err := db.updateInput("Game1", update.set, update.remove); err == nil {
+			t.Errorf("Expected %v %v to be rejected", update.set, update.remove)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	db := Memory[item]{}
+	db.Init("Items", "ID")
+	err := db.BatchWrite([]item{{ID: "1", Name: "One"}, {ID: "2", Name: "Two"}, {ID: "3", Name: "One"}}, nil)
+	if err != nil {
+		t.Fatalf("Error writing items: %v", err)
+	}
+
+	got, err := db.Get("2")
+	if err != nil || got.Name != "Two" {
+		t.Errorf("Expected item 2 got %v %v", got, err)
+	}
+	_, err = db.Get("4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound got %v", err)
+	}
+
+	named, err := db.Query("Name", "One")
+	if err != nil || len(named) != 2 {
+		t.Errorf("Expected two items named One got %v %v", named, err)
+	}
+	none, err := db.Query("Name", "Four")
+	if err != nil || len(none) != 0 {
+		t.Errorf("Expected no items got %v %v", none, err)
+	}
+
+	batch, err := db.BatchGet([]string{"3", "4", "1"})
+	if err != nil || len(batch) != 2 || batch[0].ID != "3" || batch[1].ID != "1" {
+		t.Errorf("Expected items 3 and 1 got %v %v", batch, err)
+	}
+
+	err = db.Update("1", map[string]interface{}{"Count": 5}, []string{"Name"})
+	if err != nil {
+		t.Fatalf("Error updating: %v", err)
+	}
+	got, _ = db.Get("1")
+	if got != (item{ID: "1", Count: 5}) {
+		t.Errorf("Unexpected item after the update %v", got)
+	}
+	if err := db.Update("4", map[string]interface{}{"Count": 1}, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected updating a missing item to fail got %v", err)
+	}
+
+	err = db.BatchWrite([]item{{ID: "2", Name: "Deux"}}, []string{"1", "3"})
+	if err != nil {
+		t.Fatalf("Error writing items: %v", err)
+	}
+	all, _ := db.Scan()
+	if len(all) != 1 || all[0].Name != "Deux" {
+		t.Errorf("Expected only item 2 left got %v", all)
+	}
+}
+
+func TestMemoryPutIf(t *testing.T) {
+	db := Memory[item]{}
+	db.Init("Items", "ID")
+	db.Put(item{ID: "1"})
+	// an unset attribute matches the empty value
+	err := db.PutIf(item{ID: "1", Name: "alice"}, "Name", "")
+	if err != nil {
+		t.Fatalf("Error replacing the item: %v", err)
+	}
+	err = db.PutIf(item{ID: "1", Name: "bob"}, "Name", "alice")
+	if err != nil {
+		t.Fatalf("Error replacing the item: %v", err)
+	}
+	// the second writer still expects alice and loses
+	err = db.PutIf(item{ID: "1", Name: "carol"}, "Name", "alice")
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed got %v", err)
+	}
+	err = db.PutIf(item{ID: "1", Name: "carol"}, "Name", "")
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed for a set attribute got %v", err)
+	}
+	err = db.PutIf(item{ID: "2", Name: "carol"}, "Name", "")
+	if !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("Expected ErrConditionFailed for a missing item got %v", err)
+	}
+	all, _ := db.Scan()
+	if len(all) != 1 || all[0].Name != "bob" {
+		t.Errorf("Expected only bob's item got %v", all)
+	}
+}
+
+func TestChunks(t *testing.T) {
+	keys := make([]string, 230)
+	got := chunks(keys, maxBatchGet)
+	if len(got) != 3 || len(got[0]) != 100 || len(got[2]) != 30 {
+		t.Errorf("Unexpected chunks of %d, %d and %d", len(got[0]), len(got[1]), len(got[2]))
+	}
+	if len(chunks([]string{}, maxBatchWrite)) != 0 {
+		t.Errorf("Expected no chunks for no keys")
+	}
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Memory is a Repository that keeps the items in memory, for the tests. The
+// items are matched on their attributes as DynamoDB stores them, so queries
+// and updates behave like they do on a Table.
+type Memory[T any] struct {
+	TableName string
+	Key       string
+	Items     []T
+}
+
+func (m *Memory[T]) Init(tableName string, key string) error {
+	m.TableName = tableName
+	m.Key = key
+	m.Items = []T{}
+	return nil
+}
+
+func (m *Memory[T]) Get(key string) (T, error) {
+	i, err := m.index(key)
+	if err != nil || i == -1 {
+		var item T
+		if err == nil {
+			err = fmt.Errorf("%w for %s: %s", ErrNotFound, m.Key, key)
+		}
+		return item, err
+	}
+	return m.Items[i], nil
+}
+
+func (m *Memory[T]) Query(attribute string, value string) ([]T, error) {
+	items := []T{}
+	for _, item := range m.Items {
+		attributes, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			return nil, err
+		}
+		if stored, ok := attributes[attribute]; ok && stored.S != nil && *stored.S == value {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *Memory[T]) Scan() ([]T, error) {
+	return append([]T{}, m.Items...), nil
+}
+
+func (m *Memory[T]) Put(item T) error {
+	key, err := keyOf(item, m.Key)
+	if err != nil {
+		return err
+	}
+	i, err := m.index(key)
+	if err != nil {
+		return err
+	}
+	if i == -1 {
+		m.Items = append(m.Items, item)
+	} else {
+		m.Items[i] = item
+	}
+	return nil
+}
+
+func (m *Memory[T]) PutIf(item T, attribute string, value string) error {
+	key, err := keyOf(item, m.Key)
+	if err != nil {
+		return err
+	}
+	i, err := m.index(key)
+	if err != nil {
+		return err
+	}
+	if i == -1 {
+		return ErrConditionFailed
+	}
+	attributes, err := dynamodbattribute.MarshalMap(m.Items[i])
+	if err != nil {
+		return err
+	}
+	// an empty string is stored as NULL, like a missing attribute
+	stored, ok := attributes[attribute]
+	matches := value == "" && (!ok || stored.NULL != nil)
+	if ok && stored.S != nil {
+		matches = *stored.S == value
+	}
+	if !matches {
+		return ErrConditionFailed
+	}
+	m.Items[i] = item
+	return nil
+}
+
+// Update changes the attributes of the item the way DynamoDB does, by turning
+// it into attributes and back
+func (m *Memory[T]) Update(key string, set map[string]interface{}, remove []string) error {
+	i, err := m.index(key)
+	if err != nil {
+		return err
+	}
+	if i == -1 {
+		return fmt.Errorf("%w for %s: %s", ErrNotFound, m.Key, key)
+	}
+	attributes, err := dynamodbattribute.MarshalMap(m.Items[i])
+	if err != nil {
+		return err
+	}
+	for name, value := range set {
+		attributes[name], err = dynamodbattribute.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range remove {
+		delete(attributes, name)
+	}
+	var updated T
+	err = dynamodbattribute.UnmarshalMap(attributes, &updated)
+	if err != nil {
+		return err
+	}
+	m.Items[i] = updated
+	return nil
+}
+
+func (m *Memory[T]) Delete(key string) error {
+	i, err := m.index(key)
+	if err != nil || i == -1 {
+		return err
+	}
+	m.Items = append(m.Items[:i], m.Items[i+1:]...)
+	return nil
+}
+
+func (m *Memory[T]) BatchGet(keys []string) ([]T, error) {
+	items := []T{}
+	for _, key := range keys {
+		i, err := m.index(key)
+		if err != nil {
+			return nil, err
+		}
+		if i != -1 {
+			items = append(items, m.Items[i])
+		}
+	}
+	return items, nil
+}
+
+func (m *Memory[T]) BatchWrite(puts []T, deletes []string) error {
+	for _, item := range puts {
+		err := m.Put(item)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range deletes {
+		err := m.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index is where the item with the key is in Items, -1 when it isn't there
+func (m *Memory[T]) index(key string) (int, error) {
+	for i, item := range m.Items {
+		itemKey, err := keyOf(item, m.Key)
+		if err != nil {
+			return -1, err
+		}
+		if itemKey == key {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
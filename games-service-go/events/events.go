@@ -0,0 +1,157 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+// Topics the carts service publishes that the games service reads
+const (
+	TopicCheckout       = "checkout"
+	TopicGiftAccepted   = "gift.accepted"
+	TopicRefundApproved = "refund.approved"
+)
+
+// Topics the games service publishes and reads back for its analytics
+const (
+	TopicWishlistAdded   = "wishlist.added"
+	TopicWishlistRemoved = "wishlist.removed"
+)
+
+// TopicAudit is published by every service for what admins and developers
+// do, the games service keeps the log
+const TopicAudit = "audit"
+
+var Topics = []string{TopicCheckout, TopicGiftAccepted, TopicRefundApproved, TopicWishlistAdded, TopicWishlistRemoved, TopicAudit}
+
+// Audit is the audit message. Before and After are the target as it was and
+// as it is now, left out when there was none.
+type Audit struct {
+	ID         string          `json:"ID"`
+	Time       string          `json:"Time"`
+	Service    string          `json:"Service"`
+	ActorID    string          `json:"ActorID"`
+	ActorRole  string          `json:"ActorRole"`
+	Action     string          `json:"Action"`
+	TargetType string          `json:"TargetType"`
+	TargetID   string          `json:"TargetID"`
+	Before     json.RawMessage `json:"Before,omitempty"`
+	After      json.RawMessage `json:"After,omitempty"`
+	RequestID  string          `json:"RequestID"`
+	IP         string          `json:"IP"`
+}
+
+// TopicGameReleased is published by the games service when a scheduled game
+// comes out
+const TopicGameReleased = "game.released"
+
+// Released is the game.released message
+type Released struct {
+	GameID      string `json:"GameID"`
+	Slug        string `json:"Slug"`
+	Title       string `json:"Title"`
+	AuthorID    string `json:"AuthorID"`
+	ReleaseAt   string `json:"ReleaseAt"`
+	PreOrders   bool   `json:"PreOrders"`
+	EarlyAccess bool   `json:"EarlyAccess"`
+}
+
+// The parts of the carts service messages the games service needs
+
+type Game struct {
+	ID          string `json:"ID"`
+	BundleItems []struct {
+		ID string `json:"ID"`
+	} `json:"BundleItems"`
+}
+
+// GameIDs is the game, or the games in it for a bundle
+func (g Game) GameIDs() []string {
+	if len(g.BundleItems) == 0 {
+		return []string{g.ID}
+	}
+	ids := []string{}
+	for _, item := range g.BundleItems {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// Checkout is an order, Games are the games bought for the buyer themselves.
+// The summary lines are every game paid for, gifts included.
+type Checkout struct {
+	ID      string `json:"ID"`
+	UserID  string `json:"UserID"`
+	Games   []Game `json:"Games"`
+	Date    string `json:"Date"`
+	Summary struct {
+		Lines []Line `json:"Lines"`
+	} `json:"Summary"`
+}
+
+// Line is a game in an order and what was paid for it before tax
+type Line struct {
+	Game  Game          `json:"Game"`
+	Price structs.Money `json:"Price"`
+}
+
+type Gift struct {
+	ID          string `json:"ID"`
+	OrderID     string `json:"OrderID"`
+	RecipientID string `json:"RecipientID"`
+	Game        Game   `json:"Game"`
+}
+
+// Refund is for one game of an order, which may be a bundle
+type Refund struct {
+	ID      string        `json:"ID"`
+	OrderID string        `json:"OrderID"`
+	UserID  string        `json:"UserID"`
+	GameID  string        `json:"GameID"`
+	Amount  structs.Money `json:"Amount"`
+	Decided string        `json:"Decided"`
+}
+
+// Wishlist is a game added to or taken off a user's wishlist
+type Wishlist struct {
+	ID     string `json:"ID"`
+	UserID string `json:"UserID"`
+	GameID string `json:"GameID"`
+	Date   string `json:"Date"`
+}
+
+// Event is a message as it came off Kafka, used to replay a saved stream
+// in offline mode
+type Event struct {
+	Topic string          `json:"Topic"`
+	Value json.RawMessage `json:"Value"`
+}
+
+// Load reads a file with one JSON Event per line
+func Load(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	saved := []Event{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		event := Event{}
+		err = json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, event)
+	}
+	return saved, scanner.Err()
+}
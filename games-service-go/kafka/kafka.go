@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+type KafkaConsumer struct {
+	Consumer sarama.Consumer
+}
+
+func (kafka *KafkaConsumer) InitKafkaConsumer() error {
+	url := os.Getenv("KAFKA_BROKER")
+	brokersUrl := []string{url}
+	err := error(nil)
+	kafka.Consumer, err = ConnectConsumer(brokersUrl)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func ConnectConsumer(brokersUrl []string) (sarama.Consumer, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+	conn, err := sarama.NewConsumer(brokersUrl, config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ConsumeFromStart reads every partition of the topics from the oldest message
+// on and calls handle for each message until the consumer is closed. State
+// built from the messages lives in memory, so each start reads it all again.
+func (kafka *KafkaConsumer) ConsumeFromStart(topics []string, handle func(topic string, key []byte, value []byte)) error {
+	var handleMu sync.Mutex
+	for _, topic := range topics {
+		partitions, err := kafka.Consumer.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		for _, partition := range partitions {
+			partitionConsumer, err := kafka.Consumer.ConsumePartition(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return err
+			}
+			go func() {
+				for {
+					select {
+					case msg, ok := <-partitionConsumer.Messages():
+						if !ok {
+							return
+						}
+						handleMu.Lock()
+						handle(msg.Topic, msg.Key, msg.Value)
+						handleMu.Unlock()
+					case err, ok := <-partitionConsumer.Errors():
+						if !ok {
+							return
+						}
+						log.Println("Error consuming from Kafka:", err)
+					}
+				}
+			}()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"errors"
+	"os"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrNotConnected is returned by Publish before the producer has connected
+var ErrNotConnected = errors.New("kafka producer isn't connected")
+
+type KafkaProducer struct {
+	Producer sarama.SyncProducer
+}
+
+func (kafka *KafkaProducer) InitKafkaProducer() error {
+	url := os.Getenv("KAFKA_BROKER")
+	brokersUrl := []string{url}
+	err := error(nil)
+	kafka.Producer, err = ConnectProducer(brokersUrl)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func ConnectProducer(brokersUrl []string) (sarama.SyncProducer, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	conn, err := sarama.NewSyncProducer(brokersUrl, config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Publish sends the message to the topic, keyed so messages about the same
+// thing stay in order
+func (kafka *KafkaProducer) Publish(topic string, key string, message []byte) error {
+	if kafka.Producer == nil {
+		return ErrNotConnected
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(message),
+	}
+	_, _, err := kafka.Producer.SendMessage(msg)
+	return err
+}
@@ -0,0 +1,201 @@
+package logic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	analytics "github.com/Draupniyr/games-service/analytics"
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var ErrInvalidRange = invalid("the dates must look like 2024-01-31, with from before to and at most a year apart")
+
+const (
+	// DefaultAnalyticsDays is the range shown when none is picked
+	DefaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+	// ExportLinkTTL is how long a signed CSV export link works
+	ExportLinkTTL = 5 * time.Minute
+)
+
+// AnalyticsReport is what the developer dashboard shows for a range of days.
+// Games are all the developer's games, GameID the one picked if any.
+type AnalyticsReport struct {
+	From   string
+	To     string
+	GameID string
+	Games  []structs.Game
+	Days   []analytics.Day
+	// Totals has a row for every game in the report, even ones with nothing
+	// happening, in title order
+	Totals []analytics.Day
+	Sum    analytics.Day
+}
+
+// Title is the name of one of the report's games
+func (r AnalyticsReport) Title(gameID string) string {
+	for _, game := range r.Games {
+		if game.ID == gameID {
+			return game.Title
+		}
+	}
+	return gameID
+}
+
+// ----------------- Analytics -----------------
+
+// AnalyticsRange checks the from and to dates, filling in the last
+// DefaultAnalyticsDays up to today when they are left out
+func AnalyticsRange(from string, to string, now time.Time) (string, string, error) {
+	if to == "" {
+		to = now.UTC().Format(time.DateOnly)
+	}
+	end, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		return "", "", ErrInvalidRange
+	}
+	if from == "" {
+		from = end.AddDate(0, 0, 1-DefaultAnalyticsDays).Format(time.DateOnly)
+	}
+	start, err := time.Parse(time.DateOnly, from)
+	if err != nil || start.After(end) || end.Sub(start) > maxAnalyticsDays*24*time.Hour {
+		return "", "", ErrInvalidRange
+	}
+	return from, to, nil
+}
+
+// GetAnalytics reports on the games the developer may inspect, their own and
+// their organizations', or the one of them picked
+func GetAnalytics(userID string, gameID string, from string, to string, store *analytics.Store, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) (*AnalyticsReport, error) {
+	games, err := inspectableGames(userID, orgDB, db)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(games, func(i, j int) bool { return games[i].Title < games[j].Title })
+
+	ids := []string{}
+	for _, game := range games {
+		if gameID == "" || game.ID == gameID {
+			ids = append(ids, game.ID)
+		}
+	}
+	if gameID != "" && len(ids) == 0 {
+		return nil, ErrGameNotFound
+	}
+
+	report := &AnalyticsReport{From: from, To: to, GameID: gameID, Games: games}
+	report.Days = store.Days(ids, from, to)
+	totals := analytics.Totals(report.Days)
+	for _, id := range ids {
+		total, ok := totals[id]
+		if !ok {
+			total = analytics.Sum(nil)
+			total.GameID = id
+		}
+		report.Totals = append(report.Totals, total)
+	}
+	report.Sum = analytics.Sum(report.Days)
+	return report, nil
+}
+
+// inspectableGames are the games Authorize lets the user inspect
+func inspectableGames(userID string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) ([]structs.Game, error) {
+	games := GetPersonalGames(userID, db)
+	orgs, err := GetOrganizations(userID, orgDB)
+	if err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		orgGames, err := GetOrganizationGames(org.ID, db)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, orgGames...)
+	}
+	return slices.DeleteFunc(games, func(g structs.Game) bool {
+		return Authorize(userID, "", ActionInspect, &g, orgDB) != nil
+	}), nil
+}
+
+// WriteAnalyticsCSV writes a row for each game and day with anything in it.
+// Money columns list an amount per currency, like "12.99 USD; 9.99 EUR".
+func WriteAnalyticsCSV(w io.Writer, report *AnalyticsReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "game_id", "title", "units", "gross", "refunds", "refunded", "net", "wishlist_adds", "wishlist_removes", "wishlist_sales", "conversion"})
+	for _, day := range report.Days {
+		writer.Write([]string{
+			day.Date,
+			day.GameID,
+			report.Title(day.GameID),
+			strconv.Itoa(day.Units),
+			csvMoney(day.Gross),
+			strconv.Itoa(day.Refunds),
+			csvMoney(day.Refunded),
+			csvMoney(day.Net()),
+			strconv.Itoa(day.WishlistAdds),
+			strconv.Itoa(day.WishlistRemoves),
+			strconv.Itoa(day.WishlistSales),
+			strconv.FormatFloat(day.Conversion(), 'f', 4, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvMoney(amounts map[string]structs.Money) string {
+	currencies := []string{}
+	for currency := range amounts {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	parts := []string{}
+	for _, currency := range currencies {
+		parts = append(parts, amounts[currency].Decimal()+" "+currency)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// CreateExportLink returns a signed link to the CSV of the developer's
+// report, since a browser download can't send the Authorization header
+func CreateExportLink(authorID string, gameID string, from string, to string, now time.Time, key []byte) string {
+	expires := strconv.FormatInt(now.Add(ExportLinkTTL).Unix(), 10)
+	query := url.Values{
+		"author":  {authorID},
+		"game":    {gameID},
+		"from":    {from},
+		"to":      {to},
+		"expires": {expires},
+		"sig":     {signExport(authorID, gameID, from, to, expires, key)},
+	}
+	return "/games/dev/analytics/export?" + query.Encode()
+}
+
+// CheckExportLink returns the developer the signed link is for
+func CheckExportLink(query url.Values, now time.Time, key []byte) (string, error) {
+	authorID, expires := query.Get("author"), query.Get("expires")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt || authorID == "" {
+		return "", ErrInvalidLink
+	}
+	sig := signExport(authorID, query.Get("game"), query.Get("from"), query.Get("to"), expires, key)
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(sig)) {
+		return "", ErrInvalidLink
+	}
+	return authorID, nil
+}
+
+func signExport(authorID string, gameID string, from string, to string, expires string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("analytics\n" + authorID + "\n" + gameID + "\n" + from + "\n" + to + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	analytics "github.com/Draupniyr/games-service/analytics"
+	events "github.com/Draupniyr/games-service/events"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestAnalyticsReport(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User1"))
+	db.Items = append(db.Items, createTestGame("Game3", "User2"))
+	store := analytics.NewStore()
+	store.Apply(events.TopicCheckout, []byte(`{"ID":"Order1","UserID":"User3","Date":"2024-03-02T10:00:00Z","Summary":{"Lines":[
+		{"Game":{"ID":"Game1"},"Price":{"Amount":999,"Currency":"USD"}},
+		{"Game":{"ID":"Game3"},"Price":{"Amount":500,"Currency":"USD"}}]}}`))
+
+	from, to, err := AnalyticsRange("", "", time.Date(2024, 3, 30, 12, 0, 0, 0, time.UTC))
+	simpleAssert(t, nil, err)
+	simpleAssert(t, "2024-03-01", from)
+	simpleAssert(t, "2024-03-30", to)
+	_, _, err = AnalyticsRange("2024-03-30", "2024-03-01", time.Now())
+	simpleAssert(t, ErrInvalidRange, err)
+	_, _, err = AnalyticsRange("2022-01-01", "2024-03-01", time.Now())
+	simpleAssert(t, ErrInvalidRange, err)
+	_, _, err = AnalyticsRange("March", "", time.Now())
+	simpleAssert(t, ErrInvalidRange, err)
+
+	// only the developer's own games are in the report, with a row each
+	report, err := GetAnalytics("User1", "", from, to, store, &orgDB, &db)
+	if err != nil {
+		t.Fatalf("Error getting analytics: %v", err)
+	}
+	simpleAssert(t, 2, len(report.Totals))
+	simpleAssert(t, 1, len(report.Days))
+	simpleAssert(t, 1, report.Sum.Units)
+	simpleAssert(t, structs.NewMoney(999, "USD"), report.Sum.Gross["USD"])
+
+	_, err = GetAnalytics("User1", "Game3", from, to, store, &orgDB, &db)
+	simpleAssert(t, ErrGameNotFound, err)
+	report, _ = GetAnalytics("User1", "Game2", from, to, store, &orgDB, &db)
+	simpleAssert(t, 0, len(report.Days))
+	simpleAssert(t, 1, len(report.Totals))
+
+	report, _ = GetAnalytics("User1", "Game1", from, to, store, &orgDB, &db)
+	csv := bytes.Buffer{}
+	err = WriteAnalyticsCSV(&csv, report)
+	simpleAssert(t, nil, err)
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	simpleAssert(t, 2, len(lines))
+	simpleAssert(t, "date,game_id,title,units,gross,refunds,refunded,net,wishlist_adds,wishlist_removes,wishlist_sales,conversion", lines[0])
+	simpleAssert(t, "2024-03-02,Game1,TestTitle,1,9.99 USD,0,,9.99 USD,0,0,0,0.0000", lines[1])
+}
+
+func TestExportLinks(t *testing.T) {
+	key := []byte("secret")
+	now := time.Now()
+	link, _ := url.Parse(CreateExportLink("User1", "Game1", "2024-03-01", "2024-03-30", now, key))
+	simpleAssert(t, "/games/dev/analytics/export", link.Path)
+
+	authorID, err := CheckExportLink(link.Query(), now, key)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, "User1", authorID)
+
+	// expired or tampered with
+	_, err = CheckExportLink(link.Query(), now.Add(ExportLinkTTL+time.Minute), key)
+	simpleAssert(t, ErrInvalidLink, err)
+	query := link.Query()
+	query.Set("author", "User2")
+	_, err = CheckExportLink(query, now, key)
+	simpleAssert(t, ErrInvalidLink, err)
+	query = link.Query()
+	query.Set("to", "2024-12-31")
+	_, err = CheckExportLink(query, now, key)
+	simpleAssert(t, ErrInvalidLink, err)
+}
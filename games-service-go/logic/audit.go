@@ -0,0 +1,244 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var ErrInvalidAuditFilter = invalid("the dates must look like 2024-01-31 and the limit be between 1 and 1000")
+
+// AuditRetention is how long entries stay in the audit log
+var AuditRetention = 365 * 24 * time.Hour
+
+const (
+	// DefaultAuditLimit is how many entries are shown when no limit is picked
+	DefaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditFilter picks entries of the audit log, empty fields match anything.
+// Action matches the action and the ones under it, so "game" finds
+// "game.delete". From and To are dates, both included.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	Service    string
+	TargetType string
+	TargetID   string
+	From       string
+	To         string
+	Limit      int
+}
+
+// Matches reports if the entry is one the filter picks
+func (f AuditFilter) Matches(entry structs.AuditEntry) bool {
+	if f.ActorID != "" && entry.ActorID != f.ActorID {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action && !strings.HasPrefix(entry.Action, f.Action+".") {
+		return false
+	}
+	if f.Service != "" && entry.Service != f.Service {
+		return false
+	}
+	if f.TargetType != "" && entry.TargetType != f.TargetType {
+		return false
+	}
+	if f.TargetID != "" && entry.TargetID != f.TargetID {
+		return false
+	}
+	// the times are UTC, so they start with the date
+	date := entry.Time
+	if len(date) > len(time.DateOnly) {
+		date = date[:len(time.DateOnly)]
+	}
+	if f.From != "" && date < f.From {
+		return false
+	}
+	if f.To != "" && date > f.To {
+		return false
+	}
+	return true
+}
+
+// ----------------- Audit -----------------
+
+// RecordAudit saves the entry to the audit log with the JSON of the target
+// before and after the action, and then sends it to the audit topic for
+// anyone else following it. Only failing to save is an error, the log doesn't
+// wait on Kafka.
+func RecordAudit(entry events.Audit, before interface{}, after interface{}, now time.Time, auditDB database.Repository[structs.AuditEntry], kafka kafka.KafkaProducer) error {
+	var err error
+	entry.ID = uuid.New().String()
+	entry.Time = now.UTC().Format(time.RFC3339)
+	entry.Before, err = auditJSON(before)
+	if err != nil {
+		return err
+	}
+	entry.After, err = auditJSON(after)
+	if err != nil {
+		return err
+	}
+	err = saveAuditEntry(entry, now, auditDB)
+	if err != nil {
+		return err
+	}
+	entryJson, err := json.Marshal(entry)
+	if err == nil {
+		err = kafka.Publish(events.TopicAudit, entry.TargetID, entryJson)
+	}
+	if err != nil {
+		log.Println("Error publishing audit entry", entry.ID, ":", err)
+	}
+	return nil
+}
+
+// ApplyAuditEvent adds an audit message to the log. A message read again
+// from the start of the topic keeps its ID, so it isn't added twice, and one
+// already past AuditRetention isn't added back.
+func ApplyAuditEvent(topic string, value []byte, now time.Time, auditDB database.Repository[structs.AuditEntry]) error {
+	if topic != events.TopicAudit {
+		return nil
+	}
+	message := events.Audit{}
+	err := json.Unmarshal(value, &message)
+	if err != nil {
+		return err
+	}
+	return saveAuditEntry(message, now, auditDB)
+}
+
+func saveAuditEntry(message events.Audit, now time.Time, auditDB database.Repository[structs.AuditEntry]) error {
+	happened, err := time.Parse(time.RFC3339, message.Time)
+	if err != nil || message.ID == "" {
+		return errors.New("audit message without an ID or time")
+	}
+	expiresAt := happened.Add(AuditRetention)
+	if !expiresAt.After(now) {
+		return nil
+	}
+	return auditDB.Put(structs.AuditEntry{
+		ID:         message.ID,
+		Time:       message.Time,
+		Service:    message.Service,
+		ActorID:    message.ActorID,
+		ActorRole:  message.ActorRole,
+		Action:     message.Action,
+		TargetType: message.TargetType,
+		TargetID:   message.TargetID,
+		Changes:    diffJSON(message.Before, message.After),
+		RequestID:  message.RequestID,
+		IP:         message.IP,
+		ExpiresAt:  expiresAt.Unix(),
+	})
+}
+
+// GetAuditLog returns the entries the filter picks, newest first. Entries
+// past retention that DynamoDB hasn't dropped yet are left out.
+func GetAuditLog(filter AuditFilter, now time.Time, auditDB database.Repository[structs.AuditEntry]) ([]structs.AuditEntry, error) {
+	for _, date := range []string{filter.From, filter.To} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			return nil, ErrInvalidAuditFilter
+		}
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditLimit
+	}
+	if filter.Limit < 0 || filter.Limit > maxAuditLimit {
+		return nil, ErrInvalidAuditFilter
+	}
+	entries, err := auditDB.Scan()
+	if err != nil {
+		return nil, err
+	}
+	picked := []structs.AuditEntry{}
+	for _, entry := range entries {
+		if entry.ExpiresAt > now.Unix() && filter.Matches(entry) {
+			picked = append(picked, entry)
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool {
+		if picked[i].Time != picked[j].Time {
+			return picked[i].Time > picked[j].Time
+		}
+		return picked[i].ID < picked[j].ID
+	})
+	if len(picked) > filter.Limit {
+		picked = picked[:filter.Limit]
+	}
+	return picked, nil
+}
+
+func auditJSON(value interface{}) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// diffJSON lists the top level fields that differ between the two JSON
+// objects, by name. Anything other than objects is compared whole, as the
+// field "".
+func diffJSON(before json.RawMessage, after json.RawMessage) []structs.AuditChange {
+	beforeFields, beforeOk := jsonFields(before)
+	afterFields, afterOk := jsonFields(after)
+	if !beforeOk || !afterOk {
+		beforeValue, afterValue := compactJSON(before), compactJSON(after)
+		if beforeValue == afterValue {
+			return []structs.AuditChange{}
+		}
+		return []structs.AuditChange{{Field: "", Before: beforeValue, After: afterValue}}
+	}
+	names := []string{}
+	for name := range beforeFields {
+		names = append(names, name)
+	}
+	for name := range afterFields {
+		if _, ok := beforeFields[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := []structs.AuditChange{}
+	for _, name := range names {
+		beforeValue, afterValue := compactJSON(beforeFields[name]), compactJSON(afterFields[name])
+		if beforeValue != afterValue {
+			changes = append(changes, structs.AuditChange{Field: name, Before: beforeValue, After: afterValue})
+		}
+	}
+	return changes
+}
+
+// jsonFields splits a JSON object into its fields. No value or null counts
+// as an object without any.
+func jsonFields(value json.RawMessage) (map[string]json.RawMessage, bool) {
+	fields := map[string]json.RawMessage{}
+	if len(value) == 0 || string(value) == "null" {
+		return fields, true
+	}
+	err := json.Unmarshal(value, &fields)
+	return fields, err == nil && fields != nil
+}
+
+func compactJSON(value json.RawMessage) string {
+	if len(value) == 0 || string(value) == "null" {
+		return ""
+	}
+	compacted := bytes.Buffer{}
+	if json.Compact(&compacted, value) != nil {
+		return string(value)
+	}
+	return compacted.String()
+}
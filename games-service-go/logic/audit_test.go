@@ -0,0 +1,122 @@
+package logic
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestRecordAudit(t *testing.T) {
+	auditDB := database.Memory[structs.AuditEntry]{}
+	auditDB.Init("AuditLog", "ID")
+	now := time.Now().UTC()
+	before := createTestGame("Game1", "User1")
+	after := createTestGame("Game1", "User1")
+	after.Description = "Edited"
+
+	var sent []byte
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		simpleAssert(t, events.TopicAudit, msg.Topic)
+		sent, _ = msg.Value.Encode()
+		return nil
+	})
+	entry := events.Audit{Service: "games", ActorID: "User1", ActorRole: "dev", Action: "game.update", TargetType: "game", TargetID: "Game1", RequestID: "Request1", IP: "10.0.0.1"}
+	err := RecordAudit(entry, before, after, now, &auditDB, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error recording audit entry: %v", err)
+	}
+
+	// only the changed fields are kept, and reading the topic back adds nothing
+	simpleAssert(t, nil, ApplyAuditEvent(events.TopicAudit, sent, now, &auditDB))
+	simpleAssert(t, nil, ApplyAuditEvent(events.TopicAudit, sent, now, &auditDB))
+	entries, _ := GetAuditLog(AuditFilter{}, now, &auditDB)
+	simpleAssert(t, 1, len(entries))
+	simpleAssert(t, "User1", entries[0].ActorID)
+	simpleAssert(t, "10.0.0.1", entries[0].IP)
+	simpleAssert(t, 1, len(entries[0].Changes))
+	simpleAssert(t, "Description", entries[0].Changes[0].Field)
+	simpleAssert(t, `"TestDescription"`, entries[0].Changes[0].Before)
+	simpleAssert(t, `"Edited"`, entries[0].Changes[0].After)
+
+	// a deleted target has no after
+	simpleAssert(t, 0, len(diffJSON(nil, nil)))
+	simpleAssert(t, "", diffJSON([]byte(`{"Title":"Game"}`), nil)[0].After)
+}
+
+func TestRecordAuditWithoutKafka(t *testing.T) {
+	auditDB := database.Memory[structs.AuditEntry]{}
+	auditDB.Init("AuditLog", "ID")
+	now := time.Now().UTC()
+
+	// the entry is kept even when it can't be sent on
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	entry := events.Audit{Service: "games", ActorID: "Admin1", ActorRole: "admin", Action: "game.delete", TargetType: "game", TargetID: "Game1"}
+	simpleAssert(t, nil, RecordAudit(entry, createTestGame("Game1", "User1"), nil, now, &auditDB, kafka.KafkaProducer{Producer: producer}))
+	simpleAssert(t, nil, RecordAudit(entry, nil, nil, now, &auditDB, kafka.KafkaProducer{}))
+	entries, _ := GetAuditLog(AuditFilter{}, now, &auditDB)
+	simpleAssert(t, 2, len(entries))
+	simpleAssert(t, "game.delete", entries[0].Action)
+}
+
+func TestAuditLog(t *testing.T) {
+	auditDB := database.Memory[structs.AuditEntry]{}
+	auditDB.Init("AuditLog", "ID")
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	add := func(id string, at time.Time, service string, actor string, action string, target string) {
+		message, _ := json.Marshal(events.Audit{ID: id, Time: at.Format(time.RFC3339), Service: service, ActorID: actor, Action: action, TargetType: "game", TargetID: target})
+		err := ApplyAuditEvent(events.TopicAudit, message, now, &auditDB)
+		if err != nil {
+			t.Fatalf("Error applying audit event: %v", err)
+		}
+	}
+	add("Entry1", now.Add(-48*time.Hour), "games", "Admin1", "game.delete", "Game1")
+	add("Entry2", now.Add(-24*time.Hour), "games", "Dev1", "game.update", "Game2")
+	add("Entry3", now.Add(-time.Hour), "carts", "Admin1", "refund.approve", "Refund1")
+	add("Entry4", now.Add(-AuditRetention-time.Hour), "games", "Admin1", "game.delete", "Game3")
+	simpleAssert(t, nil, ApplyAuditEvent(events.TopicCheckout, []byte("{}"), now, &auditDB))
+
+	// entries past retention are dropped, the rest come newest first
+	entries, _ := GetAuditLog(AuditFilter{}, now, &auditDB)
+	simpleAssert(t, 3, len(entries))
+	simpleAssert(t, "Entry3", entries[0].ID)
+	simpleAssert(t, "Entry1", entries[2].ID)
+	entries, _ = GetAuditLog(AuditFilter{}, now.Add(AuditRetention-47*time.Hour), &auditDB)
+	simpleAssert(t, 2, len(entries))
+
+	tests := []struct {
+		filter AuditFilter
+		want   int
+	}{
+		{AuditFilter{ActorID: "Admin1"}, 2},
+		{AuditFilter{Action: "game"}, 2},
+		{AuditFilter{Action: "game.delete"}, 1},
+		{AuditFilter{Action: "gam"}, 0},
+		{AuditFilter{Service: "carts"}, 1},
+		{AuditFilter{TargetID: "Game2"}, 1},
+		{AuditFilter{From: "2024-06-09"}, 2},
+		{AuditFilter{From: "2024-06-08", To: "2024-06-08"}, 1},
+		{AuditFilter{Limit: 1}, 1},
+	}
+	for _, test := range tests {
+		entries, err := GetAuditLog(test.filter, now, &auditDB)
+		if err != nil {
+			t.Errorf("Error getting audit log for %+v: %v", test.filter, err)
+		}
+		simpleAssert(t, test.want, len(entries))
+	}
+
+	for _, filter := range []AuditFilter{{From: "June"}, {To: "2024-13-01"}, {Limit: -1}, {Limit: 1001}} {
+		_, err := GetAuditLog(filter, now, &auditDB)
+		simpleAssert(t, ErrInvalidAuditFilter, err)
+	}
+}
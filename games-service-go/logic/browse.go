@@ -0,0 +1,324 @@
+package logic
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var ErrInvalidDate = invalid("dates must look like 2006-01-02")
+
+// BrowseFilter is what the store page is narrowed down to. Tags match all of
+// them when MatchAll is set, any of them otherwise. Prices are in minor units
+// of Currency and compared with the sale price. Released dates are inclusive.
+type BrowseFilter struct {
+	Tags           []string
+	MatchAll       bool
+	MinPrice       *int64
+	MaxPrice       *int64
+	FreeOnly       bool
+	ReleasedAfter  string
+	ReleasedBefore string
+	AuthorID       string
+	Currency       string
+	Sort           string
+}
+
+// Sort orders for browsing
+const (
+	SortTitle  = "title"
+	SortPrice  = "price"
+	SortNewest = "newest"
+)
+
+type TagFacet struct {
+	Tag      string
+	Count    int
+	Selected bool
+}
+
+// PriceFacet is a price range, Min and Max are decimals ready to put back in
+// the query. Max is empty for the last range.
+type PriceFacet struct {
+	Label    string
+	Min      string
+	Max      string
+	Free     bool
+	Count    int
+	Selected bool
+}
+
+type DeveloperFacet struct {
+	AuthorID string
+	Author   string
+	Count    int
+	Selected bool
+}
+
+type BrowseResult struct {
+	Games      []structs.Game
+	Filter     BrowseFilter
+	Tags       []TagFacet
+	Prices     []PriceFacet
+	Developers []DeveloperFacet
+}
+
+// PriceBucketEdges split the price facet into ranges, in minor units. The
+// default is for currencies with cents.
+var PriceBucketEdges = map[string][]int64{
+	"":    {500, 1000, 2000, 4000},
+	"JPY": {1000, 2000, 3000, 6000},
+}
+
+// ----------------- Browsing -----------------
+
+// BrowseGames returns the games passing the filter and the facet counts for
+// the controls on the store page. Each facet is counted over the games the
+// other filters leave, so picking a tag doesn't zero the other tags when
+// they are ORed. With MatchAll the tag counts are for the games already shown.
+func BrowseGames(filter BrowseFilter, db database.Repository[structs.Game]) (*BrowseResult, error) {
+	if filter.Currency == "" {
+		filter.Currency = structs.DefaultCurrency
+	}
+	for _, date := range []string{filter.ReleasedAfter, filter.ReleasedBefore} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return nil, ErrInvalidDate
+		}
+	}
+	all, err := GetAllGames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BrowseResult{
+		Games:  []structs.Game{},
+		Filter: filter,
+	}
+	tagCounts := map[string]int{}
+	tagNames := map[string]string{}
+	priceGames := []structs.Game{}
+	developerCounts := map[string]int{}
+	developerNames := map[string]string{}
+	for _, game := range all {
+		matchesTags := filter.matchesTags(game)
+		matchesPrice := filter.matchesPrice(game)
+		matchesDeveloper := filter.AuthorID == "" || game.AuthorID == filter.AuthorID
+		if !filter.matchesRelease(game) {
+			continue
+		}
+		if matchesPrice && matchesDeveloper && (matchesTags || !filter.MatchAll) {
+			for _, tag := range uniqueTags(game.Tags) {
+				key := strings.ToLower(tag)
+				tagCounts[key]++
+				if _, ok := tagNames[key]; !ok {
+					tagNames[key] = tag
+				}
+			}
+		}
+		if matchesTags && matchesDeveloper {
+			priceGames = append(priceGames, game)
+		}
+		if matchesTags && matchesPrice {
+			developerCounts[game.AuthorID]++
+			developerNames[game.AuthorID] = game.Author
+		}
+		if matchesTags && matchesPrice && matchesDeveloper {
+			result.Games = append(result.Games, game)
+		}
+	}
+
+	for key, count := range tagCounts {
+		result.Tags = append(result.Tags, TagFacet{
+			Tag:      tagNames[key],
+			Count:    count,
+			Selected: containsFold(filter.Tags, key),
+		})
+	}
+	sort.Slice(result.Tags, func(i, j int) bool {
+		if result.Tags[i].Count != result.Tags[j].Count {
+			return result.Tags[i].Count > result.Tags[j].Count
+		}
+		return result.Tags[i].Tag < result.Tags[j].Tag
+	})
+	for id, count := range developerCounts {
+		result.Developers = append(result.Developers, DeveloperFacet{
+			AuthorID: id,
+			Author:   developerNames[id],
+			Count:    count,
+			Selected: id == filter.AuthorID,
+		})
+	}
+	sort.Slice(result.Developers, func(i, j int) bool {
+		return result.Developers[i].Author < result.Developers[j].Author
+	})
+	result.Prices = priceFacets(priceGames, filter)
+	sortGames(result.Games, filter)
+	return result, nil
+}
+
+func (f BrowseFilter) matchesTags(game structs.Game) bool {
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range f.Tags {
+		has := containsFold(game.Tags, tag)
+		if has && !f.MatchAll {
+			return true
+		}
+		if !has && f.MatchAll {
+			return false
+		}
+	}
+	return f.MatchAll
+}
+
+func (f BrowseFilter) matchesPrice(game structs.Game) bool {
+	price := game.SalePriceIn(f.Currency)
+	if f.FreeOnly {
+		return price.Amount == 0
+	}
+	if f.MinPrice == nil && f.MaxPrice == nil {
+		return true
+	}
+	if price.Currency != f.Currency {
+		return false
+	}
+	if f.MinPrice != nil && price.Amount < *f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != nil && price.Amount > *f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// matchesRelease compares the date part of the release date, which is enough
+// since the dates are ISO formatted
+func (f BrowseFilter) matchesRelease(game structs.Game) bool {
+	if f.ReleasedAfter == "" && f.ReleasedBefore == "" {
+		return true
+	}
+	released := game.Released()
+	if len(released) > len(time.DateOnly) {
+		released = released[:len(time.DateOnly)]
+	}
+	if _, err := time.Parse(time.DateOnly, released); err != nil {
+		return false
+	}
+	if f.ReleasedAfter != "" && released < f.ReleasedAfter {
+		return false
+	}
+	if f.ReleasedBefore != "" && released > f.ReleasedBefore {
+		return false
+	}
+	return true
+}
+
+// priceFacets counts the games in the free range and each bucket range
+func priceFacets(games []structs.Game, filter BrowseFilter) []PriceFacet {
+	edges, ok := PriceBucketEdges[filter.Currency]
+	if !ok {
+		edges = PriceBucketEdges[""]
+	}
+	money := func(amount int64) structs.Money {
+		return structs.NewMoney(amount, filter.Currency)
+	}
+	selected := func(min int64, max *int64) bool {
+		if filter.FreeOnly || filter.MinPrice == nil || *filter.MinPrice != min {
+			return false
+		}
+		if max == nil {
+			return filter.MaxPrice == nil
+		}
+		return filter.MaxPrice != nil && *filter.MaxPrice == *max
+	}
+
+	facets := []PriceFacet{{Label: "Free", Free: true, Selected: filter.FreeOnly}}
+	lower := int64(1)
+	for i := 0; i <= len(edges); i++ {
+		facet := PriceFacet{Min: money(lower).Decimal()}
+		if i < len(edges) {
+			upper := edges[i] - 1
+			facet.Max = money(upper).Decimal()
+			facet.Selected = selected(lower, &upper)
+			if i == 0 {
+				facet.Label = "Under " + money(edges[i]).String()
+			} else {
+				facet.Label = money(lower).String() + " - " + money(edges[i]).String()
+			}
+			lower = edges[i]
+		} else {
+			facet.Label = money(lower).String() + " and up"
+			facet.Selected = selected(lower, nil)
+		}
+		facets = append(facets, facet)
+	}
+
+	for _, game := range games {
+		price := game.SalePriceIn(filter.Currency)
+		if price.Currency != filter.Currency {
+			continue
+		}
+		if price.Amount == 0 {
+			facets[0].Count++
+			continue
+		}
+		bucket := len(edges)
+		for i, edge := range edges {
+			if price.Amount < edge {
+				bucket = i
+				break
+			}
+		}
+		facets[bucket+1].Count++
+	}
+	return facets
+}
+
+func sortGames(games []structs.Game, filter BrowseFilter) {
+	sort.SliceStable(games, func(i, j int) bool {
+		switch filter.Sort {
+		case SortPrice:
+			pi, pj := games[i].SalePriceIn(filter.Currency), games[j].SalePriceIn(filter.Currency)
+			if pi.Amount != pj.Amount {
+				return pi.Amount < pj.Amount
+			}
+		case SortNewest:
+			if games[i].Released() != games[j].Released() {
+				return games[i].Released() > games[j].Released()
+			}
+		}
+		return games[i].Title < games[j].Title
+	})
+}
+
+func uniqueTags(tags []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, tag)
+	}
+	return unique
+}
+
+func containsFold(list []string, value string) bool {
+	value = strings.TrimSpace(value)
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestBrowseGames(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Tags = []string{"RPG", "Co-op"}
+	game.Published = "2024-01-10"
+	db.Items = append(db.Items, game)
+	game = createTestGame("Game2", "User2")
+	game.Tags = []string{"RPG"}
+	game.Price = structs.NewMoney(0, "USD")
+	game.Published = "2024-06-01"
+	db.Items = append(db.Items, game)
+	game = createTestGame("Game3", "User1")
+	game.Tags = []string{"Puzzle", "co-op"}
+	game.Price = structs.NewMoney(4500, "USD")
+	game.Published = "2023-03-03"
+	db.Items = append(db.Items, game)
+
+	// tags are ORed unless all of them have to match
+	result, err := BrowseGames(BrowseFilter{Tags: []string{"rpg", "puzzle"}}, &db)
+	if err != nil {
+		t.Errorf("Error browsing Games: %v", err)
+	}
+	simpleAssert(t, 3, len(result.Games))
+	result, _ = BrowseGames(BrowseFilter{Tags: []string{"rpg", "co-op"}, MatchAll: true}, &db)
+	simpleAssert(t, 1, len(result.Games))
+	simpleAssert(t, "Game1", result.Games[0].ID)
+
+	free, _ := BrowseGames(BrowseFilter{FreeOnly: true}, &db)
+	simpleAssert(t, 1, len(free.Games))
+	max := int64(2000)
+	result, _ = BrowseGames(BrowseFilter{MaxPrice: &max, ReleasedAfter: "2024-01-01"}, &db)
+	simpleAssert(t, 2, len(result.Games))
+	result, _ = BrowseGames(BrowseFilter{AuthorID: "User1", ReleasedBefore: "2023-12-31"}, &db)
+	simpleAssert(t, 1, len(result.Games))
+	_, err = BrowseGames(BrowseFilter{ReleasedAfter: "last week"}, &db)
+	simpleAssert(t, ErrInvalidDate, err)
+}
+
+func TestBrowseFacets(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Tags = []string{"RPG", "Co-op"}
+	db.Items = append(db.Items, game)
+	game = createTestGame("Game2", "User2")
+	game.Tags = []string{"rpg"}
+	game.Price = structs.NewMoney(0, "USD")
+	db.Items = append(db.Items, game)
+
+	result, _ := BrowseGames(BrowseFilter{Tags: []string{"co-op"}}, &db)
+	// tag counts ignore the tag filter when tags are ORed, and fold case
+	simpleAssert(t, "RPG", result.Tags[0].Tag)
+	simpleAssert(t, 2, result.Tags[0].Count)
+	simpleAssert(t, false, result.Tags[0].Selected)
+	simpleAssert(t, true, result.Tags[1].Selected)
+
+	// price buckets count the games the other filters leave, Game1 costs 12.34
+	result, _ = BrowseGames(BrowseFilter{}, &db)
+	simpleAssert(t, 1, result.Prices[0].Count)
+	simpleAssert(t, "Free", result.Prices[0].Label)
+	simpleAssert(t, 1, result.Prices[3].Count)
+	simpleAssert(t, "10.00", result.Prices[3].Min)
+	simpleAssert(t, "19.99", result.Prices[3].Max)
+	simpleAssert(t, 2, len(result.Developers))
+}
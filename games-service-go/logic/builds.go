@@ -0,0 +1,268 @@
+package logic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrBuildNotFound    = notFound("build not found")
+	ErrInvalidBuild     = invalid("a build needs a file, a version, a platform of windows, mac or linux and a channel of stable or beta")
+	ErrBuildExists      = conflict("that version is already uploaded for the platform and channel")
+	ErrBuildTooLarge    = tooLarge("the build is too large")
+	ErrChecksumMismatch = invalid("the uploaded file doesn't match the checksum")
+	ErrNotOwned         = forbidden("the game is not in your library")
+	ErrInvalidLink      = forbidden("the download link is invalid or has expired")
+)
+
+const (
+	MaxBuildSize int64 = 8 << 30
+	// fixed width so builds sort by upload time as strings
+	buildTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+	// DownloadLinkTTL is how long a signed download link works. Downloads
+	// already going keep going, resuming needs a new link.
+	DownloadLinkTTL = 15 * time.Minute
+)
+
+// ----------------- Builds -----------------
+
+// GetBuilds returns the game's builds, newest first
+func GetBuilds(gameID string, buildDB database.Repository[structs.Build]) ([]structs.Build, error) {
+	builds, err := buildDB.Query("GameID", gameID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(builds, func(i, j int) bool {
+		if builds[i].Uploaded != builds[j].Uploaded {
+			return builds[i].Uploaded > builds[j].Uploaded
+		}
+		return builds[i].ID < builds[j].ID
+	})
+	return builds, nil
+}
+
+func GetBuild(buildID string, buildDB database.Repository[structs.Build]) (*structs.Build, error) {
+	build, err := buildDB.Get(buildID)
+	if err != nil {
+		return nil, missing(err, ErrBuildNotFound)
+	}
+	return &build, nil
+}
+
+// LatestBuilds keeps the newest of the builds for each platform and channel,
+// ordered by platform then channel
+func LatestBuilds(builds []structs.Build) []structs.Build {
+	latest := []structs.Build{}
+	for _, platform := range structs.Platforms {
+		for _, channel := range structs.Channels {
+			newest := -1
+			for i, build := range builds {
+				if build.Platform != platform || build.Channel != channel {
+					continue
+				}
+				if newest < 0 || build.Uploaded > builds[newest].Uploaded {
+					newest = i
+				}
+			}
+			if newest >= 0 {
+				latest = append(latest, builds[newest])
+			}
+		}
+	}
+	return latest
+}
+
+// UploadBuild streams the file into the blob store, working out its size and
+// checksum on the way, and saves the build. A file that doesn't match the
+// checksum the developer sent is thrown away.
+func UploadBuild(gameID string, userID string, userRole string, request structs.BuildRequest, file io.Reader, store blobstore.Store, buildDB database.Repository[structs.Build], orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) (*structs.Build, error) {
+	_, err := authorizedGame(gameID, userID, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return nil, err
+	}
+	build := structs.Build{
+		ID:         uuid.New().String(),
+		GameID:     gameID,
+		Version:    strings.TrimSpace(request.Version),
+		Platform:   strings.ToLower(strings.TrimSpace(request.Platform)),
+		Channel:    strings.ToLower(strings.TrimSpace(request.Channel)),
+		Notes:      strings.TrimSpace(request.Notes),
+		FileName:   buildFileName(request.FileName),
+		Uploaded:   time.Now().UTC().Format(buildTimeFormat),
+		UploadedBy: userID,
+	}
+	if build.Channel == "" {
+		build.Channel = structs.ChannelStable
+	}
+	if build.Version == "" || len(build.Version) > 64 || !slices.Contains(structs.Platforms, build.Platform) || !slices.Contains(structs.Channels, build.Channel) {
+		return nil, ErrInvalidBuild
+	}
+	builds, err := GetBuilds(gameID, buildDB)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range builds {
+		if other.Version == build.Version && other.Platform == build.Platform && other.Channel == build.Channel {
+			return nil, ErrBuildExists
+		}
+	}
+
+	build.Key = "builds/" + gameID + "/" + build.ID + "/" + build.FileName
+	hash := sha256.New()
+	counter := &countingWriter{}
+	err = store.Put(build.Key, io.TeeReader(io.LimitReader(file, MaxBuildSize+1), io.MultiWriter(hash, counter)))
+	if err != nil {
+		return nil, err
+	}
+	build.Size = counter.n
+	build.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	switch {
+	case build.Size == 0:
+		err = ErrInvalidBuild
+	case build.Size > MaxBuildSize:
+		err = ErrBuildTooLarge
+	case request.SHA256 != "" && !strings.EqualFold(strings.TrimSpace(request.SHA256), build.SHA256):
+		err = ErrChecksumMismatch
+	default:
+		err = buildDB.Put(build)
+	}
+	if err != nil {
+		store.Delete(build.Key)
+		return nil, err
+	}
+	return &build, nil
+}
+
+func DeleteBuild(gameID string, userID string, userRole string, buildID string, store blobstore.Store, buildDB database.Repository[structs.Build], orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	_, err := authorizedGame(gameID, userID, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return err
+	}
+	build, err := GetBuild(buildID, buildDB)
+	if err != nil || build.GameID != gameID {
+		return ErrBuildNotFound
+	}
+	err = buildDB.Delete(buildID)
+	if err != nil {
+		return err
+	}
+	store.Delete(build.Key)
+	return nil
+}
+
+// buildFileName keeps the last part of the uploaded file's name with only
+// characters that are safe in a key and a Content-Disposition header
+func buildFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+	safe = strings.TrimLeft(safe, ".")
+	if safe == "" || safe == "_" {
+		return "build"
+	}
+	return safe
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// ----------------- Downloads -----------------
+
+// CanDownload checks the user may download the game. The users Authorize
+// lets inspect it always can, owners once it is released, which pre-orders
+// wait for.
+func CanDownload(userID string, gameID string, orgDB database.Repository[structs.Organization], libraryDB database.Repository[structs.LibraryEntry], db database.Repository[structs.Game]) error {
+	game, err := GetGame(gameID, db)
+	if err != nil || game.ID != gameID {
+		return ErrGameNotFound
+	}
+	if Authorize(userID, "", ActionInspect, game, orgDB) == nil {
+		return nil
+	}
+	if !OwnsGame(userID, gameID, libraryDB) {
+		return ErrNotOwned
+	}
+	if !game.IsReleased() {
+		return ErrNotReleased
+	}
+	return nil
+}
+
+// CreateDownloadLink returns a signed link to the build that works for
+// DownloadLinkTTL, if the user may download it
+func CreateDownloadLink(buildID string, userID string, now time.Time, key []byte, buildDB database.Repository[structs.Build], orgDB database.Repository[structs.Organization], libraryDB database.Repository[structs.LibraryEntry], db database.Repository[structs.Game]) (string, error) {
+	build, err := GetBuild(buildID, buildDB)
+	if err != nil {
+		return "", err
+	}
+	err = CanDownload(userID, build.GameID, orgDB, libraryDB, db)
+	if err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(now.Add(DownloadLinkTTL).Unix(), 10)
+	query := url.Values{
+		"user":    {userID},
+		"expires": {expires},
+		"sig":     {signDownload(buildID, userID, expires, key)},
+	}
+	return "/games/downloads/" + buildID + "?" + query.Encode(), nil
+}
+
+// OpenDownload checks the signed link and that the user may still download
+// the build, and opens its file
+func OpenDownload(buildID string, query url.Values, now time.Time, key []byte, store blobstore.Store, buildDB database.Repository[structs.Build], orgDB database.Repository[structs.Organization], libraryDB database.Repository[structs.LibraryEntry], db database.Repository[structs.Game]) (*structs.Build, io.ReadCloser, error) {
+	userID, expires, sig := query.Get("user"), query.Get("expires"), query.Get("sig")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return nil, nil, ErrInvalidLink
+	}
+	if !hmac.Equal([]byte(sig), []byte(signDownload(buildID, userID, expires, key))) {
+		return nil, nil, ErrInvalidLink
+	}
+	build, err := GetBuild(buildID, buildDB)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a refund since the link was made takes the download away
+	err = CanDownload(userID, build.GameID, orgDB, libraryDB, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := store.Open(build.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return build, file, nil
+}
+
+func signDownload(buildID string, userID string, expires string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(buildID + "\n" + userID + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestUploadBuild(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	buildDB := database.Memory[structs.Build]{}
+	buildDB.Init("Builds", "ID")
+	store, _ := blobstore.NewLocalStore(t.TempDir(), "/games/media")
+	request := structs.BuildRequest{Version: "1.0.0", Platform: "Windows", FileName: "C:\\builds\\my game.zip"}
+
+	_, err := UploadBuild("Game1", "User2", "dev", request, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	simpleAssert(t, ErrNotGameAuthor, err)
+	_, err = UploadBuild("Game1", "User1", "dev", structs.BuildRequest{Version: "1.0.0", Platform: "amiga"}, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	simpleAssert(t, ErrInvalidBuild, err)
+	_, err = UploadBuild("Game1", "User1", "dev", request, strings.NewReader(""), store, &buildDB, &orgDB, &db)
+	simpleAssert(t, ErrInvalidBuild, err)
+	request.SHA256 = "0000"
+	_, err = UploadBuild("Game1", "User1", "dev", request, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	simpleAssert(t, ErrChecksumMismatch, err)
+
+	request.SHA256 = ""
+	build, err := UploadBuild("Game1", "User1", "dev", request, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	if err != nil {
+		t.Fatalf("Error uploading build: %v", err)
+	}
+	simpleAssert(t, "windows", build.Platform)
+	simpleAssert(t, structs.ChannelStable, build.Channel)
+	simpleAssert(t, "my_game.zip", build.FileName)
+	simpleAssert(t, int64(4), build.Size)
+	request.SHA256 = strings.ToUpper(build.SHA256)
+	_, err = UploadBuild("Game1", "User1", "dev", request, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	simpleAssert(t, ErrBuildExists, err)
+
+	request.Version = "1.1.0"
+	newer, err := UploadBuild("Game1", "User1", "dev", request, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	if err != nil {
+		t.Fatalf("Error uploading build: %v", err)
+	}
+	request.Version = "2.0.0-beta"
+	request.Channel = "beta"
+	request.SHA256 = ""
+	beta, _ := UploadBuild("Game1", "User1", "dev", request, strings.NewReader("beta"), store, &buildDB, &orgDB, &db)
+	builds, _ := GetBuilds("Game1", &buildDB)
+	simpleAssert(t, 3, len(builds))
+	latest := LatestBuilds(builds)
+	simpleAssert(t, 2, len(latest))
+	simpleAssert(t, newer.ID, latest[0].ID)
+	simpleAssert(t, beta.ID, latest[1].ID)
+
+	simpleAssert(t, nil, DeleteBuild("Game1", "User1", "dev", build.ID, store, &buildDB, &orgDB, &db))
+	simpleAssert(t, ErrBuildNotFound, DeleteBuild("Game1", "User1", "dev", build.ID, store, &buildDB, &orgDB, &db))
+	_, err = store.Open(build.Key)
+	simpleAssert(t, blobstore.ErrNotFound, err)
+}
+
+func TestDownloadLinks(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	buildDB := database.Memory[structs.Build]{}
+	buildDB.Init("Builds", "ID")
+	libraryDB := database.Memory[structs.LibraryEntry]{}
+	libraryDB.Init("Library", "ID")
+	store, _ := blobstore.NewLocalStore(t.TempDir(), "/games/media")
+	key := []byte("secret")
+	now := time.Now()
+	build, _ := UploadBuild("Game1", "User1", "dev", structs.BuildRequest{Version: "1.0.0", Platform: "linux", FileName: "game.tar.gz"}, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+
+	_, err := CreateDownloadLink(build.ID, "User2", now, key, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, ErrNotOwned, err)
+	ApplyLibraryEvent(events.TopicCheckout, []byte(`{"ID":"Order1","UserID":"User2","Games":[{"ID":"Game1"}]}`), &libraryDB, &db)
+	link, err := CreateDownloadLink(build.ID, "User2", now, key, &buildDB, &orgDB, &libraryDB, &db)
+	if err != nil {
+		t.Fatalf("Error creating link: %v", err)
+	}
+	linkURL, _ := url.Parse(link)
+	simpleAssert(t, "/games/downloads/"+build.ID, linkURL.Path)
+
+	_, file, err := OpenDownload(build.ID, linkURL.Query(), now, key, store, &buildDB, &orgDB, &libraryDB, &db)
+	if err != nil {
+		t.Fatalf("Error opening download: %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	simpleAssert(t, "game", string(data))
+
+	// expired, tampered with or for another build
+	_, _, err = OpenDownload(build.ID, linkURL.Query(), now.Add(DownloadLinkTTL+time.Minute), key, store, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, ErrInvalidLink, err)
+	query := linkURL.Query()
+	query.Set("user", "User3")
+	_, _, err = OpenDownload(build.ID, query, now, key, store, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, ErrInvalidLink, err)
+	_, _, err = OpenDownload("Other", linkURL.Query(), now, key, store, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, ErrInvalidLink, err)
+	_, _, err = OpenDownload(build.ID, linkURL.Query(), now, []byte("other"), store, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, ErrInvalidLink, err)
+
+	// a refund after the link was made stops the download
+	ApplyLibraryEvent(events.TopicRefundApproved, []byte(`{"OrderID":"Order1","UserID":"User2","GameID":"Game1"}`), &libraryDB, &db)
+	_, _, err = OpenDownload(build.ID, linkURL.Query(), now, key, store, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, ErrNotOwned, err)
+
+	// the developer can always download their builds
+	_, err = CreateDownloadLink(build.ID, "User1", now, key, &buildDB, &orgDB, &libraryDB, &db)
+	simpleAssert(t, nil, err)
+}
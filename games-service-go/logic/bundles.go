@@ -0,0 +1,128 @@
+package logic
+
+import (
+	"slices"
+
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrParentNotFound = invalid("the base game for this DLC doesn't exist")
+	ErrInvalidParent  = invalid("DLC can only belong to a plain game by the same developer")
+	ErrInvalidBundle  = invalid("a bundle needs at least two games that exist and aren't bundles")
+	ErrInvalidKind    = invalid("kind must be game, dlc or bundle")
+	ErrKindInUse      = conflict("a game with DLC or in a bundle can't become DLC or a bundle")
+)
+
+// ----------------- Bundles and DLC -----------------
+
+// validateRelations checks that a DLC points at a base game and that a bundle
+// is made of games that exist. Plain games can't carry either.
+func validateRelations(game *structs.Game, db database.Repository[structs.Game]) error {
+	switch game.Kind {
+	case "", structs.KindGame:
+		game.Kind = structs.KindGame
+		game.ParentID = ""
+		game.BundleItems = nil
+	case structs.KindDLC:
+		game.BundleItems = nil
+		if game.ParentID == "" {
+			return ErrParentNotFound
+		}
+		if game.ParentID == game.ID {
+			return ErrInvalidParent
+		}
+		parent, err := GetGame(game.ParentID, db)
+		if err != nil || parent.ID != game.ParentID || parent.IsDeleted() {
+			return ErrParentNotFound
+		}
+		if parent.IsDLC() || parent.IsBundle() || parent.AuthorID != game.AuthorID {
+			return ErrInvalidParent
+		}
+	case structs.KindBundle:
+		game.ParentID = ""
+		seen := map[string]bool{}
+		for _, id := range game.BundleItems {
+			if seen[id] || id == game.ID {
+				return ErrInvalidBundle
+			}
+			seen[id] = true
+			item, err := GetGame(id, db)
+			if err != nil || item.ID != id || item.IsBundle() || item.IsDeleted() {
+				return ErrInvalidBundle
+			}
+		}
+		if len(seen) < 2 {
+			return ErrInvalidBundle
+		}
+	default:
+		return ErrInvalidKind
+	}
+	return nil
+}
+
+// validateKindChange stops an update from leaving other games pointing at a
+// game that can't be their base game or bundle item anymore
+func validateKindChange(game structs.Game, ogGame structs.Game, db database.Repository[structs.Game]) error {
+	if game.Kind == ogGame.Kind || game.Kind == structs.KindGame {
+		return nil
+	}
+	all, err := db.Scan()
+	if err != nil {
+		return err
+	}
+	for _, other := range all {
+		if other.ID == game.ID || other.IsDeleted() {
+			continue
+		}
+		// its DLC would be left without a plain base game
+		if other.ParentID == game.ID {
+			return ErrKindInUse
+		}
+		// bundles can't hold bundles
+		if game.IsBundle() && other.IsBundle() && slices.Contains(other.BundleItems, game.ID) {
+			return ErrKindInUse
+		}
+	}
+	return nil
+}
+
+// AttachRelations gets the games ready for the listing pages. DLC is moved
+// under its base game when that game is in the list, and bundles get the games
+// they contain filled in.
+func AttachRelations(games []structs.Game, db database.Repository[structs.Game]) ([]structs.Game, error) {
+	all, err := db.Scan()
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]structs.Game{}
+	dlc := map[string][]structs.Game{}
+	for _, game := range all {
+		byID[game.ID] = game
+		if game.IsDLC() && !game.IsDeleted() {
+			dlc[game.ParentID] = append(dlc[game.ParentID], game)
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, game := range games {
+		listed[game.ID] = true
+	}
+
+	attached := []structs.Game{}
+	for _, game := range games {
+		if game.IsDLC() && listed[game.ParentID] {
+			continue
+		}
+		game.DLC = dlc[game.ID]
+		game.BundleGames = nil
+		for _, id := range game.BundleItems {
+			if item, ok := byID[id]; ok {
+				game.BundleGames = append(game.BundleGames, item)
+			}
+		}
+		attached = append(attached, game)
+	}
+	return attached, nil
+}
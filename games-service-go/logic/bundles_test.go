@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestCreateDLC(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Base", "User1"))
+
+	// It should need a base game by the same developer.
+	dlc := createTestDLC("DLC1", "Base", "User1")
+	dlc.ParentID = "Missing"
+	simpleAssert(t, ErrParentNotFound, CreateGame(dlc, &db))
+	simpleAssert(t, ErrInvalidParent, CreateGame(createTestDLC("DLC1", "Base", "User2"), &db))
+
+	err := CreateGame(createTestDLC("DLC1", "Base", "User1"), &db)
+	if err != nil {
+		t.Errorf("Error creating DLC: %v", err)
+	}
+	simpleAssert(t, 2, len(db.Items))
+
+	// DLC can't have DLC of its own.
+	simpleAssert(t, ErrInvalidParent, CreateGame(createTestDLC("DLC2", "DLC1", "User1"), &db))
+}
+
+func TestUpdateRelations(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Base", "User1"), createTestGame("Game2", "User1"))
+	CreateGame(createTestDLC("DLC1", "Base", "User1"), &db)
+	CreateGame(createTestBundle("Bundle1", "Base", "Game2"), &db)
+
+	// an update is checked like a new game
+	selfParent := createTestDLC("Game2", "Game2", "User1")
+	simpleAssert(t, ErrInvalidParent, UpdateGame("Game2", "User1", "dev", selfParent, &orgDB, &db))
+	orphan := createTestDLC("Game2", "Missing", "User1")
+	simpleAssert(t, ErrParentNotFound, UpdateGame("Game2", "User1", "dev", orphan, &orgDB, &db))
+	selfBundle := createTestBundle("Bundle1", "Bundle1", "Base")
+	simpleAssert(t, ErrInvalidBundle, UpdateGame("Bundle1", "User1", "dev", selfBundle, &orgDB, &db))
+
+	// and can't strand the games that point at it
+	baseAsDLC := createTestDLC("Base", "Game2", "User1")
+	simpleAssert(t, ErrKindInUse, UpdateGame("Base", "User1", "dev", baseAsDLC, &orgDB, &db))
+	itemAsBundle := createTestBundle("Game2", "Base", "DLC1")
+	simpleAssert(t, ErrKindInUse, UpdateGame("Game2", "User1", "dev", itemAsBundle, &orgDB, &db))
+
+	simpleAssert(t, nil, UpdateGame("Game2", "User1", "dev", createTestDLC("Game2", "Base", "User1"), &orgDB, &db))
+}
+
+func TestCreateBundle(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User2"))
+
+	simpleAssert(t, ErrInvalidBundle, CreateGame(createTestBundle("Bundle1", "Game1"), &db))
+	simpleAssert(t, ErrInvalidBundle, CreateGame(createTestBundle("Bundle1", "Game1", "Missing"), &db))
+	simpleAssert(t, ErrInvalidBundle, CreateGame(createTestBundle("Bundle1", "Game1", "Game1"), &db))
+
+	err := CreateGame(createTestBundle("Bundle1", "Game1", "Game2"), &db)
+	if err != nil {
+		t.Errorf("Error creating bundle: %v", err)
+	}
+	// Bundles can't contain other bundles.
+	simpleAssert(t, ErrInvalidBundle, CreateGame(createTestBundle("Bundle2", "Game1", "Bundle1"), &db))
+}
+
+func TestAttachRelations(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Base", "User1"))
+	db.Items = append(db.Items, createTestDLC("DLC1", "Base", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User2"))
+	db.Items = append(db.Items, createTestBundle("Bundle1", "Base", "Game2"))
+
+	games, _ := GetAllGames(&db)
+	attached, err := AttachRelations(games, &db)
+	if err != nil {
+		t.Errorf("Error attaching relations: %v", err)
+	}
+	// The DLC is shown under its base game instead of on its own.
+	simpleAssert(t, 3, len(attached))
+	simpleAssert(t, "Base", attached[0].ID)
+	simpleAssert(t, 1, len(attached[0].DLC))
+	simpleAssert(t, "DLC1", attached[0].DLC[0].ID)
+	simpleAssert(t, 2, len(attached[2].BundleGames))
+
+	// Without its base game in the list the DLC is listed by itself.
+	attached, _ = AttachRelations([]structs.Game{createTestDLC("DLC1", "Base", "User1")}, &db)
+	simpleAssert(t, 1, len(attached))
+}
@@ -0,0 +1,174 @@
+package logic
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrInvalidReleaseDate = invalid("the release date must look like 2024-01-31")
+	ErrInvalidAgeRating   = invalid("the age rating must be one of 3+, 7+, 12+, 16+ or 18+")
+)
+
+// reservedSlugs are the paths under /games that aren't games, so no game can
+// take them as its slug
+var reservedSlugs = []string{"admin", "api", "author", "browse", "builds", "dev", "downloads", "getform", "invites", "library", "media", "orgs", "recommended", "search", "similar", "upcoming", "wishlist"}
+
+// ----------------- Details -----------------
+
+// ResolveGame finds the game by its ID or slug. A slug the game had before a
+// rename still finds it, with moved set so the caller can send the client to
+// the current one.
+func ResolveGame(idOrSlug string, db database.Repository[structs.Game]) (*structs.Game, bool, error) {
+	game, err := GetGame(idOrSlug, db)
+	if err == nil && game.ID == idOrSlug {
+		if game.IsDeleted() {
+			return nil, false, ErrGameNotFound
+		}
+		return game, false, nil
+	}
+	games, err := GetAllGames(db)
+	if err != nil {
+		return nil, false, ErrGameNotFound
+	}
+	for _, game := range games {
+		if game.Slug == idOrSlug {
+			return &game, false, nil
+		}
+	}
+	for _, game := range games {
+		if slices.Contains(game.OldSlugs, idOrSlug) {
+			return &game, true, nil
+		}
+	}
+	return nil, false, ErrGameNotFound
+}
+
+// GetGameDetail is the game with its DLC, base game and bundle contents and
+// the links the detail page and API show
+func GetGameDetail(idOrSlug string, db database.Repository[structs.Game]) (*structs.GameDetail, bool, error) {
+	game, moved, err := ResolveGame(idOrSlug, db)
+	if err != nil {
+		return nil, false, err
+	}
+	games, err := AttachRelations([]structs.Game{*game}, db)
+	if err != nil {
+		return nil, false, err
+	}
+	detail := &structs.GameDetail{
+		Game: games[0],
+		Links: structs.DetailLinks{
+			Self:    game.Path(),
+			Updates: game.Path() + "#updates",
+			Similar: "/games/similar/" + game.ID,
+			DLC:     []string{},
+		},
+	}
+	for _, dlc := range detail.DLC {
+		detail.Links.DLC = append(detail.Links.DLC, dlc.Path())
+	}
+	if game.IsDLC() {
+		parent, err := GetGame(game.ParentID, db)
+		if err == nil && parent.ID == game.ParentID {
+			detail.Parent = parent
+			detail.Links.Parent = parent.Path()
+		}
+	}
+	return detail, moved, nil
+}
+
+// assignSlug gives the game a slug made from its title that no other game
+// has now or had before. A game keeps its slug while the title gives the same
+// one, otherwise the old slug is kept so links to it still work.
+func assignSlug(game *structs.Game, previous *structs.Game, db database.Repository[structs.Game]) error {
+	base := structs.Slugify(game.Title)
+	if base == "" {
+		base = "game"
+	}
+	if previous != nil {
+		game.OldSlugs = previous.OldSlugs
+		if previous.Slug != "" && structs.Slugify(previous.Title) == base {
+			game.Slug = previous.Slug
+			return nil
+		}
+	}
+	// games in the trash keep their slugs for when they are restored
+	games, err := getGamesWithTrash(db)
+	if err != nil {
+		return err
+	}
+	taken := map[string]bool{}
+	for _, slug := range reservedSlugs {
+		taken[slug] = true
+	}
+	for _, other := range games {
+		if other.ID == game.ID {
+			continue
+		}
+		taken[other.ID] = true
+		taken[other.Slug] = true
+		for _, slug := range other.OldSlugs {
+			taken[slug] = true
+		}
+	}
+	slug := base
+	for n := 2; taken[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	game.Slug = slug
+	// renaming back to an old title takes its slug back
+	game.OldSlugs = slices.DeleteFunc(slices.Clone(game.OldSlugs), func(s string) bool { return s == slug })
+	if previous != nil && previous.Slug != "" && previous.Slug != slug {
+		game.OldSlugs = append(game.OldSlugs, previous.Slug)
+	}
+	return nil
+}
+
+// MigrateSlugs gives the games listed before slugs existed one
+func MigrateSlugs(db database.Repository[structs.Game]) (int, error) {
+	games, err := getGamesWithTrash(db)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, game := range games {
+		if game.Slug != "" {
+			continue
+		}
+		err = assignSlug(&game, nil, db)
+		if err != nil {
+			return 0, err
+		}
+		err = db.Put(game)
+		if err != nil {
+			return 0, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// validateDetails checks the release date and age rating and tidies up the
+// languages
+func validateDetails(game *structs.Game) error {
+	if game.ReleaseDate != "" {
+		if _, err := time.Parse(time.DateOnly, game.ReleaseDate); err != nil {
+			return ErrInvalidReleaseDate
+		}
+	}
+	if game.AgeRating != "" && !slices.Contains(structs.AgeRatings, game.AgeRating) {
+		return ErrInvalidAgeRating
+	}
+	languages := []string{}
+	for _, language := range game.Languages {
+		if language != "" && !containsFold(languages, language) {
+			languages = append(languages, language)
+		}
+	}
+	game.Languages = languages
+	return nil
+}
@@ -0,0 +1,119 @@
+package logic
+
+import (
+	"testing"
+)
+
+func TestSlugs(t *testing.T) {
+	db.Init("Test", "ID")
+	first := createTestGame("Game1", "User1")
+	first.Title = "Space Game"
+	err := CreateGame(first, &db)
+	if err != nil {
+		t.Fatalf("Error creating game: %v", err)
+	}
+	// Another developer's game with the same name gets its own slug.
+	second := createTestGame("Game2", "User2")
+	second.Title = "Space Game!"
+	err = CreateGame(second, &db)
+	if err != nil {
+		t.Fatalf("Error creating game: %v", err)
+	}
+	reserved := createTestGame("Game3", "User1")
+	reserved.Title = "Browse"
+	CreateGame(reserved, &db)
+
+	game, _, _ := ResolveGame("space-game", &db)
+	simpleAssert(t, "Game1", game.ID)
+	game, _, _ = ResolveGame("space-game-2", &db)
+	simpleAssert(t, "Game2", game.ID)
+	game, _, _ = ResolveGame("Game3", &db)
+	simpleAssert(t, "browse-2", game.Slug)
+
+	// Renaming moves the slug and the old one redirects.
+	renamed := createTestGame("", "User1")
+	renamed.Title = "Space Game Deluxe"
+	err = UpdateGame("Game1", "User1", "dev", renamed, &orgDB, &db)
+	if err != nil {
+		t.Fatalf("Error updating game: %v", err)
+	}
+	game, moved, err := ResolveGame("space-game", &db)
+	if err != nil {
+		t.Fatalf("Error resolving old slug: %v", err)
+	}
+	simpleAssert(t, true, moved)
+	simpleAssert(t, "space-game-deluxe", game.Slug)
+	simpleAssert(t, "/games/space-game-deluxe", game.Path())
+
+	// The old slug stays taken, and changing only the case keeps the slug.
+	third := createTestGame("Game4", "User3")
+	third.Title = "Space: Game"
+	CreateGame(third, &db)
+	game, _, _ = ResolveGame("Game4", &db)
+	simpleAssert(t, "space-game-3", game.Slug)
+	renamed.Title = "SPACE GAME DELUXE"
+	UpdateGame("Game1", "User1", "dev", renamed, &orgDB, &db)
+	game, _, _ = ResolveGame("Game1", &db)
+	simpleAssert(t, "space-game-deluxe", game.Slug)
+	simpleAssert(t, 1, len(game.OldSlugs))
+
+	_, _, err = ResolveGame("no-such-game", &db)
+	simpleAssert(t, ErrGameNotFound, err)
+}
+
+func TestGameDetails(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.ReleaseDate = "2024-13-01"
+	simpleAssert(t, ErrInvalidReleaseDate, CreateGame(game, &db))
+	game.ReleaseDate = "2024-02-01"
+	game.AgeRating = "21+"
+	simpleAssert(t, ErrInvalidAgeRating, CreateGame(game, &db))
+	game.AgeRating = "16+"
+	game.Languages = []string{"English", "english", "German"}
+	err := CreateGame(game, &db)
+	if err != nil {
+		t.Fatalf("Error creating game: %v", err)
+	}
+	dlc := createTestDLC("DLC1", "Game1", "User1")
+	err = CreateGame(dlc, &db)
+	if err != nil {
+		t.Fatalf("Error creating DLC: %v", err)
+	}
+
+	detail, moved, err := GetGameDetail("testtitle", &db)
+	if err != nil {
+		t.Fatalf("Error getting game detail: %v", err)
+	}
+	simpleAssert(t, false, moved)
+	simpleAssert(t, 2, len(detail.Languages))
+	simpleAssert(t, "/games/testtitle#updates", detail.Links.Updates)
+	simpleAssert(t, 1, len(detail.Links.DLC))
+	simpleAssert(t, "/games/testdlc-dlc1", detail.Links.DLC[0])
+
+	detail, _, err = GetGameDetail("testdlc-dlc1", &db)
+	if err != nil {
+		t.Fatalf("Error getting DLC detail: %v", err)
+	}
+	simpleAssert(t, "Game1", detail.Parent.ID)
+	simpleAssert(t, "/games/testtitle", detail.Links.Parent)
+}
+
+func TestMigrateSlugs(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User2"))
+
+	migrated, err := MigrateSlugs(&db)
+	if err != nil {
+		t.Fatalf("Error migrating slugs: %v", err)
+	}
+	simpleAssert(t, 2, migrated)
+	first, _, _ := ResolveGame("Game1", &db)
+	second, _, _ := ResolveGame("Game2", &db)
+	simpleAssert(t, "testtitle", first.Slug)
+	simpleAssert(t, "testtitle-2", second.Slug)
+
+	migrated, _ = MigrateSlugs(&db)
+	simpleAssert(t, 0, migrated)
+}
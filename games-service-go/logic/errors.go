@@ -0,0 +1,60 @@
+package logic
+
+import (
+	"errors"
+
+	database "github.com/Draupniyr/games-service/database"
+)
+
+// Kinds of error the logic returns. The handlers turn them into 400, 403,
+// 404, 409 and 413, the errors wrap one of these, check with errors.Is.
+var (
+	ErrInvalid   = errors.New("invalid")
+	ErrForbidden = errors.New("forbidden")
+	ErrNotFound  = errors.New("not found")
+	ErrConflict  = errors.New("conflict")
+	ErrTooLarge  = errors.New("too large")
+)
+
+// kindError is an error of one of the kinds above with its own message
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+func invalid(message string) error {
+	return &kindError{kind: ErrInvalid, message: message}
+}
+
+func forbidden(message string) error {
+	return &kindError{kind: ErrForbidden, message: message}
+}
+
+func notFound(message string) error {
+	return &kindError{kind: ErrNotFound, message: message}
+}
+
+func conflict(message string) error {
+	return &kindError{kind: ErrConflict, message: message}
+}
+
+func tooLarge(message string) error {
+	return &kindError{kind: ErrTooLarge, message: message}
+}
+
+// missing turns the database not finding anything into the not found error
+// of the logic, other errors are left as they are
+func missing(err error, notFound error) error {
+	if errors.Is(err, database.ErrNotFound) {
+		return notFound
+	}
+	return err
+}
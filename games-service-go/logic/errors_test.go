@@ -0,0 +1,70 @@
+package logic
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{ErrGameNotFound, ErrNotFound},
+		{ErrOrgNotFound, ErrNotFound},
+		{ErrMediaNotFound, ErrNotFound},
+		{ErrBuildNotFound, ErrNotFound},
+		{ErrTagNotFound, ErrNotFound},
+		{ErrInviteNotFound, ErrNotFound},
+		{ErrNotGameAuthor, ErrForbidden},
+		{ErrNotMember, ErrForbidden},
+		{ErrRoleNotAllowed, ErrForbidden},
+		{ErrNotOwned, ErrForbidden},
+		{ErrTitleTaken, ErrConflict},
+		{ErrTagExists, ErrConflict},
+		{ErrBuildExists, ErrConflict},
+		{ErrNotInTrash, ErrConflict},
+		{ErrLastOwner, ErrConflict},
+		{ErrAlreadyReleased, ErrConflict},
+		{ErrUpdateNotFound, ErrNotFound},
+		{ErrDiscountNotFound, ErrNotFound},
+		{ErrInvalidTag, ErrInvalid},
+		{ErrInvalidRange, ErrInvalid},
+		{ErrInvalidKind, ErrInvalid},
+		{ErrKindInUse, ErrConflict},
+		{ErrChecksumMismatch, ErrInvalid},
+		{ErrMediaTooLarge, ErrTooLarge},
+		{ErrBuildTooLarge, ErrTooLarge},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.kind) {
+			t.Errorf("Expected %q to be %v", test.err, test.kind)
+		}
+	}
+	simpleAssert(t, "game not found", ErrGameNotFound.Error())
+	simpleAssert(t, false, errors.Is(ErrInvalidTag, ErrConflict))
+}
+
+func TestNotFoundErrors(t *testing.T) {
+	db.Init("Test", "ID")
+	_, err := GetGame("Missing", &db)
+	simpleAssert(t, ErrGameNotFound, err)
+	games, err := GetGamesByAuthor("Nobody", &db)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, 0, len(games))
+
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	_, err = GetUpdate("Game1", "Missing", &db)
+	simpleAssert(t, ErrUpdateNotFound, err)
+
+	// other database errors aren't mistaken for a missing game
+	broken := &brokenDB[structs.Game]{}
+	_, err = GetGame("Game1", broken)
+	simpleAssert(t, false, errors.Is(err, ErrNotFound))
+	_, err = GetBuilds("Game1", &brokenDB[structs.Build]{})
+	simpleAssert(t, "connection refused", err.Error())
+	err = CreateGame(createTestGame("Game2", "User1"), broken)
+	simpleAssert(t, false, err == nil)
+}
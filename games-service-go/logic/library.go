@@ -0,0 +1,142 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+// ----------------- Library -----------------
+
+// ApplyLibraryEvent adds the games of a checkout or accepted gift to the
+// user's library and takes refunded ones out. Bundles count as the games in
+// them. Events can be applied again or out of order without changing the
+// outcome, so the topics can always be read from the start.
+func ApplyLibraryEvent(topic string, value []byte, libraryDB database.Repository[structs.LibraryEntry], db database.Repository[structs.Game]) error {
+	switch topic {
+	case events.TopicCheckout:
+		order := events.Checkout{}
+		err := json.Unmarshal(value, &order)
+		if err != nil {
+			return err
+		}
+		for _, game := range order.Games {
+			for _, id := range game.GameIDs() {
+				err = grantGame(order.UserID, id, "order:"+order.ID, libraryDB)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case events.TopicGiftAccepted:
+		gift := events.Gift{}
+		err := json.Unmarshal(value, &gift)
+		if err != nil {
+			return err
+		}
+		for _, id := range gift.Game.GameIDs() {
+			err = grantGame(gift.RecipientID, id, "gift:"+gift.ID, libraryDB)
+			if err != nil {
+				return err
+			}
+		}
+	case events.TopicRefundApproved:
+		refund := events.Refund{}
+		err := json.Unmarshal(value, &refund)
+		if err != nil {
+			return err
+		}
+		ids := []string{refund.GameID}
+		if game, err := GetGame(refund.GameID, db); err == nil && game.IsBundle() {
+			ids = game.BundleItems
+		}
+		for _, id := range ids {
+			err = revokeGame(refund.UserID, id, "order:"+refund.OrderID, libraryDB)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OwnsGame reports if the game is in the user's library
+func OwnsGame(userID string, gameID string, libraryDB database.Repository[structs.LibraryEntry]) bool {
+	entry, err := getLibraryEntry(userID, gameID, libraryDB)
+	return err == nil && entry.Owned()
+}
+
+// GetLibrary returns the games the user owns, by title
+func GetLibrary(userID string, libraryDB database.Repository[structs.LibraryEntry], db database.Repository[structs.Game]) ([]structs.Game, error) {
+	entries, err := libraryDB.Query("UserID", userID)
+	if err != nil {
+		return nil, err
+	}
+	gameIDs := []string{}
+	for _, entry := range entries {
+		if entry.Owned() {
+			gameIDs = append(gameIDs, entry.GameID)
+		}
+	}
+	games, err := db.BatchGet(gameIDs)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].Title < games[j].Title
+	})
+	return games, nil
+}
+
+func getLibraryEntry(userID string, gameID string, libraryDB database.Repository[structs.LibraryEntry]) (*structs.LibraryEntry, error) {
+	ID := structs.LibraryEntryID(userID, gameID)
+	entry, err := libraryDB.Get(ID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		now := time.Now().Format(time.RFC3339)
+		return &structs.LibraryEntry{ID: ID, UserID: userID, GameID: gameID, Grants: []string{}, Revoked: []string{}, Added: now}, nil
+	}
+	return &entry, nil
+}
+
+func grantGame(userID string, gameID string, grant string, libraryDB database.Repository[structs.LibraryEntry]) error {
+	if userID == "" || gameID == "" {
+		return nil
+	}
+	entry, err := getLibraryEntry(userID, gameID, libraryDB)
+	if err != nil {
+		return err
+	}
+	// seen before, or refunded before the purchase came in
+	if slices.Contains(entry.Grants, grant) || slices.Contains(entry.Revoked, grant) {
+		return nil
+	}
+	entry.Grants = append(entry.Grants, grant)
+	entry.Updated = time.Now().Format(time.RFC3339)
+	return libraryDB.Put(*entry)
+}
+
+func revokeGame(userID string, gameID string, grant string, libraryDB database.Repository[structs.LibraryEntry]) error {
+	if userID == "" || gameID == "" {
+		return nil
+	}
+	entry, err := getLibraryEntry(userID, gameID, libraryDB)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(entry.Revoked, grant) {
+		return nil
+	}
+	entry.Grants = slices.DeleteFunc(entry.Grants, func(g string) bool { return g == grant })
+	entry.Revoked = append(entry.Revoked, grant)
+	entry.Updated = time.Now().Format(time.RFC3339)
+	return libraryDB.Put(*entry)
+}
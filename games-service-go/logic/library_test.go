@@ -0,0 +1,47 @@
+package logic
+
+import (
+	"testing"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestLibraryEvents(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User1"))
+	db.Items = append(db.Items, createTestBundle("Bundle1", "Game1", "Game2"))
+	libraryDB := database.Memory[structs.LibraryEntry]{}
+	libraryDB.Init("Library", "ID")
+
+	checkout := []byte(`{"ID":"Order1","UserID":"User2","Games":[{"ID":"Bundle1","BundleItems":[{"ID":"Game1"},{"ID":"Game2"}]}]}`)
+	gift := []byte(`{"ID":"Gift1","RecipientID":"User2","Game":{"ID":"Game1"}}`)
+	refund := []byte(`{"ID":"Refund1","OrderID":"Order1","UserID":"User2","GameID":"Bundle1"}`)
+
+	// replaying the stream twice gives the same library
+	for i := 0; i < 2; i++ {
+		simpleAssert(t, nil, ApplyLibraryEvent(events.TopicCheckout, checkout, &libraryDB, &db))
+		simpleAssert(t, nil, ApplyLibraryEvent(events.TopicGiftAccepted, gift, &libraryDB, &db))
+	}
+	library, _ := GetLibrary("User2", &libraryDB, &db)
+	simpleAssert(t, 2, len(library))
+
+	// refunding the bundle keeps the gifted copy of Game1
+	simpleAssert(t, nil, ApplyLibraryEvent(events.TopicRefundApproved, refund, &libraryDB, &db))
+	simpleAssert(t, true, OwnsGame("User2", "Game1", &libraryDB))
+	simpleAssert(t, false, OwnsGame("User2", "Game2", &libraryDB))
+
+	// the checkout read again after its refund doesn't bring the game back
+	ApplyLibraryEvent(events.TopicCheckout, checkout, &libraryDB, &db)
+	simpleAssert(t, false, OwnsGame("User2", "Game2", &libraryDB))
+
+	// a refund read before its checkout still wins
+	ApplyLibraryEvent(events.TopicRefundApproved, []byte(`{"OrderID":"Order2","UserID":"User3","GameID":"Game2"}`), &libraryDB, &db)
+	ApplyLibraryEvent(events.TopicCheckout, []byte(`{"ID":"Order2","UserID":"User3","Games":[{"ID":"Game2"}]}`), &libraryDB, &db)
+	simpleAssert(t, false, OwnsGame("User3", "Game2", &libraryDB))
+
+	library, _ = GetLibrary("Nobody", &libraryDB, &db)
+	simpleAssert(t, 0, len(library))
+}
@@ -1,220 +1,322 @@
 package logic
 
 import (
-	"errors"
-
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"slices"
+	"time"
 
 	database "github.com/Draupniyr/games-service/database"
+	search "github.com/Draupniyr/games-service/search"
 	structs "github.com/Draupniyr/games-service/structs"
 )
 
 // ----------------- Games -----------------
-func GetGame(ID string, db database.DatabaseFunctionality) (*structs.Game, error) {
-	game := structs.Game{}
-	err := db.GetFilter(ID, "ID", &game)
+func GetGame(ID string, db database.Repository[structs.Game]) (*structs.Game, error) {
+	game, err := db.Get(ID)
 	if err != nil {
-		return nil, err
+		return nil, missing(err, ErrGameNotFound)
 	}
 	return &game, nil
 }
 
-func SearchGames(search string, db database.DatabaseFunctionality) ([]structs.Game, error) {
-	AllFoundGames := []structs.Game{}
-
-	games := []structs.Game{}
-	db.GetFilter(search, "Title", &games)
-	AllFoundGames = append(AllFoundGames, games...)
-
-	games = []structs.Game{}
-	db.GetFilter(search, "Description", &games)
-	AllFoundGames = append(AllFoundGames, games...)
-
-	games = []structs.Game{}
-	db.GetFilter(search, "Tags", &games)
-	AllFoundGames = append(AllFoundGames, games...)
-
-	if len(AllFoundGames) == 0 {
-		return nil, errors.New("no games found")
-	}
-
-	// Remove duplicates
-	encountered := map[string]bool{}
-	uniqueGames := []structs.Game{}
+// SearchGames looks the query up in the search index, best matches first
+func SearchGames(query string, options search.Options, index *search.Index) []search.Result {
+	return index.Search(query, options)
+}
 
-	for _, game := range AllFoundGames {
-		if !encountered[game.ID] {
-			encountered[game.ID] = true
-			uniqueGames = append(uniqueGames, game)
-		}
+// IndexGames fills the search index with every game in the database
+func IndexGames(index *search.Index, db database.Repository[structs.Game]) error {
+	games, err := GetAllGames(db)
+	if err != nil {
+		return err
 	}
-
-	return uniqueGames, nil
+	index.Rebuild(games)
+	return nil
 }
 
-func GetGamesByAuthor(authorID string, db database.DatabaseFunctionality) ([]structs.Game, error) {
-	games := []structs.Game{}
-	err := db.GetFilter(authorID, "AuthorID", &games)
+func GetGamesByAuthor(authorID string, db database.Repository[structs.Game]) ([]structs.Game, error) {
+	games, err := db.Query("AuthorID", authorID)
 	if err != nil {
 		return nil, err
 	}
 
-	return games, nil
+	return withoutDeleted(games), nil
 }
 
-func GetAllGames(db database.DatabaseFunctionality) ([]structs.Game, error) {
-	games := []structs.Game{}
-	err := db.GetAll(&games)
+// GetAllGames returns the games that aren't in the trash
+func GetAllGames(db database.Repository[structs.Game]) ([]structs.Game, error) {
+	games, err := getGamesWithTrash(db)
 	if err != nil {
 		return nil, err
 	}
 
-	return games, nil
+	return withoutDeleted(games), nil
 }
 
-func CreateGame(game structs.Game, db database.DatabaseFunctionality) error {
-	games := []structs.Game{}
-	err := db.GetFilter(game.Title, "Title", &games)
-	if err != nil {
-		err := db.CreateOrUpdate(game)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// getGamesWithTrash returns every stored game, deleted ones included
+func getGamesWithTrash(db database.Repository[structs.Game]) ([]structs.Game, error) {
+	return db.Scan()
+}
 
+func withoutDeleted(games []structs.Game) []structs.Game {
+	return slices.DeleteFunc(games, func(g structs.Game) bool { return g.IsDeleted() })
 }
 
-func UpdateGame(ID string, userid string, game structs.Game, db database.DatabaseFunctionality) error {
-	ogGame := structs.Game{}
-	err := db.GetFilter(ID, "ID", &ogGame)
+func CreateGame(game structs.Game, db database.Repository[structs.Game]) error {
+	err := validateRelations(&game, db)
 	if err != nil {
 		return err
 	}
-	if ogGame.AuthorID == userid {
-		game.ID = ID
-		err := db.CreateOrUpdate(game)
-		if err != nil {
-			return err
+	err = validateDetails(&game)
+	if err != nil {
+		return err
+	}
+	err = scheduleRelease(&game, nil, time.Now())
+	if err != nil {
+		return err
+	}
+	err = assignSlug(&game, nil, db)
+	if err != nil {
+		return err
+	}
+	games, err := db.Query("Title", game.Title)
+	if err != nil {
+		return err
+	}
+	for _, other := range games {
+		if other.ID != game.ID && !other.IsDeleted() {
+			return ErrTitleTaken
 		}
 	}
-	return nil
+	return db.Put(game)
 }
 
-func UpdateGameField(ID string, field string, value string, db database.Database) error {
-	_, err := db.DynamodbClient.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName: aws.String("Games"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"ID": {
-				S: aws.String(ID),
-			},
-		},
-		UpdateExpression: aws.String("set " + field + " = :v"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":v": {
-				S: aws.String(value),
-			},
-		},
-	})
+// UpdateGame saves the changes to the game, for the users Authorize lets
+// update it
+func UpdateGame(ID string, userid string, userRole string, game structs.Game, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	ogGame, err := authorizedGame(ID, userid, userRole, ActionUpdate, orgDB, db)
 	if err != nil {
 		return err
 	}
+	game.ID = ID
+	// the author and organization only change through a transfer
+	game.AuthorID = ogGame.AuthorID
+	game.OrganizationID = ogGame.OrganizationID
+	// media is changed through its own endpoints
+	game.Media = ogGame.Media
+	game.DeletedAt = ogGame.DeletedAt
+	game.DeletedBy = ogGame.DeletedBy
+	game.PurgedAt = ogGame.PurgedAt
+	// as are the updates and discounts, and when it was listed never changes
+	game.Published = ogGame.Published
+	game.Updates = ogGame.Updates
+	game.Discounts = ogGame.Discounts
+	err = validateRelations(&game, db)
+	if err != nil {
+		return err
+	}
+	err = validateKindChange(game, *ogGame, db)
+	if err != nil {
+		return err
+	}
+	err = validateDetails(&game)
+	if err != nil {
+		return err
+	}
+	err = scheduleRelease(&game, ogGame, time.Now())
+	if err != nil {
+		return err
+	}
+	err = assignSlug(&game, ogGame, db)
+	if err != nil {
+		return err
+	}
+	return db.Put(game)
+}
 
-	return nil
+// UpdateGameField sets one attribute of the game and leaves the others
+func UpdateGameField(ID string, field string, value string, db database.Repository[structs.Game]) error {
+	err := db.Update(ID, map[string]interface{}{field: value}, nil)
+	return missing(err, ErrGameNotFound)
 }
 
-func DeleteGame(ID string, userId string, db database.DatabaseFunctionality) error {
-	game := structs.Game{}
-	err := db.GetFilter(ID, "ID", &game)
+// DeleteGame moves the game to the trash, for the users Authorize lets
+// delete it
+func DeleteGame(ID string, userId string, userRole string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	game, err := authorizedGame(ID, userId, userRole, ActionDelete, orgDB, db)
 	if err != nil {
 		return err
 	}
-	if game.AuthorID != userId {
-		err := db.Delete(ID)
-		if err != nil {
-			return err
-		}
+	return trashGame(*game, userId, time.Now(), db)
+}
+
+// DeleteGameByID moves the game to the trash for an admin
+func DeleteGameByID(ID string, adminID string, db database.Repository[structs.Game]) error {
+	game, err := GetGame(ID, db)
+	if err != nil || game.ID != ID || game.IsDeleted() {
+		return ErrGameNotFound
 	}
-	return nil
+	return trashGame(*game, adminID, time.Now(), db)
 }
 
-func DeleteGameByID(ID string, db database.DatabaseFunctionality) error {
-	err := db.Delete(ID)
+// DeleteAll moves every game to the trash. Returns how many were moved.
+func DeleteAll(adminID string, db database.Repository[structs.Game]) (int, error) {
+	games, err := GetAllGames(db)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
-}
-func DeleteAll(db database.DatabaseFunctionality) error {
-	db.DeleteAll()
-	return nil
+	now := time.Now()
+	for _, game := range games {
+		err = trashGame(game, adminID, now, db)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(games), nil
 }
 
 // ----------------- Updates -----------------
-func CreateUpdate(ID string, userId string, update structs.Update, db database.DatabaseFunctionality) error {
-	currentGame := structs.Game{}
-	err := db.GetFilter(ID, "ID", &currentGame)
+func CreateUpdate(ID string, userId string, userRole string, update structs.Update, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	currentGame, err := authorizedGame(ID, userId, userRole, ActionUpdate, orgDB, db)
 	if err != nil {
 		return err
 	}
-	if currentGame.AuthorID != userId {
-		return nil
-	}
 	currentGame.Updates = append(currentGame.Updates, update)
-	db.CreateOrUpdate(currentGame)
-	return nil
+	return db.Put(*currentGame)
 }
 
-func DeleteUpdate(ID string, userId string, updateID string, db database.DatabaseFunctionality) error {
-	currentGame := structs.Game{}
-	err := db.GetFilter(ID, "ID", &currentGame)
+func DeleteUpdate(ID string, userId string, userRole string, updateID string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	currentGame, err := authorizedGame(ID, userId, userRole, ActionUpdate, orgDB, db)
 	if err != nil {
 		return err
 	}
-	if currentGame.AuthorID != userId {
-		return nil
-	}
 	for i, update := range currentGame.Updates {
 		if update.ID == updateID {
 			currentGame.Updates = append(currentGame.Updates[:i], currentGame.Updates[i+1:]...)
-			break
+			return db.Put(*currentGame)
 		}
 	}
-	db.CreateOrUpdate(currentGame)
-	return nil
+	return ErrUpdateNotFound
 }
 
-func GetUpdate(ID string, updateID string, db database.DatabaseFunctionality) (*structs.Update, error) {
-	currentGame := structs.Game{}
-	err := db.GetFilter(ID, "ID", &currentGame)
+func GetUpdate(ID string, updateID string, db database.Repository[structs.Game]) (*structs.Update, error) {
+	currentGame, err := db.Get(ID)
 	if err != nil {
-		return nil, err
+		return nil, missing(err, ErrGameNotFound)
 	}
 	for _, update := range currentGame.Updates {
 		if update.ID == updateID {
 			return &update, nil
 		}
 	}
-	return nil, nil
+	return nil, ErrUpdateNotFound
 }
 
-func UpdateUpdate(ID string, userId string, updateID string, update structs.UpdatePostObject, db database.DatabaseFunctionality) error {
-	currentGame := structs.Game{}
-	err := db.GetFilter(ID, "ID", &currentGame)
+func UpdateUpdate(ID string, userId string, userRole string, updateID string, update structs.UpdatePostObject, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	currentGame, err := authorizedGame(ID, userId, userRole, ActionUpdate, orgDB, db)
 	if err != nil {
 		return err
 	}
-	if currentGame.AuthorID != userId {
-		return nil
-	}
 	for i, ogupdate := range currentGame.Updates {
 		if ogupdate.ID == updateID {
 			currentGame.Updates[i].Title = update.Title
 			currentGame.Updates[i].Content = update.Content
-			db.CreateOrUpdate(currentGame)
-			return nil
+			return db.Put(*currentGame)
+		}
+	}
+	return ErrUpdateNotFound
+}
+
+// ----------------- Discounts -----------------
+func CreateDiscount(ID string, userId string, userRole string, discount structs.Discount, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	currentGame, err := authorizedGame(ID, userId, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return err
+	}
+	err = validateDiscount(discount, currentGame.Price)
+	if err != nil {
+		return err
+	}
+	currentGame.Discounts = append(currentGame.Discounts, discount)
+	return db.Put(*currentGame)
+}
+
+func DeleteDiscount(ID string, userId string, userRole string, discountID string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	currentGame, err := authorizedGame(ID, userId, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return err
+	}
+	for i, discount := range currentGame.Discounts {
+		if discount.ID == discountID {
+			currentGame.Discounts = append(currentGame.Discounts[:i], currentGame.Discounts[i+1:]...)
+			return db.Put(*currentGame)
+		}
+	}
+	return ErrDiscountNotFound
+}
+
+func validateDiscount(discount structs.Discount, price structs.Money) error {
+	switch discount.Type {
+	case structs.DiscountPercent:
+		if discount.Value <= 0 || discount.Value > 100 {
+			return invalid("percent discount must be between 0 and 100")
 		}
+	case structs.DiscountFixed:
+		if discount.Amount.Currency != price.Currency {
+			return invalid("fixed discount must be in the game's base currency")
+		}
+		if discount.Amount.Amount <= 0 || discount.Amount.Amount > price.Amount {
+			return invalid("fixed discount must be more than 0 and at most the price")
+		}
+	default:
+		return invalid("discount type must be percent or fixed")
+	}
+
+	var start, end time.Time
+	var err error
+	if discount.Start != "" {
+		start, err = time.Parse(time.RFC3339, discount.Start)
+		if err != nil {
+			return invalid("discount start must be an RFC3339 date")
+		}
+	}
+	if discount.End != "" {
+		end, err = time.Parse(time.RFC3339, discount.End)
+		if err != nil {
+			return invalid("discount end must be an RFC3339 date")
+		}
+	}
+	if discount.Start != "" && discount.End != "" && !end.After(start) {
+		return invalid("discount must end after it starts")
 	}
 	return nil
 }
+
+// ----------------- Migrations -----------------
+
+// MigratePrices rewrites every game so float prices stored before Money
+// existed are saved as minor units. Reading already converts them, so this
+// only has to put them back. Fixed discounts that kept their amount in Value
+// are moved to Amount. Returns how many games were written.
+func MigratePrices(db database.Repository[structs.Game]) (int, error) {
+	games, err := db.Scan()
+	if err != nil {
+		return 0, err
+	}
+	for i := range games {
+		game := &games[i]
+		if game.Price.Currency == "" {
+			game.Price.Currency = structs.DefaultCurrency
+		}
+		for i, discount := range game.Discounts {
+			if discount.Type == structs.DiscountFixed && discount.Amount.IsZero() && discount.Value > 0 {
+				game.Discounts[i].Amount = structs.MoneyFromFloat(discount.Value, game.Price.Currency)
+				game.Discounts[i].Value = 0
+			}
+		}
+	}
+	err = db.BatchWrite(games, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(games), nil
+}
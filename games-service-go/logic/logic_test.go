@@ -1,20 +1,27 @@
 package logic
 
 import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
 	"testing"
 
+	database "github.com/Draupniyr/games-service/database"
+	search "github.com/Draupniyr/games-service/search"
 	"github.com/Draupniyr/games-service/structs"
-	database "github.com/Draupniyr/games-service/mockdb"
 )
 
-var db database.Database
+var db database.Memory[structs.Game]
+var orgDB database.Memory[structs.Organization]
 
 func TestGetAllGames(t *testing.T) {
 	//setup
 	db.Init("Test", "ID")
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game1", "User1"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game2", "User1"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game3", "User1"))
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User1"))
+	db.Items = append(db.Items, createTestGame("Game3", "User1"))
 
 	// TestGetAllGames tests the GetAllGames function.
 	Games, err := GetAllGames(&db)
@@ -30,9 +37,9 @@ func TestGetAllGames(t *testing.T) {
 
 func TestGetGame(t *testing.T) {
 	db.Init("Test", "ID")
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game1", "User1"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game2", "User2"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game3", "User3"))
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User2"))
+	db.Items = append(db.Items, createTestGame("Game3", "User3"))
 	// TestGetGame tests the GetGame function.
 	// It should return a Game with the given userID.
 	Game, err := GetGame("Game2", &db)
@@ -45,23 +52,28 @@ func TestGetGame(t *testing.T) {
 
 func TestSearchGames(t *testing.T) {
 	db.Init("Test", "ID")
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game1", "User1"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game2", "User2"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game3", "User3"))
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User2"))
+	db.Items = append(db.Items, createTestGame("Game3", "User3"))
 	// TestSearchGames tests the SearchGames function.
 	// It should return all Games with the given search string.
-	Games, err := SearchGames("Test", &db)
+	index := search.NewIndex()
+	err := IndexGames(index, &db)
 	if err != nil {
-		t.Errorf("Error searching Games: %v", err)
+		t.Errorf("Error indexing Games: %v", err)
 	}
+	Games := SearchGames("testtitle", search.Options{}, index)
+	simpleAssert(t, 3, len(Games))
+	// prefixes match too
+	Games = SearchGames("testdesc", search.Options{}, index)
 	simpleAssert(t, 3, len(Games))
 }
 
 func TestGetGamesByAuthor(t *testing.T) {
 	db.Init("Test", "ID")
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game1", "User1"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game2", "User2"))
-	db.DynamodbClient = append(db.DynamodbClient, createTestGame("Game3", "User1"))
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User2"))
+	db.Items = append(db.Items, createTestGame("Game3", "User1"))
 	// TestGetGamesByAuthor tests the GetGamesByAuthor function.
 	// It should return all Games with the given authorID.
 	Games, err := GetGamesByAuthor("User1", &db)
@@ -78,8 +90,8 @@ func TestCreateOrUpdateGame(t *testing.T) {
 	CreateGame(createTestGame("Game2", "User2"), &db)
 	CreateGame(createTestGame("Game3", "User3"), &db)
 	// It should create a new Game if the user does not have one.
-	simpleAssert(t, 1, len(db.DynamodbClient)) // all share a name
-	simpleAssert(t, "Game1", db.DynamodbClient[0].(structs.Game).ID)
+	simpleAssert(t, 1, len(db.Items)) // all share a name
+	simpleAssert(t, "Game1", db.Items[0].ID)
 }
 
 func TestUpdateOfCreateOrUpdateGame(t *testing.T) {
@@ -88,9 +100,136 @@ func TestUpdateOfCreateOrUpdateGame(t *testing.T) {
 	CreateGame(createTestGame("Game1", "User1"), &db)
 	updateGame := createTestGame("Game1", "User1")
 	updateGame.Title = "NewTitle"
-	UpdateGame("Game1", "User1", updateGame, &db)
-	simpleAssert(t, 1, len(db.DynamodbClient))
-	simpleAssert(t, "NewTitle", db.DynamodbClient[0].(structs.Game).Title)
+	UpdateGame("Game1", "User1", "dev", updateGame, &orgDB, &db)
+	simpleAssert(t, 1, len(db.Items))
+	simpleAssert(t, "NewTitle", db.Items[0].Title)
+}
+
+func TestCreateDiscount(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateGame(createTestGame("Game1", "User1"), &db)
+
+	// It should only let the developer or an admin discount the game.
+	err := CreateDiscount("Game1", "User2", "dev", structs.Discount{ID: "D1", Type: structs.DiscountPercent, Value: 50}, &orgDB, &db)
+	if err == nil {
+		t.Errorf("Expected another developer to be refused")
+	}
+	err = CreateDiscount("Game1", "User1", "dev", structs.Discount{ID: "D1", Type: structs.DiscountPercent, Value: 50}, &orgDB, &db)
+	if err != nil {
+		t.Errorf("Error creating discount: %v", err)
+	}
+	game := db.Items[0]
+	simpleAssert(t, 1, len(game.Discounts))
+	simpleAssert(t, structs.NewMoney(617, "USD"), game.SalePriceIn("USD"))
+
+	err = DeleteDiscount("Game1", "User1", "dev", "Missing", &orgDB, &db)
+	simpleAssert(t, ErrDiscountNotFound, err)
+	err = DeleteDiscount("Game1", "User1", "dev", "D1", &orgDB, &db)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, 0, len(db.Items[0].Discounts))
+}
+
+func TestCreateDiscountValidation(t *testing.T) {
+	db.Init("Test", "ID")
+	CreateGame(createTestGame("Game1", "User1"), &db)
+
+	invalid := []structs.Discount{
+		{Type: structs.DiscountPercent, Value: 150},
+		{Type: structs.DiscountFixed, Amount: structs.NewMoney(2000, "USD")},
+		{Type: structs.DiscountFixed, Amount: structs.NewMoney(100, "EUR")},
+		{Type: "bogo", Value: 1},
+		{Type: structs.DiscountFixed, Amount: structs.NewMoney(100, "USD"), Start: "2024-06-01T00:00:00Z", End: "2024-05-01T00:00:00Z"},
+	}
+	for _, discount := range invalid {
+		err := CreateDiscount("Game1", "User1", "dev", discount, &orgDB, &db)
+		if err == nil {
+			t.Errorf("Expected discount %+v to be rejected", discount)
+		}
+	}
+}
+
+func TestSalePriceOutsideWindow(t *testing.T) {
+	game := createTestGame("Game1", "User1")
+	game.Discounts = []structs.Discount{
+		{Type: structs.DiscountFixed, Amount: structs.NewMoney(200, "USD"), End: "2000-01-01T00:00:00Z"},
+		{Type: structs.DiscountFixed, Amount: structs.NewMoney(200, "USD"), Start: "2999-01-01T00:00:00Z"},
+	}
+	simpleAssert(t, structs.NewMoney(1234, "USD"), game.SalePriceIn("USD"))
+}
+
+func TestRegionalSalePrice(t *testing.T) {
+	game := createTestGame("Game1", "User1")
+	game.Price = structs.NewMoney(2000, "USD")
+	game.Prices = map[string]structs.Money{"JPY": structs.NewMoney(3000, "JPY")}
+	game.Discounts = []structs.Discount{{Type: structs.DiscountFixed, Amount: structs.NewMoney(500, "USD")}}
+
+	// a quarter off the base price is a quarter off the regional one too
+	simpleAssert(t, structs.NewMoney(2250, "JPY"), game.SalePriceIn("JPY"))
+	// no EUR price set so the base price is used
+	simpleAssert(t, structs.NewMoney(1500, "USD"), game.SalePriceIn("EUR"))
+}
+
+func TestMigratePrices(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Price = structs.Money{Amount: 1234}
+	game.Discounts = []structs.Discount{{Type: structs.DiscountFixed, Value: 2.5}}
+	db.Items = append(db.Items, game)
+
+	migrated, err := MigratePrices(&db)
+	if err != nil {
+		t.Errorf("Error migrating prices: %v", err)
+	}
+	simpleAssert(t, 1, migrated)
+	game = db.Items[0]
+	simpleAssert(t, "USD", game.Price.Currency)
+	simpleAssert(t, structs.NewMoney(250, "USD"), game.Discounts[0].Amount)
+	simpleAssert(t, 0.0, game.Discounts[0].Value)
+}
+
+func TestChangeUpdates(t *testing.T) {
+	db.Init("Test", "ID")
+	orgDB.Init("Organizations", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Updates = []structs.Update{{ID: "Update1", Title: "Patch notes"}}
+	db.Items = append(db.Items, game)
+	edit := structs.UpdatePostObject{Title: "Patch 1.1", Content: "Fixes"}
+
+	simpleAssert(t, ErrUpdateNotFound, UpdateUpdate("Game1", "User1", "dev", "Missing", edit, &orgDB, &db))
+	simpleAssert(t, ErrUpdateNotFound, DeleteUpdate("Game1", "User1", "dev", "Missing", &orgDB, &db))
+
+	// a failed write isn't reported as done
+	readOnly := readOnlyDB[structs.Game]{Memory: &db}
+	simpleAssert(t, "table is read only", UpdateUpdate("Game1", "User1", "dev", "Update1", edit, &orgDB, readOnly).Error())
+	simpleAssert(t, "table is read only", DeleteUpdate("Game1", "User1", "dev", "Update1", &orgDB, readOnly).Error())
+
+	simpleAssert(t, nil, UpdateUpdate("Game1", "User1", "dev", "Update1", edit, &orgDB, &db))
+	update, _ := GetUpdate("Game1", "Update1", &db)
+	simpleAssert(t, "Patch 1.1", update.Title)
+	simpleAssert(t, nil, DeleteUpdate("Game1", "User1", "dev", "Update1", &orgDB, &db))
+	_, err := GetUpdate("Game1", "Update1", &db)
+	simpleAssert(t, ErrUpdateNotFound, err)
+}
+
+func TestCreateGameTitleTaken(t *testing.T) {
+	db.Init("Test", "ID")
+	simpleAssert(t, nil, CreateGame(createTestGame("Game1", "User1"), &db))
+	// a title that only contains another one is free
+	longer := createTestGame("Game2", "User1")
+	longer.Title = "TestTitle 2"
+	simpleAssert(t, nil, CreateGame(longer, &db))
+	err := CreateGame(createTestGame("Game3", "User2"), &db)
+	simpleAssert(t, ErrTitleTaken, err)
+	simpleAssert(t, true, errors.Is(err, ErrConflict))
+	_, err = GetGame("Game3", &db)
+	if err == nil {
+		t.Errorf("Expected the game with a taken title not to be created")
+	}
+	// the title of a deleted game can be used again
+	simpleAssert(t, nil, DeleteGame("Game1", "User1", "dev", &orgDB, &db))
+	simpleAssert(t, nil, CreateGame(createTestGame("Game3", "User2"), &db))
+	games, _ := GetAllGames(&db)
+	simpleAssert(t, 2, len(games))
 }
 
 // ----------------- Helper Functions -----------------
@@ -106,9 +245,58 @@ func createTestGame(id string, userID string) structs.Game {
 		Title:       "TestTitle",
 		Description: "TestDescription",
 		Tags:        []string{"TestTag1", "TestTag2"},
-		Price:       12.34,
+		Price:       structs.NewMoney(1234, "USD"),
 		Published:   "TestPublished",
 		Author:      "TestAuthor",
 		AuthorID:    userID,
 	}
 }
+
+func createTestDLC(id string, parentID string, userID string) structs.Game {
+	game := createTestGame(id, userID)
+	game.Title = "TestDLC " + id
+	game.Kind = structs.KindDLC
+	game.ParentID = parentID
+	return game
+}
+
+func createTestBundle(id string, items ...string) structs.Game {
+	game := createTestGame(id, "User1")
+	game.Title = "TestBundle " + id
+	game.Kind = structs.KindBundle
+	game.BundleItems = items
+	return game
+}
+
+func createTestPNG(width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 200, A: 255})
+	}
+	data := bytes.Buffer{}
+	png.Encode(&data, img)
+	return data.Bytes()
+}
+
+// brokenDB fails every lookup the way an unreachable DynamoDB would
+type brokenDB[T any] struct {
+	database.Memory[T]
+}
+
+func (b *brokenDB[T]) Get(key string) (T, error) {
+	var item T
+	return item, errors.New("connection refused")
+}
+
+func (b *brokenDB[T]) Query(attribute string, value string) ([]T, error) {
+	return nil, errors.New("connection refused")
+}
+
+// readOnlyDB reads like a Memory but fails every write
+type readOnlyDB[T any] struct {
+	*database.Memory[T]
+}
+
+func (r readOnlyDB[T]) Put(item T) error {
+	return errors.New("table is read only")
+}
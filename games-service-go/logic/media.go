@@ -0,0 +1,235 @@
+package logic
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrMediaNotFound = notFound("media not found")
+	ErrInvalidMedia  = invalid("covers and screenshots must be JPEG, PNG or GIF images and trailers MP4 or WebM videos")
+	ErrMediaTooLarge = tooLarge("the file is too large")
+)
+
+// Upload limits and the size of the generated thumbnails
+const (
+	MaxImageSize   int64 = 5 << 20
+	MaxVideoSize   int64 = 100 << 20
+	MaxImagePixels       = 40_000_000
+	ThumbnailWidth       = 320
+)
+
+var imageTypes = []string{"image/jpeg", "image/png", "image/gif"}
+var videoTypes = []string{"video/mp4", "video/webm"}
+
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+}
+
+// ----------------- Media -----------------
+
+// AddMedia validates the upload, stores it with a thumbnail for images and
+// adds it to the game. A new cover replaces the old one, screenshots and
+// trailers go at the end of the gallery.
+func AddMedia(gameID string, userID string, userRole string, kind string, data []byte, store blobstore.Store, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) (*structs.Media, error) {
+	game, err := authorizedGame(gameID, userID, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return nil, err
+	}
+	media, thumb, err := validateMedia(kind, data)
+	if err != nil {
+		return nil, err
+	}
+
+	media.ID = uuid.New().String()
+	media.Key = "games/" + gameID + "/" + media.ID + mediaExtensions[media.ContentType]
+	media.URL = store.URL(media.Key)
+	media.Uploaded = time.Now().Format(time.RFC3339)
+	media.UploadedBy = userID
+	err = store.Put(media.Key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if thumb != nil {
+		media.ThumbnailKey = "games/" + gameID + "/" + media.ID + "_thumb.jpg"
+		media.ThumbnailURL = store.URL(media.ThumbnailKey)
+		err = store.Put(media.ThumbnailKey, bytes.NewReader(thumb))
+		if err != nil {
+			deleteBlobs(*media, store)
+			return nil, err
+		}
+	}
+
+	replaced := []structs.Media{}
+	if media.Kind == structs.MediaCover {
+		for _, old := range game.Media {
+			if old.Kind == structs.MediaCover {
+				replaced = append(replaced, old)
+			}
+		}
+		game.Media = slices.DeleteFunc(game.Media, func(m structs.Media) bool { return m.Kind == structs.MediaCover })
+		game.Media = append([]structs.Media{*media}, game.Media...)
+	} else {
+		game.Media = append(game.Media, *media)
+	}
+	err = db.Put(*game)
+	if err != nil {
+		deleteBlobs(*media, store)
+		return nil, err
+	}
+	for _, old := range replaced {
+		deleteBlobs(old, store)
+	}
+	return media, nil
+}
+
+func DeleteMedia(gameID string, userID string, userRole string, mediaID string, store blobstore.Store, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	game, err := authorizedGame(gameID, userID, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(game.Media, func(m structs.Media) bool { return m.ID == mediaID })
+	if i < 0 {
+		return ErrMediaNotFound
+	}
+	media := game.Media[i]
+	game.Media = slices.Delete(game.Media, i, i+1)
+	err = db.Put(*game)
+	if err != nil {
+		return err
+	}
+	deleteBlobs(media, store)
+	return nil
+}
+
+// MoveMedia moves a screenshot or trailer to position in the gallery,
+// counting from 0. The cover always stays in front.
+func MoveMedia(gameID string, userID string, userRole string, mediaID string, position int, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	game, err := authorizedGame(gameID, userID, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return err
+	}
+	gallery := game.Gallery()
+	i := slices.IndexFunc(gallery, func(m structs.Media) bool { return m.ID == mediaID })
+	if i < 0 {
+		return ErrMediaNotFound
+	}
+	media := gallery[i]
+	gallery = slices.Delete(gallery, i, i+1)
+	position = max(0, min(position, len(gallery)))
+	gallery = slices.Insert(gallery, position, media)
+
+	ordered := []structs.Media{}
+	if cover := game.CoverImage(); cover != nil {
+		ordered = append(ordered, *cover)
+	}
+	game.Media = append(ordered, gallery...)
+	return db.Put(*game)
+}
+
+// validateMedia checks the upload by its contents rather than its name or
+// the type the browser sent. Images are decoded to make sure they are whole
+// and get a JPEG thumbnail.
+func validateMedia(kind string, data []byte) (*structs.Media, []byte, error) {
+	if !slices.Contains(structs.MediaKinds, kind) {
+		return nil, nil, ErrInvalidMedia
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	media := &structs.Media{Kind: kind, ContentType: contentType, Size: int64(len(data))}
+
+	if kind == structs.MediaTrailer {
+		if !slices.Contains(videoTypes, contentType) {
+			return nil, nil, ErrInvalidMedia
+		}
+		if media.Size > MaxVideoSize {
+			return nil, nil, ErrMediaTooLarge
+		}
+		return media, nil, nil
+	}
+
+	if !slices.Contains(imageTypes, contentType) {
+		return nil, nil, ErrInvalidMedia
+	}
+	if media.Size > MaxImageSize {
+		return nil, nil, ErrMediaTooLarge
+	}
+	// check the size before decoding so a small file can't claim a huge image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrInvalidMedia
+	}
+	if config.Width*config.Height > MaxImagePixels {
+		return nil, nil, ErrMediaTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrInvalidMedia
+	}
+	media.Width = config.Width
+	media.Height = config.Height
+
+	thumb := bytes.Buffer{}
+	err = jpeg.Encode(&thumb, Thumbnail(img, ThumbnailWidth), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, nil, err
+	}
+	return media, thumb.Bytes(), nil
+}
+
+// Thumbnail scales the image down to width, keeping its shape, by averaging
+// the pixels each thumbnail pixel covers. Transparent parts turn white.
+// Images narrower than width keep their size.
+func Thumbnail(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	width = max(1, min(width, srcW))
+	height := max(1, srcH*width/max(1, srcW))
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/width)
+			var r, g, b, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					// the colors are premultiplied, so adding what is missing
+					// of the alpha puts them on white
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+					n++
+				}
+			}
+			thumb.SetRGBA(x, y, color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: 0xff})
+		}
+	}
+	return thumb
+}
+
+func deleteBlobs(media structs.Media, store blobstore.Store) {
+	store.Delete(media.Key)
+	if media.ThumbnailKey != "" {
+		store.Delete(media.ThumbnailKey)
+	}
+}
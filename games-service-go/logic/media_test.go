@@ -0,0 +1,99 @@
+package logic
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestAddMedia(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	store, _ := blobstore.NewLocalStore(t.TempDir(), "/games/media")
+
+	_, err := AddMedia("Game1", "User2", "dev", structs.MediaCover, createTestPNG(10, 10), store, &orgDB, &db)
+	simpleAssert(t, ErrNotGameAuthor, err)
+	_, err = AddMedia("Game1", "User1", "dev", structs.MediaCover, []byte("not an image"), store, &orgDB, &db)
+	simpleAssert(t, ErrInvalidMedia, err)
+	_, err = AddMedia("Game1", "User1", "dev", structs.MediaTrailer, createTestPNG(10, 10), store, &orgDB, &db)
+	simpleAssert(t, ErrInvalidMedia, err)
+	_, err = AddMedia("Game1", "User1", "dev", "banner", createTestPNG(10, 10), store, &orgDB, &db)
+	simpleAssert(t, ErrInvalidMedia, err)
+
+	cover, err := AddMedia("Game1", "User1", "dev", structs.MediaCover, createTestPNG(640, 320), store, &orgDB, &db)
+	if err != nil {
+		t.Fatalf("Error adding cover: %v", err)
+	}
+	simpleAssert(t, "image/png", cover.ContentType)
+	simpleAssert(t, 640, cover.Width)
+	simpleAssert(t, "/games/media/"+cover.Key, cover.URL)
+
+	thumbFile, err := store.Open(cover.ThumbnailKey)
+	if err != nil {
+		t.Fatalf("Error opening thumbnail: %v", err)
+	}
+	thumb, _, err := image.Decode(thumbFile)
+	thumbFile.Close()
+	if err != nil {
+		t.Fatalf("Error decoding thumbnail: %v", err)
+	}
+	simpleAssert(t, ThumbnailWidth, thumb.Bounds().Dx())
+	simpleAssert(t, 160, thumb.Bounds().Dy())
+
+	// a new cover replaces the old one and its files
+	newCover, _ := AddMedia("Game1", "User1", "admin", structs.MediaCover, createTestPNG(20, 20), store, &orgDB, &db)
+	game, _ := GetGame("Game1", &db)
+	simpleAssert(t, 1, len(game.Media))
+	simpleAssert(t, newCover.ID, game.CoverImage().ID)
+	_, err = store.Open(cover.Key)
+	simpleAssert(t, blobstore.ErrNotFound, err)
+}
+
+func TestMediaGallery(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	store, _ := blobstore.NewLocalStore(t.TempDir(), "/games/media")
+
+	first, _ := AddMedia("Game1", "User1", "dev", structs.MediaScreenshot, createTestPNG(10, 10), store, &orgDB, &db)
+	second, _ := AddMedia("Game1", "User1", "dev", structs.MediaScreenshot, createTestPNG(10, 10), store, &orgDB, &db)
+	cover, _ := AddMedia("Game1", "User1", "dev", structs.MediaCover, createTestPNG(10, 10), store, &orgDB, &db)
+	game, _ := GetGame("Game1", &db)
+	simpleAssert(t, cover.ID, game.Media[0].ID)
+	simpleAssert(t, 2, len(game.Gallery()))
+	simpleAssert(t, first.ID, game.Gallery()[0].ID)
+
+	err := MoveMedia("Game1", "User1", "dev", second.ID, 0, &orgDB, &db)
+	if err != nil {
+		t.Errorf("Error moving media: %v", err)
+	}
+	game, _ = GetGame("Game1", &db)
+	simpleAssert(t, cover.ID, game.Media[0].ID)
+	simpleAssert(t, second.ID, game.Gallery()[0].ID)
+	simpleAssert(t, first.ID, game.Gallery()[1].ID)
+	simpleAssert(t, ErrMediaNotFound, MoveMedia("Game1", "User1", "dev", "Missing", 0, &orgDB, &db))
+
+	err = DeleteMedia("Game1", "User1", "dev", second.ID, store, &orgDB, &db)
+	if err != nil {
+		t.Errorf("Error deleting media: %v", err)
+	}
+	game, _ = GetGame("Game1", &db)
+	simpleAssert(t, 1, len(game.Gallery()))
+	_, err = store.Open(second.ThumbnailKey)
+	simpleAssert(t, blobstore.ErrNotFound, err)
+	simpleAssert(t, ErrMediaNotFound, DeleteMedia("Game1", "User1", "dev", second.ID, store, &orgDB, &db))
+
+	// updating the game keeps its media
+	UpdateGame("Game1", "User1", "dev", createTestGame("Game1", "User1"), &orgDB, &db)
+	game, _ = GetGame("Game1", &db)
+	simpleAssert(t, 2, len(game.Media))
+}
+
+func TestThumbnailTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	thumb := Thumbnail(img, 2)
+	simpleAssert(t, 2, thumb.Bounds().Dx())
+	simpleAssert(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, thumb.RGBAAt(0, 0))
+}
@@ -0,0 +1,359 @@
+package logic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrOrgNotFound    = notFound("the organization doesn't exist")
+	ErrInvalidOrg     = invalid("the organization needs a name and to be a developer or publisher")
+	ErrNotMember      = forbidden("you aren't a member of the organization")
+	ErrRoleNotAllowed = forbidden("your role in the organization doesn't allow this")
+	ErrInvalidRole    = invalid("the role must be owner, admin, editor or viewer")
+	ErrInviteNotFound = notFound("the invite is invalid or has expired")
+	ErrAlreadyMember  = conflict("you are already a member of the organization")
+	ErrLastOwner      = conflict("the organization needs at least one owner")
+	ErrOrgHasGames    = conflict("move or delete the organization's games first")
+	ErrOrgChanged     = conflict("the organization was changed at the same time, try again")
+)
+
+// InviteTTL is how long an invite code works
+const InviteTTL = 7 * 24 * time.Hour
+
+// OrganizationView is an organization as one of its members sees it
+type OrganizationView struct {
+	structs.Organization
+	// Role is the member's role
+	Role  string
+	Games []structs.Game
+}
+
+// Can reports if the member's role is the wanted one or above it
+func (v OrganizationView) Can(wanted string) bool {
+	return structs.RoleAllows(v.Role, wanted)
+}
+
+// ----------------- Organizations -----------------
+
+// CreateOrganization sets up the organization with the user as its owner
+func CreateOrganization(userID string, request structs.OrganizationRequest, now time.Time, orgDB database.Repository[structs.Organization]) (*structs.Organization, error) {
+	name := strings.TrimSpace(request.Name)
+	kind := strings.ToLower(strings.TrimSpace(request.Kind))
+	if kind == "" {
+		kind = structs.OrgDeveloper
+	}
+	if name == "" || !slices.Contains(structs.OrgKinds, kind) {
+		return nil, ErrInvalidOrg
+	}
+	created := now.UTC().Format(time.RFC3339)
+	org := structs.Organization{
+		ID:      uuid.New().String(),
+		Name:    name,
+		Kind:    kind,
+		Members: []structs.Member{{UserID: userID, Role: structs.RoleOwner, Joined: created}},
+		Invites: []structs.Invite{},
+		Created: created,
+		Version: uuid.New().String(),
+	}
+	err := orgDB.Put(org)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func GetOrganization(orgID string, orgDB database.Repository[structs.Organization]) (*structs.Organization, error) {
+	org, err := orgDB.Get(orgID)
+	if err != nil {
+		return nil, missing(err, ErrOrgNotFound)
+	}
+	return &org, nil
+}
+
+// GetOrganizations returns the organizations the user is a member of, by name
+func GetOrganizations(userID string, orgDB database.Repository[structs.Organization]) ([]structs.Organization, error) {
+	orgs, err := orgDB.Scan()
+	if err != nil {
+		return nil, err
+	}
+	orgs = slices.DeleteFunc(orgs, func(o structs.Organization) bool { return o.Member(userID) == nil })
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+// GetOrganizationViews returns the user's organizations with their games.
+// Only admins see the invites.
+func GetOrganizationViews(userID string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) ([]OrganizationView, error) {
+	orgs, err := GetOrganizations(userID, orgDB)
+	if err != nil {
+		return nil, err
+	}
+	views := []OrganizationView{}
+	for _, org := range orgs {
+		view := OrganizationView{Organization: org, Role: org.Member(userID).Role}
+		view.Games, err = GetOrganizationGames(org.ID, db)
+		if err != nil {
+			return nil, err
+		}
+		if !view.Can(structs.RoleAdmin) {
+			view.Invites = nil
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// GetOrganizationGames returns the games the organization owns
+func GetOrganizationGames(orgID string, db database.Repository[structs.Game]) ([]structs.Game, error) {
+	games, err := getOrganizationGamesWithTrash(orgID, db)
+	if err != nil {
+		return nil, err
+	}
+	return withoutDeleted(games), nil
+}
+
+func getOrganizationGamesWithTrash(orgID string, db database.Repository[structs.Game]) ([]structs.Game, error) {
+	return db.Query("OrganizationID", orgID)
+}
+
+// DeleteOrganization removes an organization that no longer owns any games.
+// Games in the trash still count, so they have an organization to go back to
+// when restored.
+func DeleteOrganization(orgID string, userID string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	_, err := requireRole(orgID, userID, structs.RoleOwner, orgDB)
+	if err != nil {
+		return err
+	}
+	games, err := getOrganizationGamesWithTrash(orgID, db)
+	if err != nil {
+		return err
+	}
+	for _, game := range games {
+		if game.OrganizationID == orgID && game.PurgedAt == "" {
+			return ErrOrgHasGames
+		}
+	}
+	return orgDB.Delete(orgID)
+}
+
+// requireRole gets the organization if the user has the wanted role or one
+// above it
+func requireRole(orgID string, userID string, wanted string, orgDB database.Repository[structs.Organization]) (*structs.Organization, error) {
+	org, err := GetOrganization(orgID, orgDB)
+	if err != nil {
+		return nil, err
+	}
+	member := org.Member(userID)
+	if member == nil {
+		return nil, ErrNotMember
+	}
+	if !structs.RoleAllows(member.Role, wanted) {
+		return nil, ErrRoleNotAllowed
+	}
+	return org, nil
+}
+
+// GetPersonalGames returns the author's games that aren't in an organization
+func GetPersonalGames(userID string, db database.Repository[structs.Game]) []structs.Game {
+	games, err := GetGamesByAuthor(userID, db)
+	if err != nil {
+		// nothing found
+		return []structs.Game{}
+	}
+	return slices.DeleteFunc(games, func(g structs.Game) bool { return g.OrganizationID != "" || g.AuthorID != userID })
+}
+
+// TransferGame moves the game to the organization. The user needs to be an
+// admin of the organization and allowed to delete the game where it is now.
+func TransferGame(gameID string, userID string, userRole string, orgID string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) error {
+	game, err := authorizedGame(gameID, userID, userRole, ActionDelete, orgDB, db)
+	if err != nil {
+		return err
+	}
+	_, err = requireRole(orgID, userID, structs.RoleAdmin, orgDB)
+	if err != nil {
+		return err
+	}
+	game.OrganizationID = orgID
+	return db.Put(*game)
+}
+
+// ----------------- Members -----------------
+
+// CreateInvite makes a code that lets someone join with the role. Admins can
+// invite anyone below owner, only owners can invite owners.
+func CreateInvite(orgID string, userID string, request structs.InviteRequest, now time.Time, orgDB database.Repository[structs.Organization]) (*structs.Invite, error) {
+	role := strings.ToLower(strings.TrimSpace(request.Role))
+	if !slices.Contains(structs.OrgRoles, role) {
+		return nil, ErrInvalidRole
+	}
+	org, err := requireRole(orgID, userID, structs.RoleAdmin, orgDB)
+	if err != nil {
+		return nil, err
+	}
+	if role == structs.RoleOwner && org.Member(userID).Role != structs.RoleOwner {
+		return nil, ErrRoleNotAllowed
+	}
+	code := make([]byte, 16)
+	_, err = rand.Read(code)
+	if err != nil {
+		return nil, err
+	}
+	invite := structs.Invite{
+		Code:      hex.EncodeToString(code),
+		Role:      role,
+		Note:      strings.TrimSpace(request.Note),
+		InvitedBy: userID,
+		Created:   now.UTC().Format(time.RFC3339),
+		Expires:   now.Add(InviteTTL).UTC().Format(time.RFC3339),
+	}
+	org.Invites = append(expiredInvitesRemoved(org.Invites, now), invite)
+	err = saveOrganization(org, orgDB)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// RevokeInvite stops the invite code from working
+func RevokeInvite(orgID string, userID string, code string, orgDB database.Repository[structs.Organization]) error {
+	org, err := requireRole(orgID, userID, structs.RoleAdmin, orgDB)
+	if err != nil {
+		return err
+	}
+	invites := slices.DeleteFunc(slices.Clone(org.Invites), func(i structs.Invite) bool { return i.Code == code })
+	if len(invites) == len(org.Invites) {
+		return ErrInviteNotFound
+	}
+	org.Invites = invites
+	return saveOrganization(org, orgDB)
+}
+
+// AcceptInvite makes the user a member of the organization the code is for,
+// with the role it was made with
+func AcceptInvite(code string, userID string, now time.Time, orgDB database.Repository[structs.Organization]) (*structs.Organization, error) {
+	orgs, err := orgDB.Scan()
+	if err != nil || code == "" {
+		return nil, ErrInviteNotFound
+	}
+	for _, org := range orgs {
+		index := slices.IndexFunc(org.Invites, func(i structs.Invite) bool { return i.Code == code })
+		if index < 0 {
+			continue
+		}
+		invite := org.Invites[index]
+		if inviteExpired(invite, now) {
+			return nil, ErrInviteNotFound
+		}
+		if org.Member(userID) != nil {
+			return nil, ErrAlreadyMember
+		}
+		org.Invites = slices.Delete(slices.Clone(org.Invites), index, index+1)
+		org.Members = append(slices.Clone(org.Members), structs.Member{UserID: userID, Role: invite.Role, Joined: now.UTC().Format(time.RFC3339)})
+		err = saveOrganization(&org, orgDB)
+		if err != nil {
+			return nil, err
+		}
+		return &org, nil
+	}
+	return nil, ErrInviteNotFound
+}
+
+// SetMemberRole changes a member's role. Admins manage the members below
+// owner, owners everyone, as long as an owner is left.
+func SetMemberRole(orgID string, userID string, memberID string, role string, orgDB database.Repository[structs.Organization]) error {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if !slices.Contains(structs.OrgRoles, role) {
+		return ErrInvalidRole
+	}
+	org, err := requireRole(orgID, userID, structs.RoleAdmin, orgDB)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(org.Members, func(m structs.Member) bool { return m.UserID == memberID })
+	if index < 0 {
+		return ErrNotMember
+	}
+	err = canManageMember(org, userID, org.Members[index].Role, role)
+	if err != nil {
+		return err
+	}
+	org.Members = slices.Clone(org.Members)
+	org.Members[index].Role = role
+	if org.Owners() == 0 {
+		return ErrLastOwner
+	}
+	return saveOrganization(org, orgDB)
+}
+
+// RemoveMember takes the member out of the organization. Anyone can leave,
+// removing someone else works like changing their role.
+func RemoveMember(orgID string, userID string, memberID string, orgDB database.Repository[structs.Organization]) error {
+	wanted := structs.RoleAdmin
+	if memberID == userID {
+		wanted = structs.RoleViewer
+	}
+	org, err := requireRole(orgID, userID, wanted, orgDB)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(org.Members, func(m structs.Member) bool { return m.UserID == memberID })
+	if index < 0 {
+		return ErrNotMember
+	}
+	if memberID != userID {
+		err = canManageMember(org, userID, org.Members[index].Role, "")
+		if err != nil {
+			return err
+		}
+	}
+	org.Members = slices.Delete(slices.Clone(org.Members), index, index+1)
+	if org.Owners() == 0 {
+		return ErrLastOwner
+	}
+	return saveOrganization(org, orgDB)
+}
+
+// saveOrganization writes the organization if nobody saved it since it was
+// read, ErrOrgChanged otherwise
+func saveOrganization(org *structs.Organization, orgDB database.Repository[structs.Organization]) error {
+	read := org.Version
+	org.Version = uuid.New().String()
+	err := orgDB.PutIf(*org, "Version", read)
+	if errors.Is(err, database.ErrConditionFailed) {
+		return ErrOrgChanged
+	}
+	return err
+}
+
+// canManageMember checks the user may move a member from one role to another,
+// only owners touch the owner role
+func canManageMember(org *structs.Organization, userID string, from string, to string) error {
+	if org.Member(userID).Role == structs.RoleOwner {
+		return nil
+	}
+	if from == structs.RoleOwner || to == structs.RoleOwner {
+		return ErrRoleNotAllowed
+	}
+	return nil
+}
+
+func inviteExpired(invite structs.Invite, now time.Time) bool {
+	expires, err := time.Parse(time.RFC3339, invite.Expires)
+	return err != nil || now.After(expires)
+}
+
+func expiredInvitesRemoved(invites []structs.Invite, now time.Time) []structs.Invite {
+	return slices.DeleteFunc(slices.Clone(invites), func(i structs.Invite) bool { return inviteExpired(i, now) })
+}
@@ -0,0 +1,152 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	analytics "github.com/Draupniyr/games-service/analytics"
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	database "github.com/Draupniyr/games-service/database"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestOrganizations(t *testing.T) {
+	orgDB.Init("Organizations", "ID")
+	now := time.Now()
+
+	_, err := CreateOrganization("User1", structs.OrganizationRequest{Name: " ", Kind: "developer"}, now, &orgDB)
+	simpleAssert(t, ErrInvalidOrg, err)
+	org, err := CreateOrganization("User1", structs.OrganizationRequest{Name: "Studio", Kind: "Publisher"}, now, &orgDB)
+	if err != nil {
+		t.Fatalf("Error creating organization: %v", err)
+	}
+	simpleAssert(t, structs.OrgPublisher, org.Kind)
+	simpleAssert(t, structs.RoleOwner, org.Member("User1").Role)
+
+	// invites work once and only until they expire
+	invite, err := CreateInvite(org.ID, "User1", structs.InviteRequest{Role: "admin"}, now, &orgDB)
+	if err != nil {
+		t.Fatalf("Error creating invite: %v", err)
+	}
+	_, err = CreateInvite(org.ID, "User2", structs.InviteRequest{Role: "editor"}, now, &orgDB)
+	simpleAssert(t, ErrNotMember, err)
+	_, err = AcceptInvite(invite.Code, "User2", now.Add(InviteTTL+time.Minute), &orgDB)
+	simpleAssert(t, ErrInviteNotFound, err)
+	_, err = AcceptInvite(invite.Code, "User2", now, &orgDB)
+	simpleAssert(t, nil, err)
+	_, err = AcceptInvite(invite.Code, "User3", now, &orgDB)
+	simpleAssert(t, ErrInviteNotFound, err)
+	orgs, _ := GetOrganizations("User2", &orgDB)
+	simpleAssert(t, 1, len(orgs))
+	simpleAssert(t, structs.RoleAdmin, orgs[0].Member("User2").Role)
+
+	// admins manage the members below owner
+	_, err = CreateInvite(org.ID, "User2", structs.InviteRequest{Role: "owner"}, now, &orgDB)
+	simpleAssert(t, ErrRoleNotAllowed, err)
+	invite, _ = CreateInvite(org.ID, "User2", structs.InviteRequest{Role: "viewer"}, now, &orgDB)
+	AcceptInvite(invite.Code, "User3", now, &orgDB)
+	simpleAssert(t, ErrInvalidRole, SetMemberRole(org.ID, "User2", "User3", "boss", &orgDB))
+	simpleAssert(t, nil, SetMemberRole(org.ID, "User2", "User3", "editor", &orgDB))
+	simpleAssert(t, ErrRoleNotAllowed, SetMemberRole(org.ID, "User2", "User1", "admin", &orgDB))
+	simpleAssert(t, ErrRoleNotAllowed, RemoveMember(org.ID, "User3", "User2", &orgDB))
+
+	// the last owner can't go
+	simpleAssert(t, ErrLastOwner, SetMemberRole(org.ID, "User1", "User1", "admin", &orgDB))
+	simpleAssert(t, ErrLastOwner, RemoveMember(org.ID, "User1", "User1", &orgDB))
+	simpleAssert(t, nil, RemoveMember(org.ID, "User3", "User3", &orgDB))
+	org, _ = GetOrganization(org.ID, &orgDB)
+	simpleAssert(t, 2, len(org.Members))
+	simpleAssert(t, structs.RoleOwner, org.Member("User1").Role)
+
+	simpleAssert(t, ErrRoleNotAllowed, DeleteOrganization(org.ID, "User2", &orgDB, &db))
+}
+
+func TestOrganizationChangedMeanwhile(t *testing.T) {
+	orgDB.Init("Organizations", "ID")
+	now := time.Now()
+	org, _ := CreateOrganization("User1", structs.OrganizationRequest{Name: "Studio", Kind: "developer"}, now, &orgDB)
+	invite, _ := CreateInvite(org.ID, "User1", structs.InviteRequest{Role: "editor"}, now, &orgDB)
+
+	// a copy read before someone joined can't be written back over them
+	stale, _ := GetOrganization(org.ID, &orgDB)
+	_, err := AcceptInvite(invite.Code, "User2", now, &orgDB)
+	simpleAssert(t, nil, err)
+	stale.Name = "Renamed"
+	simpleAssert(t, ErrOrgChanged, saveOrganization(stale, &orgDB))
+	org, _ = GetOrganization(org.ID, &orgDB)
+	simpleAssert(t, "Studio", org.Name)
+	simpleAssert(t, structs.RoleEditor, org.Member("User2").Role)
+
+	// organizations saved before they had a version still save once
+	orgDB.Put(structs.Organization{ID: "Org1", Name: "Old", Members: []structs.Member{{UserID: "User1", Role: structs.RoleOwner}}})
+	simpleAssert(t, nil, SetMemberRole("Org1", "User1", "User1", "owner", &orgDB))
+	org, _ = GetOrganization("Org1", &orgDB)
+	simpleAssert(t, true, org.Version != "")
+}
+
+func TestOrganizationGames(t *testing.T) {
+	db.Init("Test", "ID")
+	orgDB.Init("Organizations", "ID")
+	now := time.Now()
+	org, _ := CreateOrganization("User1", structs.OrganizationRequest{Name: "Studio"}, now, &orgDB)
+	for user, role := range map[string]string{"User2": "editor", "User3": "viewer"} {
+		invite, _ := CreateInvite(org.ID, "User1", structs.InviteRequest{Role: role}, now, &orgDB)
+		AcceptInvite(invite.Code, user, now, &orgDB)
+	}
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+
+	// a game of the author's own is theirs alone
+	simpleAssert(t, ErrNotGameAuthor, UpdateGame("Game1", "User2", "dev", createTestGame("Game1", "User2"), &orgDB, &db))
+	simpleAssert(t, ErrNotGameAuthor, DeleteGame("Game1", "User2", "dev", &orgDB, &db))
+	simpleAssert(t, ErrOrgNotFound, TransferGame("Game1", "User1", "dev", "Missing", &orgDB, &db))
+	simpleAssert(t, ErrNotGameAuthor, TransferGame("Game1", "User2", "dev", org.ID, &orgDB, &db))
+	simpleAssert(t, nil, TransferGame("Game1", "User1", "dev", org.ID, &orgDB, &db))
+	games, _ := GetOrganizationGames(org.ID, &db)
+	simpleAssert(t, 1, len(games))
+	simpleAssert(t, 0, len(GetPersonalGames("User1", &db)))
+
+	// in the organization editors change it and admins delete it
+	update := createTestGame("Game1", "User2")
+	update.Description = "Edited"
+	simpleAssert(t, nil, UpdateGame("Game1", "User2", "dev", update, &orgDB, &db))
+	game, _ := GetGame("Game1", &db)
+	simpleAssert(t, "Edited", game.Description)
+	simpleAssert(t, "User1", game.AuthorID)
+	simpleAssert(t, org.ID, game.OrganizationID)
+	simpleAssert(t, ErrRoleNotAllowed, UpdateGame("Game1", "User3", "dev", update, &orgDB, &db))
+	simpleAssert(t, ErrNotMember, UpdateGame("Game1", "User4", "dev", update, &orgDB, &db))
+
+	simpleAssert(t, nil, CreateUpdate("Game1", "User2", "dev", structs.Update{ID: "Update1", Title: "Patch"}, &orgDB, &db))
+	simpleAssert(t, ErrRoleNotAllowed, CreateUpdate("Game1", "User3", "dev", structs.Update{ID: "Update2", Title: "Patch"}, &orgDB, &db))
+	game, _ = GetGame("Game1", &db)
+	simpleAssert(t, 1, len(game.Updates))
+
+	// any member sees its sales and downloads its builds, until they leave
+	sales := analytics.NewStore()
+	noLibrary := database.Memory[structs.LibraryEntry]{}
+	report, _ := GetAnalytics("User3", "", "2024-03-01", "2024-03-30", sales, &orgDB, &db)
+	simpleAssert(t, 1, len(report.Games))
+	simpleAssert(t, nil, CanDownload("User3", "Game1", &orgDB, &noLibrary, &db))
+	simpleAssert(t, nil, RemoveMember(org.ID, "User1", "User3", &orgDB))
+	report, _ = GetAnalytics("User3", "", "2024-03-01", "2024-03-30", sales, &orgDB, &db)
+	simpleAssert(t, 0, len(report.Games))
+	_, err := GetAnalytics("User3", "Game1", "2024-03-01", "2024-03-30", sales, &orgDB, &db)
+	simpleAssert(t, ErrGameNotFound, err)
+	simpleAssert(t, ErrNotOwned, CanDownload("User3", "Game1", &orgDB, &noLibrary, &db))
+
+	simpleAssert(t, ErrOrgHasGames, DeleteOrganization(org.ID, "User1", &orgDB, &db))
+	simpleAssert(t, ErrRoleNotAllowed, DeleteGame("Game1", "User2", "dev", &orgDB, &db))
+	simpleAssert(t, nil, DeleteGame("Game1", "User1", "dev", &orgDB, &db))
+	games, _ = GetOrganizationGames(org.ID, &db)
+	simpleAssert(t, 0, len(games))
+
+	// the game could still be restored into the organization
+	simpleAssert(t, ErrOrgHasGames, DeleteOrganization(org.ID, "User1", &orgDB, &db))
+	store, _ := blobstore.NewLocalStore(t.TempDir(), "/games/media")
+	libraryDB := database.Memory[structs.LibraryEntry]{}
+	libraryDB.Init("Library", "ID")
+	buildDB := database.Memory[structs.Build]{}
+	buildDB.Init("Builds", "ID")
+	PurgeDeletedGames(time.Now().Add(TrashRetention+time.Hour), store, store, &libraryDB, &buildDB, &db)
+	simpleAssert(t, nil, DeleteOrganization(org.ID, "User1", &orgDB, &db))
+}
@@ -0,0 +1,187 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+	validate "github.com/Draupniyr/games-service/validate"
+)
+
+// PatchableFields are the fields of a game a merge patch can change. The
+// others have their own endpoints, or can't change once the game is listed.
+var PatchableFields = []string{
+	"Title", "Description", "Tags", "Price", "Prices", "Author", "Publisher",
+	"Languages", "AgeRating", "Requirements", "ReleaseDate", "ReleaseAt",
+	"PreOrders", "EarlyAccess",
+}
+
+var ErrInvalidPatch = invalid("the patch must be a JSON object with the fields of the game to change")
+
+// PatchGame applies a JSON Merge Patch (RFC 7396) to the game, for the users
+// Authorize lets update it. Members set to null are cleared and objects like
+// Prices are merged member by member. Only the attributes that end up
+// different are written, so the rest of the game is left as it is.
+func PatchGame(ID string, userID string, userRole string, patch []byte, orgDB database.Repository[structs.Organization], tagDB database.Repository[structs.Tag], db database.Repository[structs.Game]) (*structs.Game, error) {
+	ogGame, err := authorizedGame(ID, userID, userRole, ActionUpdate, orgDB, db)
+	if err != nil {
+		return nil, err
+	}
+	changes := map[string]interface{}{}
+	err = decodeJSON(patch, &changes)
+	if err != nil || changes == nil {
+		return nil, ErrInvalidPatch
+	}
+	for name := range changes {
+		if !slices.Contains(PatchableFields, name) {
+			return nil, invalid(name + " can't be changed with a patch")
+		}
+	}
+
+	original, err := json.Marshal(ogGame)
+	if err != nil {
+		return nil, err
+	}
+	document := map[string]interface{}{}
+	err = decodeJSON(original, &document)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := json.Marshal(mergePatch(document, changes))
+	if err != nil {
+		return nil, err
+	}
+	game := structs.Game{}
+	err = json.Unmarshal(merged, &game)
+	if err != nil {
+		return nil, invalid(fmt.Sprintf("the patch doesn't fit the game: %v", err))
+	}
+
+	err = validatePatched(&game)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := changes["Tags"]; ok {
+		game.Tags, err = NormalizeTags(game.Tags, tagDB)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = validateDetails(&game)
+	if err != nil {
+		return nil, err
+	}
+	err = scheduleRelease(&game, ogGame, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	err = assignSlug(&game, ogGame, db)
+	if err != nil {
+		return nil, err
+	}
+
+	set, remove, err := changedAttributes(*ogGame, game)
+	if err != nil {
+		return nil, err
+	}
+	if len(set) == 0 && len(remove) == 0 {
+		return &game, nil
+	}
+	err = db.Update(ID, set, remove)
+	if err != nil {
+		return nil, missing(err, ErrGameNotFound)
+	}
+	return &game, nil
+}
+
+// decodeJSON keeps numbers as they were sent, so prices in the smallest unit
+// don't go through a float
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// mergePatch applies the patch to the target as RFC 7396 describes: a null
+// member removes it, an object is merged into the target's object and
+// anything else replaces the target
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// validatePatched checks the patched game against the rules of the create
+// request, and keeps the description it cleaned up
+func validatePatched(game *structs.Game) error {
+	request := structs.GamePostRequest{
+		Title:        game.Title,
+		Description:  game.Description,
+		Tags:         game.Tags,
+		Price:        game.Price,
+		Prices:       game.Prices,
+		Author:       game.Author,
+		Publisher:    game.Publisher,
+		Languages:    game.Languages,
+		AgeRating:    game.AgeRating,
+		Requirements: game.Requirements,
+		ReleaseDate:  game.ReleaseDate,
+		ReleaseAt:    game.ReleaseAt,
+	}
+	err := validate.Struct(&request)
+	if err != nil {
+		return err
+	}
+	game.Description = request.Description
+	return nil
+}
+
+// changedAttributes compares the games as they are stored, and lists the
+// attributes to set to their new value and the ones that were cleared
+func changedAttributes(before structs.Game, after structs.Game) (map[string]interface{}, []string, error) {
+	beforeItem, err := dynamodbattribute.MarshalMap(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterItem, err := dynamodbattribute.MarshalMap(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := map[string]interface{}{}
+	remove := []string{}
+	afterValue := reflect.ValueOf(after)
+	for name, value := range afterItem {
+		if reflect.DeepEqual(beforeItem[name], value) {
+			continue
+		}
+		if value.NULL != nil && *value.NULL {
+			if old, ok := beforeItem[name]; ok && (old.NULL == nil || !*old.NULL) {
+				remove = append(remove, name)
+			}
+			continue
+		}
+		set[name] = afterValue.FieldByName(name).Interface()
+	}
+	slices.Sort(remove)
+	return set, remove, nil
+}
@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"errors"
+	"testing"
+
+	database "github.com/Draupniyr/games-service/database"
+	"github.com/Draupniyr/games-service/structs"
+	validate "github.com/Draupniyr/games-service/validate"
+)
+
+func TestPatchGame(t *testing.T) {
+	db.Init("Test", "ID")
+	orgDB.Init("Organizations", "ID")
+	tagDB := database.Memory[structs.Tag]{}
+	tagDB.Init("Tags", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Publisher = "TestPublisher"
+	game.Prices = map[string]structs.Money{"EUR": structs.NewMoney(1099, "EUR"), "GBP": structs.NewMoney(999, "GBP")}
+	game.Updates = []structs.Update{{ID: "Update1", Title: "Patch notes"}}
+	db.Items = append(db.Items, game)
+
+	patch := `{"Description": "<b>New</b> description", "Price": {"Amount": 0}, "Prices": {"EUR": null}, "Publisher": null}`
+	patched, err := PatchGame("Game1", "User1", "dev", []byte(patch), &orgDB, &tagDB, &db)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, "New description", patched.Description)
+
+	stored, _ := GetGame("Game1", &db)
+	simpleAssert(t, "New description", stored.Description)
+	simpleAssert(t, structs.NewMoney(0, "USD"), stored.Price)
+	simpleAssert(t, 1, len(stored.Prices))
+	simpleAssert(t, structs.NewMoney(999, "GBP"), stored.Prices["GBP"])
+	simpleAssert(t, "", stored.Publisher)
+	// the fields the patch leaves out are kept
+	simpleAssert(t, "TestTitle", stored.Title)
+	simpleAssert(t, "TestPublished", stored.Published)
+	simpleAssert(t, 1, len(stored.Updates))
+	simpleAssert(t, "User1", stored.AuthorID)
+
+	tests := []struct {
+		name   string
+		userID string
+		patch  string
+		want   error
+	}{
+		{"not an object", "User1", `["Title"]`, ErrInvalidPatch},
+		{"null", "User1", `null`, ErrInvalidPatch},
+		{"field with its own endpoint", "User1", `{"Published": "today"}`, ErrInvalid},
+		{"wrong type", "User1", `{"Tags": "one"}`, ErrInvalid},
+		{"another developer", "User2", `{"Title": "Mine"}`, ErrForbidden},
+	}
+	for _, test := range tests {
+		_, err := PatchGame("Game1", test.userID, "dev", []byte(test.patch), &orgDB, &tagDB, &db)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v got %v", test.name, test.want, err)
+		}
+	}
+	_, err = PatchGame("Missing", "User1", "dev", []byte(`{"Title": "New"}`), &orgDB, &tagDB, &db)
+	simpleAssert(t, true, errors.Is(err, ErrNotFound))
+
+	// clearing a field the game needs is a field error
+	_, err = PatchGame("Game1", "User1", "dev", []byte(`{"Title": null}`), &orgDB, &tagDB, &db)
+	var fields validate.Errors
+	if !errors.As(err, &fields) || fields[0].Field != "Title" {
+		t.Errorf("Expected a field error for the title got %v", err)
+	}
+	stored, _ = GetGame("Game1", &db)
+	simpleAssert(t, "TestTitle", stored.Title)
+}
+
+func TestChangedAttributes(t *testing.T) {
+	before := createTestGame("Game1", "User1")
+	before.Publisher = "TestPublisher"
+	after := before
+	after.Publisher = ""
+	after.Price = structs.NewMoney(0, "USD")
+	set, remove, err := changedAttributes(before, after)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, 1, len(set))
+	simpleAssert(t, structs.NewMoney(0, "USD"), set["Price"].(structs.Money))
+	simpleAssert(t, 1, len(remove))
+	simpleAssert(t, "Publisher", remove[0])
+}
@@ -0,0 +1,81 @@
+package logic
+
+import (
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrGameNotFound     = notFound("game not found")
+	ErrNotGameAuthor    = forbidden("only the developer or an admin can change this game")
+	ErrTitleTaken       = conflict("a game with this title already exists")
+	ErrUpdateNotFound   = notFound("update not found")
+	ErrDiscountNotFound = notFound("discount not found")
+)
+
+// Actions a user can take on a game
+const (
+	ActionRead    = "read"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionPublish = "publish"
+	ActionInspect = "inspect"
+)
+
+// orgRoles is the role in the game's organization each action takes
+var orgRoles = map[string]string{
+	ActionRead:    structs.RoleViewer,
+	ActionUpdate:  structs.RoleEditor,
+	ActionDelete:  structs.RoleAdmin,
+	ActionPublish: structs.RoleEditor,
+	ActionInspect: structs.RoleViewer,
+}
+
+// ----------------- Policy -----------------
+
+// Authorize decides if the user may take the action on the game. Anyone may
+// read a game and nobody can find one in the trash. Publishing lists a new
+// game, under its organization for an editor of it or as the user's own.
+// Updating a game takes an editor of its organization and deleting an admin
+// of it, or the author for a game of their own. Platform admins may update
+// and delete any game. Inspecting a game, its sales and its builds before
+// release, takes any member of its organization or the author.
+func Authorize(userID string, userRole string, action string, game *structs.Game, orgDB database.Repository[structs.Organization]) error {
+	if game == nil || game.IsDeleted() {
+		return ErrGameNotFound
+	}
+	wanted, ok := orgRoles[action]
+	if !ok {
+		return ErrForbidden
+	}
+	if action == ActionRead {
+		return nil
+	}
+	if userRole == "admin" && action != ActionPublish {
+		return nil
+	}
+	if game.OrganizationID != "" {
+		_, err := requireRole(game.OrganizationID, userID, wanted, orgDB)
+		return err
+	}
+	if userID == "" || game.AuthorID != userID {
+		return ErrNotGameAuthor
+	}
+	return nil
+}
+
+// authorizedGame gets the game if the user may take the action on it
+func authorizedGame(gameID string, userID string, userRole string, action string, orgDB database.Repository[structs.Organization], db database.Repository[structs.Game]) (*structs.Game, error) {
+	game, err := GetGame(gameID, db)
+	if err != nil {
+		return nil, err
+	}
+	if game.ID != gameID {
+		return nil, ErrGameNotFound
+	}
+	err = Authorize(userID, userRole, action, game, orgDB)
+	if err != nil {
+		return nil, err
+	}
+	return game, nil
+}
@@ -0,0 +1,111 @@
+package logic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestAuthorize(t *testing.T) {
+	orgDB.Init("Organizations", "ID")
+	now := time.Now()
+	org, _ := CreateOrganization("Owner1", structs.OrganizationRequest{Name: "Studio"}, now, &orgDB)
+	for user, role := range map[string]string{"Admin1": "admin", "Editor1": "editor", "Viewer1": "viewer"} {
+		invite, _ := CreateInvite(org.ID, "Owner1", structs.InviteRequest{Role: role}, now, &orgDB)
+		AcceptInvite(invite.Code, user, now, &orgDB)
+	}
+	personal := createTestGame("Game1", "User1")
+	owned := createTestGame("Game2", "Owner1")
+	owned.OrganizationID = org.ID
+	deleted := createTestGame("Game3", "User1")
+	deleted.DeletedAt = now.Format(time.RFC3339)
+	orphan := createTestGame("Game4", "User1")
+	orphan.OrganizationID = "Missing"
+	anonymous := createTestGame("Game5", "")
+
+	tests := []struct {
+		name   string
+		userID string
+		role   string
+		action string
+		game   *structs.Game
+		want   error
+	}{
+		{"anyone reads", "", "", ActionRead, &personal, nil},
+		{"nobody reads the trash", "User1", "admin", ActionRead, &deleted, ErrNotFound},
+		{"nothing to read", "User1", "dev", ActionRead, nil, ErrNotFound},
+		{"author updates", "User1", "dev", ActionUpdate, &personal, nil},
+		{"author deletes", "User1", "dev", ActionDelete, &personal, nil},
+		{"author publishes", "User1", "dev", ActionPublish, &personal, nil},
+		{"other developer updates", "User2", "dev", ActionUpdate, &personal, ErrForbidden},
+		{"other developer deletes", "User2", "dev", ActionDelete, &personal, ErrForbidden},
+		{"other developer publishes", "User2", "dev", ActionPublish, &personal, ErrForbidden},
+		{"no user", "", "", ActionUpdate, &anonymous, ErrForbidden},
+		{"platform admin updates", "Staff1", "admin", ActionUpdate, &personal, nil},
+		{"platform admin deletes", "Staff1", "admin", ActionDelete, &owned, nil},
+		{"platform admin publishes for others", "Staff1", "admin", ActionPublish, &owned, ErrForbidden},
+		{"platform admin can't change the trash", "Staff1", "admin", ActionUpdate, &deleted, ErrNotFound},
+		{"author can't change the trash", "User1", "dev", ActionDelete, &deleted, ErrNotFound},
+		{"unknown action", "User1", "dev", "sell", &personal, ErrForbidden},
+		{"org editor updates", "Editor1", "dev", ActionUpdate, &owned, nil},
+		{"org editor publishes", "Editor1", "dev", ActionPublish, &owned, nil},
+		{"org editor deletes", "Editor1", "dev", ActionDelete, &owned, ErrForbidden},
+		{"org admin deletes", "Admin1", "dev", ActionDelete, &owned, nil},
+		{"org viewer updates", "Viewer1", "dev", ActionUpdate, &owned, ErrForbidden},
+		{"org viewer reads", "Viewer1", "dev", ActionRead, &owned, nil},
+		{"author outside the org", "User1", "dev", ActionUpdate, &owned, ErrForbidden},
+		{"missing org", "User1", "dev", ActionUpdate, &orphan, ErrNotFound},
+	}
+	for _, test := range tests {
+		err := Authorize(test.userID, test.role, test.action, test.game, &orgDB)
+		if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+			t.Errorf("%s: expected %v got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestGameChangesNeedPermission(t *testing.T) {
+	db.Init("Test", "ID")
+	orgDB.Init("Organizations", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	edited := createTestGame("Game1", "User2")
+	edited.Description = "Edited"
+
+	tests := []struct {
+		name   string
+		change func() error
+		want   error
+	}{
+		{"update by another developer", func() error { return UpdateGame("Game1", "User2", "dev", edited, &orgDB, &db) }, ErrForbidden},
+		{"update of a missing game", func() error { return UpdateGame("Missing", "User1", "dev", edited, &orgDB, &db) }, ErrNotFound},
+		{"delete by another developer", func() error { return DeleteGame("Game1", "User2", "dev", &orgDB, &db) }, ErrForbidden},
+		{"delete of a missing game", func() error { return DeleteGame("Missing", "User1", "dev", &orgDB, &db) }, ErrNotFound},
+		{"update note by another developer", func() error {
+			return CreateUpdate("Game1", "User2", "dev", structs.Update{ID: "Update1"}, &orgDB, &db)
+		}, ErrForbidden},
+		{"discount by another developer", func() error {
+			return CreateDiscount("Game1", "User2", "dev", structs.Discount{ID: "D1", Type: structs.DiscountPercent, Value: 10}, &orgDB, &db)
+		}, ErrForbidden},
+		{"transfer by another developer", func() error { return TransferGame("Game1", "User2", "dev", "Org1", &orgDB, &db) }, ErrForbidden},
+	}
+	for _, test := range tests {
+		err := test.change()
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v got %v", test.name, test.want, err)
+		}
+	}
+	// none of them went through
+	game, _ := GetGame("Game1", &db)
+	simpleAssert(t, "TestDescription", game.Description)
+	simpleAssert(t, false, game.IsDeleted())
+	simpleAssert(t, 0, len(game.Updates))
+	simpleAssert(t, 0, len(game.Discounts))
+
+	simpleAssert(t, nil, UpdateGame("Game1", "User1", "dev", edited, &orgDB, &db))
+	game, _ = GetGame("Game1", &db)
+	simpleAssert(t, "Edited", game.Description)
+	simpleAssert(t, "User1", game.AuthorID)
+	simpleAssert(t, nil, DeleteGame("Game1", "User1", "dev", &orgDB, &db))
+}
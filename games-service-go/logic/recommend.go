@@ -0,0 +1,121 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	recommend "github.com/Draupniyr/games-service/recommend"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+// ----------------- Wishlist -----------------
+
+// GetWishlist returns the user's wishlist, empty if they never added anything
+func GetWishlist(userID string, wishlistDB database.Repository[structs.Wishlist]) (*structs.Wishlist, error) {
+	wishlist, err := wishlistDB.Get(userID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return &structs.Wishlist{ID: userID, UserID: userID, GameIDs: []string{}}, nil
+	}
+	return &wishlist, nil
+}
+
+// AddToWishlist adds the game and publishes wishlist.added for the
+// analytics. The wishlist is put back if the event can't be sent, so the
+// numbers don't miss it.
+func AddToWishlist(userID string, gameID string, wishlistDB database.Repository[structs.Wishlist], db database.Repository[structs.Game], kafka kafka.KafkaProducer) (*structs.Wishlist, error) {
+	if game, err := GetGame(gameID, db); err != nil || game.IsDeleted() {
+		return nil, ErrGameNotFound
+	}
+	wishlist, err := GetWishlist(userID, wishlistDB)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(wishlist.GameIDs, gameID) {
+		return wishlist, nil
+	}
+	previous := *wishlist
+	wishlist.GameIDs = append(slices.Clone(wishlist.GameIDs), gameID)
+	return saveWishlist(*wishlist, previous, gameID, events.TopicWishlistAdded, wishlistDB, kafka)
+}
+
+func RemoveFromWishlist(userID string, gameID string, wishlistDB database.Repository[structs.Wishlist], kafka kafka.KafkaProducer) (*structs.Wishlist, error) {
+	wishlist, err := GetWishlist(userID, wishlistDB)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(wishlist.GameIDs, gameID) {
+		return wishlist, nil
+	}
+	previous := *wishlist
+	wishlist.GameIDs = slices.DeleteFunc(slices.Clone(wishlist.GameIDs), func(id string) bool { return id == gameID })
+	return saveWishlist(*wishlist, previous, gameID, events.TopicWishlistRemoved, wishlistDB, kafka)
+}
+
+func saveWishlist(wishlist structs.Wishlist, previous structs.Wishlist, gameID string, topic string, wishlistDB database.Repository[structs.Wishlist], kafka kafka.KafkaProducer) (*structs.Wishlist, error) {
+	wishlist.Updated = time.Now().Format(time.RFC3339)
+	err := wishlistDB.Put(wishlist)
+	if err != nil {
+		return nil, err
+	}
+	changeJson, err := json.Marshal(events.Wishlist{
+		ID:     uuid.New().String(),
+		UserID: wishlist.UserID,
+		GameID: gameID,
+		Date:   wishlist.Updated,
+	})
+	if err == nil {
+		err = kafka.Publish(topic, gameID, changeJson)
+	}
+	if err != nil {
+		wishlistDB.Put(previous)
+		return nil, err
+	}
+	return &wishlist, nil
+}
+
+// ----------------- Recommendations -----------------
+
+// RecommendCatalog gives the engine every game in the database to recommend from
+func RecommendCatalog(engine *recommend.Engine, db database.Repository[structs.Game]) error {
+	games, err := GetAllGames(db)
+	if err != nil {
+		return err
+	}
+	engine.SetCatalog(games)
+	return nil
+}
+
+// GetRecommendedGames returns games for the user from what they own and
+// have on their wishlist
+func GetRecommendedGames(userID string, limit int, engine *recommend.Engine, wishlistDB database.Repository[structs.Wishlist]) ([]structs.Game, error) {
+	wishlist, err := GetWishlist(userID, wishlistDB)
+	if err != nil {
+		return nil, err
+	}
+	return scoredGames(engine.Recommend(userID, wishlist.GameIDs, limit), engine), nil
+}
+
+// MoreLikeThis returns the games most like the game
+func MoreLikeThis(gameID string, limit int, engine *recommend.Engine) []structs.Game {
+	return scoredGames(engine.Similar(gameID, limit), engine)
+}
+
+func scoredGames(scored []recommend.Scored, engine *recommend.Engine) []structs.Game {
+	games := []structs.Game{}
+	for _, s := range scored {
+		if game, ok := engine.Game(s.GameID); ok {
+			games = append(games, game)
+		}
+	}
+	return games
+}
@@ -0,0 +1,88 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/games-service/database"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	recommend "github.com/Draupniyr/games-service/recommend"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestWishlist(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"))
+	db.Items = append(db.Items, createTestGame("Game2", "User1"))
+	wishlistDB := database.Memory[structs.Wishlist]{}
+	wishlistDB.Init("Wishlists", "ID")
+
+	wishlist, err := GetWishlist("User2", &wishlistDB)
+	if err != nil {
+		t.Errorf("Error getting wishlist: %v", err)
+	}
+	simpleAssert(t, 0, len(wishlist.GameIDs))
+
+	// an event for each change, none when nothing changes
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	k := kafka.KafkaProducer{Producer: producer}
+	_, err = AddToWishlist("User2", "Missing", &wishlistDB, &db, k)
+	simpleAssert(t, ErrGameNotFound, err)
+	AddToWishlist("User2", "Game1", &wishlistDB, &db, k)
+	AddToWishlist("User2", "Game2", &wishlistDB, &db, k)
+	wishlist, _ = AddToWishlist("User2", "Game1", &wishlistDB, &db, k)
+	simpleAssert(t, 2, len(wishlist.GameIDs))
+
+	wishlist, _ = RemoveFromWishlist("User2", "Game1", &wishlistDB, k)
+	simpleAssert(t, 1, len(wishlist.GameIDs))
+	wishlist, _ = GetWishlist("User2", &wishlistDB)
+	simpleAssert(t, "Game2", wishlist.GameIDs[0])
+
+	// the change is undone when the event can't be sent
+	producer = mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	_, err = AddToWishlist("User2", "Game1", &wishlistDB, &db, kafka.KafkaProducer{Producer: producer})
+	simpleAssert(t, sarama.ErrOutOfBrokers, err)
+	wishlist, _ = GetWishlist("User2", &wishlistDB)
+	simpleAssert(t, 1, len(wishlist.GameIDs))
+}
+
+func TestRecommendedGames(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Tags = []string{"Puzzle"}
+	db.Items = append(db.Items, game)
+	game = createTestGame("Game2", "User1")
+	game.Tags = []string{"Puzzle"}
+	db.Items = append(db.Items, game)
+	game = createTestGame("Game3", "User1")
+	game.Tags = []string{"Racing"}
+	db.Items = append(db.Items, game)
+	wishlistDB := database.Memory[structs.Wishlist]{}
+	wishlistDB.Init("Wishlists", "ID")
+
+	engine := recommend.NewEngine()
+	err := RecommendCatalog(engine, &db)
+	if err != nil {
+		t.Errorf("Error loading catalog: %v", err)
+	}
+	similar := MoreLikeThis("Game1", 5, engine)
+	simpleAssert(t, 1, len(similar))
+	simpleAssert(t, "Game2", similar[0].ID)
+
+	// the wishlist is what the recommendations go on for a new user
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	AddToWishlist("User2", "Game2", &wishlistDB, &db, kafka.KafkaProducer{Producer: producer})
+	games, err := GetRecommendedGames("User2", 5, engine, &wishlistDB)
+	if err != nil {
+		t.Errorf("Error getting recommendations: %v", err)
+	}
+	simpleAssert(t, 1, len(games))
+	simpleAssert(t, "Game1", games[0].ID)
+}
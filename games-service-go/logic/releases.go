@@ -0,0 +1,127 @@
+package logic
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrInvalidReleaseTime = invalid("the release time must look like 2024-01-31T18:00")
+	ErrAlreadyReleased    = conflict("the game is already out and can't be moved to a later release")
+	ErrNotReleased        = forbidden("the game isn't out yet, the download unlocks at the release")
+)
+
+// releaseTimeFormats are RFC 3339 and what a datetime-local input sends,
+// which is taken as UTC
+var releaseTimeFormats = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02T15:04:05"}
+
+// ----------------- Releases -----------------
+
+// scheduleRelease works out the release state from ReleaseAt. A game with a
+// release time still to come is upcoming, one without or with a past one is
+// out. A game that is out stays out.
+func scheduleRelease(game *structs.Game, previous *structs.Game, now time.Time) error {
+	if game.ReleaseAt == "" {
+		if previous != nil && !previous.IsReleased() {
+			// keep the schedule when the form leaves it alone
+			game.ReleaseAt = previous.ReleaseAt
+		} else {
+			game.ReleaseState = structs.ReleaseReleased
+			return nil
+		}
+	}
+	releaseAt, err := parseReleaseTime(game.ReleaseAt)
+	if err != nil {
+		return err
+	}
+	game.ReleaseAt = releaseAt.Format(time.RFC3339)
+	game.ReleaseDate = releaseAt.Format(time.DateOnly)
+	if !releaseAt.After(now) {
+		game.ReleaseState = structs.ReleaseReleased
+		return nil
+	}
+	if previous != nil && previous.IsReleased() {
+		return ErrAlreadyReleased
+	}
+	game.ReleaseState = structs.ReleaseUpcoming
+	return nil
+}
+
+func parseReleaseTime(value string) (time.Time, error) {
+	for _, format := range releaseTimeFormats {
+		releaseAt, err := time.Parse(format, value)
+		if err == nil {
+			return releaseAt.UTC(), nil
+		}
+	}
+	return time.Time{}, ErrInvalidReleaseTime
+}
+
+// GetUpcomingGames returns the games still to come, soonest first
+func GetUpcomingGames(db database.Repository[structs.Game]) ([]structs.Game, error) {
+	games, err := GetAllGames(db)
+	if err != nil {
+		return nil, err
+	}
+	upcoming := []structs.Game{}
+	for _, game := range games {
+		if !game.IsReleased() {
+			upcoming = append(upcoming, game)
+		}
+	}
+	sort.Slice(upcoming, func(i, j int) bool {
+		if upcoming[i].ReleaseAt != upcoming[j].ReleaseAt {
+			return upcoming[i].ReleaseAt < upcoming[j].ReleaseAt
+		}
+		return upcoming[i].ID < upcoming[j].ID
+	})
+	return upcoming, nil
+}
+
+// ReleaseDueGames releases the upcoming games whose time has come and
+// publishes game.released for each. The event goes out before the game is
+// saved as released, so a failed save means it is sent again on the next run
+// rather than never. Every replica runs the job, so readers of the topic
+// should expect the odd repeat.
+func ReleaseDueGames(now time.Time, db database.Repository[structs.Game], kafka kafka.KafkaProducer) ([]structs.Game, error) {
+	upcoming, err := GetUpcomingGames(db)
+	if err != nil {
+		return nil, err
+	}
+	released := []structs.Game{}
+	for _, game := range upcoming {
+		releaseAt, err := parseReleaseTime(game.ReleaseAt)
+		if err != nil || releaseAt.After(now) {
+			continue
+		}
+		releasedJson, err := json.Marshal(events.Released{
+			GameID:      game.ID,
+			Slug:        game.Slug,
+			Title:       game.Title,
+			AuthorID:    game.AuthorID,
+			ReleaseAt:   game.ReleaseAt,
+			PreOrders:   game.PreOrders,
+			EarlyAccess: game.EarlyAccess,
+		})
+		if err != nil {
+			return released, err
+		}
+		err = kafka.Publish(events.TopicGameReleased, game.ID, releasedJson)
+		if err != nil {
+			return released, err
+		}
+		game.ReleaseState = structs.ReleaseReleased
+		err = db.Put(game)
+		if err != nil {
+			return released, err
+		}
+		released = append(released, game)
+	}
+	return released, nil
+}
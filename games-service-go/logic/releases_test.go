@@ -0,0 +1,108 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestScheduledRelease(t *testing.T) {
+	db.Init("Test", "ID")
+	later := time.Now().Add(48 * time.Hour).UTC()
+	game := createTestGame("Game1", "User1")
+	game.ReleaseAt = "tomorrow"
+	simpleAssert(t, ErrInvalidReleaseTime, CreateGame(game, &db))
+	game.ReleaseAt = later.Format("2006-01-02T15:04")
+	game.PreOrders = true
+	err := CreateGame(game, &db)
+	if err != nil {
+		t.Fatalf("Error creating game: %v", err)
+	}
+	saved, _ := GetGame("Game1", &db)
+	simpleAssert(t, false, saved.IsReleased())
+	simpleAssert(t, true, saved.CanPreOrder())
+	simpleAssert(t, later.Format(time.DateOnly), saved.ReleaseDate)
+	simpleAssert(t, later.Truncate(time.Minute).Format(time.RFC3339), saved.ReleaseAt)
+
+	// An update without a release time keeps the schedule.
+	update := createTestGame("", "User1")
+	update.Title = "Renamed"
+	UpdateGame("Game1", "User1", "dev", update, &orgDB, &db)
+	saved, _ = GetGame("Game1", &db)
+	simpleAssert(t, structs.ReleaseUpcoming, saved.ReleaseState)
+
+	// Games without a release time come out right away and can't be pushed
+	// back once out.
+	CreateGame(createTestGame("Game2", "User1"), &db)
+	saved, _ = GetGame("Game2", &db)
+	simpleAssert(t, true, saved.IsReleased())
+	update = createTestGame("", "User1")
+	update.ReleaseAt = later.Format(time.RFC3339)
+	simpleAssert(t, ErrAlreadyReleased, UpdateGame("Game2", "User1", "dev", update, &orgDB, &db))
+
+	upcoming, _ := GetUpcomingGames(&db)
+	simpleAssert(t, 1, len(upcoming))
+	simpleAssert(t, "Game1", upcoming[0].ID)
+}
+
+func TestReleaseDueGames(t *testing.T) {
+	db.Init("Test", "ID")
+	now := time.Now().UTC()
+	due := createTestGame("Game1", "User1")
+	due.ReleaseState = structs.ReleaseUpcoming
+	due.ReleaseAt = now.Add(-time.Minute).Format(time.RFC3339)
+	notYet := createTestGame("Game2", "User1")
+	notYet.ReleaseState = structs.ReleaseUpcoming
+	notYet.ReleaseAt = now.Add(time.Hour).Format(time.RFC3339)
+	db.Items = append(db.Items, due, notYet, createTestGame("Game3", "User1"))
+
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != events.TopicGameReleased {
+			t.Errorf("Expected topic %s got %s", events.TopicGameReleased, msg.Topic)
+		}
+		return nil
+	})
+	released, err := ReleaseDueGames(now, &db, kafka.KafkaProducer{Producer: producer})
+	if err != nil {
+		t.Fatalf("Error releasing games: %v", err)
+	}
+	simpleAssert(t, 1, len(released))
+	saved, _ := GetGame("Game1", &db)
+	simpleAssert(t, true, saved.IsReleased())
+	saved, _ = GetGame("Game2", &db)
+	simpleAssert(t, false, saved.IsReleased())
+
+	// Nothing is due on the next run.
+	released, _ = ReleaseDueGames(now, &db, kafka.KafkaProducer{Producer: mocks.NewSyncProducer(t, nil)})
+	simpleAssert(t, 0, len(released))
+}
+
+func TestPreOrderDownloads(t *testing.T) {
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.ReleaseState = structs.ReleaseUpcoming
+	game.ReleaseAt = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	game.PreOrders = true
+	db.Items = append(db.Items, game)
+	libraryDB := database.Memory[structs.LibraryEntry]{}
+	libraryDB.Init("Library", "ID")
+
+	// The pre-order is in the library but can't be downloaded until the
+	// release, except by the developer.
+	ApplyLibraryEvent(events.TopicCheckout, []byte(`{"ID":"Order1","UserID":"User2","Games":[{"ID":"Game1"}]}`), &libraryDB, &db)
+	simpleAssert(t, true, OwnsGame("User2", "Game1", &libraryDB))
+	simpleAssert(t, ErrNotReleased, CanDownload("User2", "Game1", &orgDB, &libraryDB, &db))
+	simpleAssert(t, nil, CanDownload("User1", "Game1", &orgDB, &libraryDB, &db))
+
+	game.ReleaseState = structs.ReleaseReleased
+	db.Put(game)
+	simpleAssert(t, nil, CanDownload("User2", "Game1", &orgDB, &libraryDB, &db))
+}
@@ -0,0 +1,205 @@
+package logic
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var (
+	ErrTagNotFound = notFound("tag not found")
+	ErrTagExists   = conflict("a tag with that name or alias already exists")
+	ErrInvalidTag  = invalid("a tag needs a name and a category of genre, feature or theme")
+)
+
+// ----------------- Tags -----------------
+
+// GetTags returns the curated tags by category, then name
+func GetTags(tagDB database.Repository[structs.Tag]) ([]structs.Tag, error) {
+	tags, err := tagDB.Scan()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Category != tags[j].Category {
+			return tags[i].Category < tags[j].Category
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func GetTag(ID string, tagDB database.Repository[structs.Tag]) (*structs.Tag, error) {
+	tag, err := tagDB.Get(ID)
+	if err != nil {
+		return nil, missing(err, ErrTagNotFound)
+	}
+	return &tag, nil
+}
+
+func CreateTag(adminID string, request structs.TagRequest, tagDB database.Repository[structs.Tag]) (*structs.Tag, error) {
+	tag := structs.Tag{
+		ID:        structs.TagID(request.Name),
+		Name:      request.Name,
+		Aliases:   cleanAliases(request.Name, request.Aliases),
+		Category:  request.Category,
+		Created:   time.Now().Format(time.RFC3339),
+		CreatedBy: adminID,
+	}
+	if _, err := GetTag(tag.ID, tagDB); err == nil {
+		return nil, ErrTagExists
+	}
+	err := validateTag(tag, tagDB)
+	if err != nil {
+		return nil, err
+	}
+	err = tagDB.Put(tag)
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// UpdateTag changes a tag. A renamed tag keeps its old name as an alias so
+// games still carrying it get folded into the new one.
+func UpdateTag(ID string, request structs.TagRequest, tagDB database.Repository[structs.Tag]) (*structs.Tag, error) {
+	tag, err := GetTag(ID, tagDB)
+	if err != nil {
+		return nil, err
+	}
+	aliases := request.Aliases
+	if !strings.EqualFold(tag.Name, request.Name) {
+		aliases = append(aliases, tag.Name)
+	}
+	tag.Name = request.Name
+	tag.Aliases = cleanAliases(request.Name, aliases)
+	tag.Category = request.Category
+	err = validateTag(*tag, tagDB)
+	if err != nil {
+		return nil, err
+	}
+	err = tagDB.Put(*tag)
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func DeleteTag(ID string, tagDB database.Repository[structs.Tag]) error {
+	_, err := GetTag(ID, tagDB)
+	if err != nil {
+		return err
+	}
+	return tagDB.Delete(ID)
+}
+
+// validateTag checks the category and that no other tag already uses the
+// name or one of the aliases
+func validateTag(tag structs.Tag, tagDB database.Repository[structs.Tag]) error {
+	if tag.ID == "" || !slices.Contains(structs.TagCategories, tag.Category) {
+		return ErrInvalidTag
+	}
+	tags, err := GetTags(tagDB)
+	if err != nil {
+		return err
+	}
+	names := append([]string{tag.Name}, tag.Aliases...)
+	for _, other := range tags {
+		if other.ID == tag.ID {
+			continue
+		}
+		for _, name := range names {
+			if structs.TagID(name) == other.ID || containsFold(other.Aliases, name) || strings.EqualFold(other.Name, name) {
+				return ErrTagExists
+			}
+		}
+	}
+	return nil
+}
+
+// cleanAliases trims the aliases and drops empty ones, repeats and the name
+func cleanAliases(name string, aliases []string) []string {
+	cleaned := []string{}
+	for _, alias := range uniqueTags(aliases) {
+		if !strings.EqualFold(alias, name) {
+			cleaned = append(cleaned, alias)
+		}
+	}
+	return cleaned
+}
+
+// ----------------- Normalization -----------------
+
+// tagLookup maps every name and alias, lower cased, to the canonical name
+func tagLookup(tagDB database.Repository[structs.Tag]) (map[string]string, error) {
+	tags, err := GetTags(tagDB)
+	if err != nil {
+		return nil, err
+	}
+	lookup := map[string]string{}
+	for _, tag := range tags {
+		lookup[strings.ToLower(tag.Name)] = tag.Name
+		lookup[tag.ID] = tag.Name
+		for _, alias := range tag.Aliases {
+			lookup[strings.ToLower(alias)] = tag.Name
+		}
+	}
+	return lookup, nil
+}
+
+// NormalizeTags swaps every tag for its canonical name and drops repeats.
+// Tags that aren't curated are kept as they were typed, trimmed.
+func NormalizeTags(tags []string, tagDB database.Repository[structs.Tag]) ([]string, error) {
+	lookup, err := tagLookup(tagDB)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeTags(tags, lookup), nil
+}
+
+func normalizeTags(tags []string, lookup map[string]string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if canonical, ok := lookup[strings.ToLower(tag)]; ok {
+			tag = canonical
+		} else if canonical, ok := lookup[structs.TagID(tag)]; ok {
+			tag = canonical
+		}
+		normalized = append(normalized, tag)
+	}
+	return uniqueTags(normalized)
+}
+
+// ----------------- Migrations -----------------
+
+// MigrateTags folds the tags on every game into the curated ones. Returns how
+// many games changed.
+func MigrateTags(db database.Repository[structs.Game], tagDB database.Repository[structs.Tag]) (int, error) {
+	lookup, err := tagLookup(tagDB)
+	if err != nil {
+		return 0, err
+	}
+	games, err := getGamesWithTrash(db)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, game := range games {
+		tags := normalizeTags(game.Tags, lookup)
+		if slices.Equal(tags, game.Tags) {
+			continue
+		}
+		game.Tags = tags
+		err = db.Put(game)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
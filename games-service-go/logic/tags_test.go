@@ -0,0 +1,71 @@
+package logic
+
+import (
+	"testing"
+
+	database "github.com/Draupniyr/games-service/database"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestTagCRUD(t *testing.T) {
+	tagDB := database.Memory[structs.Tag]{}
+	tagDB.Init("Tags", "ID")
+
+	tag, err := CreateTag("Admin", structs.TagRequest{Name: "Role-Playing", Aliases: []string{"RPG", "rpg", " "}, Category: structs.TagGenre}, &tagDB)
+	if err != nil {
+		t.Fatalf("Error creating tag: %v", err)
+	}
+	simpleAssert(t, "role-playing", tag.ID)
+	simpleAssert(t, 1, len(tag.Aliases))
+
+	// names and aliases can't be used twice
+	_, err = CreateTag("Admin", structs.TagRequest{Name: "rpg", Category: structs.TagGenre}, &tagDB)
+	simpleAssert(t, ErrTagExists, err)
+	_, err = CreateTag("Admin", structs.TagRequest{Name: "Role Playing", Category: structs.TagGenre}, &tagDB)
+	simpleAssert(t, ErrTagExists, err)
+	_, err = CreateTag("Admin", structs.TagRequest{Name: "Co-op", Category: "mood"}, &tagDB)
+	simpleAssert(t, ErrInvalidTag, err)
+
+	// a renamed tag keeps the old name as an alias
+	tag, err = UpdateTag("role-playing", structs.TagRequest{Name: "RPG Adventure", Aliases: []string{"RPG"}, Category: structs.TagGenre}, &tagDB)
+	if err != nil {
+		t.Fatalf("Error updating tag: %v", err)
+	}
+	simpleAssert(t, 2, len(tag.Aliases))
+	simpleAssert(t, "Role-Playing", tag.Aliases[1])
+
+	simpleAssert(t, nil, DeleteTag("role-playing", &tagDB))
+	simpleAssert(t, ErrTagNotFound, DeleteTag("role-playing", &tagDB))
+}
+
+func TestNormalizeAndMigrateTags(t *testing.T) {
+	tagDB := database.Memory[structs.Tag]{}
+	tagDB.Init("Tags", "ID")
+	CreateTag("Admin", structs.TagRequest{Name: "Role-Playing", Aliases: []string{"RPG"}, Category: structs.TagGenre}, &tagDB)
+	CreateTag("Admin", structs.TagRequest{Name: "Co-op", Aliases: []string{"Cooperative"}, Category: structs.TagFeature}, &tagDB)
+
+	tags, err := NormalizeTags([]string{"rpg", "Role Playing", " cooperative", "Indie"}, &tagDB)
+	if err != nil {
+		t.Errorf("Error normalizing tags: %v", err)
+	}
+	simpleAssert(t, 3, len(tags))
+	simpleAssert(t, "Role-Playing", tags[0])
+	simpleAssert(t, "Co-op", tags[1])
+	simpleAssert(t, "Indie", tags[2])
+
+	db.Init("Test", "ID")
+	game := createTestGame("Game1", "User1")
+	game.Tags = []string{"RPG", "co-op"}
+	db.Items = append(db.Items, game)
+	game = createTestGame("Game2", "User1")
+	game.Tags = []string{"Role-Playing"}
+	db.Items = append(db.Items, game)
+
+	migrated, err := MigrateTags(&db, &tagDB)
+	if err != nil {
+		t.Errorf("Error migrating tags: %v", err)
+	}
+	simpleAssert(t, 1, migrated)
+	simpleAssert(t, "Role-Playing", db.Items[0].Tags[0])
+	simpleAssert(t, "Co-op", db.Items[0].Tags[1])
+}
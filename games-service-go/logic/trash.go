@@ -0,0 +1,144 @@
+package logic
+
+import (
+	"sort"
+	"time"
+
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	database "github.com/Draupniyr/games-service/database"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+var ErrNotInTrash = conflict("game isn't in the trash")
+
+// TrashRetention is how long deleted games can be restored before the purge
+// job takes them out of the trash
+var TrashRetention = 30 * 24 * time.Hour
+
+// TrashedGame is a game in the trash with when it will be purged
+type TrashedGame struct {
+	structs.Game
+	PurgeAt string
+}
+
+// ----------------- Trash -----------------
+
+func trashGame(game structs.Game, userID string, now time.Time, db database.Repository[structs.Game]) error {
+	game.DeletedAt = now.UTC().Format(time.RFC3339)
+	game.DeletedBy = userID
+	return db.Put(game)
+}
+
+// GetTrash returns the deleted games that can still be restored, the most
+// recently deleted first
+func GetTrash(db database.Repository[structs.Game]) ([]TrashedGame, error) {
+	games, err := getGamesWithTrash(db)
+	if err != nil {
+		return nil, err
+	}
+	trash := []TrashedGame{}
+	for _, game := range games {
+		if !game.IsDeleted() || game.PurgedAt != "" {
+			continue
+		}
+		purgeAt := ""
+		if deleted, err := time.Parse(time.RFC3339, game.DeletedAt); err == nil {
+			purgeAt = deleted.Add(TrashRetention).Format(time.RFC3339)
+		}
+		trash = append(trash, TrashedGame{Game: game, PurgeAt: purgeAt})
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		if trash[i].DeletedAt != trash[j].DeletedAt {
+			return trash[i].DeletedAt > trash[j].DeletedAt
+		}
+		return trash[i].ID < trash[j].ID
+	})
+	return trash, nil
+}
+
+// RestoreGame takes the game out of the trash, as it was when deleted
+func RestoreGame(ID string, db database.Repository[structs.Game]) (*structs.Game, error) {
+	game, err := GetGame(ID, db)
+	if err != nil {
+		return nil, err
+	}
+	if game.ID != ID {
+		return nil, ErrGameNotFound
+	}
+	if !game.IsDeleted() || game.PurgedAt != "" {
+		return nil, ErrNotInTrash
+	}
+	game.DeletedAt = ""
+	game.DeletedBy = ""
+	err = db.Put(*game)
+	if err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+// PurgeDeletedGames takes the games deleted more than TrashRetention ago out
+// of the trash. Games nobody owns are removed with their media and builds.
+// Games in someone's library are kept, marked purged, so they stay in it and
+// can still be downloaded. Bundles are always kept, refunds need to know
+// what was in them. Returns the purged games.
+func PurgeDeletedGames(now time.Time, mediaStore blobstore.Store, buildStore blobstore.Store, libraryDB database.Repository[structs.LibraryEntry], buildDB database.Repository[structs.Build], db database.Repository[structs.Game]) ([]structs.Game, error) {
+	games, err := getGamesWithTrash(db)
+	if err != nil {
+		return nil, err
+	}
+	purged := []structs.Game{}
+	for _, game := range games {
+		if !game.IsDeleted() || game.PurgedAt != "" {
+			continue
+		}
+		deleted, err := time.Parse(time.RFC3339, game.DeletedAt)
+		if err == nil && deleted.Add(TrashRetention).After(now) {
+			continue
+		}
+		if game.IsBundle() || isOwned(game.ID, libraryDB) {
+			game.PurgedAt = now.UTC().Format(time.RFC3339)
+			err = db.Put(game)
+		} else {
+			err = removeGame(game, mediaStore, buildStore, buildDB, db)
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged = append(purged, game)
+	}
+	return purged, nil
+}
+
+// isOwned reports if the game is in anyone's library
+func isOwned(gameID string, libraryDB database.Repository[structs.LibraryEntry]) bool {
+	entries, err := libraryDB.Query("GameID", gameID)
+	if err != nil {
+		// keep the game when there is no telling
+		return true
+	}
+	for _, entry := range entries {
+		if entry.Owned() {
+			return true
+		}
+	}
+	return false
+}
+
+func removeGame(game structs.Game, mediaStore blobstore.Store, buildStore blobstore.Store, buildDB database.Repository[structs.Build], db database.Repository[structs.Game]) error {
+	builds, err := GetBuilds(game.ID, buildDB)
+	if err != nil {
+		return err
+	}
+	for _, build := range builds {
+		err = buildDB.Delete(build.ID)
+		if err != nil {
+			return err
+		}
+		buildStore.Delete(build.Key)
+	}
+	for _, media := range game.Media {
+		deleteBlobs(media, mediaStore)
+	}
+	return db.Delete(game.ID)
+}
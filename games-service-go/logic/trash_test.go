@@ -0,0 +1,88 @@
+package logic
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama/mocks"
+
+	blobstore "github.com/Draupniyr/games-service/blobstore"
+	database "github.com/Draupniyr/games-service/database"
+	events "github.com/Draupniyr/games-service/events"
+	kafka "github.com/Draupniyr/games-service/kafka"
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func TestTrash(t *testing.T) {
+	db.Init("Test", "ID")
+	db.Items = append(db.Items, createTestGame("Game1", "User1"), createTestGame("Game2", "User1"), createTestGame("Game3", "User1"))
+	db.Items = append(db.Items, createTestBundle("Bundle1", "Game1", "Game2"))
+	libraryDB := database.Memory[structs.LibraryEntry]{}
+	libraryDB.Init("Library", "ID")
+	buildDB := database.Memory[structs.Build]{}
+	buildDB.Init("Builds", "ID")
+	store, _ := blobstore.NewLocalStore(t.TempDir(), "/games/media")
+	_, err := UploadBuild("Game2", "User1", "dev", structs.BuildRequest{Version: "1.0.0", Platform: "Windows", FileName: "game.zip"}, strings.NewReader("game"), store, &buildDB, &orgDB, &db)
+	if err != nil {
+		t.Fatalf("Error uploading build: %v", err)
+	}
+	ApplyLibraryEvent(events.TopicCheckout, []byte(`{"ID":"Order1","UserID":"User2","Games":[{"ID":"Game1"}]}`), &libraryDB, &db)
+
+	// deleted games are hidden from the listings and can't be changed
+	simpleAssert(t, nil, DeleteGame("Game1", "User1", "dev", &orgDB, &db))
+	simpleAssert(t, nil, DeleteGameByID("Game2", "Admin1", &db))
+	simpleAssert(t, ErrGameNotFound, DeleteGameByID("Game2", "Admin1", &db))
+	simpleAssert(t, ErrGameNotFound, DeleteGame("Game2", "User1", "dev", &orgDB, &db))
+	simpleAssert(t, ErrGameNotFound, UpdateGame("Game2", "User1", "dev", createTestGame("Game2", "User1"), &orgDB, &db))
+	games, _ := GetAllGames(&db)
+	simpleAssert(t, 2, len(games))
+	games, _ = GetGamesByAuthor("User1", &db)
+	simpleAssert(t, 2, len(games))
+	_, _, err = ResolveGame("Game1", &db)
+	simpleAssert(t, ErrGameNotFound, err)
+	wishlistDB := database.Memory[structs.Wishlist]{}
+	wishlistDB.Init("Wishlists", "ID")
+	_, err = AddToWishlist("User3", "Game1", &wishlistDB, &db, kafka.KafkaProducer{Producer: mocks.NewSyncProducer(t, nil)})
+	simpleAssert(t, ErrGameNotFound, err)
+
+	// owners keep it in their library
+	library, _ := GetLibrary("User2", &libraryDB, &db)
+	simpleAssert(t, 1, len(library))
+	simpleAssert(t, nil, CanDownload("User2", "Game1", &orgDB, &libraryDB, &db))
+
+	trash, _ := GetTrash(&db)
+	simpleAssert(t, 2, len(trash))
+	simpleAssert(t, "User1", trash[0].DeletedBy)
+	simpleAssert(t, "Admin1", trash[1].DeletedBy)
+	restored, err := RestoreGame("Game2", &db)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, false, restored.IsDeleted())
+	_, err = RestoreGame("Game2", &db)
+	simpleAssert(t, ErrNotInTrash, err)
+	games, _ = GetAllGames(&db)
+	simpleAssert(t, 3, len(games))
+
+	// nothing is purged before its time
+	simpleAssert(t, nil, DeleteGameByID("Game2", "Admin1", &db))
+	simpleAssert(t, nil, DeleteGameByID("Bundle1", "Admin1", &db))
+	purged, _ := PurgeDeletedGames(time.Now(), store, store, &libraryDB, &buildDB, &db)
+	simpleAssert(t, 0, len(purged))
+
+	// after it owned games and bundles are kept out of the trash, the rest is removed
+	purged, err = PurgeDeletedGames(time.Now().Add(TrashRetention+time.Hour), store, store, &libraryDB, &buildDB, &db)
+	simpleAssert(t, nil, err)
+	simpleAssert(t, 3, len(purged))
+	trash, _ = GetTrash(&db)
+	simpleAssert(t, 0, len(trash))
+	_, err = RestoreGame("Game1", &db)
+	simpleAssert(t, ErrNotInTrash, err)
+	library, _ = GetLibrary("User2", &libraryDB, &db)
+	simpleAssert(t, 1, len(library))
+	bundle, _ := GetGame("Bundle1", &db)
+	simpleAssert(t, "Bundle1", bundle.ID)
+	_, err = RestoreGame("Game2", &db)
+	simpleAssert(t, ErrGameNotFound, err)
+	builds, _ := GetBuilds("Game2", &buildDB)
+	simpleAssert(t, 0, len(builds))
+}
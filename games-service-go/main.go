@@ -1,24 +1,60 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"html/template"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 
 	auth "github.com/Draupniyr/games-service/auth"
+	blobstore "github.com/Draupniyr/games-service/blobstore"
 	database "github.com/Draupniyr/games-service/database"
 	structs "github.com/Draupniyr/games-service/structs"
 	logic "github.com/Draupniyr/games-service/logic"
+	problem "github.com/Draupniyr/games-service/problem"
+	search "github.com/Draupniyr/games-service/search"
+	recommend "github.com/Draupniyr/games-service/recommend"
+	analytics "github.com/Draupniyr/games-service/analytics"
+	events "github.com/Draupniyr/games-service/events"
+	kafkaClient "github.com/Draupniyr/games-service/kafka"
+	validate "github.com/Draupniyr/games-service/validate"
 )
 
-var db database.Database
+var db database.Table[structs.Game]
+var tagDB database.Table[structs.Tag]
+var wishlistDB database.Table[structs.Wishlist]
+var buildDB database.Table[structs.Build]
+var libraryDB database.Table[structs.LibraryEntry]
+var orgDB database.Table[structs.Organization]
+var auditDB database.Table[structs.AuditEntry]
 var consulClient *api.Client
+var mediaStore blobstore.Store
+
+// buildStore keeps the build files apart from the media, which is served to
+// anyone, so builds are only downloaded through a signed link
+var buildStore blobstore.Store
+var downloadKey []byte
+// producer is set by connectProducer, read it through kafkaProducer
+var producer kafkaClient.KafkaProducer
+var producerMu sync.RWMutex
+var searchIndex = search.NewIndex()
+var recommender = recommend.NewEngine()
+var analyticsStore = analytics.NewStore()
 
 func init() {
 
@@ -27,6 +63,73 @@ func init() {
 		log.Fatal("Error initializing database:", err)
 	} // Initialize the database connection   Hopefully
 
+	err = tagDB.Init("Tags", "ID")
+	if err != nil {
+		log.Fatal("Error initializing tag database:", err)
+	}
+
+	err = wishlistDB.Init("Wishlists", "ID")
+	if err != nil {
+		log.Fatal("Error initializing wishlist database:", err)
+	}
+
+	err = buildDB.Init("Builds", "ID")
+	if err != nil {
+		log.Fatal("Error initializing build database:", err)
+	}
+
+	err = libraryDB.Init("Library", "ID")
+	if err != nil {
+		log.Fatal("Error initializing library database:", err)
+	}
+
+	err = orgDB.Init("Organizations", "ID")
+	if err != nil {
+		log.Fatal("Error initializing organization database:", err)
+	}
+
+	err = auditDB.Init("AuditLog", "ID")
+	if err != nil {
+		log.Fatal("Error initializing audit database:", err)
+	}
+	err = auditDB.EnableTTL("ExpiresAt")
+	if err != nil {
+		log.Println("Error enabling TTL on", auditDB.TableName, ":", err)
+	}
+	if days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS")); err == nil {
+		logic.TrashRetention = time.Duration(days) * 24 * time.Hour
+	}
+	if days, err := strconv.Atoi(os.Getenv("AUDIT_RETENTION_DAYS")); err == nil {
+		logic.AuditRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+	mediaStore, err = blobstore.NewLocalStore(mediaDir, "/games/media")
+	if err != nil {
+		log.Fatal("Error initializing media store:", err)
+	}
+	buildDir := os.Getenv("BUILD_DIR")
+	if buildDir == "" {
+		buildDir = "builds"
+	}
+	builds, err := blobstore.NewLocalStore(buildDir, "/games/downloads")
+	if err != nil {
+		log.Fatal("Error initializing build store:", err)
+	}
+	// builds uploaded before BUILD_DIR existed are still under MEDIA_DIR/builds
+	buildStore = blobstore.Fallback{Store: builds, Old: mediaStore}
+
+	// every replica has to sign download links with the same key
+	downloadKey = []byte(os.Getenv("DOWNLOAD_SIGNING_KEY"))
+	if len(downloadKey) == 0 {
+		log.Println("DOWNLOAD_SIGNING_KEY is not set, download links only work on this replica")
+		downloadKey = make([]byte, 32)
+		rand.Read(downloadKey)
+	}
+
 	consulConfig := api.DefaultConfig()
 	consulConfig.Address = os.Getenv("CONSUL_ADDRESS")
 	consulClient, err = api.NewClient(consulConfig)
@@ -43,27 +146,79 @@ func main() {
 		log.Fatal("Error registering service with Consul:", err)
 	}
 
+	go connectProducer()
+
+	err = logic.IndexGames(searchIndex, &db)
+	if err != nil {
+		log.Println("Error building search index:", err)
+	}
+	err = logic.RecommendCatalog(recommender, &db)
+	if err != nil {
+		log.Println("Error loading games for recommendations:", err)
+	}
+	go runReindexJob()
+	go runReleaseJob()
+	go runPurgeJob()
+	go startEventConsumer()
+
 	http.HandleFunc("/games/getform", GamesFormHandler)
 	http.HandleFunc("/games/{id}", GamesHandlerID)
+	http.HandleFunc("/games/api/{id}", getGameAPI)
+	http.HandleFunc("/games/search", searchGames)
+	http.HandleFunc("/games/browse", browseGames)
 	http.HandleFunc("/games/search/{search}", getGamesBySearch)
 	http.HandleFunc("/games/author/{id}", getGamesByAuthor)
+	http.HandleFunc("/games/similar/{id}", getSimilarGames)
+	http.HandleFunc("/games/upcoming", getUpcomingGames)
+	http.HandleFunc("/games/media/{key...}", getMediaFile)
+	http.HandleFunc("/games/downloads/{id}", downloadBuild)
 
 	//http.HandleFunc("/{gameID}/{updateID}", GameUpdateHandler)
 
 	http.HandleFunc("/games", GamesHandler)
 
 	http.Handle("/games/library", auth.Authorize(http.HandlerFunc(getGamesByUserOwned)))
+	http.Handle("/games/recommended", auth.Authorize(http.HandlerFunc(getRecommendedGames)))
+	http.Handle("/games/wishlist", auth.Authorize(http.HandlerFunc(getWishlist)))
+	http.Handle("/games/wishlist/{id}", auth.Authorize(http.HandlerFunc(WishlistHandlerID)))
+	http.Handle("/games/builds/{id}/link", auth.Authorize(http.HandlerFunc(createDownloadLink)))
+	http.Handle("/games/invites", auth.Authorize(http.HandlerFunc(acceptInvite)))
 
 	// Developer endpoints
 	//http.Handle("/developer/games", auth.Authorize(http.HandlerFunc(getDeveloperGames)))
 	http.Handle("/games/dev/create", auth.Authorize(http.HandlerFunc(createGame)))
 	http.Handle("/games/dev/delete/{id}", auth.Authorize(http.HandlerFunc(deleteGameID)))
 	http.Handle("/games/dev/update/{id}", auth.Authorize(http.HandlerFunc(updateGameID)))
+	http.Handle("/games/dev/discounts/{id}", auth.Authorize(http.HandlerFunc(createDiscount), "dev", "admin"))
+	http.Handle("/games/dev/discounts/{id}/{discountID}", auth.Authorize(http.HandlerFunc(deleteDiscount), "dev", "admin"))
+	http.Handle("/games/dev/media/{id}", auth.Authorize(http.HandlerFunc(MediaHandler), "dev", "admin"))
+	http.Handle("/games/dev/media/{id}/{mediaID}", auth.Authorize(http.HandlerFunc(MediaHandlerID), "dev", "admin"))
+	http.Handle("/games/dev/builds/{id}", auth.Authorize(http.HandlerFunc(BuildsHandler), "dev", "admin"))
+	http.Handle("/games/dev/builds/{id}/{buildID}", auth.Authorize(http.HandlerFunc(deleteBuild), "dev", "admin"))
+	http.Handle("/games/dev/analytics", auth.Authorize(http.HandlerFunc(getAnalytics), "dev", "admin"))
+	http.Handle("/games/dev/analytics/data", auth.Authorize(http.HandlerFunc(getAnalyticsData), "dev", "admin"))
+	http.Handle("/games/dev/analytics/link", auth.Authorize(http.HandlerFunc(createExportLink), "dev", "admin"))
+	http.HandleFunc("/games/dev/analytics/export", exportAnalytics)
+	http.Handle("/games/orgs", auth.Authorize(http.HandlerFunc(OrgsHandler), "dev", "admin"))
+	http.Handle("/games/orgs/{id}", auth.Authorize(http.HandlerFunc(deleteOrganization), "dev", "admin"))
+	http.Handle("/games/orgs/{id}/invites", auth.Authorize(http.HandlerFunc(createInvite), "dev", "admin"))
+	http.Handle("/games/orgs/{id}/invites/{code}", auth.Authorize(http.HandlerFunc(revokeInvite), "dev", "admin"))
+	http.Handle("/games/orgs/{id}/members/{memberID}", auth.Authorize(http.HandlerFunc(MembersHandlerID), "dev", "admin"))
+	http.Handle("/games/orgs/{id}/games", auth.Authorize(http.HandlerFunc(transferGame), "dev", "admin"))
 
 	// Admin endpoints
 	http.Handle("/games/admin", auth.Authorize(http.HandlerFunc(getGamesAdmin), "admin"))
 	http.Handle("/games/admin/delete/{id}", auth.Authorize(http.HandlerFunc(deleteGameByGameID), "admin"))
+	http.Handle("/games/admin/trash", auth.Authorize(http.HandlerFunc(getTrash), "admin"))
+	http.Handle("/games/admin/trash/{id}/restore", auth.Authorize(http.HandlerFunc(restoreGame), "admin"))
 	http.Handle("/games/admin/approve/{id}", auth.Authorize(http.HandlerFunc(approveGameID), "admin"))
+	http.Handle("/games/admin/migrate/prices", auth.Authorize(http.HandlerFunc(migratePrices), "admin"))
+	http.Handle("/games/admin/migrate/slugs", auth.Authorize(http.HandlerFunc(migrateSlugs), "admin"))
+	http.Handle("/games/admin/migrate/tags", auth.Authorize(http.HandlerFunc(migrateTags), "admin"))
+	http.Handle("/games/admin/tags", auth.Authorize(http.HandlerFunc(TagsHandler), "admin"))
+	http.Handle("/games/admin/tags/{id}", auth.Authorize(http.HandlerFunc(TagsHandlerID), "admin"))
+	http.Handle("/games/admin/audit", auth.Authorize(http.HandlerFunc(getAuditLog), "admin"))
+	http.Handle("/games/admin/audit/data", auth.Authorize(http.HandlerFunc(getAuditLogData), "admin"))
 
 	log.Printf("Games service listening on port %d", port)
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), nil))
@@ -92,7 +247,7 @@ func registerService() error {
 }
 
 func GamesFormHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, "submitgameform.html", nil)
+	renderTemplate(w, r, "submitgameform.html", nil)
 }
 
 func GamesHandlerID(w http.ResponseWriter, r *http.Request) {
@@ -101,11 +256,11 @@ func GamesHandlerID(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		getGamesID(w, r)
 	case http.MethodDelete: // Dev
-		deleteGameID(w, r)
+		auth.Authorize(http.HandlerFunc(deleteGameID)).ServeHTTP(w, r)
 	case http.MethodPatch: // Dev
-		updateGameID(w, r)
+		auth.Authorize(http.HandlerFunc(patchGameID)).ServeHTTP(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -113,79 +268,169 @@ func GamesHandler(w http.ResponseWriter, r *http.Request) {
 	// Retrieve Games from DynamoDB
 	switch r.Method {
 	case http.MethodGet:
-		getGames(w, r)
+		browseGames(w, r)
 	case http.MethodPost: //DEV
-		createGame(w, r)
+		auth.Authorize(http.HandlerFunc(createGame)).ServeHTTP(w, r)
 	case http.MethodDelete: // ADMIN
-		deleteAllGame(w, r)
+		auth.Authorize(http.HandlerFunc(deleteAllGame), "admin").ServeHTTP(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func approveGameID(w http.ResponseWriter, r *http.Request) {
-	logic.UpdateGameField("Published", "Approved", "true", db)
+	id := getIDfromURL(r)
+	err := logic.UpdateGameField(id, "Approved", "true", &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.approve", "game", id, nil, nil)
 }
 
+// getGamesID is the game's detail page, found by its ID or slug. Slugs the
+// game had before a rename redirect to the current one.
 func getGamesID(w http.ResponseWriter, r *http.Request) {
-	id := getIDfromURL(r)
-	game, err := logic.GetGame(id, &db)
-	if err != nil {
-		log.Println("Error getting Game from database:", err)
-		http.Error(w, "Internal Server Error", http.StatusNotFound)
+	detail, moved, ok := getGameDetail(w, r)
+	if !ok {
+		return
+	}
+	if moved {
+		http.Redirect(w, r, detail.Path(), http.StatusMovedPermanently)
 		return
 	}
-	games := []structs.Game{*game}
-	// Render the template with the retrieved Games data
-	renderTemplate(w, "gameslist2.html", map[string]interface{}{
-		"Games": games,
+	renderTemplate(w, r, "gamedetail.html", map[string]interface{}{
+		"Game":     detail,
+		"Currency": requestCurrency(r),
 	})
 }
 
-func getGames(w http.ResponseWriter, r *http.Request) {
+// getGameAPI is the detail page's data as JSON
+func getGameAPI(w http.ResponseWriter, r *http.Request) {
+	detail, moved, ok := getGameDetail(w, r)
+	if !ok {
+		return
+	}
+	if moved {
+		http.Redirect(w, r, "/games/api/"+detail.Slug, http.StatusMovedPermanently)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
 
+func getGameDetail(w http.ResponseWriter, r *http.Request) (*structs.GameDetail, bool, bool) {
+	detail, moved, err := logic.GetGameDetail(r.PathValue("id"), &db)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false, false
+	}
+	return detail, moved, true
+}
 
-	// Filter the Games based on the search string
-	GamesToDisplay, err := logic.GetAllGames(&db)
+// browseGames is the store page narrowed down by the tag, match, min, max,
+// free, from, to, author and sort query parameters, with the facet counts.
+// The price parameter is a price facet picked on the page, "free" or a
+// "min-max" range, and takes the place of min and max when it is set.
+func browseGames(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prices := url.Values{"min": {query.Get("min")}, "max": {query.Get("max")}}
+	if price := query.Get("price"); price == "free" {
+		query.Set("free", "true")
+	} else if min, max, ok := strings.Cut(price, "-"); ok {
+		prices = url.Values{"min": {min}, "max": {max}}
+	}
+	filter := logic.BrowseFilter{
+		Tags:           query["tag"],
+		MatchAll:       query.Get("match") == "all",
+		FreeOnly:       query.Get("free") == "on" || query.Get("free") == "true",
+		ReleasedAfter:  query.Get("from"),
+		ReleasedBefore: query.Get("to"),
+		AuthorID:       query.Get("author"),
+		Currency:       requestCurrency(r),
+		Sort:           query.Get("sort"),
+	}
+	var err error
+	filter.MinPrice, err = queryPrice(prices, "min", filter.Currency)
 	if err != nil {
-		log.Println("Error getting Game from database:", err)
-		http.Error(w, "Internal Server Error", http.StatusNotFound)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	filter.MaxPrice, err = queryPrice(prices, "max", filter.Currency)
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	browse, err := logic.BrowseGames(filter, &db)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
+	renderStore(w, r, browse.Games, browse)
+}
+
+// renderGames shows the games on the store page with their DLC nested under
+// them and bundles listing what they contain
+func renderGames(w http.ResponseWriter, r *http.Request, games []structs.Game) {
+	renderStore(w, r, games, nil)
+}
 
-	renderTemplate(w, "gameslist2.html", map[string]interface{}{
-		"Games": GamesToDisplay,
+// renderStore is renderGames with the browse facets next to the games when
+// browse is set
+func renderStore(w http.ResponseWriter, r *http.Request, games []structs.Game, browse *logic.BrowseResult) {
+	games, err := logic.AttachRelations(games, &db)
+	if err != nil {
+		log.Println("Error getting related games from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "gameslist2.html", map[string]interface{}{
+		"Games":    games,
+		"Browse":   browse,
+		"Params":   r.URL.Query(),
+		"Currency": requestCurrency(r),
 	})
 }
 
+// getGamesByUserOwned is the user's library with the latest build of each
+// game for every platform and channel
 func getGamesByUserOwned(w http.ResponseWriter, r *http.Request) {
 	// Get the user ID from the request context
 	userIDValue := r.Context().Value("userID")
 	if userIDValue == nil {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	userID, ok := userIDValue.(string)
 	if !ok {
 		log.Println("User ID is not of type string")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	log.Print(userID)
 
-	// Get the Games owned by the user
-	// DOES NOT WORK BECAUSE USERS ARENT REALLY A THING THAT EXISTS
-	//GamesToDisplay, err := db.GetGamesByUser(userID)
-	//if err != nil {
-	//	log.Println("Error getting Game from database:", err)
-	//	http.Error(w, "Internal Server Error", http.StatusNotFound)
-	//	return
-	//}
+	games, err := logic.GetLibrary(userID, &libraryDB, &db)
+	if err != nil {
+		log.Println("Error getting library from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	builds := map[string][]structs.Build{}
+	for _, game := range games {
+		gameBuilds, err := logic.GetBuilds(game.ID, &buildDB)
+		if err != nil {
+			log.Println("Error getting builds from database:", err)
+			problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		builds[game.ID] = logic.LatestBuilds(gameBuilds)
+	}
 
-	renderTemplate(w, "gameslist2.html", map[string]interface{}{
-		"Games": nil,
+	renderTemplate(w, r, "library.html", map[string]interface{}{
+		"Games":  games,
+		"Builds": builds,
 	})
 }
 
@@ -194,12 +439,13 @@ func getGamesAdmin(w http.ResponseWriter, r *http.Request) {
 	GamesToDisplay, err := logic.GetAllGames(&db)
 	if err != nil {
 		log.Println("Error getting Game from database:", err)
-		http.Error(w, "Internal Server Error", http.StatusNotFound)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "admingameslist.html", map[string]interface{}{
-		"Games": GamesToDisplay,
+	renderTemplate(w, r, "admingameslist.html", map[string]interface{}{
+		"Games":    GamesToDisplay,
+		"Currency": requestCurrency(r),
 	})
 }
 
@@ -211,38 +457,227 @@ func getGamesByAuthor(w http.ResponseWriter, r *http.Request) {
 	GamesToDisplay, err := logic.GetGamesByAuthor(authorID, &db)
 	if err != nil {
 		log.Println("Error getting Game from database:", err)
-		http.Error(w, "Internal Server Error", http.StatusNotFound)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "gameslist2.html", map[string]interface{}{
-		"Games": GamesToDisplay,
-	})
+	renderGames(w, r, GamesToDisplay)
 }
 
+// getGamesBySearch is the old search path, it searches the catalog for the
+// last part of the path
 func getGamesBySearch(w http.ResponseWriter, r *http.Request) {
-	// Get the search string from the URL
-	search := getIDfromURL(r)
+	renderSearch(w, r, getIDfromURL(r))
+}
+
+// searchGames searches the catalog for q, filtered by the tag, author, kind,
+// min and max query parameters. Prices are in the user's currency.
+func searchGames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	renderSearch(w, r, r.URL.Query().Get("q"))
+}
 
-	// Get the Games by the search string
-	GamesToDisplay, err := logic.SearchGames(search, &db)
+func renderSearch(w http.ResponseWriter, r *http.Request, q string) {
+	query := r.URL.Query()
+	options := search.Options{
+		Tags:     query["tag"],
+		AuthorID: query.Get("author"),
+		Kind:     query.Get("kind"),
+		Currency: requestCurrency(r),
+		Limit:    50,
+	}
+	var err error
+	options.MinPrice, err = queryPrice(query, "min", options.Currency)
 	if err != nil {
-		log.Println("Error getting Game from database:", err)
-		http.Error(w, "Internal Server Error", http.StatusNotFound)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	options.MaxPrice, err = queryPrice(query, "max", options.Currency)
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		options.Limit = limit
+	}
+
+	renderTemplate(w, r, "searchresults.html", map[string]interface{}{
+		"Query":    q,
+		"Params":   query,
+		"Results":  logic.SearchGames(q, options, searchIndex),
+		"Currency": options.Currency,
+	})
+}
+
+// queryPrice reads a decimal price like 19.99 from the query, nil when it
+// isn't there
+func queryPrice(query url.Values, name string, currency string) (*int64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	price, err := structs.ParseMoney(value, currency)
+	if err != nil {
+		return nil, err
+	}
+	return &price.Amount, nil
+}
+
+// reindexGame brings the search index and the recommendations up to date
+// with the game after it was created, changed or deleted
+func reindexGame(id string) {
+	game, err := logic.GetGame(id, &db)
+	if err != nil || game.IsDeleted() {
+		searchIndex.Remove(id)
+		recommender.RemoveGame(id)
+		return
+	}
+	searchIndex.Add(*game)
+	recommender.AddGame(*game)
+}
+
+// runReindexJob rebuilds the search index from the database every
+// SEARCH_REINDEX_MINUTES, so changes made through other replicas show up
+func runReindexJob() {
+	interval := 5 * time.Minute
+	if minutes, err := strconv.Atoi(os.Getenv("SEARCH_REINDEX_MINUTES")); err == nil && minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+	for range time.Tick(interval) {
+		err := logic.IndexGames(searchIndex, &db)
+		if err != nil {
+			log.Println("Error rebuilding search index:", err)
+		}
+		err = logic.RecommendCatalog(recommender, &db)
+		if err != nil {
+			log.Println("Error reloading games for recommendations:", err)
+		}
+	}
+}
+
+// runReleaseJob releases the upcoming games whose time has come every
+// RELEASE_CHECK_SECONDS, publishing game.released for each
+func runReleaseJob() {
+	interval := time.Minute
+	if seconds, err := strconv.Atoi(os.Getenv("RELEASE_CHECK_SECONDS")); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	for range time.Tick(interval) {
+		released, err := logic.ReleaseDueGames(time.Now(), &db, kafkaProducer())
+		if err != nil {
+			log.Println("Error releasing games:", err)
+		}
+		for _, game := range released {
+			log.Println("Released", game.Title, "(", game.ID, ")")
+			reindexGame(game.ID)
+		}
+	}
+}
+
+// runPurgeJob takes the games deleted more than TRASH_RETENTION_DAYS ago out
+// of the trash every hour
+func runPurgeJob() {
+	for range time.Tick(time.Hour) {
+		purged, err := logic.PurgeDeletedGames(time.Now(), mediaStore, buildStore, &libraryDB, &buildDB, &db)
+		if err != nil {
+			log.Println("Error purging deleted games:", err)
+		}
+		for _, game := range purged {
+			log.Println("Purged", game.Title, "(", game.ID, ")")
+			entry := events.Audit{Service: "games", ActorRole: "system", Action: "game.purge", TargetType: "game", TargetID: game.ID}
+			err = logic.RecordAudit(entry, game, auditGame(game.ID), time.Now(), &auditDB, kafkaProducer())
+			if err != nil {
+				log.Println("Error recording audit entry for game.purge", game.ID, ":", err)
+			}
+		}
+	}
+}
+
+// connectProducer retries Kafka in the background, publishing fails until it connects
+func connectProducer() {
+	var connected kafkaClient.KafkaProducer
+	err := connected.InitKafkaProducer()
+	for err != nil {
+		log.Println("Error initializing Kafka producer:", err)
+		time.Sleep(5 * time.Second)
+		err = connected.InitKafkaProducer()
+	}
+	producerMu.Lock()
+	producer = connected
+	producerMu.Unlock()
+	log.Println("Kafka producer initialized")
+}
+
+func kafkaProducer() kafkaClient.KafkaProducer {
+	producerMu.RLock()
+	defer producerMu.RUnlock()
+	return producer
+}
+
+// startEventConsumer feeds every checkout, accepted gift and approved refund,
+// from the start of the topics, to the recommendations and the libraries.
+// With RECOMMEND_OFFLINE_FILE set it replays the events saved in that file
+// instead of connecting to Kafka, so the recommendations are the same on
+// every run.
+func startEventConsumer() {
+	if path := os.Getenv("RECOMMEND_OFFLINE_FILE"); path != "" {
+		saved, err := events.Load(path)
+		if err != nil {
+			log.Println("Error loading saved events:", err)
+			return
+		}
+		for _, event := range saved {
+			handleEvent(event.Topic, event.Value)
+		}
+		log.Println("Replayed", len(saved), "events from", path)
 		return
 	}
 
-	renderTemplate(w, "gameslist2.html", map[string]interface{}{
-		"Games": GamesToDisplay,
+	var kafka kafkaClient.KafkaConsumer
+	err := kafka.InitKafkaConsumer()
+	for err != nil {
+		log.Println("Error initializing Kafka consumer:", err)
+		time.Sleep(5 * time.Second)
+		err = kafka.InitKafkaConsumer()
+	}
+	err = kafka.ConsumeFromStart(events.Topics, func(topic string, key []byte, value []byte) {
+		handleEvent(topic, value)
 	})
+	if err != nil {
+		log.Println("Error consuming events:", err)
+	}
+}
+
+func handleEvent(topic string, value []byte) {
+	err := recommender.Apply(topic, value)
+	if err != nil {
+		log.Println("Error applying", topic, "event to recommendations:", err)
+	}
+	err = logic.ApplyLibraryEvent(topic, value, &libraryDB, &db)
+	if err != nil {
+		log.Println("Error applying", topic, "event to libraries:", err)
+	}
+	err = analyticsStore.Apply(topic, value)
+	if err != nil {
+		log.Println("Error applying", topic, "event to analytics:", err)
+	}
+	err = logic.ApplyAuditEvent(topic, value, time.Now(), &auditDB)
+	if err != nil {
+		log.Println("Error adding", topic, "event to the audit log:", err)
+	}
 }
 
 func createGame(w http.ResponseWriter, r *http.Request) {
 	// Get the developer's ID from the request context
 	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
 	if userID == "" {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -251,7 +686,12 @@ func createGame(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&createRequest)
 	if err != nil {
 		log.Println("Error decoding request body:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&createRequest)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -264,43 +704,83 @@ func createGame(w http.ResponseWriter, r *http.Request) {
 	log.Println("Description: ", createRequest.Description)
 	log.Println("Tags: ", createRequest.Tags)
 	log.Println("Price: ", createRequest.Price)
+	log.Println("Kind: ", createRequest.Kind)
 
-	err = logic.CreateGame(createRequest.GamePostRequestToGame(), &db)
+	game := createRequest.GamePostRequestToGame()
+	err = logic.Authorize(userID, userRole, logic.ActionPublish, &game, &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	game.Tags, err = logic.NormalizeTags(game.Tags, &tagDB)
+	if err != nil {
+		log.Println("Error normalizing tags:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = logic.CreateGame(game, &db)
 	if err != nil {
-		log.Println("Error creating Game in database:", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	audit(r, "game.create", "game", game.ID, nil, auditGame(game.ID))
+	reindexGame(game.ID)
 }
 
 func deleteGameID(w http.ResponseWriter, r *http.Request) {
 	id := getIDfromURL(r)
 	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
 	if userID == "" {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	logic.DeleteGame(id, userID, &db)
+	before := auditGame(id)
+	err := logic.DeleteGame(id, userID, userRole, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.delete", "game", id, before, auditGame(id))
+	reindexGame(id)
 }
 
 func deleteGameByGameID(w http.ResponseWriter, r *http.Request) {
 	id := getIDfromURL(r)
-	logic.DeleteGameByID(id, &db)
+	adminID := r.Context().Value("userID").(string)
+	before := auditGame(id)
+	err := logic.DeleteGameByID(id, adminID, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.delete", "game", id, before, auditGame(id))
+	reindexGame(id)
 }
 
 func deleteAllGame(w http.ResponseWriter, r *http.Request) {
-	// Delete all items from the Games table
-	db.DeleteAll()
+	adminID := r.Context().Value("userID").(string)
+	// Move every game to the trash
+	deleted, err := logic.DeleteAll(adminID, &db)
+	if err != nil {
+		log.Println("Error deleting Games from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "game.delete_all", "games", "", map[string]int{"Count": deleted}, nil)
+	searchIndex.Rebuild(nil)
+	recommender.SetCatalog(nil)
 }
 
 func updateGameID(w http.ResponseWriter, r *http.Request) {
 	id := getIDfromURL(r)
 	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
 	if userID == "" {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -309,11 +789,64 @@ func updateGameID(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&updateRequest)
 	if err != nil {
 		log.Println("Error decoding request body:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&updateRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	game := updateRequest.GamePostRequestToGame()
+	game.Tags, err = logic.NormalizeTags(game.Tags, &tagDB)
+	if err != nil {
+		log.Println("Error normalizing tags:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	before := auditGame(id)
 	//       v The new Id and Publish are igored here, they should never be updated
-	logic.UpdateGame(id, userID, updateRequest.GamePostRequestToGame(), &db)
+	err = logic.UpdateGame(id, userID, userRole, game, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.update", "game", id, before, auditGame(id))
+	reindexGame(id)
+}
+
+// patchGameID changes only the fields in the JSON Merge Patch sent, and
+// replies with the game as it is now
+func patchGameID(w http.ResponseWriter, r *http.Request) {
+	id := getIDfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
+	if userID == "" {
+		log.Println("User ID not found in the request context")
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/merge-patch+json" && contentType != "application/json" {
+		problem.Error(w, r, "Send the changes as application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before := auditGame(id)
+	game, err := logic.PatchGame(id, userID, userRole, patch, &orgDB, &tagDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.update", "game", id, before, auditGame(id))
+	reindexGame(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(game)
 }
 
 func GameUpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -329,16 +862,17 @@ func GameUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		getUpdate(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 func createUpdate(w http.ResponseWriter, r *http.Request) {
 	gameID := getIDfromURL(r)
 	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
 	if userID == "" {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 	// Parse the request body
@@ -346,29 +880,48 @@ func createUpdate(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&updateRequest)
 	if err != nil {
 		log.Println("Error decoding request body:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&updateRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before := auditGame(gameID)
+	err = logic.CreateUpdate(gameID, userID, userRole, updateRequest.UpdatePostObjectToUpdate(), &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	logic.CreateUpdate(gameID, userID, updateRequest.UpdatePostObjectToUpdate(), &db)
+	audit(r, "update.create", "game", gameID, before, auditGame(gameID))
 }
 
 func deleteUpdate(w http.ResponseWriter, r *http.Request) {
 	gameID, updateID := getTwoIDsfromURL(r)
 	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
 	if userID == "" {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	before := auditGame(gameID)
+	err := logic.DeleteUpdate(gameID, userID, userRole, updateID, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	logic.DeleteUpdate(gameID, userID, updateID, &db)
+	audit(r, "update.delete", "game", gameID, before, auditGame(gameID))
 }
 
 func updateUpdate(w http.ResponseWriter, r *http.Request) {
 	gameID, updateID := getTwoIDsfromURL(r)
 	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
 	if userID == "" {
 		log.Println("User ID not found in the request context")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		problem.Error(w, r, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 	// Parse the request body
@@ -376,47 +929,1170 @@ func updateUpdate(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&updateRequest)
 	if err != nil {
 		log.Println("Error decoding request body:", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&updateRequest)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	logic.UpdateUpdate(gameID, userID, updateID, updateRequest, &db)
+	before := auditGame(gameID)
+	err = logic.UpdateUpdate(gameID, userID, userRole, updateID, updateRequest, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "update.edit", "game", gameID, before, auditGame(gameID))
 }
 
 func getUpdate(w http.ResponseWriter, r *http.Request) {
 	gameID, updateID := getTwoIDsfromURL(r)
 	update, err := logic.GetUpdate(gameID, updateID, &db)
 	if err != nil {
-		log.Println("Error getting Update from database:", err)
-		http.Error(w, "Internal Server Error", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 	// todo: Render the template with the retrieved Update data
-	renderTemplate(w, "Update.html", map[string]interface{}{
+	renderTemplate(w, r, "Update.html", map[string]interface{}{
 		"Update": update,
 	})
 }
 
-func getIDfromURL(r *http.Request) string {
-	url := r.URL.Path
-	parts := strings.Split(url, "/")
-	return parts[len(parts)-1]
+func createDiscount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	gameID := getIDfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+
+	var discountRequest structs.DiscountPostRequest
+	err := json.NewDecoder(r.Body).Decode(&discountRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&discountRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	before := auditGame(gameID)
+	err = logic.CreateDiscount(gameID, userID, userRole, discountRequest.DiscountPostRequestToDiscount(userID), &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "discount.create", "game", gameID, before, auditGame(gameID))
+	reindexGame(gameID)
+	w.WriteHeader(http.StatusCreated)
 }
 
-func getTwoIDsfromURL(r *http.Request) (string, string) {
-	url := r.URL.Path
-	parts := strings.Split(url, "/")
-	return parts[len(parts)-2], parts[len(parts)-1]
+func deleteDiscount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	gameID, discountID := getTwoIDsfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+
+	before := auditGame(gameID)
+	err := logic.DeleteDiscount(gameID, userID, userRole, discountID, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "discount.delete", "game", gameID, before, auditGame(gameID))
+	reindexGame(gameID)
 }
 
-func renderTemplate(w http.ResponseWriter, templateName string, data interface{}) {
-	t, err := template.ParseFiles("templates/" + templateName)
+func migratePrices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	migrated, err := logic.MigratePrices(&db)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println("Error migrating prices:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	audit(r, "game.migrate_prices", "games", "", nil, map[string]int{"Migrated": migrated})
+	log.Println("Migrated prices of", migrated, "games")
+	logic.IndexGames(searchIndex, &db)
+	logic.RecommendCatalog(recommender, &db)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"migrated": migrated})
+}
 
-	err = t.Execute(w, data)
+func migrateSlugs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	migrated, err := logic.MigrateSlugs(&db)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println("Error migrating slugs:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "game.migrate_slugs", "games", "", nil, map[string]int{"Migrated": migrated})
+	log.Println("Gave", migrated, "games a slug")
+	logic.IndexGames(searchIndex, &db)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"migrated": migrated})
+}
+
+func migrateTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	migrated, err := logic.MigrateTags(&db, &tagDB)
+	if err != nil {
+		log.Println("Error migrating tags:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	audit(r, "game.migrate_tags", "games", "", nil, map[string]int{"Migrated": migrated})
+	log.Println("Migrated tags of", migrated, "games")
+	logic.IndexGames(searchIndex, &db)
+	logic.RecommendCatalog(recommender, &db)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"migrated": migrated})
+}
+
+// ----------------- Media -----------------
+
+func MediaHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderMedia(w, r, getIDfromURL(r))
+	case http.MethodPost:
+		uploadMedia(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func MediaHandlerID(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		moveMedia(w, r)
+	case http.MethodDelete:
+		deleteMedia(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadMedia takes a multipart form with the file in "file" and what it is,
+// cover, screenshot or trailer, in "kind"
+func uploadMedia(w http.ResponseWriter, r *http.Request) {
+	gameID := getIDfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, logic.MaxVideoSize+1<<20)
+	err := r.ParseMultipartForm(32 << 20)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, logic.ErrMediaTooLarge)
+		return
+	}
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	media, err := logic.AddMedia(gameID, userID, userRole, r.FormValue("kind"), data, mediaStore, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "media.upload", "game", gameID, nil, media)
+	reindexGame(gameID)
+	renderMedia(w, r, gameID)
+}
+
+func moveMedia(w http.ResponseWriter, r *http.Request) {
+	gameID, mediaID := getTwoIDsfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+	var moveRequest struct {
+		Position int `json:"position"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&moveRequest)
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	before := auditGame(gameID)
+	err = logic.MoveMedia(gameID, userID, userRole, mediaID, moveRequest.Position, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "media.move", "game", gameID, before, auditGame(gameID))
+	reindexGame(gameID)
+	renderMedia(w, r, gameID)
+}
+
+func deleteMedia(w http.ResponseWriter, r *http.Request) {
+	gameID, mediaID := getTwoIDsfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+	before := auditGame(gameID)
+	err := logic.DeleteMedia(gameID, userID, userRole, mediaID, mediaStore, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "media.delete", "game", gameID, before, auditGame(gameID))
+	reindexGame(gameID)
+	renderMedia(w, r, gameID)
+}
+
+// renderMedia shows the game's cover and gallery with the upload form
+func renderMedia(w http.ResponseWriter, r *http.Request, gameID string) {
+	game, err := logic.GetGame(gameID, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// the positions the move buttons send, templates can't count
+	type galleryItem struct {
+		structs.Media
+		Up   int
+		Down int
+		Last bool
+	}
+	gallery := []galleryItem{}
+	for i, media := range game.Gallery() {
+		gallery = append(gallery, galleryItem{Media: media, Up: i - 1, Down: i + 1, Last: i == len(game.Gallery())-1})
+	}
+	renderTemplate(w, r, "media.html", map[string]interface{}{
+		"Game":         game,
+		"Gallery":      gallery,
+		"Kinds":        structs.MediaKinds,
+		"MaxImageSize": logic.MaxImageSize >> 20,
+		"MaxVideoSize": logic.MaxVideoSize >> 20,
+	})
+}
+
+// getMediaFile serves an uploaded file. Keys are never reused, so browsers
+// may keep them for good.
+func getMediaFile(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	// builds uploaded before they had their own store are still under
+	// MEDIA_DIR, and only go out through a signed link
+	if strings.HasPrefix(key, "builds/") {
+		problem.Error(w, r, "Not Found", http.StatusNotFound)
+		return
+	}
+	file, err := mediaStore.Open(key)
+	if err != nil {
+		problem.Error(w, r, "Not Found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, file)
+}
+
+// ----------------- Builds -----------------
+
+func BuildsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderBuilds(w, r, getIDfromURL(r))
+	case http.MethodPost:
+		uploadBuild(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadBuild takes a multipart form with the version, platform, channel,
+// notes and optionally the sha256 of the file, then the file in "file". The
+// file is read last and streamed, so it has to come after the other fields.
+func uploadBuild(w http.ResponseWriter, r *http.Request) {
+	gameID := getIDfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+
+	r.Body = http.MaxBytesReader(w, r.Body, logic.MaxBuildSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	request := structs.BuildRequest{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			request.FileName = part.FileName()
+			err = validate.Struct(&request)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			build, err := logic.UploadBuild(gameID, userID, userRole, request, part, buildStore, &buildDB, &orgDB, &db)
+			if err != nil {
+				writeBuildError(w, r, err)
+				return
+			}
+			audit(r, "build.upload", "build", build.ID, nil, build)
+			renderBuilds(w, r, gameID)
+			return
+		}
+		value, _ := io.ReadAll(io.LimitReader(part, 4096))
+		switch part.FormName() {
+		case "version":
+			request.Version = string(value)
+		case "platform":
+			request.Platform = string(value)
+		case "channel":
+			request.Channel = string(value)
+		case "notes":
+			request.Notes = string(value)
+		case "sha256":
+			request.SHA256 = string(value)
+		}
+	}
+	problem.Error(w, r, logic.ErrInvalidBuild.Error(), http.StatusBadRequest)
+}
+
+func deleteBuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	gameID, buildID := getTwoIDsfromURL(r)
+	userID := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+	before, _ := logic.GetBuild(buildID, &buildDB)
+	err := logic.DeleteBuild(gameID, userID, userRole, buildID, buildStore, &buildDB, &orgDB, &db)
+	if err != nil {
+		writeBuildError(w, r, err)
+		return
+	}
+	audit(r, "build.delete", "build", buildID, before, nil)
+	renderBuilds(w, r, gameID)
+}
+
+func renderBuilds(w http.ResponseWriter, r *http.Request, gameID string) {
+	game, err := logic.GetGame(gameID, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	builds, err := logic.GetBuilds(gameID, &buildDB)
+	if err != nil {
+		log.Println("Error getting builds from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "builds.html", map[string]interface{}{
+		"Game":      game,
+		"Builds":    builds,
+		"Platforms": structs.Platforms,
+		"Channels":  structs.Channels,
+	})
+}
+
+// createDownloadLink gives an owner a short lived link to the build. The link
+// works without the Authorization header, so browsers and download managers
+// can fetch and resume it.
+func createDownloadLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	buildID := r.PathValue("id")
+	link, err := logic.CreateDownloadLink(buildID, userID, time.Now(), downloadKey, &buildDB, &orgDB, &libraryDB, &db)
+	if err != nil {
+		writeBuildError(w, r, err)
+		return
+	}
+	build, _ := logic.GetBuild(buildID, &buildDB)
+	w.Write([]byte(`<a class="bg-blue-500 text-white px-3 py-1 rounded-md hover:bg-blue-600" href="` + template.HTMLEscapeString(link) + `" download="` + template.HTMLEscapeString(build.FileName) + `">Download now</a>`))
+}
+
+// downloadBuild serves the build for a signed link, with Range requests so
+// downloads can be resumed
+func downloadBuild(w http.ResponseWriter, r *http.Request) {
+	buildID := getIDfromURL(r)
+	build, file, err := logic.OpenDownload(buildID, r.URL.Query(), time.Now(), downloadKey, buildStore, &buildDB, &orgDB, &libraryDB, &db)
+	if err != nil {
+		writeBuildError(w, r, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+build.FileName+`"`)
+	w.Header().Set("ETag", `"`+build.SHA256+`"`)
+	w.Header().Set("X-Checksum-SHA256", build.SHA256)
+	w.Header().Set("Cache-Control", "private, no-store")
+	uploaded, _ := time.Parse(time.RFC3339, build.Uploaded)
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, build.FileName, uploaded, seeker)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(build.Size, 10))
+	io.Copy(w, file)
+}
+
+// writeBuildError is writeError with a body over MaxBuildSize reported as
+// too large a build
+func writeBuildError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = logic.ErrBuildTooLarge
+	}
+	writeError(w, r, err)
+}
+
+// ----------------- Analytics -----------------
+
+// getAnalytics is the developer's dashboard for the from, to and game query
+// parameters
+func getAnalytics(w http.ResponseWriter, r *http.Request) {
+	report, ok := analyticsReport(w, r, r.Context().Value("userID").(string))
+	if !ok {
+		return
+	}
+	renderTemplate(w, r, "analytics.html", report)
+}
+
+func getAnalyticsData(w http.ResponseWriter, r *http.Request) {
+	report, ok := analyticsReport(w, r, r.Context().Value("userID").(string))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"From":   report.From,
+		"To":     report.To,
+		"GameID": report.GameID,
+		"Days":   report.Days,
+		"Totals": report.Totals,
+		"Sum":    report.Sum,
+	})
+}
+
+// createExportLink answers with a link to the CSV of the report the
+// dashboard shows
+func createExportLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, ok := analyticsReport(w, r, r.Context().Value("userID").(string))
+	if !ok {
+		return
+	}
+	link := logic.CreateExportLink(r.Context().Value("userID").(string), report.GameID, report.From, report.To, time.Now(), downloadKey)
+	w.Write([]byte(`<a class="bg-blue-500 text-white px-3 py-1 rounded-md hover:bg-blue-600" href="` + template.HTMLEscapeString(link) + `" download>Download CSV</a>`))
+}
+
+func exportAnalytics(w http.ResponseWriter, r *http.Request) {
+	authorID, err := logic.CheckExportLink(r.URL.Query(), time.Now(), downloadKey)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	report, ok := analyticsReport(w, r, authorID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "analytics-" + report.From + "-" + report.To + ".csv"}))
+	err = logic.WriteAnalyticsCSV(w, report)
+	if err != nil {
+		log.Println("Error writing analytics CSV:", err)
+	}
+}
+
+func analyticsReport(w http.ResponseWriter, r *http.Request, authorID string) (*logic.AnalyticsReport, bool) {
+	query := r.URL.Query()
+	from, to, err := logic.AnalyticsRange(query.Get("from"), query.Get("to"), time.Now())
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+	report, err := logic.GetAnalytics(authorID, query.Get("game"), from, to, analyticsStore, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+	return report, true
+}
+
+// ----------------- Organizations -----------------
+
+func OrgsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderOrgs(w, r)
+	case http.MethodPost:
+		createOrganization(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func MembersHandlerID(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		setMemberRole(w, r)
+	case http.MethodDelete:
+		removeMember(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createOrganization(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	var orgRequest structs.OrganizationRequest
+	err := json.NewDecoder(r.Body).Decode(&orgRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&orgRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	org, err := logic.CreateOrganization(userID, orgRequest, time.Now(), &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.create", "org", org.ID, nil, auditOrg(org.ID))
+	renderOrgs(w, r)
+}
+
+func deleteOrganization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	before := auditOrg(r.PathValue("id"))
+	err := logic.DeleteOrganization(r.PathValue("id"), userID, &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.delete", "org", r.PathValue("id"), before, nil)
+	renderOrgs(w, r)
+}
+
+func createInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	var inviteRequest structs.InviteRequest
+	err := json.NewDecoder(r.Body).Decode(&inviteRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&inviteRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before := auditOrg(r.PathValue("id"))
+	_, err = logic.CreateInvite(r.PathValue("id"), userID, inviteRequest, time.Now(), &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.invite.create", "org", r.PathValue("id"), before, auditOrg(r.PathValue("id")))
+	renderOrgs(w, r)
+}
+
+func revokeInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	before := auditOrg(r.PathValue("id"))
+	err := logic.RevokeInvite(r.PathValue("id"), userID, r.PathValue("code"), &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.invite.revoke", "org", r.PathValue("id"), before, auditOrg(r.PathValue("id")))
+	renderOrgs(w, r)
+}
+
+// acceptInvite joins the organization the invite code sent in the body is for
+func acceptInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	var acceptRequest structs.AcceptInviteRequest
+	err := json.NewDecoder(r.Body).Decode(&acceptRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&acceptRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	org, err := logic.AcceptInvite(strings.TrimSpace(acceptRequest.Code), userID, time.Now(), &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.invite.accept", "org", org.ID, nil, org.Member(userID))
+	renderOrgs(w, r)
+}
+
+func setMemberRole(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	var memberRequest structs.MemberRequest
+	err := json.NewDecoder(r.Body).Decode(&memberRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&memberRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before := auditOrg(r.PathValue("id"))
+	err = logic.SetMemberRole(r.PathValue("id"), userID, r.PathValue("memberID"), memberRequest.Role, &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.member.role", "org", r.PathValue("id"), before, auditOrg(r.PathValue("id")))
+	renderOrgs(w, r)
+}
+
+func removeMember(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	before := auditOrg(r.PathValue("id"))
+	err := logic.RemoveMember(r.PathValue("id"), userID, r.PathValue("memberID"), &orgDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "org.member.remove", "org", r.PathValue("id"), before, auditOrg(r.PathValue("id")))
+	renderOrgs(w, r)
+}
+
+// transferGame moves the game sent in the body into the organization
+func transferGame(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Context().Value("userID").(string)
+	userRole := r.Context().Value("userRole").(string)
+	var transferRequest structs.TransferRequest
+	err := json.NewDecoder(r.Body).Decode(&transferRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&transferRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before := auditGame(transferRequest.GameID)
+	err = logic.TransferGame(transferRequest.GameID, userID, userRole, r.PathValue("id"), &orgDB, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.transfer", "game", transferRequest.GameID, before, auditGame(transferRequest.GameID))
+	reindexGame(transferRequest.GameID)
+	renderOrgs(w, r)
+}
+
+func renderOrgs(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	orgs, err := logic.GetOrganizationViews(userID, &orgDB, &db)
+	if err != nil {
+		log.Println("Error getting organizations from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "orgs.html", map[string]interface{}{
+		"Orgs":     orgs,
+		"OwnGames": logic.GetPersonalGames(userID, &db),
+		"UserID":   userID,
+		"Roles":    structs.OrgRoles,
+		"Kinds":    structs.OrgKinds,
+	})
+}
+
+// ----------------- Trash -----------------
+
+func getTrash(w http.ResponseWriter, r *http.Request) {
+	trash, err := logic.GetTrash(&db)
+	if err != nil {
+		log.Println("Error getting deleted games from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "trash.html", map[string]interface{}{
+		"Games": trash,
+	})
+}
+
+func restoreGame(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	before := auditGame(id)
+	_, err := logic.RestoreGame(id, &db)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "game.restore", "game", id, before, auditGame(id))
+	reindexGame(id)
+	getTrash(w, r)
+}
+
+// ----------------- Audit -----------------
+
+// audit records what the user just did in the audit log. The action has
+// happened by then, so a failure to record it is only logged.
+func audit(r *http.Request, action string, targetType string, targetID string, before interface{}, after interface{}) {
+	userID, _ := r.Context().Value("userID").(string)
+	userRole, _ := r.Context().Value("userRole").(string)
+	entry := events.Audit{
+		Service:    "games",
+		ActorID:    userID,
+		ActorRole:  userRole,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		RequestID:  requestID(r),
+		IP:         clientIP(r),
+	}
+	err := logic.RecordAudit(entry, before, after, time.Now(), &auditDB, kafkaProducer())
+	if err != nil {
+		log.Println("Error recording audit entry for", action, targetID, ":", err)
+	}
+}
+
+// auditGame is the game as the audit log records it, nil when there is none
+func auditGame(id string) *structs.Game {
+	game, err := logic.GetGame(id, &db)
+	if err != nil || game.ID != id {
+		return nil
+	}
+	return game
+}
+
+// auditOrg is the organization as the audit log records it, without the
+// invite codes
+func auditOrg(id string) *structs.Organization {
+	org, err := logic.GetOrganization(id, &orgDB)
+	if err != nil {
+		return nil
+	}
+	invites := []structs.Invite{}
+	for _, invite := range org.Invites {
+		invite.Code = ""
+		invites = append(invites, invite)
+	}
+	org.Invites = invites
+	return org
+}
+
+// requestID is the ID the proxy gave the request, or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// clientIP is the address the request came from, before the proxy
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getAuditLog shows the entries picked by the actor, action, service, type,
+// target, from, to and limit query parameters
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, entries, ok := auditLog(w, r)
+	if !ok {
+		return
+	}
+	renderTemplate(w, r, "audit.html", map[string]interface{}{
+		"Filter":   filter,
+		"Entries":  entries,
+		"Services": []string{"games", "carts", "auth"},
+	})
+}
+
+func getAuditLogData(w http.ResponseWriter, r *http.Request) {
+	_, entries, ok := auditLog(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func auditLog(w http.ResponseWriter, r *http.Request) (logic.AuditFilter, []structs.AuditEntry, bool) {
+	query := r.URL.Query()
+	filter := logic.AuditFilter{
+		ActorID:    query.Get("actor"),
+		Action:     query.Get("action"),
+		Service:    query.Get("service"),
+		TargetType: query.Get("type"),
+		TargetID:   query.Get("target"),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			problem.Error(w, r, logic.ErrInvalidAuditFilter.Error(), http.StatusBadRequest)
+			return filter, nil, false
+		}
+	}
+	entries, err := logic.GetAuditLog(filter, time.Now(), &auditDB)
+	if err != nil {
+		writeError(w, r, err)
+		return filter, nil, false
+	}
+	return filter, entries, true
+}
+
+// ----------------- Recommendations -----------------
+
+// getRecommendedGames shows the games picked for the user from their library
+// and wishlist
+func getRecommendedGames(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	games, err := logic.GetRecommendedGames(userID, recommendLimit(r), recommender, &wishlistDB)
+	if err != nil {
+		log.Println("Error getting recommendations:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderList(w, r, "Recommended for you", games)
+}
+
+// getSimilarGames is the "more like this" list for a game
+func getSimilarGames(w http.ResponseWriter, r *http.Request) {
+	games := logic.MoreLikeThis(getIDfromURL(r), recommendLimit(r), recommender)
+	renderList(w, r, "More like this", games)
+}
+
+// recommendLimit is the limit query parameter, 8 when it is missing
+func recommendLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 50 {
+		return 8
+	}
+	return limit
+}
+
+// getUpcomingGames lists the games still to come, soonest first
+func getUpcomingGames(w http.ResponseWriter, r *http.Request) {
+	games, err := logic.GetUpcomingGames(&db)
+	if err != nil {
+		log.Println("Error getting upcoming games:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderList(w, r, "Coming soon", games)
+}
+
+// renderList shows the games on the store page under a heading, without the
+// browse facets
+func renderList(w http.ResponseWriter, r *http.Request, heading string, games []structs.Game) {
+	games, err := logic.AttachRelations(games, &db)
+	if err != nil {
+		log.Println("Error getting related games from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "gameslist2.html", map[string]interface{}{
+		"Heading":  heading,
+		"Games":    games,
+		"Currency": requestCurrency(r),
+	})
+}
+
+// ----------------- Wishlist -----------------
+
+func getWishlist(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	wishlist, err := logic.GetWishlist(userID, &wishlistDB)
+	if err != nil {
+		log.Println("Error getting wishlist:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	games := []structs.Game{}
+	for _, id := range wishlist.GameIDs {
+		game, err := logic.GetGame(id, &db)
+		if err == nil && !game.IsDeleted() {
+			games = append(games, *game)
+		}
+	}
+	renderList(w, r, "Wishlist", games)
+}
+
+func WishlistHandlerID(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	gameID := getIDfromURL(r)
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		_, err = logic.AddToWishlist(userID, gameID, &wishlistDB, &db, kafkaProducer())
+	case http.MethodDelete:
+		_, err = logic.RemoveFromWishlist(userID, gameID, &wishlistDB, kafkaProducer())
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.Write([]byte("On your wishlist"))
+		return
+	}
+	w.Write([]byte("Removed from your wishlist"))
+}
+
+// ----------------- Tags -----------------
+
+func TagsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderTags(w, r)
+	case http.MethodPost:
+		createTag(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TagsHandlerID(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		updateTag(w, r)
+	case http.MethodDelete:
+		deleteTag(w, r)
+	default:
+		problem.Error(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createTag(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value("userID").(string)
+	var tagRequest structs.TagRequest
+	err := json.NewDecoder(r.Body).Decode(&tagRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&tagRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tag, err := logic.CreateTag(adminID, tagRequest, &tagDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "tag.create", "tag", tag.ID, nil, tag)
+	renderTags(w, r)
+}
+
+func updateTag(w http.ResponseWriter, r *http.Request) {
+	var tagRequest structs.TagRequest
+	err := json.NewDecoder(r.Body).Decode(&tagRequest)
+	if err != nil {
+		log.Println("Error decoding request body:", err)
+		problem.Error(w, r, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	err = validate.Struct(&tagRequest)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, _ := logic.GetTag(getIDfromURL(r), &tagDB)
+	tag, err := logic.UpdateTag(getIDfromURL(r), tagRequest, &tagDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "tag.update", "tag", tag.ID, before, tag)
+	renderTags(w, r)
+}
+
+func deleteTag(w http.ResponseWriter, r *http.Request) {
+	before, _ := logic.GetTag(getIDfromURL(r), &tagDB)
+	err := logic.DeleteTag(getIDfromURL(r), &tagDB)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	audit(r, "tag.delete", "tag", getIDfromURL(r), before, nil)
+	renderTags(w, r)
+}
+
+func renderTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := logic.GetTags(&tagDB)
+	if err != nil {
+		log.Println("Error getting tags from database:", err)
+		problem.Error(w, r, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "tags.html", map[string]interface{}{
+		"Tags":       tags,
+		"Categories": structs.TagCategories,
+	})
+}
+
+// requestCurrency is the currency the user picked, from the query or the
+// currency cookie set by the frontend.
+func requestCurrency(r *http.Request) string {
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		return structs.NormalizeCurrency(currency)
+	}
+	if cookie, err := r.Cookie("currency"); err == nil {
+		return structs.NormalizeCurrency(cookie.Value)
+	}
+	return structs.DefaultCurrency
+}
+
+func getIDfromURL(r *http.Request) string {
+	url := r.URL.Path
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
+
+func getTwoIDsfromURL(r *http.Request) (string, string) {
+	url := r.URL.Path
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// renderTemplate renders the template with the shared pieces in
+// partials.html available to it
+func renderTemplate(w http.ResponseWriter, r *http.Request, templateName string, data interface{}) {
+	t, err := template.ParseFiles("templates/"+templateName, "templates/partials.html")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = t.Execute(w, data)
+	if err != nil {
+		log.Println("Error rendering", templateName, ":", err)
+	}
+}
+
+// writeError replies with the status code for the kind of error. Anything
+// that isn't one of them is logged and answered with a plain 500, so the
+// details of what broke stay in the logs.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var fields validate.Errors
+	if errors.As(err, &fields) {
+		params := make([]problem.InvalidParam, len(fields))
+		for i, field := range fields {
+			params[i] = problem.InvalidParam{Name: field.Field, Reason: field.Message}
+		}
+		problem.Invalid(w, r, "Some fields aren't valid", params)
+		return
+	}
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Println("Error handling", r.Method, r.URL.Path, ":", err)
+		problem.Error(w, r, "Internal Server Error", status)
+		return
+	}
+	problem.Error(w, r, err.Error(), status)
+}
+
+// errorStatus is the status code for the kinds of error of the logic, the
+// database and the blob store
+func errorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, logic.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, logic.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, logic.ErrNotFound), errors.Is(err, database.ErrNotFound), errors.Is(err, blobstore.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, logic.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, logic.ErrTooLarge), errors.As(err, &tooLarge):
+		return http.StatusRequestEntityTooLarge
 	}
+	return http.StatusInternalServerError
 }
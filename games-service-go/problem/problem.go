@@ -0,0 +1,79 @@
+// Package problem writes the error responses of the service. API clients get
+// problem details (RFC 9457) as JSON, HTMX requests get a fragment of HTML
+// the frontend shows to the user.
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+)
+
+// Details is the problem details body
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// InvalidParam is a field of the request that isn't valid, listed in the
+// invalid-params member of the problem details
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+const fragment = `<div class="bg-red-100 border border-red-400 text-red-700 px-4 py-2 rounded-md mb-4" role="alert"><strong>%s</strong> %s%s</div>`
+
+// Error replies to the request with the status code and detail, in place of
+// http.Error
+func Error(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	write(w, r, detail, status, nil)
+}
+
+// Invalid replies with a 400 listing the fields of the request that aren't
+// valid. The fragment for HTMX names the field of each item in data-field, so
+// forms can show the reasons next to their inputs.
+func Invalid(w http.ResponseWriter, r *http.Request, detail string, params []InvalidParam) {
+	write(w, r, detail, http.StatusBadRequest, params)
+}
+
+func write(w http.ResponseWriter, r *http.Request, detail string, status int, params []InvalidParam) {
+	title := http.StatusText(status)
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		list := ""
+		if len(params) > 0 {
+			list = `<ul class="list-disc ml-6">`
+			for _, param := range params {
+				list += fmt.Sprintf(`<li data-field="%s">%s</li>`, html.EscapeString(param.Name), html.EscapeString(param.Name+" "+param.Reason))
+			}
+			list += `</ul>`
+		}
+		fmt.Fprintf(w, fragment, html.EscapeString(title), html.EscapeString(detail), list)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	details := Details{
+		Type:     "about:blank",
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	}
+	if len(params) == 0 {
+		json.NewEncoder(w).Encode(details)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Details
+		InvalidParams []InvalidParam `json:"invalid-params"`
+	}{details, params})
+}
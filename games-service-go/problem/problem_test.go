@@ -0,0 +1,79 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/games/1", nil)
+	w := httptest.NewRecorder()
+	Error(w, r, "game not found", http.StatusNotFound)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	details := Details{}
+	err := json.Unmarshal(w.Body.Bytes(), &details)
+	if err != nil {
+		t.Fatalf("Error reading body: %v", err)
+	}
+	expected := Details{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "game not found", Instance: "/games/1"}
+	if details != expected {
+		t.Errorf("Expected %+v got %+v", expected, details)
+	}
+}
+
+func TestErrorHTMX(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/games", nil)
+	r.Header.Set("HX-Request", "true")
+	w := httptest.NewRecorder()
+	Error(w, r, "a game with the title <b> already exists", http.StatusConflict)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Conflict") || !strings.Contains(body, "&lt;b&gt;") {
+		t.Errorf("Unexpected fragment %s", body)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	params := []InvalidParam{{Name: "Title", Reason: "is required"}, {Name: "Tags.3", Reason: "can't have <b>"}}
+
+	r := httptest.NewRequest(http.MethodPost, "/developer/games/create", nil)
+	w := httptest.NewRecorder()
+	Invalid(w, r, "Some fields aren't valid", params)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 got %d", w.Code)
+	}
+	body := struct {
+		Details
+		InvalidParams []InvalidParam `json:"invalid-params"`
+	}{}
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("Error reading body: %v", err)
+	}
+	if body.Title != "Bad Request" || len(body.InvalidParams) != 2 || body.InvalidParams[1] != params[1] {
+		t.Errorf("Unexpected body %s", w.Body.String())
+	}
+
+	r.Header.Set("HX-Request", "true")
+	w = httptest.NewRecorder()
+	Invalid(w, r, "Some fields aren't valid", params)
+	fragment := w.Body.String()
+	if !strings.Contains(fragment, `<li data-field="Title">Title is required</li>`) || !strings.Contains(fragment, "can&#39;t have &lt;b&gt;") {
+		t.Errorf("Unexpected fragment %s", fragment)
+	}
+}
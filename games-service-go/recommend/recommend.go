@@ -0,0 +1,312 @@
+package recommend
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	events "github.com/Draupniyr/games-service/events"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+// How much owning a game together and sharing tags count towards similarity
+const (
+	CoPurchaseWeight = 0.7
+	TagWeight        = 0.3
+	// a wishlisted game says less about taste than an owned one
+	WishlistWeight = 0.5
+)
+
+type Scored struct {
+	GameID string
+	Score  float64
+}
+
+// Engine keeps item to item similarity from what users own together and the
+// tags games share. Ownership is updated one event at a time so nothing has
+// to be recomputed from scratch. It is safe to use from several goroutines.
+type Engine struct {
+	mu    sync.RWMutex
+	games map[string]structs.Game
+	// owned counts the copies of each game each user has
+	owned map[string]map[string]int
+	// owners is how many users own each game
+	owners map[string]int
+	// together is how many users own both games
+	together map[string]map[string]int
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		games:    map[string]structs.Game{},
+		owned:    map[string]map[string]int{},
+		owners:   map[string]int{},
+		together: map[string]map[string]int{},
+	}
+}
+
+// ----------------- Catalog -----------------
+
+// SetCatalog replaces the games the engine recommends from
+func (e *Engine) SetCatalog(games []structs.Game) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.games = map[string]structs.Game{}
+	for _, game := range games {
+		e.games[game.ID] = game
+	}
+}
+
+func (e *Engine) AddGame(game structs.Game) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.games[game.ID] = game
+}
+
+// RemoveGame stops recommending the game, what users own stays as it was
+func (e *Engine) RemoveGame(gameID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.games, gameID)
+}
+
+func (e *Engine) Game(gameID string) (structs.Game, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	game, ok := e.games[gameID]
+	return game, ok
+}
+
+// Owns reports if the user owns the game as far as the events seen so far go
+func (e *Engine) Owns(userID string, gameID string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.owned[userID][gameID] > 0
+}
+
+// ----------------- Events -----------------
+
+// Apply updates ownership from one checkout, gift.accepted or refund.approved
+// message. Other topics are ignored.
+func (e *Engine) Apply(topic string, value []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch topic {
+	case events.TopicCheckout:
+		order := events.Checkout{}
+		err := json.Unmarshal(value, &order)
+		if err != nil {
+			return err
+		}
+		for _, game := range order.Games {
+			for _, id := range game.GameIDs() {
+				e.grant(order.UserID, id)
+			}
+		}
+	case events.TopicGiftAccepted:
+		gift := events.Gift{}
+		err := json.Unmarshal(value, &gift)
+		if err != nil {
+			return err
+		}
+		for _, id := range gift.Game.GameIDs() {
+			e.grant(gift.RecipientID, id)
+		}
+	case events.TopicRefundApproved:
+		refund := events.Refund{}
+		err := json.Unmarshal(value, &refund)
+		if err != nil {
+			return err
+		}
+		ids := []string{refund.GameID}
+		if bundle, ok := e.games[refund.GameID]; ok && bundle.IsBundle() {
+			ids = bundle.BundleItems
+		}
+		for _, id := range ids {
+			e.revoke(refund.UserID, id)
+		}
+	}
+	return nil
+}
+
+// Replay applies saved events in order, it is how the offline mode is fed
+func (e *Engine) Replay(saved []events.Event) error {
+	for _, event := range saved {
+		err := e.Apply(event.Topic, event.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) grant(userID string, gameID string) {
+	if userID == "" || gameID == "" {
+		return
+	}
+	library := e.owned[userID]
+	if library == nil {
+		library = map[string]int{}
+		e.owned[userID] = library
+	}
+	library[gameID]++
+	if library[gameID] > 1 {
+		return
+	}
+	e.owners[gameID]++
+	for other, copies := range library {
+		if other != gameID && copies > 0 {
+			e.addTogether(gameID, other, 1)
+		}
+	}
+}
+
+func (e *Engine) revoke(userID string, gameID string) {
+	library := e.owned[userID]
+	if library[gameID] <= 0 {
+		return
+	}
+	library[gameID]--
+	if library[gameID] > 0 {
+		return
+	}
+	delete(library, gameID)
+	e.owners[gameID]--
+	for other, copies := range library {
+		if copies > 0 {
+			e.addTogether(gameID, other, -1)
+		}
+	}
+}
+
+func (e *Engine) addTogether(a string, b string, n int) {
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		if e.together[pair[0]] == nil {
+			e.together[pair[0]] = map[string]int{}
+		}
+		e.together[pair[0]][pair[1]] += n
+		if e.together[pair[0]][pair[1]] <= 0 {
+			delete(e.together[pair[0]], pair[1])
+		}
+	}
+}
+
+// ----------------- Recommendations -----------------
+
+// Similar returns the games most like the game, best first. Ties are broken by
+// ID so the same data always gives the same list.
+func (e *Engine) Similar(gameID string, limit int) []Scored {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	scores := e.similar(gameID)
+	delete(scores, gameID)
+	return top(scores, limit)
+}
+
+// Recommend returns games for the user from what they own and wishlisted,
+// leaving out both. Users with nothing to go on get the most owned games.
+func (e *Engine) Recommend(userID string, wishlist []string, limit int) []Scored {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	library := e.owned[userID]
+	seeds := map[string]float64{}
+	for id, copies := range library {
+		if copies > 0 {
+			seeds[id] = 1
+		}
+	}
+	for _, id := range wishlist {
+		if _, ok := seeds[id]; !ok {
+			seeds[id] = WishlistWeight
+		}
+	}
+
+	scores := map[string]float64{}
+	for seed, weight := range seeds {
+		for id, score := range e.similar(seed) {
+			scores[id] += weight * score
+		}
+	}
+	if len(scores) == 0 {
+		for id := range e.games {
+			scores[id] = float64(e.owners[id])
+		}
+	}
+	for id := range seeds {
+		delete(scores, id)
+	}
+	for id := range scores {
+		game := e.games[id]
+		// DLC is only worth showing to people with the base game
+		if game.IsDLC() && library[game.ParentID] <= 0 {
+			delete(scores, id)
+		}
+	}
+	return top(scores, limit)
+}
+
+// similar scores every catalog game against the game, by how many users own
+// both compared to how many own each, and by how many tags they share
+func (e *Engine) similar(gameID string) map[string]float64 {
+	scores := map[string]float64{}
+	for other, both := range e.together[gameID] {
+		if _, ok := e.games[other]; !ok || both <= 0 {
+			continue
+		}
+		scores[other] += CoPurchaseWeight * float64(both) / math.Sqrt(float64(e.owners[gameID]*e.owners[other]))
+	}
+	game, ok := e.games[gameID]
+	if ok && len(game.Tags) > 0 {
+		for id, other := range e.games {
+			if overlap := tagOverlap(game.Tags, other.Tags); overlap > 0 {
+				scores[id] += TagWeight * overlap
+			}
+		}
+	}
+	return scores
+}
+
+// tagOverlap is the Jaccard index of the two tag lists, ignoring case
+func tagOverlap(a []string, b []string) float64 {
+	set := map[string]bool{}
+	for _, tag := range a {
+		set[strings.ToLower(tag)] = true
+	}
+	shared, union := 0, len(set)
+	seen := map[string]bool{}
+	for _, tag := range b {
+		tag = strings.ToLower(tag)
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		if set[tag] {
+			shared++
+		} else {
+			union++
+		}
+	}
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+func top(scores map[string]float64, limit int) []Scored {
+	scored := []Scored{}
+	for id, score := range scores {
+		scored = append(scored, Scored{GameID: id, Score: score})
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].Score != scored[j].Score {
+			return scored[i].Score > scored[j].Score
+		}
+		return scored[i].GameID < scored[j].GameID
+	})
+	if limit > 0 && len(scored) > limit {
+		scored = scored[:limit]
+	}
+	return scored
+}
@@ -0,0 +1,167 @@
+package recommend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	events "github.com/Draupniyr/games-service/events"
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+func testEngine() *Engine {
+	engine := NewEngine()
+	engine.SetCatalog([]structs.Game{
+		{ID: "portal", Title: "Portal", Tags: []string{"Puzzle", "Sci-Fi"}},
+		{ID: "portal2", Title: "Portal 2", Tags: []string{"Puzzle", "Sci-Fi", "Co-op"}},
+		{ID: "talos", Title: "The Talos Principle", Tags: []string{"Puzzle"}},
+		{ID: "racer", Title: "Racing Stars", Tags: []string{"Racing"}},
+		{ID: "farm", Title: "Farm Life", Tags: []string{"Simulation"}},
+		{ID: "portal2-dlc", Title: "Portal 2 Perpetual Testing", Tags: []string{"Puzzle"}, Kind: structs.KindDLC, ParentID: "portal2"},
+		{ID: "puzzle-pack", Title: "Puzzle Pack", Kind: structs.KindBundle, BundleItems: []string{"portal", "talos"}},
+	})
+	return engine
+}
+
+func checkout(t *testing.T, engine *Engine, userID string, body string) {
+	err := engine.Apply(events.TopicCheckout, []byte(`{"UserID":"`+userID+`","Games":[`+body+`]}`))
+	if err != nil {
+		t.Fatalf("Error applying checkout: %v", err)
+	}
+}
+
+func scoredIDs(scored []Scored) []string {
+	ids := []string{}
+	for _, s := range scored {
+		ids = append(ids, s.GameID)
+	}
+	return ids
+}
+
+func sameIDs(t *testing.T, want []string, got []Scored) {
+	ids := scoredIDs(got)
+	if len(ids) != len(want) {
+		t.Errorf("Expected %v got %v", want, ids)
+		return
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("Expected %v got %v", want, ids)
+			return
+		}
+	}
+}
+
+func TestSimilarByTags(t *testing.T) {
+	engine := testEngine()
+	// portal2 shares two of three tags, talos and the DLC one of two
+	sameIDs(t, []string{"portal2", "portal2-dlc", "talos"}, engine.Similar("portal", 0))
+	sameIDs(t, []string{"portal2"}, engine.Similar("portal", 1))
+	sameIDs(t, []string{}, engine.Similar("missing", 0))
+}
+
+func TestSimilarByCoPurchase(t *testing.T) {
+	engine := testEngine()
+	checkout(t, engine, "u1", `{"ID":"racer"},{"ID":"farm"}`)
+	checkout(t, engine, "u2", `{"ID":"racer"},{"ID":"farm"}`)
+
+	// racer has no tags in common with anything, only co-purchases
+	sameIDs(t, []string{"farm"}, engine.Similar("racer", 0))
+	if !engine.Owns("u1", "farm") {
+		t.Errorf("Expected u1 to own farm")
+	}
+}
+
+func TestBundleAndRefund(t *testing.T) {
+	engine := testEngine()
+	checkout(t, engine, "u1", `{"ID":"racer"}`)
+	checkout(t, engine, "u1", `{"ID":"puzzle-pack","BundleItems":[{"ID":"portal"},{"ID":"talos"}]}`)
+	if !engine.Owns("u1", "portal") || !engine.Owns("u1", "talos") {
+		t.Errorf("Expected the bundle games to be owned")
+	}
+	sameIDs(t, []string{"portal", "talos"}, engine.Similar("racer", 0))
+
+	err := engine.Apply(events.TopicRefundApproved, []byte(`{"UserID":"u1","GameID":"puzzle-pack"}`))
+	if err != nil {
+		t.Fatalf("Error applying refund: %v", err)
+	}
+	if engine.Owns("u1", "portal") || engine.Owns("u1", "talos") {
+		t.Errorf("Expected the refunded bundle games to be gone")
+	}
+	sameIDs(t, []string{}, engine.Similar("racer", 0))
+}
+
+func TestRefundKeepsOtherCopy(t *testing.T) {
+	engine := testEngine()
+	checkout(t, engine, "u1", `{"ID":"farm"}`)
+	err := engine.Apply(events.TopicGiftAccepted, []byte(`{"RecipientID":"u1","Game":{"ID":"farm"}}`))
+	if err != nil {
+		t.Fatalf("Error applying gift: %v", err)
+	}
+	engine.Apply(events.TopicRefundApproved, []byte(`{"UserID":"u1","GameID":"farm"}`))
+	if !engine.Owns("u1", "farm") {
+		t.Errorf("Expected the gifted copy to still be owned")
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	engine := testEngine()
+	checkout(t, engine, "u1", `{"ID":"portal"},{"ID":"racer"}`)
+	checkout(t, engine, "u2", `{"ID":"portal"},{"ID":"farm"}`)
+
+	// owned games are left out and the DLC needs portal2
+	got := engine.Recommend("u1", nil, 0)
+	sameIDs(t, []string{"farm", "portal2", "talos"}, got)
+
+	// wishlisted games are seeds too but never recommended
+	got = engine.Recommend("u1", []string{"portal2"}, 0)
+	for _, id := range scoredIDs(got) {
+		if id == "portal2" || id == "portal" || id == "racer" {
+			t.Errorf("Expected %s to be left out", id)
+		}
+	}
+
+	// nothing to go on falls back to the most owned games
+	sameIDs(t, []string{"portal", "farm", "racer"}, engine.Recommend("new", nil, 3))
+}
+
+func TestRecommendDeterministic(t *testing.T) {
+	first := testEngine()
+	second := testEngine()
+	for _, engine := range []*Engine{first, second} {
+		checkout(t, engine, "u1", `{"ID":"portal"},{"ID":"talos"}`)
+		checkout(t, engine, "u2", `{"ID":"portal2"},{"ID":"talos"}`)
+	}
+	for i := 0; i < 10; i++ {
+		sameIDs(t, scoredIDs(first.Recommend("u1", nil, 0)), second.Recommend("u1", nil, 0))
+	}
+}
+
+func TestReplayFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	lines := `{"Topic":"checkout","Value":{"UserID":"u1","Games":[{"ID":"racer"},{"ID":"farm"}]}}
+
+{"Topic":"cart.abandoned","Value":{"UserID":"u2"}}
+{"Topic":"gift.accepted","Value":{"RecipientID":"u2","Game":{"ID":"racer"}}}
+`
+	err := os.WriteFile(path, []byte(lines), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := events.Load(path)
+	if err != nil {
+		t.Fatalf("Error loading events: %v", err)
+	}
+	if len(saved) != 3 {
+		t.Errorf("Expected 3 events got %d", len(saved))
+	}
+	engine := testEngine()
+	err = engine.Replay(saved)
+	if err != nil {
+		t.Fatalf("Error replaying events: %v", err)
+	}
+	if !engine.Owns("u2", "racer") {
+		t.Errorf("Expected u2 to own racer")
+	}
+	sameIDs(t, []string{"farm"}, engine.Recommend("u2", nil, 0))
+}
@@ -0,0 +1,401 @@
+package search
+
+import (
+	"html"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+// How much a match in each field counts
+const (
+	TitleBoost       = 3.0
+	TagBoost         = 2.0
+	DescriptionBoost = 1.0
+)
+
+// How much a query word counts when it matches a word in the index exactly,
+// as the start of a longer word, or with a typo
+const (
+	exactWeight  = 1.0
+	prefixWeight = 0.6
+	fuzzyWeight  = 0.4
+)
+
+// Options narrow the results down. Empty fields don't filter. Prices are
+// compared in Currency, the game's regional price when it has one.
+type Options struct {
+	Tags     []string
+	AuthorID string
+	Kind     string
+	Currency string
+	MinPrice *int64
+	MaxPrice *int64
+	Limit    int
+}
+
+// Result is a game that matched, with the matched words in the title and
+// description wrapped in <mark>
+type Result struct {
+	Game        structs.Game
+	Score       float64
+	Title       template.HTML
+	Description template.HTML
+}
+
+// Index is an inverted index of the catalog kept in memory. It is safe to use
+// from several goroutines.
+type Index struct {
+	mu    sync.RWMutex
+	games map[string]structs.Game
+	// postings maps each word to the games it is in and how much it counts there
+	postings map[string]map[string]float64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		games:    map[string]structs.Game{},
+		postings: map[string]map[string]float64{},
+	}
+}
+
+// Rebuild replaces everything in the index with the games
+func (idx *Index) Rebuild(games []structs.Game) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.games = map[string]structs.Game{}
+	idx.postings = map[string]map[string]float64{}
+	for _, game := range games {
+		idx.add(game)
+	}
+}
+
+// Add indexes the game, replacing what was indexed for it before
+func (idx *Index) Add(game structs.Game) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(game.ID)
+	idx.add(game)
+}
+
+func (idx *Index) Remove(gameID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(gameID)
+}
+
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.games)
+}
+
+func (idx *Index) add(game structs.Game) {
+	idx.games[game.ID] = game
+	weights := map[string]float64{}
+	for _, term := range Terms(game.Title) {
+		weights[term] += TitleBoost
+	}
+	for _, tag := range game.Tags {
+		for _, term := range Terms(tag) {
+			weights[term] += TagBoost
+		}
+	}
+	for _, term := range Terms(game.Description) {
+		weights[term] += DescriptionBoost
+	}
+	for term, weight := range weights {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[string]float64{}
+		}
+		idx.postings[term][game.ID] = weight
+	}
+}
+
+func (idx *Index) remove(gameID string) {
+	if _, ok := idx.games[gameID]; !ok {
+		return
+	}
+	delete(idx.games, gameID)
+	for term, games := range idx.postings {
+		delete(games, gameID)
+		if len(games) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+}
+
+// Search returns the games matching every word of the query, best first. The
+// last word also matches as a prefix so results show up while typing, and
+// longer words match with a typo or two. An empty query returns every game
+// that passes the filters, by title.
+func (idx *Index) Search(query string, options Options) []Result {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	words := Terms(query)
+	scores := map[string]float64{}
+	matched := map[string]bool{}
+	if len(words) == 0 {
+		for id := range idx.games {
+			scores[id] = 0
+		}
+	}
+	for i, word := range words {
+		wordScores := map[string]float64{}
+		for term, weight := range idx.matchTerms(word, i == len(words)-1) {
+			matched[term] = true
+			idf := math.Log(1 + float64(len(idx.games))/float64(len(idx.postings[term])))
+			for id, fieldWeight := range idx.postings[term] {
+				score := weight * fieldWeight * idf
+				if score > wordScores[id] {
+					wordScores[id] = score
+				}
+			}
+		}
+		if i == 0 {
+			scores = wordScores
+			continue
+		}
+		// every word has to match
+		for id := range scores {
+			if _, ok := wordScores[id]; !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += wordScores[id]
+		}
+	}
+
+	results := []Result{}
+	for id, score := range scores {
+		game := idx.games[id]
+		if !options.matches(game) {
+			continue
+		}
+		results = append(results, Result{
+			Game:        game,
+			Score:       score,
+			Title:       Highlight(game.Title, matched),
+			Description: Highlight(game.Description, matched),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Game.Title < results[j].Game.Title
+	})
+	if options.Limit > 0 && len(results) > options.Limit {
+		results = results[:options.Limit]
+	}
+	return results
+}
+
+// matchTerms finds the words in the index the query word matches and how
+// much each match counts
+func (idx *Index) matchTerms(word string, prefix bool) map[string]float64 {
+	found := map[string]float64{}
+	maxEdits := allowedEdits(word)
+	for term := range idx.postings {
+		weight := 0.0
+		switch {
+		case term == word:
+			weight = exactWeight
+		case prefix && strings.HasPrefix(term, word):
+			weight = prefixWeight
+		case maxEdits > 0 && withinEdits(word, term, maxEdits):
+			weight = fuzzyWeight
+		}
+		if weight > 0 {
+			found[term] = weight
+		}
+	}
+	return found
+}
+
+func (o Options) matches(game structs.Game) bool {
+	if o.AuthorID != "" && game.AuthorID != o.AuthorID {
+		return false
+	}
+	if o.Kind != "" {
+		kind := game.Kind
+		if kind == "" {
+			kind = structs.KindGame
+		}
+		if kind != o.Kind {
+			return false
+		}
+	}
+	for _, tag := range o.Tags {
+		if !hasTag(game, tag) {
+			return false
+		}
+	}
+	if o.MinPrice != nil || o.MaxPrice != nil {
+		currency := o.Currency
+		if currency == "" {
+			currency = structs.DefaultCurrency
+		}
+		price := game.SalePriceIn(currency)
+		if price.Currency != currency {
+			return false
+		}
+		if o.MinPrice != nil && price.Amount < *o.MinPrice {
+			return false
+		}
+		if o.MaxPrice != nil && price.Amount > *o.MaxPrice {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(game structs.Game, tag string) bool {
+	for _, gameTag := range game.Tags {
+		if strings.EqualFold(strings.TrimSpace(gameTag), strings.TrimSpace(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------- Text -----------------
+
+// Terms splits text into lower case words and stems them
+func Terms(text string) []string {
+	terms := []string{}
+	for _, word := range words(text) {
+		terms = append(terms, Stem(word))
+	}
+	return terms
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Stem takes the common English endings off a lower case word so "racing"
+// and "raced" both index as "rac", while "races" only loses its s and
+// indexes as "race". It is deliberately simple, words only need to stem the
+// same way in the index and in queries.
+func Stem(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+		return word
+	case strings.HasSuffix(word, "ing") && len(word) > 5:
+		return trimDouble(word[:len(word)-3])
+	case strings.HasSuffix(word, "ed") && len(word) > 4:
+		return trimDouble(word[:len(word)-2])
+	case strings.HasSuffix(word, "ly") && len(word) > 4:
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "es") && len(word) > 4:
+		return word[:len(word)-1]
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// trimDouble turns "shoott" back into "shoot" after "shooting" lost its
+// ending, and "stopp" into "stop"
+func trimDouble(stem string) string {
+	n := len(stem)
+	if n >= 3 && stem[n-1] == stem[n-2] && !strings.ContainsRune("aeioulsz", rune(stem[n-1])) {
+		return stem[:n-1]
+	}
+	return stem
+}
+
+// allowedEdits is how many typos a query word may have, none for short words
+func allowedEdits(word string) int {
+	switch {
+	case len(word) >= 8:
+		return 2
+	case len(word) >= 4:
+		return 1
+	}
+	return 0
+}
+
+// withinEdits reports if the Levenshtein distance between a and b is at most max
+func withinEdits(a string, b string, max int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > max {
+		return false
+	}
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		best := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			best = min(best, current[j])
+		}
+		if best > max {
+			return false
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)] <= max
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Highlight escapes the text and wraps the words whose stem is in terms in <mark>
+func Highlight(text string, terms map[string]bool) template.HTML {
+	var b strings.Builder
+	start := -1
+	flush := func(end int) {
+		word := text[start:end]
+		if terms[Stem(strings.ToLower(word))] {
+			b.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		start = -1
+	}
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord {
+			if start >= 0 {
+				flush(i)
+			}
+			b.WriteString(html.EscapeString(string(r)))
+		}
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return template.HTML(b.String())
+}
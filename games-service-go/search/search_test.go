@@ -0,0 +1,107 @@
+package search
+
+import (
+	"testing"
+
+	structs "github.com/Draupniyr/games-service/structs"
+)
+
+func testIndex() *Index {
+	idx := NewIndex()
+	idx.Rebuild([]structs.Game{
+		{ID: "1", Title: "Portal", Description: "A puzzle game with portals", Tags: []string{"Puzzle"}, Price: structs.NewMoney(999, "USD"), AuthorID: "Valve"},
+		{ID: "2", Title: "Portal 2", Description: "More puzzles, now with co-op", Tags: []string{"Puzzle", "Co-op"}, Price: structs.NewMoney(1999, "USD"), AuthorID: "Valve"},
+		{ID: "3", Title: "Racing Stars", Description: "Arcade racing with a portal level", Tags: []string{"Racing"}, Price: structs.NewMoney(0, "USD"), AuthorID: "Other"},
+		{ID: "4", Title: "Farm Life", Description: "Grow crops & raise animals", Tags: []string{"Simulation"}, Price: structs.NewMoney(1499, "USD"), AuthorID: "Other"},
+	})
+	return idx
+}
+
+func resultIDs(results []Result) []string {
+	ids := []string{}
+	for _, result := range results {
+		ids = append(ids, result.Game.ID)
+	}
+	return ids
+}
+
+func sameIDs(t *testing.T, want []string, got []Result) {
+	ids := resultIDs(got)
+	if len(ids) != len(want) {
+		t.Errorf("Expected %v got %v", want, ids)
+		return
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("Expected %v got %v", want, ids)
+			return
+		}
+	}
+}
+
+func TestSearchRanksTitleMatchesFirst(t *testing.T) {
+	idx := testIndex()
+	// lower case matches "Portal 2" too, the description match ranks last
+	results := idx.Search("portal", Options{})
+	sameIDs(t, []string{"1", "2", "3"}, results)
+
+	// every word has to match
+	sameIDs(t, []string{"2"}, idx.Search("portal 2", Options{}))
+}
+
+func TestSearchStemsPrefixesAndTypos(t *testing.T) {
+	idx := testIndex()
+	sameIDs(t, []string{"1", "2"}, idx.Search("puzzles", Options{}))
+	// the last word matches as a prefix
+	sameIDs(t, []string{"4"}, idx.Search("farm li", Options{}))
+	// one typo is fine on a longer word
+	sameIDs(t, []string{"4"}, idx.Search("simulatoin", Options{}))
+}
+
+func TestSearchFilters(t *testing.T) {
+	idx := testIndex()
+	sameIDs(t, []string{"2"}, idx.Search("portal", Options{Tags: []string{"co-op"}}))
+	sameIDs(t, []string{"3"}, idx.Search("portal", Options{AuthorID: "Other"}))
+
+	max := int64(1000)
+	sameIDs(t, []string{"1", "3"}, idx.Search("portal", Options{Currency: "USD", MaxPrice: &max}))
+
+	// no query lists everything that passes the filters, by title
+	sameIDs(t, []string{"4", "3"}, idx.Search("", Options{AuthorID: "Other"}))
+}
+
+func TestIndexAddAndRemove(t *testing.T) {
+	idx := testIndex()
+	idx.Remove("1")
+	sameIDs(t, []string{"2", "3"}, idx.Search("portal", Options{}))
+
+	idx.Add(structs.Game{ID: "2", Title: "Gateway", Description: "Renamed"})
+	sameIDs(t, []string{"3"}, idx.Search("portal", Options{}))
+	sameIDs(t, []string{"2"}, idx.Search("gateway", Options{}))
+}
+
+func TestHighlight(t *testing.T) {
+	got := Highlight("Portal <2> & portals", map[string]bool{"portal": true})
+	want := "<mark>Portal</mark> &lt;2&gt; &amp; <mark>portals</mark>"
+	if string(got) != want {
+		t.Errorf("Expected %v got %v", want, got)
+	}
+}
+
+func TestStem(t *testing.T) {
+	tests := map[string]string{
+		"games":    "game",
+		"racing":   "rac",
+		"raced":    "rac",
+		"races":    "race",
+		"stories":  "story",
+		"shooting": "shoot",
+		"boss":     "boss",
+		"portal":   "portal",
+	}
+	for word, want := range tests {
+		if got := Stem(word); got != want {
+			t.Errorf("Stem(%s): expected %v got %v", word, want, got)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package structs
+
+// AuditEntry is one action in the audit log. Entries are only ever added,
+// DynamoDB drops them once ExpiresAt, in epoch seconds, has passed.
+type AuditEntry struct {
+	ID         string        `json:"ID"`
+	Time       string        `json:"Time"`
+	Service    string        `json:"Service"`
+	ActorID    string        `json:"ActorID"`
+	ActorRole  string        `json:"ActorRole"`
+	Action     string        `json:"Action"`
+	TargetType string        `json:"TargetType"`
+	TargetID   string        `json:"TargetID"`
+	Changes    []AuditChange `json:"Changes"`
+	RequestID  string        `json:"RequestID"`
+	IP         string        `json:"IP"`
+	ExpiresAt  int64         `json:"ExpiresAt"`
+}
+
+// AuditChange is a field of the target that changed, with the JSON of its
+// value before and after. An empty side means the field wasn't there.
+type AuditChange struct {
+	Field  string `json:"Field"`
+	Before string `json:"Before"`
+	After  string `json:"After"`
+}
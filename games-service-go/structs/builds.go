@@ -0,0 +1,67 @@
+package structs
+
+// Platforms a build can be for
+const (
+	PlatformWindows = "windows"
+	PlatformMac     = "mac"
+	PlatformLinux   = "linux"
+)
+
+var Platforms = []string{PlatformWindows, PlatformMac, PlatformLinux}
+
+// Release channels, beta builds are for owners who want to try what is next
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+var Channels = []string{ChannelStable, ChannelBeta}
+
+// Build is an uploaded version of a game for one platform and channel. Key
+// is where the blob store keeps the file and SHA256 its hex checksum.
+type Build struct {
+	ID         string `json:"ID"`
+	GameID     string `json:"GameID"`
+	Version    string `json:"Version"`
+	Platform   string `json:"Platform"`
+	Channel    string `json:"Channel"`
+	Notes      string `json:"Notes"`
+	FileName   string `json:"FileName"`
+	Key        string `json:"Key"`
+	Size       int64  `json:"Size"`
+	SHA256     string `json:"SHA256"`
+	Uploaded   string `json:"Uploaded"`
+	UploadedBy string `json:"UploadedBy"`
+}
+
+type BuildRequest struct {
+	Version  string `validate:"required,max=40,chars=line"`
+	Platform string `validate:"required,oneof=windows mac linux"`
+	Channel  string `validate:"required,oneof=stable beta"`
+	Notes    string `validate:"html,max=5000,chars=text"`
+	FileName string `validate:"max=255,chars=line"`
+	// SHA256 is the checksum the developer expects, checked when set
+	SHA256 string `validate:"max=64,chars=id"`
+}
+
+// LibraryEntry is a game in a user's library. Grants are the orders and
+// gifts the user got the game through, like "order:<ID>" or "gift:<ID>", and
+// Revoked the grants taken back by refunds. The game is owned while any grant
+// is left. Keeping them makes replaying the events from the start safe.
+type LibraryEntry struct {
+	ID      string   `json:"ID"`
+	UserID  string   `json:"UserID"`
+	GameID  string   `json:"GameID"`
+	Grants  []string `json:"Grants"`
+	Revoked []string `json:"Revoked"`
+	Added   string   `json:"Added"`
+	Updated string   `json:"Updated"`
+}
+
+func LibraryEntryID(userID string, gameID string) string {
+	return userID + "#" + gameID
+}
+
+func (e LibraryEntry) Owned() bool {
+	return len(e.Grants) > 0
+}
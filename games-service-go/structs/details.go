@@ -0,0 +1,129 @@
+package structs
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// AgeRatings are the ratings a game can be listed with, by the youngest age
+// it is meant for. An empty rating means the game hasn't been rated.
+var AgeRatings = []string{"3+", "7+", "12+", "16+", "18+"}
+
+// Requirements are what a computer needs to run the game, as the developer
+// writes them
+type Requirements struct {
+	OS        string `json:"OS" validate:"max=200,chars=line"`
+	Processor string `json:"Processor" validate:"max=200,chars=line"`
+	Memory    string `json:"Memory" validate:"max=200,chars=line"`
+	Graphics  string `json:"Graphics" validate:"max=200,chars=line"`
+	Storage   string `json:"Storage" validate:"max=200,chars=line"`
+}
+
+func (r Requirements) IsZero() bool {
+	return r == Requirements{}
+}
+
+type SystemRequirements struct {
+	Minimum     Requirements `json:"Minimum"`
+	Recommended Requirements `json:"Recommended"`
+}
+
+func (s SystemRequirements) IsZero() bool {
+	return s.Minimum.IsZero() && s.Recommended.IsZero()
+}
+
+// parseFormRequirements fills in the requirements from the flat fields a form
+// sends, like "Minimum.OS", on top of a Requirements object
+func parseFormRequirements(data []byte, requirements *SystemRequirements) error {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+	for key, raw := range fields {
+		level, field, ok := strings.Cut(key, ".")
+		if !ok {
+			continue
+		}
+		var target *Requirements
+		switch level {
+		case "Minimum":
+			target = &requirements.Minimum
+		case "Recommended":
+			target = &requirements.Recommended
+		default:
+			continue
+		}
+		value := ""
+		err = json.Unmarshal(raw, &value)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSpace(value)
+		switch field {
+		case "OS":
+			target.OS = value
+		case "Processor":
+			target.Processor = value
+		case "Memory":
+			target.Memory = value
+		case "Graphics":
+			target.Graphics = value
+		case "Storage":
+			target.Storage = value
+		}
+	}
+	return nil
+}
+
+// Slugify turns a title into the lower case, dash separated form used in
+// URLs. Anything that isn't a letter or digit separates words.
+func Slugify(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// Released is the game's release date, or the day it was listed when the
+// developer didn't give one
+func (g Game) Released() string {
+	if g.ReleaseDate != "" {
+		return g.ReleaseDate
+	}
+	return g.Published
+}
+
+// Path is the game's detail page
+func (g Game) Path() string {
+	if g.Slug != "" {
+		return "/games/" + g.Slug
+	}
+	return "/games/" + g.ID
+}
+
+// PublisherName is who published the game, the developer when they published
+// it themselves
+func (g Game) PublisherName() string {
+	if g.Publisher != "" {
+		return g.Publisher
+	}
+	return g.Author
+}
+
+// GameDetail is a game as its detail page and API show it
+type GameDetail struct {
+	Game
+	// Parent is the base game of a DLC
+	Parent *Game       `json:"Parent,omitempty"`
+	Links  DetailLinks `json:"Links"`
+}
+
+type DetailLinks struct {
+	Self    string   `json:"Self"`
+	Updates string   `json:"Updates"`
+	Similar string   `json:"Similar"`
+	DLC     []string `json:"DLC"`
+	Parent  string   `json:"Parent,omitempty"`
+}
@@ -0,0 +1,66 @@
+package structs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Portal 2":              "portal-2",
+		"  Half-Life: Alyx  ":   "half-life-alyx",
+		"Tom Clancy's The Game": "tom-clancy-s-the-game",
+		"Pokémon":               "pokémon",
+		"!!!":                   "",
+	}
+	for title, want := range cases {
+		if got := Slugify(title); got != want {
+			t.Errorf("Slugify(%q) expected %q got %q", title, want, got)
+		}
+	}
+}
+
+func TestGamePostRequestDetails(t *testing.T) {
+	var request GamePostRequest
+	body := `{"Title":"T","Languages":"English, French,","Minimum.OS":" Windows 10 ","Recommended.Memory":"16 GB","Requirements":{"Minimum":{"Storage":"50 GB"}}}`
+	err := json.Unmarshal([]byte(body), &request)
+	if err != nil {
+		t.Fatalf("Error decoding request: %v", err)
+	}
+	if len(request.Languages) != 2 || request.Languages[1] != "French" {
+		t.Errorf("Expected English and French got %v", request.Languages)
+	}
+	want := SystemRequirements{
+		Minimum:     Requirements{OS: "Windows 10", Storage: "50 GB"},
+		Recommended: Requirements{Memory: "16 GB"},
+	}
+	if request.Requirements != want {
+		t.Errorf("Expected %v got %v", want, request.Requirements)
+	}
+}
+
+func TestReleased(t *testing.T) {
+	game := Game{Published: "2024-01-02"}
+	if game.Released() != "2024-01-02" {
+		t.Errorf("Expected the listing date got %q", game.Released())
+	}
+	game.ReleaseDate = "2020-05-06"
+	if game.Released() != "2020-05-06" {
+		t.Errorf("Expected the release date got %q", game.Released())
+	}
+}
+
+func TestGamePostRequestCheckboxes(t *testing.T) {
+	var request GamePostRequest
+	err := json.Unmarshal([]byte(`{"Title":"T","PreOrders":"on","EarlyAccess":false}`), &request)
+	if err != nil {
+		t.Fatalf("Error decoding request: %v", err)
+	}
+	if !request.PreOrders || request.EarlyAccess {
+		t.Errorf("Expected pre-orders and no early access got %v %v", request.PreOrders, request.EarlyAccess)
+	}
+	err = json.Unmarshal([]byte(`{"PreOrders":"maybe"}`), &request)
+	if err == nil {
+		t.Errorf("Expected an error for a bad checkbox value")
+	}
+}
@@ -0,0 +1,196 @@
+package structs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// DefaultCurrency is used for prices stored before currencies existed and
+// for requests that don't say which currency they are in.
+const DefaultCurrency = "USD"
+
+type currencyInfo struct {
+	Symbol string
+	Digits int
+}
+
+// Currencies we sell in, with their symbol and number of minor unit digits.
+var Currencies = map[string]currencyInfo{
+	"USD": {Symbol: "$", Digits: 2},
+	"CAD": {Symbol: "CA$", Digits: 2},
+	"EUR": {Symbol: "€", Digits: 2},
+	"GBP": {Symbol: "£", Digits: 2},
+	"JPY": {Symbol: "¥", Digits: 0},
+}
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// Money is an amount in the minor unit of its currency, so 1999 USD is $19.99.
+type Money struct {
+	Amount   int64  `json:"Amount" validate:"min=0"`
+	Currency string `json:"Currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func IsCurrency(currency string) bool {
+	_, ok := Currencies[currency]
+	return ok
+}
+
+// NormalizeCurrency upper cases the code and falls back to the default for
+// anything we don't sell in.
+func NormalizeCurrency(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !IsCurrency(currency) {
+		return DefaultCurrency
+	}
+	return currency
+}
+
+// ParseMoney reads a decimal amount in major units like "19.99" without going
+// through a float. Amounts can't be negative or signed, so no price,
+// discount or gift card is read as less than nothing.
+func ParseMoney(value string, currency string) (Money, error) {
+	info, ok := Currencies[currency]
+	if !ok {
+		return Money{}, ErrUnknownCurrency
+	}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		return Money{}, fmt.Errorf("invalid amount %q, it can't have a sign", value)
+	}
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(fraction) > info.Digits {
+		return Money{}, fmt.Errorf("%s has at most %d decimals", currency, info.Digits)
+	}
+	fraction += strings.Repeat("0", info.Digits-len(fraction))
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// MoneyFromFloat converts a legacy float price in major units.
+func MoneyFromFloat(value float64, currency string) Money {
+	digits := Currencies[currency].Digits
+	return Money{Amount: int64(math.Round(value * math.Pow10(digits))), Currency: currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}
+}
+
+// Percent returns the given percentage of the amount, rounded half up to the
+// minor unit.
+func (m Money) Percent(percent float64) Money {
+	basisPoints := int64(math.Round(percent * 100))
+	return Money{Amount: (m.Amount*basisPoints + 5000) / 10000, Currency: m.Currency}
+}
+
+// Decimal is the amount in major units without a symbol, like "19.99".
+func (m Money) Decimal() string {
+	digits := Currencies[m.Currency].Digits
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if digits == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	unit := int64(math.Pow10(digits))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, digits, amount%unit)
+}
+
+func (m Money) String() string {
+	info, ok := Currencies[m.Currency]
+	if !ok {
+		return m.Decimal() + " " + m.Currency
+	}
+	if m.Amount < 0 {
+		return "-" + info.Symbol + Money{Amount: -m.Amount, Currency: m.Currency}.Decimal()
+	}
+	return info.Symbol + m.Decimal()
+}
+
+// JSON is used by the templates to pass prices on to the cart.
+func (m Money) JSON() string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// UnmarshalJSON takes {"Amount":1999,"Currency":"USD"} or a plain decimal
+// number in major units of the default currency, which is what older clients send.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		type Alias Money
+		var alias Alias
+		if err := json.Unmarshal(data, &alias); err != nil {
+			return err
+		}
+		*m = Money(alias)
+		m.Currency = NormalizeCurrency(m.Currency)
+		return nil
+	}
+	if trimmed == "null" {
+		return nil
+	}
+	parsed, err := ParseMoney(strings.Trim(trimmed, `"`), DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// UnmarshalDynamoDBAttributeValue also reads the float prices stored before
+// Money existed, so old items load and get rewritten by the migration.
+func (m *Money) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if av.N != nil {
+		value, err := strconv.ParseFloat(*av.N, 64)
+		if err != nil {
+			return err
+		}
+		*m = MoneyFromFloat(value, DefaultCurrency)
+		return nil
+	}
+	if av.M == nil {
+		return nil
+	}
+	type Alias Money
+	var alias Alias
+	if err := dynamodbattribute.UnmarshalMap(av.M, &alias); err != nil {
+		return err
+	}
+	*m = Money(alias)
+	return nil
+}
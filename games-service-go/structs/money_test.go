@@ -0,0 +1,105 @@
+package structs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     Money
+	}{
+		{"19.99", "USD", NewMoney(1999, "USD")},
+		{"0.1", "USD", NewMoney(10, "USD")},
+		{"5", "EUR", NewMoney(500, "EUR")},
+		{"1200", "JPY", NewMoney(1200, "JPY")},
+		{".5", "GBP", NewMoney(50, "GBP")},
+	}
+	for _, test := range tests {
+		got, err := ParseMoney(test.value, test.currency)
+		if err != nil {
+			t.Errorf("Error parsing %s: %v", test.value, err)
+		}
+		if got != test.want {
+			t.Errorf("Expected %v got %v", test.want, got)
+		}
+	}
+
+	invalid := []string{"1.999", "abc", "1.2.3", "-5", "-0.50", "+5", " +1.00", "1.-5"}
+	for _, value := range invalid {
+		if _, err := ParseMoney(value, "USD"); err == nil {
+			t.Errorf("Expected %s to be rejected", value)
+		}
+	}
+	if _, err := ParseMoney("1.5", "JPY"); err == nil {
+		t.Errorf("Expected JPY decimals to be rejected")
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	if got := NewMoney(1999, "USD").String(); got != "$19.99" {
+		t.Errorf("Expected $19.99 got %s", got)
+	}
+	if got := NewMoney(1200, "JPY").String(); got != "¥1200" {
+		t.Errorf("Expected ¥1200 got %s", got)
+	}
+	if got := NewMoney(5, "EUR").String(); got != "€0.05" {
+		t.Errorf("Expected €0.05 got %s", got)
+	}
+}
+
+func TestMoneyPercentRoundsHalfUp(t *testing.T) {
+	if got := NewMoney(1234, "USD").Percent(50); got.Amount != 617 {
+		t.Errorf("Expected 617 got %d", got.Amount)
+	}
+	if got := NewMoney(999, "USD").Percent(12.5); got.Amount != 125 {
+		t.Errorf("Expected 125 got %d", got.Amount)
+	}
+}
+
+func TestGamePostRequestPrices(t *testing.T) {
+	var request GamePostRequest
+	err := json.Unmarshal([]byte(`{"Title":"T","price":"0.30","Currency":"eur","Prices":{"usd":0.35,"JPY":{"Amount":40,"Currency":"JPY"}}}`), &request)
+	if err != nil {
+		t.Fatalf("Error decoding request: %v", err)
+	}
+	if request.Price != NewMoney(30, "EUR") {
+		t.Errorf("Expected 30 EUR got %v", request.Price)
+	}
+	if request.Prices["USD"] != NewMoney(35, "USD") {
+		t.Errorf("Expected 35 USD got %v", request.Prices["USD"])
+	}
+	if request.Prices["JPY"] != NewMoney(40, "JPY") {
+		t.Errorf("Expected 40 JPY got %v", request.Prices["JPY"])
+	}
+}
+
+func TestLegacyFloatPriceFromDynamoDB(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{
+		"ID":    {S: aws.String("Game1")},
+		"Price": {N: aws.String("12.34")},
+	}
+	game := Game{}
+	err := dynamodbattribute.UnmarshalMap(item, &game)
+	if err != nil {
+		t.Fatalf("Error unmarshaling game: %v", err)
+	}
+	if game.Price != NewMoney(1234, "USD") {
+		t.Errorf("Expected 1234 USD got %v", game.Price)
+	}
+
+	// and it comes back out as a Money map
+	marshaled, err := dynamodbattribute.MarshalMap(game)
+	if err != nil {
+		t.Fatalf("Error marshaling game: %v", err)
+	}
+	if marshaled["Price"].M == nil {
+		t.Errorf("Expected price to be stored as a map")
+	}
+}
@@ -1,23 +1,22 @@
 package structs
 
 import (
+	"errors"
 	"log"
-	"strconv"
 	"strings"
 
 	"time"
 
 	"encoding/json"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/google/uuid"
 )
 
 type UpdatePostObject struct {
-	Title   string `json:"Title"`
-	Content string `json:"Content"`
+	Title   string `json:"Title" validate:"required,max=100,chars=line"`
+	Content string `json:"Content" validate:"html,required,max=10000,chars=text"`
 }
 
 func (u *UpdatePostObject) UpdatePostObjectToUpdate() Update {
@@ -51,12 +50,28 @@ func UpdateToDynamoDBItem(updates []Update) []*dynamodb.AttributeValue {
 }
 
 type GamePostRequest struct {
-	Title       string   `json:"Title"`
-	Description string   `json:"Description"`
-	Tags        []string `json:"Tags"`
-	Price       float64  `json:"price"`
-	Author      string   `json:"Author"`
-	AuthorID    string   `json:"AuthorID"`
+	Title       string           `json:"Title" validate:"required,max=100,chars=line"`
+	Description string           `json:"Description" validate:"html,required,max=5000,chars=text"`
+	Tags        []string         `json:"Tags" validate:"max=20" each:"required,max=40,chars=line"`
+	Price       Money            `json:"price"`
+	Currency    string           `json:"Currency"`
+	Prices      map[string]Money `json:"Prices"`
+	Author      string           `json:"Author" validate:"required,max=100,chars=line"`
+	AuthorID    string           `json:"AuthorID"`
+	// OrganizationID lists the game under one of the author's organizations
+	OrganizationID string   `json:"OrganizationID" validate:"max=64,chars=id"`
+	Kind           string   `json:"Kind" validate:"oneof=game dlc bundle"`
+	ParentID       string   `json:"ParentID" validate:"max=64,chars=id"`
+	BundleItems    []string `json:"BundleItems" validate:"max=50" each:"required,max=64,chars=id"`
+
+	ReleaseDate  string             `json:"ReleaseDate" validate:"max=10"`
+	Publisher    string             `json:"Publisher" validate:"max=100,chars=line"`
+	Languages    []string           `json:"Languages" validate:"max=50" each:"required,max=40,chars=line"`
+	AgeRating    string             `json:"AgeRating" validate:"oneof=3+ 7+ 12+ 16+ 18+"`
+	Requirements SystemRequirements `json:"Requirements"`
+	ReleaseAt    string             `json:"ReleaseAt" validate:"max=40"`
+	PreOrders    bool               `json:"PreOrders"`
+	EarlyAccess  bool               `json:"EarlyAccess"`
 }
 
 func (g *GamePostRequest) GamePostRequestToGame() Game {
@@ -70,150 +85,442 @@ func (g *GamePostRequest) GamePostRequestToGame() Game {
 		Description: g.Description,
 		Tags:        g.Tags,
 		Price:       g.Price,
+		Prices:      g.Prices,
 		Updates:    []Update{},
 		Author: 	g.Author,
 		AuthorID: 	g.AuthorID,
+		OrganizationID: strings.TrimSpace(g.OrganizationID),
+		Kind:        g.Kind,
+		ParentID:    g.ParentID,
+		BundleItems: g.BundleItems,
+		ReleaseDate:  strings.TrimSpace(g.ReleaseDate),
+		Publisher:    strings.TrimSpace(g.Publisher),
+		Languages:    g.Languages,
+		AgeRating:    strings.TrimSpace(g.AgeRating),
+		Requirements: g.Requirements,
+		ReleaseAt:    strings.TrimSpace(g.ReleaseAt),
+		PreOrders:    g.PreOrders,
+		EarlyAccess:  g.EarlyAccess,
 	}
 	log.Println("ID: ", game.ID)
 	log.Println("Published: ", game.Published)
 	return game
 }
 
-//custom unmarshaler for price
+// custom unmarshaler so prices can be sent as plain decimals in the request's
+// Currency, or as Money objects
 func (r *GamePostRequest) UnmarshalJSON(data []byte) error {
-    type Alias GamePostRequest
-    aux := &struct {
-        Price json.Number `json:"price"`
-        *Alias
-    }{
-        Alias: (*Alias)(r),
-    }
-    if err := json.Unmarshal(data, &aux); err != nil {
-        return err
-    }
-    price, err := aux.Price.Float64()
-    if err != nil {
-        return err
-    }
-    r.Price = price
-    return nil
-}
+	type Alias GamePostRequest
+	aux := &struct {
+		Price       json.RawMessage            `json:"price"`
+		Prices      map[string]json.RawMessage `json:"Prices"`
+		BundleItems json.RawMessage            `json:"BundleItems"`
+		Languages   json.RawMessage            `json:"Languages"`
+		PreOrders   json.RawMessage            `json:"PreOrders"`
+		EarlyAccess json.RawMessage            `json:"EarlyAccess"`
+		*Alias
+	}{
+		Alias: (*Alias)(r),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.Currency = NormalizeCurrency(r.Currency)
+	price, err := parseRequestMoney(aux.Price, r.Currency)
+	if err != nil {
+		return err
+	}
+	r.Price = price
 
-type Game struct {
-	ID          string   `json:"ID"`
-	Title       string   `json:"Title"`
-	Description string   `json:"Description"`
-	Tags        []string `json:"Tags"`
-	Price       float64  `json:"Price"`
-	Updates     []Update `json:"Updates"`
-	Published   string   `json:"Published"`
-	Author      string   `json:"Author"`
-	AuthorID    string   `json:"AuthorID"`
-}
-
-func (g* Game) GameToDynamoDBItem() map[string]*dynamodb.AttributeValue{
-	ExpressionAttributeValues:= map[string]*dynamodb.AttributeValue{}
-	if g.Title != "" {
-		ExpressionAttributeValues[":title"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Title),
-		}
+	bundleItems, err := parseIDList(aux.BundleItems)
+	if err != nil {
+		return err
 	}
-	if g.Description != "" {
-		ExpressionAttributeValues[":description"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Description),
-		}
+	r.BundleItems = bundleItems
+
+	languages, err := parseIDList(aux.Languages)
+	if err != nil {
+		return err
 	}
-	if len(g.Tags) != 0 {
-		ExpressionAttributeValues[":tags"] = &dynamodb.AttributeValue{
-			SS: aws.StringSlice(g.Tags),
-		}
+	r.Languages = languages
+
+	r.PreOrders, err = parseRequestBool(aux.PreOrders)
+	if err != nil {
+		return err
 	}
-	if g.Price != 0 {
-		ExpressionAttributeValues[":price"] = &dynamodb.AttributeValue{
-			N: aws.String(strconv.FormatFloat(g.Price, 'f', -1, 64)),
-		}
+	r.EarlyAccess, err = parseRequestBool(aux.EarlyAccess)
+	if err != nil {
+		return err
 	}
-	// if len(g.Updates) != 0 {
-	// 	ExpressionAttributeValues[":updates"] = &dynamodb.AttributeValue{
-	// 		L: UpdateToDynamoDBItem(g.Updates),
-	// 	}
-	// }
-	if g.Published != "" {
-		ExpressionAttributeValues[":published"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Published),
-		}
+
+	err = parseFormRequirements(data, &r.Requirements)
+	if err != nil {
+		return err
 	}
-	if g.Author != "" {
-		ExpressionAttributeValues[":author"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Author),
+
+	r.Prices = nil
+	for currency, raw := range aux.Prices {
+		currency = strings.ToUpper(currency)
+		if !IsCurrency(currency) {
+			return ErrUnknownCurrency
 		}
+		regional, err := parseRequestMoney(raw, currency)
+		if err != nil {
+			return err
+		}
+		if r.Prices == nil {
+			r.Prices = map[string]Money{}
+		}
+		r.Prices[currency] = regional
 	}
-	if g.AuthorID != "" {
-		ExpressionAttributeValues[":authorID"] = &dynamodb.AttributeValue{
-			S: aws.String(g.AuthorID),
+	return nil
+}
+
+// parseIDList takes a JSON list of IDs, or the comma separated string a form
+// sends
+func parseIDList(raw json.RawMessage) ([]string, error) {
+	value := strings.TrimSpace(string(raw))
+	if value == "" || value == "null" {
+		return nil, nil
+	}
+	ids := []string{}
+	if strings.HasPrefix(value, "[") {
+		err := json.Unmarshal(raw, &ids)
+		return ids, err
+	}
+	joined := ""
+	err := json.Unmarshal(raw, &joined)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range strings.Split(joined, ",") {
+		id = strings.TrimSpace(id)
+		if id != "" {
+			ids = append(ids, id)
 		}
 	}
+	return ids, nil
+}
 
-	return ExpressionAttributeValues
+// parseRequestBool takes a JSON bool, or the "on" a checked form checkbox
+// sends
+func parseRequestBool(raw json.RawMessage) (bool, error) {
+	value := strings.TrimSpace(string(raw))
+	switch value {
+	case "", "null", `""`, "false", `"false"`, `"off"`:
+		return false, nil
+	case "true", `"true"`, `"on"`:
+		return true, nil
+	}
+	return false, errors.New("expected true or false, got " + value)
 }
 
-func (g* Game) GameToDynamoDBUpdateItem() map[string]*dynamodb.AttributeValue{
-	ExpressionAttributeValues:= map[string]*dynamodb.AttributeValue{}
-	if g.Title != "" {
-		ExpressionAttributeValues[":title"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Title),
-		}
+func parseRequestMoney(raw json.RawMessage, currency string) (Money, error) {
+	value := strings.TrimSpace(string(raw))
+	if value == "" || value == "null" || value == `""` {
+		return Money{Currency: currency}, nil
 	}
-	if g.Description != "" {
-		ExpressionAttributeValues[":description"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Description),
-		}
+	if strings.HasPrefix(value, "{") {
+		money := Money{}
+		err := json.Unmarshal(raw, &money)
+		return money, err
 	}
-	if len(g.Tags) != 0 {
-		ExpressionAttributeValues[":tags"] = &dynamodb.AttributeValue{
-			SS: aws.StringSlice(g.Tags),
+	return ParseMoney(strings.Trim(value, `"`), currency)
+}
+
+// Kinds of store entries. Games saved before kinds existed have none and are
+// plain games.
+const (
+	KindGame   = "game"
+	KindDLC    = "dlc"
+	KindBundle = "bundle"
+)
+
+type Game struct {
+	ID          string           `json:"ID"`
+	Title       string           `json:"Title"`
+	Description string           `json:"Description"`
+	Tags        []string         `json:"Tags"`
+	Price       Money            `json:"Price"`
+	Prices      map[string]Money `json:"Prices"`
+	Discounts   []Discount       `json:"Discounts"`
+	Updates     []Update         `json:"Updates"`
+	Published   string           `json:"Published"`
+	Author      string           `json:"Author"`
+	AuthorID    string           `json:"AuthorID"`
+	// OrganizationID is the organization that owns the game. Games without
+	// one belong to their author alone.
+	OrganizationID string `json:"OrganizationID"`
+	Kind        string           `json:"Kind"`
+	// ParentID is the base game a DLC belongs to
+	ParentID string `json:"ParentID"`
+	// BundleItems are the IDs of the games sold in a bundle
+	BundleItems []string `json:"BundleItems"`
+	// Media is the cover art, screenshots and trailers in gallery order
+	Media []Media `json:"Media"`
+	// Slug names the game in its URL. OldSlugs are the ones it had before
+	// being renamed, which redirect to the current one.
+	Slug     string   `json:"Slug"`
+	OldSlugs []string `json:"OldSlugs"`
+	// ReleaseDate is when the game came out, Published is when it was listed
+	// in the store
+	ReleaseDate  string             `json:"ReleaseDate"`
+	Publisher    string             `json:"Publisher"`
+	Languages    []string           `json:"Languages"`
+	AgeRating    string             `json:"AgeRating"`
+	Requirements SystemRequirements `json:"Requirements"`
+	// ReleaseAt is when an upcoming game comes out, in RFC 3339 UTC. The
+	// release job moves it from ReleaseUpcoming to ReleaseReleased then.
+	ReleaseAt    string `json:"ReleaseAt"`
+	ReleaseState string `json:"ReleaseState"`
+	// PreOrders lets the game be bought before it comes out. The download
+	// unlocks at the release.
+	PreOrders   bool `json:"PreOrders"`
+	EarlyAccess bool `json:"EarlyAccess"`
+	// DeletedAt is when the game was moved to the trash and DeletedBy who
+	// did it. PurgedAt is when it was taken out of the trash for good but
+	// kept because it is in someone's library.
+	DeletedAt string `json:"DeletedAt"`
+	DeletedBy string `json:"DeletedBy"`
+	PurgedAt  string `json:"PurgedAt"`
+
+	// filled in for the listing pages, never stored
+	DLC         []Game `json:"DLC,omitempty" dynamodbav:"-"`
+	BundleGames []Game `json:"BundleGames,omitempty" dynamodbav:"-"`
+}
+
+// CoverImage is the game's cover art, nil when none was uploaded
+func (g Game) CoverImage() *Media {
+	for _, media := range g.Media {
+		if media.Kind == MediaCover {
+			return &media
 		}
 	}
-	if g.Price != 0 {
-		ExpressionAttributeValues[":price"] = &dynamodb.AttributeValue{
-			N: aws.String(strconv.FormatFloat(g.Price, 'f', -1, 64)),
+	return nil
+}
+
+// Gallery is the screenshots and trailers in the order the developer set
+func (g Game) Gallery() []Media {
+	gallery := []Media{}
+	for _, media := range g.Media {
+		if media.Kind != MediaCover {
+			gallery = append(gallery, media)
 		}
 	}
-	// if len(g.Updates) != 0 {
-	// 	ExpressionAttributeValues[":updates"] = &dynamodb.AttributeValue{
-	// 		L: UpdateToDynamoDBItem(g.Updates),
-	// 	}
-	// }
-	if g.Author != "" {
-		ExpressionAttributeValues[":author"] = &dynamodb.AttributeValue{
-			S: aws.String(g.Author),
+	return gallery
+}
+
+// IsDeleted reports if the game is in the trash or purged, and so hidden
+// from everyone but the people who own it
+func (g Game) IsDeleted() bool {
+	return g.DeletedAt != ""
+}
+
+func (g Game) IsDLC() bool {
+	return g.Kind == KindDLC
+}
+
+func (g Game) IsBundle() bool {
+	return g.Kind == KindBundle
+}
+
+// BundleItemsJSON is used by the templates to pass what a bundle contains on
+// to the cart, with the prices it needs to discount the parts already owned.
+func (g Game) BundleItemsJSON() string {
+	type bundleItem struct {
+		ID     string           `json:"ID"`
+		Title  string           `json:"Title"`
+		Price  Money            `json:"Price"`
+		Prices map[string]Money `json:"Prices"`
+	}
+	items := []bundleItem{}
+	for _, game := range g.BundleGames {
+		items = append(items, bundleItem{
+			ID:     game.ID,
+			Title:  game.Title,
+			Price:  game.Price,
+			Prices: game.Prices,
+		})
+	}
+	b, err := json.Marshal(items)
+	if err != nil {
+		log.Println("Error marshaling bundle items:", err)
+		return "[]"
+	}
+	return string(b)
+}
+
+// PriceIn returns the regional price for the currency, or the base price when
+// the developer didn't set one.
+func (g Game) PriceIn(currency string) Money {
+	if price, ok := g.Prices[currency]; ok {
+		return price
+	}
+	return g.Price
+}
+
+// ActiveDiscount returns the discount running right now, the biggest one if
+// several windows overlap.
+func (g Game) ActiveDiscount() *Discount {
+	now := time.Now()
+	var best *Discount
+	for i := range g.Discounts {
+		d := &g.Discounts[i]
+		if !d.ActiveAt(now) {
+			continue
+		}
+		if best == nil || d.Apply(g.Price, g.Price).Amount < best.Apply(g.Price, g.Price).Amount {
+			best = d
 		}
 	}
+	return best
+}
 
+// SalePriceIn is the price in the currency after the active discount.
+func (g Game) SalePriceIn(currency string) Money {
+	d := g.ActiveDiscount()
+	if d == nil {
+		return g.PriceIn(currency)
+	}
+	return d.Apply(g.PriceIn(currency), g.Price)
+}
 
-	return ExpressionAttributeValues
+// DiscountsJSON is used by the templates to pass the discounts on to the cart.
+func (g Game) DiscountsJSON() string {
+	if g.Discounts == nil {
+		return "[]"
+	}
+	b, err := json.Marshal(g.Discounts)
+	if err != nil {
+		log.Println("Error marshaling discounts:", err)
+		return "[]"
+	}
+	return string(b)
 }
 
-// Something like: "set Title = :title, Description = :description, Tags = :tags, Price = :price, Updates = :updates, Published = :published"
-func (g* Game) GameToUpdateString() string{
-	FinalString := "set "
-	if g.Title != "" {
-		FinalString += "Title = :title, "
+// PricesJSON is used by the templates to pass the regional prices on to the cart.
+func (g Game) PricesJSON() string {
+	if g.Prices == nil {
+		return "{}"
 	}
-	if g.Description != "" {
-		FinalString += "Description = :description, "
+	b, err := json.Marshal(g.Prices)
+	if err != nil {
+		log.Println("Error marshaling prices:", err)
+		return "{}"
 	}
-	if len(g.Tags) != 0 {
-		FinalString += "Tags = :tags, "
+	return string(b)
+}
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Discount is a scheduled sale on a game. Percent discounts use Value, fixed
+// ones take Amount off the base price. Start and End are RFC3339 and either
+// can be left empty for an open ended window.
+type Discount struct {
+	ID        string  `json:"ID"`
+	Type      string  `json:"Type"`
+	Value     float64 `json:"Value"`
+	Amount    Money   `json:"Amount"`
+	Start     string  `json:"Start"`
+	End       string  `json:"End"`
+	CreatedBy string  `json:"CreatedBy"`
+}
+
+func (d Discount) ActiveAt(now time.Time) bool {
+	if d.Start != "" {
+		start, err := time.Parse(time.RFC3339, d.Start)
+		if err != nil || now.Before(start) {
+			return false
+		}
 	}
-	if g.Price != 0 {
-		FinalString += "Price = :price, "
+	if d.End != "" {
+		end, err := time.Parse(time.RFC3339, d.End)
+		if err != nil || !now.Before(end) {
+			return false
+		}
 	}
-	// if len(g.Updates) != 0 {
-	// 	FinalString += "Updates = :updates, "
-	// }
+	return true
+}
 
-	FinalString = FinalString[:len(FinalString)-2]
+// Apply returns the discounted price, never below zero. A fixed discount on a
+// regional price takes off the same share of it as it does of the base price.
+func (d Discount) Apply(price Money, basePrice Money) Money {
+	off := Money{Currency: price.Currency}
+	switch d.Type {
+	case DiscountPercent:
+		off = price.Percent(d.Value)
+	case DiscountFixed:
+		if d.Amount.Currency == price.Currency {
+			off.Amount = d.Amount.Amount
+		} else if basePrice.Amount > 0 {
+			off.Amount = (d.Amount.Amount*price.Amount + basePrice.Amount/2) / basePrice.Amount
+		}
+	}
+	discounted := price.Sub(off)
+	if discounted.Amount < 0 {
+		discounted.Amount = 0
+	}
+	return discounted
+}
+
+type DiscountPostRequest struct {
+	Type   string  `json:"Type" validate:"required,oneof=percent fixed"`
+	Value  float64 `json:"Value" validate:"min=0,max=100"`
+	Amount Money   `json:"Amount"`
+	Start  string  `json:"Start" validate:"max=40"`
+	End    string  `json:"End" validate:"max=40"`
+}
+
+func (d *DiscountPostRequest) DiscountPostRequestToDiscount(createdBy string) Discount {
+	return Discount{
+		ID:        uuid.New().String(),
+		Type:      d.Type,
+		Value:     d.Value,
+		Amount:    d.Amount,
+		Start:     d.Start,
+		End:       d.End,
+		CreatedBy: createdBy,
+	}
+}
+
+// Wishlist is the games a user wants, one per user so ID is the user ID
+type Wishlist struct {
+	ID      string   `json:"ID"`
+	UserID  string   `json:"UserID"`
+	GameIDs []string `json:"GameIDs"`
+	Updated string   `json:"Updated"`
+}
+
+// Media kinds
+const (
+	MediaCover      = "cover"
+	MediaScreenshot = "screenshot"
+	MediaTrailer    = "trailer"
+)
+
+var MediaKinds = []string{MediaCover, MediaScreenshot, MediaTrailer}
+
+// Media is an uploaded file shown with a game. Key and ThumbnailKey are where
+// the blob store keeps it, URL and ThumbnailURL where it is downloaded from.
+// Trailers have no thumbnail.
+type Media struct {
+	ID           string `json:"ID"`
+	Kind         string `json:"Kind"`
+	ContentType  string `json:"ContentType"`
+	Key          string `json:"Key"`
+	URL          string `json:"URL"`
+	ThumbnailKey string `json:"ThumbnailKey"`
+	ThumbnailURL string `json:"ThumbnailURL"`
+	Width        int    `json:"Width"`
+	Height       int    `json:"Height"`
+	Size         int64  `json:"Size"`
+	Uploaded     string `json:"Uploaded"`
+	UploadedBy   string `json:"UploadedBy"`
+}
 
-	return FinalString
+func (m Media) IsVideo() bool {
+	return strings.HasPrefix(m.ContentType, "video/")
 }
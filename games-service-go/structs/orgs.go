@@ -0,0 +1,104 @@
+package structs
+
+import "slices"
+
+// Roles in an organization, from the most to the least it allows. Owners
+// run the organization, admins add and remove members and games, editors
+// change the games and viewers can only look.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var OrgRoles = []string{RoleOwner, RoleAdmin, RoleEditor, RoleViewer}
+
+// Kinds of organization
+const (
+	OrgDeveloper = "developer"
+	OrgPublisher = "publisher"
+)
+
+var OrgKinds = []string{OrgDeveloper, OrgPublisher}
+
+// Organization is a studio or publisher whose members share its games
+type Organization struct {
+	ID      string   `json:"ID"`
+	Name    string   `json:"Name"`
+	Kind    string   `json:"Kind"`
+	Members []Member `json:"Members"`
+	Invites []Invite `json:"Invites"`
+	Created string   `json:"Created"`
+	// Version changes on every save, so two members changing the
+	// organization at once can't overwrite each other
+	Version string `json:"Version"`
+}
+
+type Member struct {
+	UserID string `json:"UserID"`
+	Role   string `json:"Role"`
+	Joined string `json:"Joined"`
+}
+
+// Invite lets whoever has its code join with the role. The code is sent to
+// the invitee out of band and works once, until it expires.
+type Invite struct {
+	Code      string `json:"Code"`
+	Role      string `json:"Role"`
+	Note      string `json:"Note"`
+	InvitedBy string `json:"InvitedBy"`
+	Created   string `json:"Created"`
+	Expires   string `json:"Expires"`
+}
+
+type OrganizationRequest struct {
+	Name string `json:"Name" validate:"required,max=100,chars=line"`
+	Kind string `json:"Kind" validate:"required,oneof=developer publisher"`
+}
+
+type InviteRequest struct {
+	Role string `json:"Role" validate:"required,oneof=owner admin editor viewer"`
+	// Note says who the invite is for, for the members list
+	Note string `json:"Note" validate:"max=200,chars=line"`
+}
+
+type AcceptInviteRequest struct {
+	Code string `json:"Code" validate:"required,max=64,chars=id"`
+}
+
+type TransferRequest struct {
+	GameID string `json:"GameID" validate:"required,max=64,chars=id"`
+}
+
+type MemberRequest struct {
+	Role string `json:"Role" validate:"required,oneof=owner admin editor viewer"`
+}
+
+// Member returns the user's membership, nil when they aren't a member
+func (o Organization) Member(userID string) *Member {
+	for _, member := range o.Members {
+		if member.UserID == userID {
+			return &member
+		}
+	}
+	return nil
+}
+
+// Owners counts the members with the owner role
+func (o Organization) Owners() int {
+	owners := 0
+	for _, member := range o.Members {
+		if member.Role == RoleOwner {
+			owners++
+		}
+	}
+	return owners
+}
+
+// RoleAllows reports if the role is the wanted one or above it
+func RoleAllows(role string, wanted string) bool {
+	have := slices.Index(OrgRoles, role)
+	need := slices.Index(OrgRoles, wanted)
+	return have >= 0 && need >= 0 && have <= need
+}
@@ -0,0 +1,23 @@
+package structs
+
+// Release states of a game. Games listed before releases could be scheduled
+// have none and are out.
+const (
+	ReleaseUpcoming = "upcoming"
+	ReleaseReleased = "released"
+)
+
+// IsReleased reports if the game is out, so owners can download it
+func (g Game) IsReleased() bool {
+	return g.ReleaseState != ReleaseUpcoming
+}
+
+// CanPreOrder reports if the game can be bought before it comes out
+func (g Game) CanPreOrder() bool {
+	return !g.IsReleased() && g.PreOrders
+}
+
+// Purchasable reports if the game can be added to a cart
+func (g Game) Purchasable() bool {
+	return g.IsReleased() || g.PreOrders
+}
@@ -0,0 +1,62 @@
+package structs
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Tag categories
+const (
+	TagGenre   = "genre"
+	TagFeature = "feature"
+	TagTheme   = "theme"
+)
+
+var TagCategories = []string{TagGenre, TagFeature, TagTheme}
+
+// Tag is a curated tag. Name is how it is shown and stored on games, Aliases
+// are other spellings that get folded into it, like "rpg" for "Role-Playing".
+type Tag struct {
+	ID        string   `json:"ID"`
+	Name      string   `json:"Name"`
+	Aliases   []string `json:"Aliases"`
+	Category  string   `json:"Category"`
+	Created   string   `json:"Created"`
+	CreatedBy string   `json:"CreatedBy"`
+}
+
+type TagRequest struct {
+	Name     string   `json:"Name" validate:"required,max=40,chars=line"`
+	Aliases  []string `json:"Aliases" validate:"max=20" each:"required,max=40,chars=line"`
+	Category string   `json:"Category" validate:"required,oneof=genre feature theme"`
+}
+
+// custom unmarshaler so the admin form can send the aliases comma separated
+func (r *TagRequest) UnmarshalJSON(data []byte) error {
+	type Alias TagRequest
+	aux := &struct {
+		Aliases json.RawMessage `json:"Aliases"`
+		*Alias
+	}{
+		Alias: (*Alias)(r),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	aliases, err := parseIDList(aux.Aliases)
+	if err != nil {
+		return err
+	}
+	r.Aliases = aliases
+	r.Name = strings.TrimSpace(r.Name)
+	r.Category = strings.ToLower(strings.TrimSpace(r.Category))
+	return nil
+}
+
+// TagID is the ID for a tag name, lower case with dashes
+func TagID(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_' || r == '/'
+	})
+	return strings.Join(words, "-")
+}
@@ -0,0 +1,78 @@
+package validate
+
+import "strings"
+
+// StripTags removes the HTML tags and comments from s, and what is inside
+// script and style elements, leaving the text. A < that doesn't start a tag,
+// like in "1 < 2", is kept.
+func StripTags(s string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(s, '<')
+		if start == -1 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:start])
+		s = s[start:]
+		if !startsTag(s) {
+			b.WriteByte('<')
+			s = s[1:]
+			continue
+		}
+		end := tagEnd(s)
+		if end == -1 {
+			// a tag left open takes the rest of the text with it
+			return b.String()
+		}
+		s = s[end:]
+	}
+}
+
+func startsTag(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	c := s[1]
+	return c == '/' || c == '!' || c == '?' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// tagEnd is the index just past the tag s starts with, and past the closing
+// tag for script and style, -1 when it never ends
+func tagEnd(s string) int {
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "<!--") {
+		end := strings.Index(lower[4:], "-->")
+		if end == -1 {
+			return -1
+		}
+		return 4 + end + 3
+	}
+	for _, element := range []string{"script", "style"} {
+		if !opensElement(lower, element) {
+			continue
+		}
+		closing := strings.Index(lower, "</"+element)
+		if closing == -1 {
+			return -1
+		}
+		end := strings.IndexByte(lower[closing:], '>')
+		if end == -1 {
+			return -1
+		}
+		return closing + end + 1
+	}
+	end := strings.IndexByte(s, '>')
+	if end == -1 {
+		return -1
+	}
+	return end + 1
+}
+
+func opensElement(lower string, element string) bool {
+	rest, ok := strings.CutPrefix(lower, "<"+element)
+	if !ok {
+		return false
+	}
+	return rest == "" || strings.ContainsAny(rest[:1], " \t\r\n/>")
+}
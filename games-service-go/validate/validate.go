@@ -0,0 +1,253 @@
+// Package validate checks the requests sent to the service against the rules
+// in the validate tags of their fields, like
+//
+//	Title string `validate:"required,max=100,chars=line"`
+//
+// Lists take the rules for their elements in an each tag. Nested structs,
+// and the structs in lists and maps, are checked with their own tags.
+//
+// The rules are:
+//
+//	required   the field can't be empty, or blank for strings
+//	min=N      strings have at least N characters, lists N elements and
+//	           numbers are at least N
+//	max=N      the same, at most N
+//	oneof=a b  the field is one of the values separated by spaces, in any
+//	           case
+//	chars=set  strings only have the characters of one of the sets: text
+//	           is anything printable with line breaks, line is anything
+//	           printable on one line and id is letters, digits, - and _
+//	html       strips the HTML tags from the string before the rules after
+//	           it check it
+//
+// Empty fields are only checked by required, so optional fields can still be
+// left out.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError says what is wrong with one field of the request. Field is the
+// name of the field in the struct, dotted for nested fields and list
+// elements, like Prices.EUR.Amount or Tags.3.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Errors lists every field of a request that breaks its rules
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + " " + fieldError.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Struct checks the struct v points to, and returns Errors when any field
+// breaks its rules. v has to be a pointer so html can clean the strings up.
+func Struct(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: Struct needs a pointer to a struct, got %T", v))
+	}
+	errs := Errors{}
+	checkStruct(value.Elem(), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func checkStruct(value reflect.Value, prefix string, errs *Errors) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + field.Name
+		fieldValue := value.Field(i)
+		if !checkValue(fieldValue, name, field.Tag.Get("validate"), errs) {
+			continue
+		}
+		each, hasEach := field.Tag.Lookup("each")
+		if hasEach && fieldValue.Kind() == reflect.Slice {
+			for j := 0; j < fieldValue.Len(); j++ {
+				checkValue(fieldValue.Index(j), name+"."+strconv.Itoa(j), each, errs)
+			}
+		}
+		checkNested(fieldValue, name, errs)
+	}
+}
+
+// checkNested checks the structs in the field with their own tags
+func checkNested(value reflect.Value, name string, errs *Errors) {
+	switch value.Kind() {
+	case reflect.Struct:
+		checkStruct(value, name+".", errs)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < value.Len(); i++ {
+			checkStruct(value.Index(i), name+"."+strconv.Itoa(i)+".", errs)
+		}
+	case reflect.Map:
+		if value.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, key := range keys {
+			// map values can't be changed in place, so html works on a copy
+			elem := reflect.New(value.Type().Elem()).Elem()
+			elem.Set(value.MapIndex(key))
+			checkStruct(elem, name+"."+fmt.Sprint(key)+".", errs)
+			value.SetMapIndex(key, elem)
+		}
+	}
+}
+
+// checkValue applies the rules to the value, and reports if it passed them
+func checkValue(value reflect.Value, name string, rules string, errs *Errors) bool {
+	if rules == "" {
+		return true
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		message := ""
+		switch rule {
+		case "html":
+			value.SetString(StripTags(value.String()))
+			continue
+		case "required":
+			if isEmpty(value) {
+				message = "is required"
+			}
+		case "min", "max", "oneof", "chars":
+			if isEmpty(value) {
+				continue
+			}
+			message = checkRule(value, rule, arg)
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q on %s", rule, name))
+		}
+		if message != "" {
+			*errs = append(*errs, FieldError{Field: name, Message: message})
+			return false
+		}
+	}
+	return true
+}
+
+func checkRule(value reflect.Value, rule string, arg string) string {
+	switch rule {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: %s needs a number, got %q", rule, arg))
+		}
+		size, unit := measure(value)
+		if rule == "min" && size < limit {
+			return "must be at least " + arg + unit
+		}
+		if rule == "max" && size > limit {
+			return "must be at most " + arg + unit
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if strings.EqualFold(strings.TrimSpace(value.String()), option) {
+				return ""
+			}
+		}
+		return "must be " + list(options)
+	case "chars":
+		set, ok := charSets[arg]
+		if !ok {
+			panic(fmt.Sprintf("validate: unknown character set %q", arg))
+		}
+		s := value.String()
+		if !utf8.ValidString(s) {
+			return "isn't valid UTF-8"
+		}
+		for _, r := range s {
+			if !set.allowed(r) {
+				return set.message
+			}
+		}
+	}
+	return ""
+}
+
+// measure is the length of strings and lists, and the value of numbers
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters long"
+	case reflect.Slice, reflect.Map:
+		return float64(value.Len()), " items long"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	panic(fmt.Sprintf("validate: can't measure a %s", value.Kind()))
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// list joins the options like "game, dlc or bundle"
+func list(options []string) string {
+	if len(options) == 1 {
+		return options[0]
+	}
+	return strings.Join(options[:len(options)-1], ", ") + " or " + options[len(options)-1]
+}
+
+type charSet struct {
+	allowed func(r rune) bool
+	message string
+}
+
+var charSets = map[string]charSet{
+	"text": {
+		allowed: func(r rune) bool {
+			return r == '\n' || r == '\r' || r == '\t' || unicode.IsPrint(r) || unicode.IsSpace(r)
+		},
+		message: "can't have control characters",
+	},
+	"line": {
+		allowed: func(r rune) bool {
+			return unicode.IsPrint(r)
+		},
+		message: "can't have line breaks or control characters",
+	},
+	"id": {
+		allowed: func(r rune) bool {
+			return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_')
+		},
+		message: "can only have letters, digits, - and _",
+	},
+}
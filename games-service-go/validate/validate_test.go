@@ -0,0 +1,140 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Draupniyr/games-service/structs"
+)
+
+func fieldMessages(t *testing.T, err error) map[string]string {
+	t.Helper()
+	if err == nil {
+		return map[string]string{}
+	}
+	var fields Errors
+	if !errors.As(err, &fields) {
+		t.Fatalf("Expected field errors got %v", err)
+	}
+	messages := map[string]string{}
+	for _, field := range fields {
+		messages[field.Field] = field.Message
+	}
+	return messages
+}
+
+func TestGamePostRequest(t *testing.T) {
+	tags := make([]string, 10000)
+	for i := range tags {
+		tags[i] = "tag"
+	}
+	request := structs.GamePostRequest{
+		Title:       "  ",
+		Description: "<script>alert(1)</script>",
+		Tags:        tags,
+		Price:       structs.NewMoney(-100, "USD"),
+		Prices:      map[string]structs.Money{"EUR": structs.NewMoney(-5, "EUR")},
+		Author:      "Dev\nStudio",
+		Kind:        "mod",
+		BundleItems: []string{"game-1", "game 2"},
+		AgeRating:   "21+",
+	}
+	request.Requirements.Minimum.OS = strings.Repeat("a", 201)
+
+	messages := fieldMessages(t, Struct(&request))
+	expected := map[string]string{
+		"Title":                   "is required",
+		"Description":             "is required",
+		"Tags":                    "must be at most 20 items long",
+		"Price.Amount":            "must be at least 0",
+		"Prices.EUR.Amount":       "must be at least 0",
+		"Author":                  "can't have line breaks or control characters",
+		"Kind":                    "must be game, dlc or bundle",
+		"BundleItems.1":           "can only have letters, digits, - and _",
+		"AgeRating":               "must be 3+, 7+, 12+, 16+ or 18+",
+		"Requirements.Minimum.OS": "must be at most 200 characters long",
+	}
+	for field, message := range expected {
+		if messages[field] != message {
+			t.Errorf("Expected %s %q got %q", field, message, messages[field])
+		}
+	}
+	if len(messages) != len(expected) {
+		t.Errorf("Expected %d errors got %v", len(expected), messages)
+	}
+}
+
+func TestValidRequest(t *testing.T) {
+	request := structs.GamePostRequest{
+		Title:       "Café: The Game!",
+		Description: "A <b>great</b> game.\nPlay it now.",
+		Tags:        []string{"Action", "Co-op"},
+		Price:       structs.NewMoney(1999, "USD"),
+		Author:      "Dev Studio",
+		Kind:        "DLC",
+		ParentID:    "3f2b6c1e-0d4a-4c7e-9b1a-2e5f8d9c0a11",
+	}
+	err := Struct(&request)
+	if err != nil {
+		t.Fatalf("Expected no errors got %v", err)
+	}
+	if request.Description != "A great game.\nPlay it now." {
+		t.Errorf("Expected the tags stripped from the description got %q", request.Description)
+	}
+}
+
+func TestEachRules(t *testing.T) {
+	request := structs.TagRequest{
+		Name:     "RPG",
+		Aliases:  []string{"role playing", "", strings.Repeat("x", 41)},
+		Category: "genre",
+	}
+	messages := fieldMessages(t, Struct(&request))
+	if messages["Aliases.1"] != "is required" || messages["Aliases.2"] != "must be at most 40 characters long" || len(messages) != 2 {
+		t.Errorf("Unexpected errors %v", messages)
+	}
+}
+
+// every request the service decodes has its rules checked, which panics on
+// a rule that doesn't exist
+func TestRequestRules(t *testing.T) {
+	requests := []interface{}{
+		&structs.GamePostRequest{},
+		&structs.UpdatePostObject{},
+		&structs.DiscountPostRequest{},
+		&structs.OrganizationRequest{},
+		&structs.InviteRequest{},
+		&structs.AcceptInviteRequest{},
+		&structs.TransferRequest{},
+		&structs.MemberRequest{},
+		&structs.TagRequest{},
+		&structs.BuildRequest{},
+	}
+	for _, request := range requests {
+		Struct(request)
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"<p>Hello <b>world</b></p>", "Hello world"},
+		{"1 < 2 and 3 > 2", "1 < 2 and 3 > 2"},
+		{"before<script>alert('<b>')</script>after", "beforeafter"},
+		{"a<STYLE type=\"text/css\">p{}</STYLE>b", "ab"},
+		{"a<!-- <b>comment</b> -->b", "ab"},
+		{"<img src=x onerror=alert(1)>", ""},
+		{"text <b unclosed", "text "},
+		{"<scripts>kept</scripts>", "kept"},
+	}
+	for _, test := range tests {
+		got := StripTags(test.html)
+		if got != test.want {
+			t.Errorf("StripTags(%q): expected %q got %q", test.html, test.want, got)
+		}
+	}
+}